@@ -0,0 +1,104 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Definition of a binary array: a field whose values have meanings
+// given by their positions, such as the Canon CameraSettings field.
+type ArrayDef struct {
+	// Type of the values, which may differ from the type of the
+	// field, for example if the array is stored as UNDEFINED data.
+	Type Type
+	// Names of the values, by index. Values without names aren't
+	// decoded.
+	Names map[int]string
+}
+
+// A value decoded from a binary array.
+type ArrayValue struct {
+	Index int // Index in an array, or byte offset in a block.
+	Name  string
+	Value int64
+}
+
+// Values decoded from a binary array, in order of index.
+type ArrayValues []ArrayValue
+
+// Return the value with a given name, and whether it was found.
+func (vals ArrayValues) Get(name string) (int64, bool) {
+	for _, val := range vals {
+		if val.Name == name {
+			return val.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Decode the named values of a binary array from a field. Values whose
+// index is past the end of the field data are omitted.
+func (def ArrayDef) Decode(field Field, order binary.ByteOrder) (ArrayValues, error) {
+	size := def.Type.Size()
+	if size == 0 || !def.Type.IsIntegral() {
+		return nil, fmt.Errorf("Array type %s isn't an integer type", def.Type.Name())
+	}
+	count := uint32(len(field.Data)) / size
+	if fieldSize := field.Size(); fieldSize < uint32(len(field.Data)) {
+		count = fieldSize / size
+	}
+	array := Field{field.Tag, def.Type, count, field.Data}
+	indexes := make([]int, 0, len(def.Names))
+	for i := range def.Names {
+		if i >= 0 && uint32(i) < count {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	vals := make(ArrayValues, len(indexes))
+	for j, i := range indexes {
+		vals[j] = ArrayValue{i, def.Names[i], array.AnyInteger(uint32(i), order)}
+	}
+	return vals, nil
+}
+
+// A value in a binary block.
+type BlockEntry struct {
+	Offset uint32 // Byte offset in the block.
+	Type   Type   // An integer type.
+	Name   string
+	// If not 0, the bits of the value that are used, which are
+	// shifted down to form the value.
+	Mask uint32
+}
+
+// Definition of a binary block: data containing values of various
+// types at fixed offsets, such as the Nikon VRInfo field.
+type BlockDef []BlockEntry
+
+// Decode the values of a binary block from data. Values that extend
+// past the end of the data are omitted.
+func (def BlockDef) Decode(data []byte, order binary.ByteOrder) (ArrayValues, error) {
+	vals := make(ArrayValues, 0, len(def))
+	for _, entry := range def {
+		size := entry.Type.Size()
+		if size == 0 || !entry.Type.IsIntegral() {
+			return nil, fmt.Errorf("Type %s of block value %s isn't an integer type", entry.Type.Name(), entry.Name)
+		}
+		if uint64(entry.Offset)+uint64(size) > uint64(len(data)) {
+			continue
+		}
+		field := Field{0, entry.Type, 1, data[entry.Offset : entry.Offset+size]}
+		val := field.AnyInteger(0, order)
+		if entry.Mask != 0 {
+			val = int64(uint32(val) & entry.Mask)
+			for mask := entry.Mask; mask&1 == 0; mask >>= 1 {
+				val >>= 1
+			}
+		}
+		vals = append(vals, ArrayValue{int(entry.Offset), entry.Name, val})
+	}
+	sort.SliceStable(vals, func(i, j int) bool { return vals[i].Index < vals[j].Index })
+	return vals, nil
+}
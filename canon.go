@@ -0,0 +1,308 @@
+package tiff66
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Definitions of the Canon1 binary arrays, with names as used by
+// Exiftool. In CameraSettings and ShotInfo, the first value is the
+// size of the array in bytes.
+var Canon1ArrayDefs = map[Tag]ArrayDef{
+	0x0001: {SSHORT, map[int]string{
+		1:  "MacroMode",
+		2:  "SelfTimer",
+		3:  "Quality",
+		4:  "CanonFlashMode",
+		5:  "ContinuousDrive",
+		7:  "FocusMode",
+		9:  "RecordMode",
+		10: "CanonImageSize",
+		11: "EasyMode",
+		12: "DigitalZoom",
+		13: "Contrast",
+		14: "Saturation",
+		15: "Sharpness",
+		16: "CameraISO",
+		17: "MeteringMode",
+		18: "FocusRange",
+		19: "AFPoint",
+		20: "CanonExposureMode",
+		22: "LensType",
+		23: "MaxFocalLength",
+		24: "MinFocalLength",
+		25: "FocalUnits",
+		26: "MaxAperture",
+		27: "MinAperture",
+		28: "FlashActivity",
+		29: "FlashBits",
+		32: "FocusContinuous",
+		33: "AESetting",
+		34: "ImageStabilization",
+		35: "DisplayAperture",
+		36: "ZoomSourceWidth",
+		37: "ZoomTargetWidth",
+		39: "SpotMeteringMode",
+		40: "PhotoEffect",
+		41: "ManualFlashOutput",
+		42: "ColorTone",
+		46: "SRAWQuality",
+	}},
+	0x0002: {SHORT, map[int]string{
+		0: "FocalType",
+		1: "FocalLength",
+		2: "FocalPlaneXSize",
+		3: "FocalPlaneYSize",
+	}},
+	0x0004: {SSHORT, map[int]string{
+		1:  "AutoISO",
+		2:  "BaseISO",
+		3:  "MeasuredEV",
+		4:  "TargetAperture",
+		5:  "TargetExposureTime",
+		6:  "ExposureCompensation",
+		7:  "WhiteBalance",
+		8:  "SlowShutter",
+		9:  "SequenceNumber",
+		10: "OpticalZoomCode",
+		12: "CameraTemperature",
+		13: "FlashGuideNumber",
+		14: "AFPointsInFocus",
+		15: "FlashExposureComp",
+		16: "AutoExposureBracketing",
+		17: "AEBBracketValue",
+		18: "ControlMode",
+		19: "FocusDistanceUpper",
+		20: "FocusDistanceLower",
+		21: "FNumber",
+		22: "ExposureTime",
+		23: "MeasuredEV2",
+		24: "BulbDuration",
+		26: "CameraType",
+		27: "AutoRotate",
+		28: "NDFilter",
+		29: "SelfTimer2",
+		33: "FlashOutput",
+	}},
+	0x0005: {SSHORT, map[int]string{
+		2: "PanoramaFrameNumber",
+		5: "PanoramaDirection",
+	}},
+	0x0098: {SHORT, map[int]string{
+		0: "CropLeftMargin",
+		1: "CropRightMargin",
+		2: "CropTopMargin",
+		3: "CropBottomMargin",
+	}},
+	0x009A: {LONG, map[int]string{
+		0: "AspectRatio",
+		1: "CroppedImageWidth",
+		2: "CroppedImageHeight",
+		3: "CroppedImageLeft",
+		4: "CroppedImageTop",
+	}},
+	0x00A0: {SSHORT, map[int]string{
+		1:  "ToneCurve",
+		2:  "Sharpness",
+		3:  "SharpnessFrequency",
+		4:  "SensorRedLevel",
+		5:  "SensorBlueLevel",
+		6:  "WhiteBalanceRed",
+		7:  "WhiteBalanceBlue",
+		8:  "WhiteBalance",
+		9:  "ColorTemperature",
+		10: "PictureStyle",
+		11: "DigitalGain",
+		12: "WBShiftAB",
+		13: "WBShiftGM",
+	}},
+}
+
+// Decode a binary array, such as CameraSettings (tag 0x0001) or
+// ShotInfo (tag 0x0004), from a Canon1 maker note node.
+func (node IFDNode) CanonArray(tag Tag) (ArrayValues, error) {
+	if node.GetSpace() != Canon1Space {
+		return nil, fmt.Errorf("CanonArray: node is in %s space, not Canon1", node.GetSpace().Name())
+	}
+	def, ok := Canon1ArrayDefs[tag]
+	if !ok {
+		return nil, fmt.Errorf("CanonArray: field %d(0x%X) isn't a known binary array", tag, tag)
+	}
+	fields := node.FindFields([]Tag{tag})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("CanonArray: field %d(0x%X) not found", tag, tag)
+	}
+	return def.Decode(*fields[0], node.Order)
+}
+
+// Canon1 fields with variable-length structures.
+const (
+	canon1CustomFunctions   = 0x000F
+	canon1AFInfo            = 0x0012
+	canon1AFInfo2           = 0x0026
+	canon1CustomFunctions1D = 0x0090
+	canon1CustomFunctions2  = 0x0099
+)
+
+// An autofocus point from a Canon AFInfo or AFInfo2 field. Positions
+// are relative to the center of the image.
+type CanonAFPoint struct {
+	Width, Height uint16
+	X, Y          int16
+	InFocus       bool
+	Selected      bool // Only set from AFInfo2.
+}
+
+// Autofocus information from a Canon AFInfo or AFInfo2 field.
+type CanonAFInfo struct {
+	AreaMode                    uint16 // Only set from AFInfo2.
+	ValidPoints                 uint16
+	ImageWidth, ImageHeight     uint16
+	AFImageWidth, AFImageHeight uint16
+	Points                      []CanonAFPoint
+	PrimaryPoint                int // Index of the primary point, or -1.
+}
+
+// Return the next n values from vals, or nil if there are fewer.
+func takeShorts(vals *[]uint16, n int) []uint16 {
+	if len(*vals) < n {
+		return nil
+	}
+	taken := (*vals)[:n]
+	*vals = (*vals)[n:]
+	return taken
+}
+
+// Return the autofocus information from a Canon1 maker note node,
+// decoded from the AFInfo2 field used by newer cameras or the AFInfo
+// field used by older ones.
+func (node IFDNode) CanonAFInfo() (*CanonAFInfo, error) {
+	if node.GetSpace() != Canon1Space {
+		return nil, fmt.Errorf("CanonAFInfo: node is in %s space, not Canon1", node.GetSpace().Name())
+	}
+	info := &CanonAFInfo{PrimaryPoint: -1}
+	vals, ok := node.GetShorts(canon1AFInfo2)
+	afinfo2 := ok
+	if afinfo2 {
+		// The first value is the size in bytes.
+		if len(vals) < 2 {
+			return nil, errors.New("CanonAFInfo: AF information is too short")
+		}
+		info.AreaMode = vals[1]
+		vals = vals[2:]
+	} else if vals, ok = node.GetShorts(canon1AFInfo); !ok {
+		return nil, errors.New("CanonAFInfo: AFInfo field not found")
+	}
+	head := takeShorts(&vals, 6)
+	if head == nil {
+		return nil, errors.New("CanonAFInfo: AF information is too short")
+	}
+	count := int(head[0])
+	info.ValidPoints = head[1]
+	info.ImageWidth, info.ImageHeight = head[2], head[3]
+	info.AFImageWidth, info.AFImageHeight = head[4], head[5]
+	var widths, heights []uint16
+	if afinfo2 {
+		widths = takeShorts(&vals, count)
+		heights = takeShorts(&vals, count)
+	} else if size := takeShorts(&vals, 2); size != nil {
+		// A single area size for all points.
+		widths = make([]uint16, count)
+		heights = make([]uint16, count)
+		for i := 0; i < count; i++ {
+			widths[i], heights[i] = size[0], size[1]
+		}
+	}
+	xs := takeShorts(&vals, count)
+	ys := takeShorts(&vals, count)
+	if widths == nil || heights == nil || xs == nil || ys == nil {
+		return nil, errors.New("CanonAFInfo: AF information is too short for its number of points")
+	}
+	maskLen := (count + 15) / 16
+	inFocus := takeShorts(&vals, maskLen)
+	var selected []uint16
+	if afinfo2 {
+		selected = takeShorts(&vals, maskLen)
+	}
+	if primary := takeShorts(&vals, 1); primary != nil && int(primary[0]) < count {
+		info.PrimaryPoint = int(primary[0])
+	}
+	info.Points = make([]CanonAFPoint, count)
+	for i := range info.Points {
+		point := &info.Points[i]
+		point.Width, point.Height = widths[i], heights[i]
+		point.X, point.Y = int16(xs[i]), int16(ys[i])
+		point.InFocus = inFocus != nil && inFocus[i/16]&(1<<uint(i%16)) != 0
+		point.Selected = selected != nil && selected[i/16]&(1<<uint(i%16)) != 0
+	}
+	return info, nil
+}
+
+// A Canon custom function setting. Group is 0 for functions from the
+// CustomFunctions or CustomFunctions1D fields, which have a single
+// value each.
+type CanonCustomFunction struct {
+	Group  uint32
+	Number uint32
+	Values []int32
+}
+
+// Return the custom function settings from a Canon1 maker note node,
+// decoded from the CustomFunctions2 field used by newer cameras, or the
+// CustomFunctions or CustomFunctions1D fields used by older ones.
+func (node IFDNode) CanonCustomFunctions() ([]CanonCustomFunction, error) {
+	if node.GetSpace() != Canon1Space {
+		return nil, fmt.Errorf("CanonCustomFunctions: node is in %s space, not Canon1", node.GetSpace().Name())
+	}
+	if vals, ok := node.GetLongs(canon1CustomFunctions2); ok {
+		return canonCustomFunctions2(vals)
+	}
+	for _, tag := range []Tag{canon1CustomFunctions, canon1CustomFunctions1D} {
+		vals, ok := node.GetShorts(tag)
+		if !ok {
+			continue
+		}
+		// The first value is the size in bytes, and each
+		// following value has the function number in its high
+		// byte and the setting in its low byte.
+		var funcs []CanonCustomFunction
+		for i := 1; i < len(vals); i++ {
+			funcs = append(funcs, CanonCustomFunction{0, uint32(vals[i] >> 8), []int32{int32(vals[i] & 0xFF)}})
+		}
+		return funcs, nil
+	}
+	return nil, errors.New("CanonCustomFunctions: custom functions field not found")
+}
+
+// Decode the values of a CustomFunctions2 field: the size in bytes and
+// the number of groups, followed by each group's number, size in bytes
+// and number of functions, then for each function its number, number of
+// values and the values.
+func canonCustomFunctions2(vals []uint32) ([]CanonCustomFunction, error) {
+	errShort := errors.New("CanonCustomFunctions: CustomFunctions2 field is too short")
+	if len(vals) < 2 {
+		return nil, errShort
+	}
+	groups := vals[1]
+	vals = vals[2:]
+	var funcs []CanonCustomFunction
+	for g := uint32(0); g < groups; g++ {
+		if len(vals) < 3 {
+			return nil, errShort
+		}
+		group, count := vals[0], vals[2]
+		vals = vals[3:]
+		for f := uint32(0); f < count; f++ {
+			if len(vals) < 2 || uint64(len(vals)-2) < uint64(vals[1]) {
+				return nil, errShort
+			}
+			fn := CanonCustomFunction{group, vals[0], make([]int32, vals[1])}
+			for i := range fn.Values {
+				fn.Values[i] = int32(vals[2+i])
+			}
+			vals = vals[2+len(fn.Values):]
+			funcs = append(funcs, fn)
+		}
+	}
+	return funcs, nil
+}
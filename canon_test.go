@@ -0,0 +1,140 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestCanonArray(t *testing.T) {
+	order := binary.LittleEndian
+	node := NewIFDNode(Canon1Space)
+	node.Order = order
+	settings := make([]uint16, 20)
+	settings[0] = uint16(len(settings) * 2)
+	settings[1] = 2       // MacroMode
+	settings[16] = 0xFFFF // CameraISO, negative as a signed value.
+	node.SetShorts(0x0001, settings)
+	node.SetShorts(0x0002, []uint16{1, 50})
+	node.SetASCII(0x0006, "Canon EOS")
+
+	vals, err := node.CanonArray(0x0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := vals.Get("MacroMode"); !ok || v != 2 {
+		t.Errorf("MacroMode is %d", v)
+	}
+	if v, ok := vals.Get("CameraISO"); !ok || v != -1 {
+		t.Errorf("CameraISO is %d", v)
+	}
+	// Values past the end of the array are omitted.
+	if _, ok := vals.Get("LensType"); ok {
+		t.Error("LensType decoded from short array")
+	}
+	for i := 1; i < len(vals); i++ {
+		if vals[i].Index <= vals[i-1].Index {
+			t.Fatal("Values aren't in order")
+		}
+	}
+	vals, err = node.CanonArray(0x0002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := vals.Get("FocalLength"); v != 50 {
+		t.Errorf("FocalLength is %d", v)
+	}
+	if _, err := node.CanonArray(0x0006); err == nil {
+		t.Error("Field that isn't an array didn't cause an error")
+	}
+	if _, err := node.CanonArray(0x0004); err == nil {
+		t.Error("Missing field didn't cause an error")
+	}
+	if _, err := NewIFDNode(TIFFSpace).CanonArray(0x0001); err == nil {
+		t.Error("Node in wrong space didn't cause an error")
+	}
+}
+
+func TestCanonAFInfo(t *testing.T) {
+	order := binary.BigEndian
+	node := NewIFDNode(Canon1Space)
+	node.Order = order
+	neg := func(v int16) uint16 { return uint16(v) }
+	node.SetShorts(canon1AFInfo, []uint16{2, 2, 3000, 2000, 300, 200, 40, 30, neg(-50), 50, 0, 0, 1})
+	info, err := node.CanonAFInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Points) != 2 || info.ImageWidth != 3000 || info.PrimaryPoint != -1 {
+		t.Fatalf("Wrong AFInfo %+v", info)
+	}
+	if p := info.Points[0]; p.X != -50 || p.Width != 40 || p.Height != 30 || !p.InFocus {
+		t.Errorf("Wrong point %+v", p)
+	}
+	if info.Points[1].InFocus {
+		t.Error("Point 1 is in focus")
+	}
+
+	// AFInfo2 is used in preference to AFInfo.
+	node.SetShorts(canon1AFInfo2, []uint16{
+		0, 2, 3, 3, 6000, 4000, 600, 400,
+		10, 20, 30, // Widths.
+		11, 21, 31, // Heights.
+		neg(-100), 0, 100, // X.
+		0, neg(-5), 5, // Y.
+		2, 3, 1})
+	info, err = node.CanonAFInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AreaMode != 2 || len(info.Points) != 3 || info.PrimaryPoint != 1 {
+		t.Fatalf("Wrong AFInfo2 %+v", info)
+	}
+	want := []CanonAFPoint{
+		{10, 11, -100, 0, false, true},
+		{20, 21, 0, -5, true, true},
+		{30, 31, 100, 5, false, false},
+	}
+	for i := range want {
+		if info.Points[i] != want[i] {
+			t.Errorf("Point %d is %+v", i, info.Points[i])
+		}
+	}
+
+	node.SetShorts(canon1AFInfo2, []uint16{0, 2, 3, 3, 6000, 4000, 600, 400, 10})
+	if _, err := node.CanonAFInfo(); err == nil {
+		t.Error("Truncated AFInfo2 didn't cause an error")
+	}
+}
+
+func TestCanonCustomFunctions(t *testing.T) {
+	order := binary.LittleEndian
+	node := NewIFDNode(Canon1Space)
+	node.Order = order
+	if _, err := node.CanonCustomFunctions(); err == nil {
+		t.Error("Missing field didn't cause an error")
+	}
+	node.SetShorts(canon1CustomFunctions, []uint16{6, 0x0102, 0x0300})
+	funcs, err := node.CanonCustomFunctions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(funcs) != 2 || funcs[0].Number != 1 || funcs[0].Values[0] != 2 || funcs[1].Number != 3 || funcs[1].Values[0] != 0 {
+		t.Errorf("Wrong custom functions %+v", funcs)
+	}
+
+	node.SetLongs(canon1CustomFunctions2, []uint32{48, 1, 1, 32, 2, 0x101, 1, 5, 0x102, 2, 1, 0xFFFFFFFF})
+	funcs, err = node.CanonCustomFunctions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(funcs) != 2 || funcs[0].Group != 1 || funcs[0].Number != 0x101 || funcs[0].Values[0] != 5 {
+		t.Fatalf("Wrong custom functions %+v", funcs)
+	}
+	if v := funcs[1].Values; len(v) != 2 || v[0] != 1 || v[1] != -1 {
+		t.Errorf("Wrong values %v", v)
+	}
+	node.SetLongs(canon1CustomFunctions2, []uint32{48, 1, 1, 32, 2, 0x101, 3, 5})
+	if _, err := node.CanonCustomFunctions(); err == nil {
+		t.Error("Truncated CustomFunctions2 didn't cause an error")
+	}
+}
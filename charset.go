@@ -0,0 +1,79 @@
+package tiff66
+
+import (
+	"unicode/utf8"
+)
+
+// Policy for converting the data of ASCII fields to strings. Many
+// cameras and programs write UTF-8 or Latin-1 text in ASCII fields.
+type StringPolicy uint8
+
+const (
+	// Return the bytes unchanged, which may not be valid UTF-8.
+	StringRaw StringPolicy = iota
+	// Replace any byte that isn't 7-bit ASCII with U+FFFD.
+	StringStrictASCII
+	// Keep valid UTF-8 sequences, replacing invalid bytes with
+	// U+FFFD.
+	StringUTF8
+	// Keep the text if it's valid UTF-8, otherwise decode it as
+	// Latin-1 (ISO 8859-1).
+	StringLatin1Fallback
+)
+
+// Policy used by Field.ASCII.
+var stringPolicy = StringRaw
+
+// Set the policy used by Field.ASCII, and hence by printing and
+// exporting, to convert ASCII field data to strings. The default is
+// StringRaw.
+func SetStringPolicy(policy StringPolicy) {
+	stringPolicy = policy
+}
+
+// Convert bytes to a string according to a policy.
+func (policy StringPolicy) decode(data []byte) string {
+	switch policy {
+	case StringStrictASCII:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			if b < utf8.RuneSelf {
+				runes[i] = rune(b)
+			} else {
+				runes[i] = utf8.RuneError
+			}
+		}
+		return string(runes)
+	case StringUTF8:
+		if utf8.Valid(data) {
+			return string(data)
+		}
+		runes := make([]rune, 0, len(data))
+		for len(data) > 0 {
+			r, size := utf8.DecodeRune(data)
+			runes = append(runes, r)
+			data = data[size:]
+		}
+		return string(runes)
+	case StringLatin1Fallback:
+		if utf8.Valid(data) {
+			return string(data)
+		}
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	return string(data)
+}
+
+// Return the data of an ASCII field as a string according to a given
+// policy, without a trailing NUL.
+func (f Field) DecodeASCII(policy StringPolicy) string {
+	data := f.Data
+	if l := len(data); l > 0 && data[l-1] == 0 {
+		data = data[:l-1]
+	}
+	return policy.decode(data)
+}
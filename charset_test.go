@@ -0,0 +1,36 @@
+package tiff66
+
+import (
+	"testing"
+)
+
+func TestStringPolicy(t *testing.T) {
+	latin1 := Field{Artist, ASCII, 6, []byte("Ren\xE9e\000")}
+	utf8 := Field{Artist, ASCII, 7, []byte("Ren\xC3\xA9e\000")}
+	tests := []struct {
+		policy       StringPolicy
+		latin1, utf8 string
+	}{
+		{StringRaw, "Ren\xE9e", "Ren\xC3\xA9e"},
+		{StringStrictASCII, "Ren�e", "Ren��e"},
+		{StringUTF8, "Ren�e", "Renée"},
+		{StringLatin1Fallback, "Renée", "Renée"},
+	}
+	for _, test := range tests {
+		if s := latin1.DecodeASCII(test.policy); s != test.latin1 {
+			t.Errorf("Policy %d: Latin-1 decoded as %q", test.policy, s)
+		}
+		if s := utf8.DecodeASCII(test.policy); s != test.utf8 {
+			t.Errorf("Policy %d: UTF-8 decoded as %q", test.policy, s)
+		}
+	}
+	SetStringPolicy(StringLatin1Fallback)
+	s := latin1.ASCII()
+	SetStringPolicy(StringRaw)
+	if s != "Renée" {
+		t.Errorf("ASCII with Latin-1 fallback returned %q", s)
+	}
+	if s := latin1.ASCII(); s != "Ren\xE9e" {
+		t.Errorf("ASCII with default policy returned %q", s)
+	}
+}
@@ -0,0 +1,108 @@
+package tiff66
+
+import (
+	"encoding/binary"
+)
+
+// Interface for decoding and encoding IFDs in a tag namespace defined
+// outside this package, such as a maker note format. It's wrapped in a
+// CustomSpaceRec to be used as the SpaceRec of a node. Positions are
+// relative to the start of the TIFF header.
+type CustomSpace interface {
+	GetSpace() TagSpace
+	IsMakerNote() bool
+	// Decode the IFD at pos into node. The node's Order is
+	// initially that of the parent IFD. Standard IFD tables can be
+	// decoded with CustomSource.GetIFD.
+	GetIFD(node *IFDNode, src *CustomSource, pos uint32) error
+	// Return any sub-IFDs that a field refers to, as its IFD is
+	// decoded by CustomSource.GetIFD.
+	TakeField(src *CustomSource, order binary.ByteOrder, field Field) ([]SubIFD, error)
+	// Return the size of a node when encoded, including its field
+	// data and image data, but not its sub-IFDs or next IFD.
+	Size(node IFDNode) uint64
+	// Encode a node and its sub-IFDs at pos in buf, returning the
+	// position following them. Standard IFD tables can be encoded
+	// with IFDNode.PutGenericIFD.
+	PutIFD(node IFDNode, buf []byte, pos uint32) (uint32, error)
+	// Return the node's image data, which is written after the
+	// IFD's fields.
+	GetImageData() []ImageData
+}
+
+// SpaceRec that calls a CustomSpace.
+type CustomSpaceRec struct {
+	Custom CustomSpace
+}
+
+func (rec *CustomSpaceRec) GetSpace() TagSpace {
+	return rec.Custom.GetSpace()
+}
+
+func (rec *CustomSpaceRec) IsMakerNote() bool {
+	return rec.Custom.IsMakerNote()
+}
+
+func (rec *CustomSpaceRec) nodeSize(node IFDNode) uint64 {
+	return rec.Custom.Size(node)
+}
+
+func (rec *CustomSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	return rec.Custom.TakeField(&CustomSource{src, ifdPositions}, order, field)
+}
+
+func (rec *CustomSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return rec.Custom.GetIFD(node, &CustomSource{src, ifdPositions}, pos)
+}
+
+func (rec *CustomSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetFooter(src, pos, rec.Custom.GetSpace(), ifdPositions)
+}
+
+func (rec *CustomSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	return rec.Custom.PutIFD(node, buf, pos)
+}
+
+func (rec *CustomSpaceRec) GetImageData() []ImageData {
+	return rec.Custom.GetImageData()
+}
+
+// The input being decoded, as seen by a CustomSpace.
+type CustomSource struct {
+	src          source
+	ifdPositions posMap
+}
+
+// Return size bytes of input data at pos.
+func (cs *CustomSource) Data(pos, size uint32) ([]byte, error) {
+	return cs.src.data(pos, size)
+}
+
+// Return the length of the input.
+func (cs *CustomSource) Len() uint32 {
+	return cs.src.len()
+}
+
+// Decode a standard IFD table at pos into node, calling the node's
+// CustomSpace for each field. Any next IFD is decoded in the same
+// space.
+func (cs *CustomSource) GetIFD(node *IFDNode, pos uint32) error {
+	return node.genericGetIFDTreeIter(cs.src, pos, cs.ifdPositions)
+}
+
+// Decode an IFD tree at pos, with its root in a given space.
+func (cs *CustomSource) GetIFDTree(order binary.ByteOrder, pos uint32, space TagSpace) (*IFDNode, error) {
+	return getIFDTreeIter(cs.src, order, pos, NewSpaceRec(space), cs.ifdPositions)
+}
+
+// Return the size of a node encoded as a standard IFD, including its
+// field data and image data, but not its sub-IFDs or next IFD.
+func (node IFDNode) GenericSize() uint64 {
+	return node.genericSize()
+}
+
+// Encode a node as a standard IFD at pos in buf, followed by its
+// sub-IFDs and next IFD, returning the position following them.
+func (node IFDNode) PutGenericIFD(buf []byte, pos uint32) (uint32, error) {
+	return node.genericPutIFDTree(buf, pos)
+}
@@ -0,0 +1,109 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// A maker note format with a label followed by a standard IFD.
+type testCustomSpace struct {
+	space TagSpace
+}
+
+var testCustomLabel = []byte("PRIV")
+
+func (c *testCustomSpace) GetSpace() TagSpace {
+	return c.space
+}
+
+func (*testCustomSpace) IsMakerNote() bool {
+	return true
+}
+
+func (*testCustomSpace) GetIFD(node *IFDNode, src *CustomSource, pos uint32) error {
+	label, err := src.Data(pos, uint32(len(testCustomLabel)))
+	if err != nil || string(label) != string(testCustomLabel) {
+		return errors.New("Label not found")
+	}
+	return src.GetIFD(node, pos+uint32(len(testCustomLabel)))
+}
+
+func (*testCustomSpace) TakeField(src *CustomSource, order binary.ByteOrder, field Field) ([]SubIFD, error) {
+	return nil, nil
+}
+
+func (*testCustomSpace) Size(node IFDNode) uint64 {
+	return uint64(len(testCustomLabel)) + node.GenericSize()
+}
+
+func (*testCustomSpace) PutIFD(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	copy(buf[pos:], testCustomLabel)
+	return node.PutGenericIFD(buf, pos+uint32(len(testCustomLabel)))
+}
+
+func (*testCustomSpace) GetImageData() []ImageData {
+	return nil
+}
+
+// Register a tag space with a CustomSpace and use it for a maker note
+// format, and check that the maker note is decoded and repacked.
+func TestCustomSpace(t *testing.T) {
+	var space TagSpace
+	space, err := RegisterTagSpace(TagSpaceDef{"CustomSpace test", map[Tag]string{1: "One"}, false, func() SpaceRec {
+		return &CustomSpaceRec{&testCustomSpace{space}}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterMakerNote(func(buf []byte, make, model string) bool {
+		return len(buf) >= len(testCustomLabel) && string(buf[:len(testCustomLabel)]) == string(testCustomLabel)
+	}, func() SpaceRec {
+		return NewSpaceRec(space)
+	})
+	order := binary.LittleEndian
+	maker := NewIFDNode(space)
+	maker.Order = order
+	maker.SetASCII(1, "Custom value")
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = order
+	exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+	exif.SubIFDs = []SubIFD{{makerNote, maker}}
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.SetASCII(Make, "CustomSpace test camera")
+	if err := root.SetExifIFD(exif); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getroot.SubIFDs) != 1 || len(getroot.SubIFDs[0].Node.SubIFDs) != 1 {
+		t.Fatal("Maker note not found")
+	}
+	getmaker := getroot.SubIFDs[0].Node.SubIFDs[0].Node
+	if getmaker.GetSpace() != space {
+		t.Fatalf("Maker note decoded in %s space", getmaker.GetSpace().Name())
+	}
+	if _, ok := getmaker.SpaceRec.(*CustomSpaceRec); !ok {
+		t.Error("Maker note doesn't have a CustomSpaceRec")
+	}
+	if s, _ := getmaker.GetASCII(1); s != "Custom value" {
+		t.Errorf("Field decoded as %q", s)
+	}
+	out := make([]byte, HeaderSize+getroot.TreeSize())
+	PutHeader(out, order, HeaderSize)
+	if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(buf) {
+		t.Error("Repacked maker note differs from original")
+	}
+}
@@ -101,3 +101,49 @@ func TestData(t *testing.T) {
 	doOrder(t, binary.BigEndian)
 	doOrder(t, binary.LittleEndian)
 }
+
+// Test the Value and SetValue functions.
+func TestValue(t *testing.T) {
+	order := binary.LittleEndian
+	long := Field{ImageWidth, LONG, 2, make([]byte, 8)}
+	if err := long.SetValue(Value{Type: SHORT, Int: 640}, 1, order); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := long.Value(1, order); !ok || val.Type != LONG || val.Int != 640 {
+		t.Error("LONG value not set")
+	}
+	if err := long.SetValue(Value{Type: RATIONAL, Num: 1, Denom: 2}, 0, order); err == nil {
+		t.Error("RATIONAL value stored in LONG field")
+	}
+	srat := Field{XResolution, SRATIONAL, 1, make([]byte, 8)}
+	if err := srat.SetValue(Value{Type: SRATIONAL, Num: -1, Denom: 3}, 0, order); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := srat.Value(0, order); val.Num != -1 || val.Denom != 3 {
+		t.Error("SRATIONAL value not set")
+	}
+	double := Field{XResolution, DOUBLE, 1, make([]byte, 8)}
+	if err := double.SetValue(Value{Type: FLOAT, Float: 0.5}, 0, order); err != nil {
+		t.Fatal(err)
+	}
+	if vals := double.Values(order); len(vals) != 1 || vals[0].Float != 0.5 {
+		t.Error("DOUBLE value not set")
+	}
+	ascii := Field{Make, ASCII, 0, nil}
+	ascii.PutASCII("Go")
+	ascii.Count = uint32(len(ascii.Data))
+	if vals := ascii.Values(order); len(vals) != 3 || vals[0].Int != 'G' || vals[2].Int != 0 {
+		t.Error("ASCII values incorrect")
+	}
+	unknown := Field{Make, Type(99), 0xFFFFFFFF, []byte{1, 2, 3, 4}}
+	if _, ok := unknown.Value(0, order); ok {
+		t.Error("Value of unknown type field returned")
+	}
+	if vals := unknown.Values(order); len(vals) != 0 {
+		t.Error("Values of unknown type field returned")
+	}
+	short := Field{ImageWidth, SHORT, 0xFFFFFFFF, []byte{1, 0, 2, 0}}
+	if vals := short.Values(order); len(vals) != 2 || vals[1].Int != 2 {
+		t.Error("Values not limited to the data present")
+	}
+}
@@ -0,0 +1,205 @@
+package tiff66
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of Exif date/time strings for the time package.
+const exifDateTimeLayout = "2006:01:02 15:04:05"
+
+// Return true if an Exif date/time string is empty or consists only
+// of blanks, separators and zeros, which indicates an unknown value.
+func exifTimeUnknown(s string) bool {
+	return strings.Trim(s, " :0") == ""
+}
+
+// Parse an Exif date/time string, with optional sub-second and offset
+// strings, as found in the DateTime, SubSecTime and OffsetTime fields
+// and their Original and Digitized variants. The date/time has the
+// form "YYYY:MM:DD HH:MM:SS", the sub-second string contains decimal
+// digits for the fraction of a second, and the offset has the form
+// "+HH:MM" or "-HH:MM". Empty strings, or strings with blanks in place
+// of the digits, are taken as missing. If there's no offset, the time
+// is interpreted in loc, which will be UTC if nil. The returned bool
+// indicates whether the offset was present.
+func ParseExifDateTime(datetime, subsec, offset string, loc *time.Location) (time.Time, bool, error) {
+	datetime = strings.TrimRight(datetime, " \000")
+	if exifTimeUnknown(datetime) {
+		return time.Time{}, false, errors.New("Date/time is unknown")
+	}
+	if len(datetime) == len(exifDateTimeLayout) {
+		// Some software uses dashes in the date.
+		datetime = strings.Replace(datetime[:10], "-", ":", -1) + datetime[10:]
+	}
+	hasZone := false
+	offset = strings.TrimRight(offset, " \000")
+	if strings.Trim(offset, " :") != "" {
+		zone, err := time.Parse("-07:00", offset)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("Invalid time offset %q", offset)
+		}
+		_, secs := zone.Zone()
+		loc = time.FixedZone("", secs)
+		hasZone = true
+	} else if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(exifDateTimeLayout, datetime, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("Invalid date/time %q", datetime)
+	}
+	subsec = strings.TrimRight(subsec, " \000")
+	if subsec != "" {
+		if len(subsec) > 9 {
+			subsec = subsec[:9]
+		}
+		frac, err := strconv.ParseUint(subsec, 10, 32)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("Invalid sub-second time %q", subsec)
+		}
+		for i := len(subsec); i < 9; i++ {
+			frac *= 10
+		}
+		t = t.Add(time.Duration(frac))
+	}
+	return t, hasZone, nil
+}
+
+// Format a time as Exif date/time, sub-second and offset strings,
+// suitable for ParseExifDateTime. The sub-second string is empty if
+// the time has no fractional second, and otherwise has up to 9 digits.
+func FormatExifDateTime(t time.Time) (datetime, subsec, offset string) {
+	datetime = t.Format(exifDateTimeLayout)
+	if t.Nanosecond() != 0 {
+		subsec = strings.TrimRight(fmt.Sprintf("%09d", t.Nanosecond()), "0")
+	}
+	offset = t.Format("-07:00")
+	return
+}
+
+// Return the tags of the sub-second and offset fields corresponding to
+// a date/time field.
+func dateTimeTags(tag Tag) (Tag, Tag, error) {
+	switch tag {
+	case DateTime:
+		return SubSecTime, OffsetTime, nil
+	case DateTimeOriginal:
+		return SubSecTimeOriginal, OffsetTimeOriginal, nil
+	case DateTimeDigitized:
+		return SubSecTimeDigitized, OffsetTimeDigitized, nil
+	}
+	return 0, 0, fmt.Errorf("Tag %d(0x%X) isn't a date/time tag", tag, tag)
+}
+
+// Return the Exif IFD linked from a TIFF IFD, or nil.
+func (node IFDNode) exifIFD() *IFDNode {
+	for _, sub := range node.SubIFDs {
+		if sub.Tag == ExifIFD && sub.Node.GetSpace() == ExifSpace {
+			return sub.Node
+		}
+	}
+	return nil
+}
+
+// Return the string value of an ASCII field in a node, or an empty
+// string if not found.
+func (node *IFDNode) asciiField(tag Tag) string {
+	if node == nil {
+		return ""
+	}
+	fields := node.FindFields([]Tag{tag})
+	if len(fields) == 0 || fields[0].Type != ASCII {
+		return ""
+	}
+	return fields[0].ASCII()
+}
+
+// Return the time from a date/time field in a TIFF tree, which is one
+// of DateTime, DateTimeOriginal or DateTimeDigitized, combined with
+// the corresponding sub-second and offset fields in the Exif IFD, if
+// present. DateTime is found in the root IFD and the others in the
+// Exif IFD. If there's no offset field, the time is interpreted in
+// loc, which will be UTC if nil. The returned bool indicates whether
+// the offset was present.
+func (node *IFDNode) GetDateTime(tag Tag, loc *time.Location) (time.Time, bool, error) {
+	subsecTag, offsetTag, err := dateTimeTags(tag)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	exif := node.exifIFD()
+	dtNode := exif
+	if tag == DateTime {
+		dtNode = node
+	}
+	if dtNode == nil || len(dtNode.FindFields([]Tag{tag})) == 0 {
+		return time.Time{}, false, fmt.Errorf("Date/time field %d(0x%X) not found", tag, tag)
+	}
+	return ParseExifDateTime(dtNode.asciiField(tag), exif.asciiField(subsecTag), exif.asciiField(offsetTag), loc)
+}
+
+// Set a date/time field in a TIFF tree, as for GetDateTime, and the
+// corresponding sub-second field. The sub-second field is deleted if
+// the time has no fractional second. If withZone is true, the offset
+// field is also set, otherwise it's deleted. The tree must already
+// have an Exif IFD, unless tag is DateTime and the time has no
+// fractional second or zone to record.
+func (node *IFDNode) SetDateTime(tag Tag, t time.Time, withZone bool) error {
+	subsecTag, offsetTag, err := dateTimeTags(tag)
+	if err != nil {
+		return err
+	}
+	datetime, subsec, offset := FormatExifDateTime(t)
+	exif := node.exifIFD()
+	if exif == nil && (tag != DateTime || subsec != "" || withZone) {
+		return errors.New("Tree has no Exif IFD")
+	}
+	if tag == DateTime {
+		node.SetASCII(tag, datetime)
+	} else {
+		exif.SetASCII(tag, datetime)
+	}
+	if exif == nil {
+		return nil
+	}
+	if subsec != "" {
+		exif.SetASCII(subsecTag, subsec)
+	} else {
+		exif.DeleteFields([]Tag{subsecTag})
+	}
+	if withZone {
+		exif.SetASCII(offsetTag, offset)
+	} else {
+		exif.DeleteFields([]Tag{offsetTag})
+	}
+	return nil
+}
+
+// Return the time an image was captured, from the DateTimeOriginal,
+// SubSecTimeOriginal and OffsetTimeOriginal fields of a TIFF tree's
+// Exif IFD, as for GetDateTime.
+func (node *IFDNode) GetCaptureTime(loc *time.Location) (time.Time, bool, error) {
+	return node.GetDateTime(DateTimeOriginal, loc)
+}
+
+// Set the time an image was captured in a TIFF tree: DateTimeOriginal
+// and its sub-second and offset fields, as for SetDateTime, and also
+// DateTime and its sub-second and offset fields, so that they stay
+// consistent. An Exif IFD is created if the tree doesn't have one.
+func (node *IFDNode) SetCaptureTime(t time.Time, withZone bool) error {
+	if node.exifIFD() == nil {
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = node.Order
+		exif.AddField(Field{ExifVersion, UNDEFINED, 4, []byte("0232")}, true)
+		if err := node.SetExifIFD(exif); err != nil {
+			return err
+		}
+	}
+	if err := node.SetDateTime(DateTimeOriginal, t, withZone); err != nil {
+		return err
+	}
+	return node.SetDateTime(DateTime, t, withZone)
+}
@@ -0,0 +1,118 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestParseExifDateTime(t *testing.T) {
+	tokyo := time.FixedZone("", 9*3600)
+	tests := []struct {
+		datetime, subsec, offset string
+		expected                 time.Time
+		hasZone                  bool
+	}{
+		{"2019:05:06 07:08:09", "", "", time.Date(2019, 5, 6, 7, 8, 9, 0, time.UTC), false},
+		{"2019:05:06 07:08:09", "25", "+09:00", time.Date(2019, 5, 6, 7, 8, 9, 250000000, tokyo), true},
+		{"2019-05-06 07:08:09", "123456789123", "+00:00", time.Date(2019, 5, 6, 7, 8, 9, 123456789, time.UTC), true},
+		{"2019:05:06 07:08:09 ", "5  ", "   :  ", time.Date(2019, 5, 6, 7, 8, 9, 500000000, time.UTC), false},
+	}
+	for _, test := range tests {
+		got, hasZone, err := ParseExifDateTime(test.datetime, test.subsec, test.offset, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.datetime, err)
+			continue
+		}
+		if !got.Equal(test.expected) || hasZone != test.hasZone {
+			t.Errorf("%q %q %q: got %v %v", test.datetime, test.subsec, test.offset, got, hasZone)
+		}
+	}
+	for _, bad := range []string{"", "    :  :     :  :  ", "0000:00:00 00:00:00", "2019:13:06 07:08:09"} {
+		if _, _, err := ParseExifDateTime(bad, "", "", nil); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	datetime, subsec, offset := FormatExifDateTime(time.Date(2019, 5, 6, 7, 8, 9, 250000000, tokyo))
+	if datetime != "2019:05:06 07:08:09" || subsec != "25" || offset != "+09:00" {
+		t.Errorf("FormatExifDateTime returned %q %q %q", datetime, subsec, offset)
+	}
+}
+
+func TestDateTimeFields(t *testing.T) {
+	exif := NewIFDNode(ExifSpace)
+	root := NewIFDNode(TIFFSpace)
+	root.Fields = []Field{{ExifIFD, LONG, 1, []byte{0, 0, 0, 0}}}
+	root.SubIFDs = []SubIFD{{ExifIFD, exif}}
+	zone := time.FixedZone("", -5*3600)
+	tm := time.Date(2020, 1, 2, 3, 4, 5, 600000000, zone)
+	if err := root.SetDateTime(DateTimeOriginal, tm, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.SetDateTime(DateTime, tm, false); err != nil {
+		t.Fatal(err)
+	}
+	if f := exif.FindFields([]Tag{OffsetTimeOriginal}); len(f) != 1 || f[0].ASCII() != "-05:00" || f[0].Count != 7 {
+		t.Error("OffsetTimeOriginal not set")
+	}
+	got, hasZone, err := root.GetDateTime(DateTimeOriginal, nil)
+	if err != nil || !got.Equal(tm) || !hasZone {
+		t.Errorf("DateTimeOriginal: %v %v %v", got, hasZone, err)
+	}
+	got, hasZone, err = root.GetDateTime(DateTime, zone)
+	if err != nil || !got.Equal(tm) || hasZone {
+		t.Errorf("DateTime: %v %v %v", got, hasZone, err)
+	}
+	if _, _, err := root.GetDateTime(DateTimeDigitized, nil); err == nil {
+		t.Error("Missing DateTimeDigitized didn't cause an error")
+	}
+}
+
+func TestCaptureTime(t *testing.T) {
+	root := NewIFDNode(TIFFSpace)
+	root.Order = binary.BigEndian
+	zone := time.FixedZone("", 10*3600)
+	tm := time.Date(2021, 6, 7, 8, 9, 10, 250000000, zone)
+	if err := root.SetCaptureTime(tm, true); err != nil {
+		t.Fatal(err)
+	}
+	exif := root.exifIFD()
+	if exif == nil {
+		t.Fatal("Exif IFD not created")
+	}
+	for _, tag := range []Tag{DateTimeOriginal, SubSecTimeOriginal, OffsetTimeOriginal, SubSecTime, OffsetTime} {
+		if _, found := exif.FindField(tag); !found {
+			t.Errorf("Field %d(0x%X) not set", tag, tag)
+		}
+	}
+	got, hasZone, err := root.GetCaptureTime(nil)
+	if err != nil || !got.Equal(tm) || !hasZone {
+		t.Errorf("Capture time: %v %v %v", got, hasZone, err)
+	}
+	if got, _, err = root.GetDateTime(DateTime, nil); err != nil || !got.Equal(tm) {
+		t.Errorf("DateTime: %v %v", got, err)
+	}
+
+	// Changing the time reuses the Exif IFD and removes fields that
+	// no longer apply.
+	tm = time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+	if err := root.SetCaptureTime(tm, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.SubIFDs) != 1 || root.exifIFD() != exif {
+		t.Error("Exif IFD replaced")
+	}
+	if _, found := exif.FindField(SubSecTimeOriginal); found {
+		t.Error("SubSecTimeOriginal not deleted")
+	}
+	if s, _ := root.GetASCII(DateTime); s != "2021:06:07 09:00:00" {
+		t.Errorf("DateTime is %q", s)
+	}
+	if got, hasZone, err = root.GetCaptureTime(time.UTC); err != nil || !got.Equal(tm) || hasZone {
+		t.Errorf("Capture time: %v %v %v", got, hasZone, err)
+	}
+	root.Fix()
+	if err := root.Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,31 @@
+package tiff66
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+)
+
+// Decompress data with the zlib format used in TIFF image data with
+// Deflate compression (Compression 8 or 32946). Output beyond limit
+// bytes, which is normally the uncompressed size of the strip or tile,
+// is discarded, so that a small segment can't expand without bound.
+func DeflateDecode(data []byte, limit uint32) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(io.LimitReader(r, int64(limit)))
+}
+
+// Compress data with the zlib format, as decoded by DeflateDecode.
+func DeflateEncode(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	// Writes to a bytes.Buffer don't fail.
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,90 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestDeflate(t *testing.T) {
+	data := bytes.Repeat([]byte("deflate"), 1000)
+	dec, err := DeflateDecode(DeflateEncode(data), uint32(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, data) {
+		t.Error("Deflate round trip failed")
+	}
+	if dec, err := DeflateDecode(DeflateEncode(data), 10); err != nil || len(dec) != 10 {
+		t.Errorf("Deflate output not limited: %d bytes, %v", len(dec), err)
+	}
+	if _, err := DeflateDecode([]byte{1, 2, 3}, 100); err == nil {
+		t.Error("Invalid data didn't cause an error")
+	}
+}
+
+func TestPredictor(t *testing.T) {
+	be := binary.BigEndian
+	for _, compression := range []uint16{CompressionNone, CompressionLZW, CompressionDeflate, CompressionDeflateOld} {
+		// 16 bit RGB with horizontal prediction.
+		node := imageNode(be, []Field{
+			shortField(ImageWidth, be, 3),
+			shortField(ImageLength, be, 2),
+			shortField(BitsPerSample, be, 16, 16, 16),
+			shortField(Compression, be, compression),
+			shortField(PhotometricInterpretation, be, PhotometricRGB),
+			shortField(SamplesPerPixel, be, 3),
+			shortField(Predictor, be, PredictorHorizontal),
+		}, StripOffsets, StripByteCounts, nil)
+		pixels := make([]byte, 3*2*3*2)
+		for i := 0; i < len(pixels); i += 2 {
+			be.PutUint16(pixels[i:], uint16(i*1000))
+		}
+		segment, err := node.EncodeSegment(pixels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compression == CompressionNone && be.Uint16(segment[6:]) != 6000 {
+			t.Errorf("Wrong differenced sample %d", be.Uint16(segment[6:]))
+		}
+		node.SpaceRec.(*TIFFSpaceRec).imageData[0].Segments = []ImageSegment{segment}
+		decoded, err := node.DecodeSegment(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, pixels) {
+			t.Errorf("Predictor round trip failed with compression %d", compression)
+		}
+		img, err := node.DecodeImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := img.(*image.RGBA64).RGBA64At(2, 1); c.R != 30000 || c.G != 32000 || c.B != 34000 {
+			t.Errorf("Wrong RGB pixel %v", c)
+		}
+	}
+}
+
+// A tile width whose row size wraps in 32 bits is rejected instead of
+// looping forever when the prediction is reversed.
+func TestPredictorRowOverflow(t *testing.T) {
+	le := binary.LittleEndian
+	node := imageNode(le, []Field{
+		longField(ImageWidth, le, 16),
+		longField(ImageLength, le, 16),
+		shortField(BitsPerSample, le, 8, 8, 8, 8, 8, 8, 8, 8),
+		shortField(PhotometricInterpretation, le, PhotometricRGB),
+		shortField(SamplesPerPixel, le, 8),
+		shortField(Predictor, le, PredictorHorizontal),
+		longField(TileWidth, le, 1<<29),
+		longField(TileLength, le, 1),
+	}, TileOffsets, TileByteCounts, []ImageSegment{make(ImageSegment, 16)})
+	node.Fix()
+	if _, err := node.DecodeSegment(make(ImageSegment, 16)); err == nil {
+		t.Error("Tile row size overflow not detected")
+	}
+	if _, err := node.DecodeImage(); err == nil {
+		t.Error("Tile row size overflow not detected")
+	}
+}
@@ -0,0 +1,197 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Kind of a difference between two IFDNode trees.
+type DiffKind uint8
+
+const (
+	DiffAdded   DiffKind = 0 // Present only in the second tree.
+	DiffRemoved DiffKind = 1 // Present only in the first tree.
+	DiffChanged DiffKind = 2 // Present in both, with different types or values.
+)
+
+// Return the name of a difference kind.
+func (kind DiffKind) String() string {
+	switch kind {
+	case DiffAdded:
+		return "Added"
+	case DiffRemoved:
+		return "Removed"
+	case DiffChanged:
+		return "Changed"
+	}
+	return "Unknown"
+}
+
+// A difference between two IFDNode trees, as returned by DiffTrees.
+// For an IFD found in only one of the trees, Tag is 0 and Old and New
+// are nil. Otherwise it's a field difference, and Old or New is nil if
+// the field was added or removed.
+type TreeDiff struct {
+	Path  string   // Location of the IFD, as returned by NodePaths.
+	Space TagSpace // Tag space of the IFD.
+	Kind  DiffKind
+	Tag   Tag
+	Old   *Field // Field in the first tree.
+	New   *Field // Field in the second tree.
+	// Byte orders of the IFDs containing Old and New.
+	OldOrder, NewOrder binary.ByteOrder
+}
+
+// Return the locations of the nodes in a tree, such as "IFD0",
+// "IFD0/Exif", "IFD0/Exif/Canon1" and "IFD1", in the order visited by
+// Walk. A node in a Next chain following a sub-IFD has its position in
+// the chain appended, e.g., "IFD0/TIFF1", and a sub-IFD that isn't the
+// first with its tag has its index appended in brackets, e.g.,
+// "IFD0/TIFF[1]".
+func NodePaths(root *IFDNode) ([]string, map[string]*IFDNode) {
+	var paths []string
+	nodes := make(map[string]*IFDNode)
+	var visit func(node *IFDNode, prefix string, top bool)
+	visit = func(node *IFDNode, prefix string, top bool) {
+		for i := 0; node != nil; i, node = i+1, node.Next {
+			var path string
+			switch {
+			case top:
+				path = fmt.Sprintf("IFD%d", i)
+			case i == 0:
+				path = prefix
+			default:
+				path = fmt.Sprintf("%s%d", prefix, i)
+			}
+			paths = append(paths, path)
+			nodes[path] = node
+			counts := make(map[Tag]int)
+			for _, sub := range node.SubIFDs {
+				subPath := path + "/" + sub.Node.GetSpace().Name()
+				if n := counts[sub.Tag]; n > 0 {
+					subPath = fmt.Sprintf("%s[%d]", subPath, n)
+				}
+				counts[sub.Tag]++
+				visit(sub.Node, subPath, false)
+			}
+		}
+	}
+	visit(root, "", true)
+	return paths, nodes
+}
+
+// Return the data of a field converted to big-endian order, for
+// comparison with fields from IFDs in other orders.
+func comparableData(f Field, order binary.ByteOrder) []byte {
+	data := f.Data
+	if size := f.Size(); uint32(len(data)) > size {
+		data = data[:size]
+	}
+	if order == binary.LittleEndian {
+		return swapUnits(data, swapSize(f.Type))
+	}
+	return data
+}
+
+// Return the concatenated segments of the image data of a node with a
+// given offset tag, and whether the node has such image data. Segments
+// that haven't been loaded are read from their source, and if that
+// fails the data is treated as not found.
+func imageDataContents(node *IFDNode, tag Tag) ([]byte, bool) {
+	for _, id := range node.GetImageData() {
+		if id.OffsetTag == tag {
+			var data bytes.Buffer
+			if err := id.Copy(&data); err != nil {
+				return nil, false
+			}
+			return data.Bytes(), true
+		}
+	}
+	return nil, false
+}
+
+// Indicate if two fields with the same tag differ. Fields that point
+// to image data are compared by the contents of the data, and fields
+// that point to sub-IFDs aren't compared, since their values are
+// positions that aren't expected to match.
+func fieldsDiffer(oldNode, newNode *IFDNode, oldField, newField Field) bool {
+	if oldData, ok := imageDataContents(oldNode, oldField.Tag); ok {
+		newData, ok := imageDataContents(newNode, newField.Tag)
+		return !ok || !bytes.Equal(oldData, newData)
+	}
+	if oldNode.nthSubIFD(oldField.Tag, 0) >= 0 && newNode.nthSubIFD(newField.Tag, 0) >= 0 {
+		return false
+	}
+	if oldField.Type != newField.Type || oldField.Count != newField.Count {
+		return true
+	}
+	return !bytes.Equal(comparableData(oldField, oldNode.Order), comparableData(newField, newNode.Order))
+}
+
+// Compare two IFDNode trees, returning the IFDs found in only one of
+// them, and the fields added, removed or changed in the IFDs found in
+// both. IFDs are matched by their locations, as given by NodePaths, and
+// fields by tag. Field values are compared independently of the byte
+// order of the IFDs. The differences are sorted by IFD, in the order
+// the IFDs are visited by Walk, then by tag.
+func DiffTrees(oldRoot, newRoot *IFDNode) []TreeDiff {
+	oldPaths, oldNodes := NodePaths(oldRoot)
+	newPaths, newNodes := NodePaths(newRoot)
+	var diffs []TreeDiff
+	for _, path := range oldPaths {
+		if _, found := newNodes[path]; !found {
+			diffs = append(diffs, TreeDiff{Path: path, Space: oldNodes[path].GetSpace(), Kind: DiffRemoved})
+		}
+	}
+	for _, path := range newPaths {
+		newNode := newNodes[path]
+		oldNode, found := oldNodes[path]
+		if !found {
+			diffs = append(diffs, TreeDiff{Path: path, Space: newNode.GetSpace(), Kind: DiffAdded})
+			continue
+		}
+		if oldNode.GetSpace() != newNode.GetSpace() {
+			diffs = append(diffs, TreeDiff{Path: path, Space: oldNode.GetSpace(), Kind: DiffRemoved})
+			diffs = append(diffs, TreeDiff{Path: path, Space: newNode.GetSpace(), Kind: DiffAdded})
+			continue
+		}
+		diff := TreeDiff{Path: path, Space: newNode.GetSpace(), OldOrder: oldNode.Order, NewOrder: newNode.Order}
+		for i := range oldNode.Fields {
+			old := &oldNode.Fields[i]
+			diff.Tag, diff.Old, diff.New = old.Tag, old, nil
+			if fields := newNode.FindFields([]Tag{old.Tag}); len(fields) == 0 {
+				diff.Kind = DiffRemoved
+				diffs = append(diffs, diff)
+			} else if fieldsDiffer(oldNode, newNode, *old, *fields[0]) {
+				diff.Kind = DiffChanged
+				diff.New = fields[0]
+				diffs = append(diffs, diff)
+			}
+		}
+		for i := range newNode.Fields {
+			added := &newNode.Fields[i]
+			if len(oldNode.FindFields([]Tag{added.Tag})) == 0 {
+				diff.Tag, diff.Old, diff.New = added.Tag, nil, added
+				diff.Kind = DiffAdded
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+	// Sort IFDs in the order they're found in the first tree, then
+	// the second.
+	order := make(map[string]int)
+	for _, path := range append(oldPaths, newPaths...) {
+		if _, found := order[path]; !found {
+			order[path] = len(order)
+		}
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		if diffs[i].Path != diffs[j].Path {
+			return order[diffs[i].Path] < order[diffs[j].Path]
+		}
+		return diffs[i].Tag < diffs[j].Tag
+	})
+	return diffs
+}
@@ -0,0 +1,61 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestDiffTrees(t *testing.T) {
+	build := func(order binary.ByteOrder, artist string, exposure uint32, gps bool) *IFDNode {
+		root := NewIFDNode(TIFFSpace)
+		root.Order = order
+		root.SetASCII(Artist, artist)
+		root.SetShorts(Orientation, []uint16{1})
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = order
+		exif.SetRationals(ExposureTime, [][2]uint32{{1, exposure}})
+		root.SetExifIFD(exif)
+		if gps {
+			g := NewIFDNode(GPSSpace)
+			g.Order = order
+			g.SetBytes(GPSVersionID, []byte{2, 3, 0, 0})
+			root.SetGPSIFD(g)
+		}
+		return root
+	}
+	paths, nodes := NodePaths(build(binary.BigEndian, "Someone", 250, true))
+	if !reflect.DeepEqual(paths, []string{"IFD0", "IFD0/Exif", "IFD0/GPS"}) || nodes["IFD0/GPS"].GetSpace() != GPSSpace {
+		t.Errorf("Node paths are %q", paths)
+	}
+
+	// The same values in a different byte order.
+	if diffs := DiffTrees(build(binary.BigEndian, "Someone", 250, true), build(binary.LittleEndian, "Someone", 250, true)); len(diffs) != 0 {
+		t.Errorf("Identical trees have differences %+v", diffs)
+	}
+
+	oldRoot := build(binary.BigEndian, "Someone", 250, true)
+	newRoot := build(binary.LittleEndian, "Someone else", 125, false)
+	newRoot.DeleteFields([]Tag{Orientation})
+	newRoot.SetASCII(Software, "Something")
+	type summary struct {
+		path string
+		kind DiffKind
+		tag  Tag
+	}
+	var got []summary
+	for _, d := range DiffTrees(oldRoot, newRoot) {
+		got = append(got, summary{d.Path, d.Kind, d.Tag})
+	}
+	expected := []summary{
+		{"IFD0", DiffRemoved, Orientation},
+		{"IFD0", DiffAdded, Software},
+		{"IFD0", DiffChanged, Artist},
+		{"IFD0", DiffRemoved, GPSIFD},
+		{"IFD0/Exif", DiffChanged, ExposureTime},
+		{"IFD0/GPS", DiffRemoved, 0},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Differences are %v", got)
+	}
+}
@@ -0,0 +1,328 @@
+package tiff66
+
+import (
+	"encoding/binary"
+)
+
+// Tags defined in the Adobe Digital Negative (DNG) specification,
+// version 1.5 unless otherwise specified. They are found in the main
+// IFD (IFD 0) and in the raw and preview IFDs of DNG files.
+const (
+	DNGVersion                   = 0xC612
+	DNGBackwardVersion           = 0xC613
+	UniqueCameraModel            = 0xC614
+	LocalizedCameraModel         = 0xC615
+	CFAPlaneColor                = 0xC616
+	CFALayout                    = 0xC617
+	LinearizationTable           = 0xC618
+	BlackLevelRepeatDim          = 0xC619
+	BlackLevel                   = 0xC61A
+	BlackLevelDeltaH             = 0xC61B
+	BlackLevelDeltaV             = 0xC61C
+	WhiteLevel                   = 0xC61D
+	DefaultScale                 = 0xC61E
+	DefaultCropOrigin            = 0xC61F
+	DefaultCropSize              = 0xC620
+	ColorMatrix1                 = 0xC621
+	ColorMatrix2                 = 0xC622
+	CameraCalibration1           = 0xC623
+	CameraCalibration2           = 0xC624
+	ReductionMatrix1             = 0xC625
+	ReductionMatrix2             = 0xC626
+	AnalogBalance                = 0xC627
+	AsShotNeutral                = 0xC628
+	AsShotWhiteXY                = 0xC629
+	BaselineExposure             = 0xC62A
+	BaselineNoise                = 0xC62B
+	BaselineSharpness            = 0xC62C
+	BayerGreenSplit              = 0xC62D
+	LinearResponseLimit          = 0xC62E
+	CameraSerialNumber           = 0xC62F
+	LensInfo                     = 0xC630
+	ChromaBlurRadius             = 0xC631
+	AntiAliasStrength            = 0xC632
+	ShadowScale                  = 0xC633
+	DNGPrivateData               = 0xC634
+	MakerNoteSafety              = 0xC635
+	CalibrationIlluminant1       = 0xC65A
+	CalibrationIlluminant2       = 0xC65B
+	BestQualityScale             = 0xC65C
+	RawDataUniqueID              = 0xC65D
+	OriginalRawFileName          = 0xC68B
+	OriginalRawFileData          = 0xC68C
+	ActiveArea                   = 0xC68D
+	MaskedAreas                  = 0xC68E
+	AsShotICCProfile             = 0xC68F
+	AsShotPreProfileMatrix       = 0xC690
+	CurrentICCProfile            = 0xC691
+	CurrentPreProfileMatrix      = 0xC692
+	ColorimetricReference        = 0xC6BF
+	CameraCalibrationSignature   = 0xC6F3
+	ProfileCalibrationSignature  = 0xC6F4
+	ExtraCameraProfiles          = 0xC6F5
+	AsShotProfileName            = 0xC6F6
+	NoiseReductionApplied        = 0xC6F7
+	ProfileName                  = 0xC6F8
+	ProfileHueSatMapDims         = 0xC6F9
+	ProfileHueSatMapData1        = 0xC6FA
+	ProfileHueSatMapData2        = 0xC6FB
+	ProfileToneCurve             = 0xC6FC
+	ProfileEmbedPolicy           = 0xC6FD
+	ProfileCopyright             = 0xC6FE
+	ForwardMatrix1               = 0xC714
+	ForwardMatrix2               = 0xC715
+	PreviewApplicationName       = 0xC716
+	PreviewApplicationVersion    = 0xC717
+	PreviewSettingsName          = 0xC718
+	PreviewSettingsDigest        = 0xC719
+	PreviewColorSpace            = 0xC71A
+	PreviewDateTime              = 0xC71B
+	RawImageDigest               = 0xC71C
+	OriginalRawFileDigest        = 0xC71D
+	SubTileBlockSize             = 0xC71E
+	RowInterleaveFactor          = 0xC71F
+	ProfileLookTableDims         = 0xC725
+	ProfileLookTableData         = 0xC726
+	OpcodeList1                  = 0xC740
+	OpcodeList2                  = 0xC741
+	OpcodeList3                  = 0xC74E
+	NoiseProfile                 = 0xC761
+	OriginalDefaultFinalSize     = 0xC791
+	OriginalBestQualityFinalSize = 0xC792
+	OriginalDefaultCropSize      = 0xC793
+	ProfileHueSatMapEncoding     = 0xC7A3
+	ProfileLookTableEncoding     = 0xC7A4
+	BaselineExposureOffset       = 0xC7A5
+	DefaultBlackRender           = 0xC7A6
+	NewRawImageDigest            = 0xC7A7
+	RawToPreviewGain             = 0xC7A8
+	DefaultUserCrop              = 0xC7B5
+	DepthFormat                  = 0xC7E9
+	DepthNear                    = 0xC7EA
+	DepthFar                     = 0xC7EB
+	DepthUnits                   = 0xC7EC
+	DepthMeasureType             = 0xC7ED
+	EnhanceParams                = 0xC7EE
+)
+
+// Mappings from DNG-specific tags to strings.
+var dngOnlyTagNames = map[Tag]string{
+	DNGVersion:                   "DNGVersion",
+	DNGBackwardVersion:           "DNGBackwardVersion",
+	UniqueCameraModel:            "UniqueCameraModel",
+	LocalizedCameraModel:         "LocalizedCameraModel",
+	CFAPlaneColor:                "CFAPlaneColor",
+	CFALayout:                    "CFALayout",
+	LinearizationTable:           "LinearizationTable",
+	BlackLevelRepeatDim:          "BlackLevelRepeatDim",
+	BlackLevel:                   "BlackLevel",
+	BlackLevelDeltaH:             "BlackLevelDeltaH",
+	BlackLevelDeltaV:             "BlackLevelDeltaV",
+	WhiteLevel:                   "WhiteLevel",
+	DefaultScale:                 "DefaultScale",
+	DefaultCropOrigin:            "DefaultCropOrigin",
+	DefaultCropSize:              "DefaultCropSize",
+	ColorMatrix1:                 "ColorMatrix1",
+	ColorMatrix2:                 "ColorMatrix2",
+	CameraCalibration1:           "CameraCalibration1",
+	CameraCalibration2:           "CameraCalibration2",
+	ReductionMatrix1:             "ReductionMatrix1",
+	ReductionMatrix2:             "ReductionMatrix2",
+	AnalogBalance:                "AnalogBalance",
+	AsShotNeutral:                "AsShotNeutral",
+	AsShotWhiteXY:                "AsShotWhiteXY",
+	BaselineExposure:             "BaselineExposure",
+	BaselineNoise:                "BaselineNoise",
+	BaselineSharpness:            "BaselineSharpness",
+	BayerGreenSplit:              "BayerGreenSplit",
+	LinearResponseLimit:          "LinearResponseLimit",
+	CameraSerialNumber:           "CameraSerialNumber",
+	LensInfo:                     "LensInfo",
+	ChromaBlurRadius:             "ChromaBlurRadius",
+	AntiAliasStrength:            "AntiAliasStrength",
+	ShadowScale:                  "ShadowScale",
+	DNGPrivateData:               "DNGPrivateData",
+	MakerNoteSafety:              "MakerNoteSafety",
+	CalibrationIlluminant1:       "CalibrationIlluminant1",
+	CalibrationIlluminant2:       "CalibrationIlluminant2",
+	BestQualityScale:             "BestQualityScale",
+	RawDataUniqueID:              "RawDataUniqueID",
+	OriginalRawFileName:          "OriginalRawFileName",
+	OriginalRawFileData:          "OriginalRawFileData",
+	ActiveArea:                   "ActiveArea",
+	MaskedAreas:                  "MaskedAreas",
+	AsShotICCProfile:             "AsShotICCProfile",
+	AsShotPreProfileMatrix:       "AsShotPreProfileMatrix",
+	CurrentICCProfile:            "CurrentICCProfile",
+	CurrentPreProfileMatrix:      "CurrentPreProfileMatrix",
+	ColorimetricReference:        "ColorimetricReference",
+	CameraCalibrationSignature:   "CameraCalibrationSignature",
+	ProfileCalibrationSignature:  "ProfileCalibrationSignature",
+	ExtraCameraProfiles:          "ExtraCameraProfiles",
+	AsShotProfileName:            "AsShotProfileName",
+	NoiseReductionApplied:        "NoiseReductionApplied",
+	ProfileName:                  "ProfileName",
+	ProfileHueSatMapDims:         "ProfileHueSatMapDims",
+	ProfileHueSatMapData1:        "ProfileHueSatMapData1",
+	ProfileHueSatMapData2:        "ProfileHueSatMapData2",
+	ProfileToneCurve:             "ProfileToneCurve",
+	ProfileEmbedPolicy:           "ProfileEmbedPolicy",
+	ProfileCopyright:             "ProfileCopyright",
+	ForwardMatrix1:               "ForwardMatrix1",
+	ForwardMatrix2:               "ForwardMatrix2",
+	PreviewApplicationName:       "PreviewApplicationName",
+	PreviewApplicationVersion:    "PreviewApplicationVersion",
+	PreviewSettingsName:          "PreviewSettingsName",
+	PreviewSettingsDigest:        "PreviewSettingsDigest",
+	PreviewColorSpace:            "PreviewColorSpace",
+	PreviewDateTime:              "PreviewDateTime",
+	RawImageDigest:               "RawImageDigest",
+	OriginalRawFileDigest:        "OriginalRawFileDigest",
+	SubTileBlockSize:             "SubTileBlockSize",
+	RowInterleaveFactor:          "RowInterleaveFactor",
+	ProfileLookTableDims:         "ProfileLookTableDims",
+	ProfileLookTableData:         "ProfileLookTableData",
+	OpcodeList1:                  "OpcodeList1",
+	OpcodeList2:                  "OpcodeList2",
+	OpcodeList3:                  "OpcodeList3",
+	NoiseProfile:                 "NoiseProfile",
+	OriginalDefaultFinalSize:     "OriginalDefaultFinalSize",
+	OriginalBestQualityFinalSize: "OriginalBestQualityFinalSize",
+	OriginalDefaultCropSize:      "OriginalDefaultCropSize",
+	ProfileHueSatMapEncoding:     "ProfileHueSatMapEncoding",
+	ProfileLookTableEncoding:     "ProfileLookTableEncoding",
+	BaselineExposureOffset:       "BaselineExposureOffset",
+	DefaultBlackRender:           "DefaultBlackRender",
+	NewRawImageDigest:            "NewRawImageDigest",
+	RawToPreviewGain:             "RawToPreviewGain",
+	DefaultUserCrop:              "DefaultUserCrop",
+	DepthFormat:                  "DepthFormat",
+	DepthNear:                    "DepthNear",
+	DepthFar:                     "DepthFar",
+	DepthUnits:                   "DepthUnits",
+	DepthMeasureType:             "DepthMeasureType",
+	EnhanceParams:                "EnhanceParams",
+}
+
+// Mappings from tags in DNG IFDs to strings. DNG IFDs are TIFF IFDs
+// with additional tags, so this includes all of TagNames.
+var DNGTagNames = mergeTagNames(TagNames, dngOnlyTagNames)
+
+// Return a new map containing the entries of all the given maps.
+func mergeTagNames(maps ...map[Tag]string) map[Tag]string {
+	merged := make(map[Tag]string)
+	for _, m := range maps {
+		for tag, name := range m {
+			merged[tag] = name
+		}
+	}
+	return merged
+}
+
+// Indicate if the IFD at pos contains a DNGVersion field, which
+// identifies IFD 0 of a DNG file.
+func hasDNGVersion(src source, order binary.ByteOrder, pos uint32) bool {
+	countData, err := src.data(pos, 2)
+	if err != nil {
+		return false
+	}
+	entries := uint32(order.Uint16(countData))
+	table, err := src.data(pos+2, entries*TableEntrySize)
+	if err != nil {
+		return false
+	}
+	for i := uint32(0); i < entries; i++ {
+		if order.Uint16(table[i*TableEntrySize:]) == DNGVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// SpaceRec for DNG nodes. DNG files are TIFF files in which IFD 0
+// contains a DNGVersion field. IFD 0 usually holds a reduced
+// resolution preview, and the raw image data and any other previews
+// are found in IFDs referred to by its SubIFDs field. These IFDs may
+// all contain DNG-specific tags, so are given DNGSpace. Otherwise
+// they are processed like TIFF nodes.
+type DNGSpaceRec struct {
+	tiff TIFFSpaceRec
+}
+
+func (*DNGSpaceRec) GetSpace() TagSpace {
+	return DNGSpace
+}
+
+func (*DNGSpaceRec) IsMakerNote() bool {
+	return false
+}
+
+func (*DNGSpaceRec) nodeSize(node IFDNode) uint64 {
+	return node.genericSize()
+}
+
+func (rec *DNGSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	// Raw and preview images.
+	if field.Type == IFD || field.Tag == SubIFDs {
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(DNGSpace))
+	}
+	// Everything else, including Exif and GPS IFDs and image
+	// data, is the same as TIFF.
+	return rec.tiff.takeField(src, order, ifdPositions, idx, field, dataPos)
+}
+
+func (*DNGSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
+}
+
+func (*DNGSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetFooter(src, pos, DNGSpace, ifdPositions)
+}
+
+func (*DNGSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	return node.genericPutIFDTree(buf, pos)
+}
+
+func (rec *DNGSpaceRec) GetImageData() []ImageData {
+	return rec.tiff.GetImageData()
+}
+
+// Return the DNG nodes among node, its SubIFDs and its Next chain,
+// recursively.
+func (node *IFDNode) dngNodes() []*IFDNode {
+	var nodes []*IFDNode
+	for ; node != nil; node = node.Next {
+		if node.GetSpace() != DNGSpace {
+			continue
+		}
+		nodes = append(nodes, node)
+		for _, sub := range node.SubIFDs {
+			nodes = append(nodes, sub.Node.dngNodes()...)
+		}
+	}
+	return nodes
+}
+
+// Return the IFD holding the main raw image in a DNG tree, which is
+// the DNG IFD with a NewSubfileType of 0, or nil if not found. It may
+// be IFD 0 itself or one of its SubIFDs.
+func (node *IFDNode) DNGRawIFD() *IFDNode {
+	for _, n := range node.dngNodes() {
+		if subType, _ := n.integerField(NewSubfileType); subType == 0 {
+			return n
+		}
+	}
+	return nil
+}
+
+// Return the IFDs holding preview images in a DNG tree, which are
+// those with the reduced resolution bit set in NewSubfileType.
+func (node *IFDNode) DNGPreviewIFDs() []*IFDNode {
+	var previews []*IFDNode
+	for _, n := range node.dngNodes() {
+		if subType, _ := n.integerField(NewSubfileType); subType&1 != 0 {
+			previews = append(previews, n)
+		}
+	}
+	return previews
+}
@@ -0,0 +1,62 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Create a DNG-like tree, with a preview in IFD 0 and the raw image in
+// a SubIFD, and check that it's decoded in DNG space.
+func TestDNG(t *testing.T) {
+	order := binary.LittleEndian
+	raw := []byte("raw sensor data")
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.Fields = []Field{
+		{NewSubfileType, LONG, 1, []byte{1, 0, 0, 0}},
+		{SubIFDs, LONG, 1, make([]byte, 4)},
+		{DNGVersion, BYTE, 4, []byte{1, 4, 0, 0}},
+	}
+	sub := NewIFDNode(TIFFSpace)
+	sub.Order = order
+	sub.Fields = []Field{
+		{NewSubfileType, LONG, 1, []byte{0, 0, 0, 0}},
+		{StripOffsets, LONG, 1, make([]byte, 4)},
+		{StripByteCounts, LONG, 1, make([]byte, 4)},
+		{ActiveArea, SHORT, 4, []byte{0, 0, 0, 0, 1, 0, 15, 0}},
+	}
+	sub.Fields[2].PutLong(uint32(len(raw)), 0, order)
+	sub.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{raw}}}}
+	root.SubIFDs = []SubIFD{{SubIFDs, sub}}
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getroot.GetSpace() != DNGSpace {
+		t.Error("IFD 0 not in DNG space")
+	}
+	rawIFD := getroot.DNGRawIFD()
+	if rawIFD == nil || len(getroot.SubIFDs) != 1 || rawIFD != getroot.SubIFDs[0].Node {
+		t.Fatal("Raw IFD not found")
+	}
+	if rawIFD.GetSpace() != DNGSpace {
+		t.Error("Raw IFD not in DNG space")
+	}
+	imageData := rawIFD.GetImageData()
+	if len(imageData) != 1 || string(imageData[0].Segments[0]) != string(raw) {
+		t.Error("Raw image data not found")
+	}
+	previews := getroot.DNGPreviewIFDs()
+	if len(previews) != 1 || previews[0] != getroot {
+		t.Error("Preview IFD not found")
+	}
+	if DNGTagNames[ActiveArea] != "ActiveArea" || DNGTagNames[ImageWidth] != "ImageWidth" {
+		t.Error("DNGTagNames incomplete")
+	}
+}
@@ -0,0 +1,252 @@
+package tiff66
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A multi-page TIFF file, as a list of page IFDs that are linked into
+// a Next chain when the file is written. Pages is ordinarily modified
+// with the Document methods, but may also be changed directly.
+type Document struct {
+	Pages []*IFDNode
+}
+
+// Create a Document from the root node of a TIFF tree and its Next
+// chain. The nodes are shared with the tree.
+func NewDocument(root *IFDNode) *Document {
+	return &Document{Pages: root.Chain()}
+}
+
+// Return a node followed by the nodes in its Next chain, or nil if the
+// node is nil. If the chain loops back to a node already seen, it's
+// treated as ending there.
+func (node *IFDNode) Chain() []*IFDNode {
+	var chain []*IFDNode
+	seen := make(map[*IFDNode]bool)
+	for ; node != nil && !seen[node]; node = node.Next {
+		seen[node] = true
+		chain = append(chain, node)
+	}
+	return chain
+}
+
+// Return the number of nodes in a Next chain, including the node
+// itself, as for Chain. For the root of a TIFF tree, this is the number
+// of pages.
+func (node *IFDNode) ChainLen() int {
+	return len(node.Chain())
+}
+
+// Link a node, with any Next chain it has, to the end of a Next chain.
+// An error is returned if the added chain shares nodes with the
+// existing one, which would create a loop.
+func (node *IFDNode) AppendNext(next *IFDNode) error {
+	if next == nil {
+		return errors.New("AppendNext: node is nil")
+	}
+	chain := node.Chain()
+	if len(chain) == 0 {
+		return errors.New("AppendNext: chain is nil")
+	}
+	seen := make(map[*IFDNode]bool, len(chain))
+	for _, n := range chain {
+		seen[n] = true
+	}
+	for _, n := range next.Chain() {
+		if seen[n] {
+			return errors.New("AppendNext: node is already in the chain")
+		}
+	}
+	chain[len(chain)-1].Next = next
+	return nil
+}
+
+// Remove node i from a Next chain, where 0 is the node itself, and
+// relink the nodes on either side of it. Return the first node of the
+// resulting chain, which is the node itself unless i is 0, and the
+// removed node, whose Next is cleared.
+func (node *IFDNode) SpliceOut(i int) (*IFDNode, *IFDNode, error) {
+	chain := node.Chain()
+	if i < 0 || i >= len(chain) {
+		return node, nil, fmt.Errorf("SpliceOut: node %d out of range", i)
+	}
+	removed := chain[i]
+	var rest *IFDNode
+	if i+1 < len(chain) {
+		rest = chain[i+1]
+	}
+	removed.Next = nil
+	if i == 0 {
+		return rest, removed, nil
+	}
+	chain[i-1].Next = rest
+	return node, removed, nil
+}
+
+// Return the number of pages.
+func (doc *Document) Len() int {
+	return len(doc.Pages)
+}
+
+// Add a page at the end of the document. Any Next chain of the page
+// is ignored.
+func (doc *Document) Append(page *IFDNode) {
+	doc.Pages = append(doc.Pages, page)
+}
+
+// Insert a page before page i, where i is between 0 and Len(). Any
+// Next chain of the page is ignored.
+func (doc *Document) Insert(i int, page *IFDNode) error {
+	if i < 0 || i > len(doc.Pages) {
+		return fmt.Errorf("Document.Insert: page %d out of range", i)
+	}
+	doc.Pages = append(doc.Pages, nil)
+	copy(doc.Pages[i+1:], doc.Pages[i:])
+	doc.Pages[i] = page
+	return nil
+}
+
+// Set the PageNumber field in each page to its page number, starting
+// from 0, and the total number of pages, adding it if required.
+func (doc *Document) SetPageNumbers() {
+	total := uint16(len(doc.Pages))
+	for i, page := range doc.Pages {
+		page.replaceField(shortField(PageNumber, page.Order, uint16(i), total))
+	}
+}
+
+// Link the pages into a Next chain and return the first, which is the
+// root of a tree that can be serialized with WriteIFDTree or
+// PutIFDTree. An error is returned if the document is empty or a page
+// is nil or appears more than once.
+func (doc *Document) Root() (*IFDNode, error) {
+	if len(doc.Pages) == 0 {
+		return nil, errors.New("Document has no pages")
+	}
+	seen := make(map[*IFDNode]bool)
+	for i, page := range doc.Pages {
+		if page == nil {
+			return nil, fmt.Errorf("Document page %d is nil", i)
+		}
+		if seen[page] {
+			return nil, fmt.Errorf("Document page %d appears more than once", i)
+		}
+		seen[page] = true
+	}
+	doc.link()
+	return doc.Pages[0], nil
+}
+
+// Link the pages into a Next chain.
+func (doc *Document) link() {
+	for i, page := range doc.Pages {
+		if page == nil {
+			continue
+		}
+		page.Next = nil
+		if i > 0 && doc.Pages[i-1] != nil {
+			doc.Pages[i-1].Next = page
+		}
+	}
+}
+
+// Update the PageNumber fields of the pages that have them, after
+// pages are deleted or moved.
+func (doc *Document) renumber() {
+	total := uint16(len(doc.Pages))
+	for i, page := range doc.Pages {
+		if page != nil && len(page.FindFields([]Tag{PageNumber})) > 0 {
+			page.replaceField(shortField(PageNumber, page.Order, uint16(i), total))
+		}
+	}
+}
+
+// Delete page i. The remaining pages are relinked, so that the deleted
+// page and its image data won't be included when the document is
+// written, and their PageNumber fields, if any, are updated.
+func (doc *Document) Delete(i int) error {
+	if i < 0 || i >= len(doc.Pages) {
+		return fmt.Errorf("Document.Delete: page %d out of range", i)
+	}
+	deleted := doc.Pages[i]
+	doc.Pages = append(doc.Pages[:i], doc.Pages[i+1:]...)
+	if deleted != nil {
+		deleted.Next = nil
+	}
+	doc.link()
+	doc.renumber()
+	return nil
+}
+
+// Move page from to position to, shifting the pages in between. The
+// pages are relinked and their PageNumber fields, if any, are updated.
+func (doc *Document) Move(from, to int) error {
+	if from < 0 || from >= len(doc.Pages) || to < 0 || to >= len(doc.Pages) {
+		return fmt.Errorf("Document.Move: page %d or %d out of range", from, to)
+	}
+	page := doc.Pages[from]
+	if from < to {
+		copy(doc.Pages[from:], doc.Pages[from+1:to+1])
+	} else {
+		copy(doc.Pages[to+1:], doc.Pages[to:from])
+	}
+	doc.Pages[to] = page
+	doc.link()
+	doc.renumber()
+	return nil
+}
+
+// Reorder the pages, so that page i of the result is page order[i] of
+// the original. order must be a permutation of the page indexes. The
+// pages are relinked and their PageNumber fields, if any, are updated.
+func (doc *Document) Reorder(order []int) error {
+	if len(order) != len(doc.Pages) {
+		return fmt.Errorf("Document.Reorder: %d indexes for %d pages", len(order), len(doc.Pages))
+	}
+	pages := make([]*IFDNode, len(order))
+	used := make([]bool, len(order))
+	for i, idx := range order {
+		if idx < 0 || idx >= len(order) || used[idx] {
+			return errors.New("Document.Reorder: order isn't a permutation")
+		}
+		used[idx] = true
+		pages[i] = doc.Pages[idx]
+	}
+	doc.Pages = pages
+	doc.link()
+	doc.renumber()
+	return nil
+}
+
+// Return page i as the root of a standalone TIFF tree, without a Next
+// chain. Its fields and sub-IFDs are copied, but field data and image
+// data are shared with the document. If the page has a PageNumber
+// field, it's changed to page 0 of 1.
+func (doc *Document) Page(i int) (*IFDNode, error) {
+	if i < 0 || i >= len(doc.Pages) {
+		return nil, fmt.Errorf("Document.Page: page %d out of range", i)
+	}
+	page := *doc.Pages[i]
+	page.Next = nil
+	clone, err := cloneTree(&page, page.Order)
+	if err != nil {
+		return nil, err
+	}
+	if len(clone.FindFields([]Tag{PageNumber})) > 0 {
+		clone.replaceField(shortField(PageNumber, clone.Order, 0, 1))
+	}
+	return clone, nil
+}
+
+// Split a document into standalone trees, one per page, as for Page.
+func (doc *Document) Split() ([]*IFDNode, error) {
+	trees := make([]*IFDNode, len(doc.Pages))
+	for i := range doc.Pages {
+		var err error
+		if trees[i], err = doc.Page(i); err != nil {
+			return nil, err
+		}
+	}
+	return trees, nil
+}
@@ -0,0 +1,210 @@
+package tiff66
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Create a single-page gray image IFD filled with a value.
+func grayPage(t *testing.T, val uint8) *IFDNode {
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = val
+	}
+	node, err := EncodeImage(img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// Return the gray value of the first pixel of each page in a tree.
+func pageValues(t *testing.T, root *IFDNode) []uint8 {
+	var vals []uint8
+	for node := root; node != nil; node = node.Next {
+		img, err := node.DecodeImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, color.GrayModel.Convert(img.At(0, 0)).(color.Gray).Y)
+	}
+	return vals
+}
+
+func TestDocument(t *testing.T) {
+	doc := NewDocument(nil)
+	if _, err := doc.Root(); err == nil {
+		t.Error("Empty document didn't cause an error")
+	}
+	doc.Append(grayPage(t, 10))
+	doc.Append(grayPage(t, 30))
+	if err := doc.Insert(1, grayPage(t, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Insert(4, grayPage(t, 40)); err == nil {
+		t.Error("Out of range insertion didn't cause an error")
+	}
+	doc.SetPageNumbers()
+	root, err := doc.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := getTIFFTree(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = NewDocument(reread)
+	if doc.Len() != 3 {
+		t.Fatalf("Document has %d pages, expected 3", doc.Len())
+	}
+	if vals := pageValues(t, reread); !bytes.Equal(vals, []uint8{10, 20, 30}) {
+		t.Errorf("Pages in wrong order: %v", vals)
+	}
+	for i, page := range doc.Pages {
+		f := page.FindFields([]Tag{PageNumber})
+		if len(f) != 1 || f[0].Short(0, page.Order) != uint16(i) || f[0].Short(1, page.Order) != 3 {
+			t.Errorf("Wrong PageNumber in page %d", i)
+		}
+	}
+
+	trees, err := doc.Split()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tree := range trees {
+		if tree.Next != nil {
+			t.Errorf("Split page %d has a Next IFD", i)
+		}
+		var buf bytes.Buffer
+		if err := tree.WriteIFDTree(&buf); err != nil {
+			t.Fatal(err)
+		}
+		single, err := getTIFFTree(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vals := pageValues(t, single); !bytes.Equal(vals, []uint8{uint8(10 * (i + 1))}) {
+			t.Errorf("Split page %d has wrong pages %v", i, vals)
+		}
+		if f := single.FindFields([]Tag{PageNumber}); f[0].Short(0, single.Order) != 0 || f[0].Short(1, single.Order) != 1 {
+			t.Errorf("Split page %d has wrong PageNumber", i)
+		}
+	}
+	if f := doc.Pages[2].FindFields([]Tag{PageNumber}); f[0].Short(0, doc.Pages[2].Order) != 2 {
+		t.Error("Split changed the PageNumber of the document")
+	}
+	if _, err := doc.Page(3); err == nil {
+		t.Error("Out of range page didn't cause an error")
+	}
+
+	doc.Append(doc.Pages[0])
+	if _, err := doc.Root(); err == nil {
+		t.Error("Repeated page didn't cause an error")
+	}
+}
+
+func TestDocumentEdit(t *testing.T) {
+	doc := NewDocument(nil)
+	for _, val := range []uint8{10, 20, 30, 40} {
+		doc.Append(grayPage(t, val))
+	}
+	doc.SetPageNumbers()
+	root, err := doc.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	if root.Next != nil {
+		t.Error("Deleted page still linked")
+	}
+	if err := doc.Move(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Reorder([]int{0, 2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Reorder([]int{0, 0, 1}); err == nil {
+		t.Error("Invalid order didn't cause an error")
+	}
+	var out bytes.Buffer
+	if err := doc.Pages[0].WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out.Bytes(), bytes.Repeat([]byte{10}, 16)) {
+		t.Error("Image data of deleted page was written")
+	}
+	reread, err := getTIFFTree(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals := pageValues(t, reread); !bytes.Equal(vals, []uint8{40, 30, 20}) {
+		t.Errorf("Pages in wrong order: %v", vals)
+	}
+	i := uint16(0)
+	for node := reread; node != nil; node = node.Next {
+		f := node.FindFields([]Tag{PageNumber})
+		if f[0].Short(0, node.Order) != i || f[0].Short(1, node.Order) != 3 {
+			t.Errorf("Wrong PageNumber in page %d", i)
+		}
+		i++
+	}
+}
+
+func TestChain(t *testing.T) {
+	var nilNode *IFDNode
+	if nilNode.ChainLen() != 0 {
+		t.Error("Nil node has a non-empty chain")
+	}
+	root := grayPage(t, 10)
+	for _, val := range []uint8{20, 30} {
+		if err := root.AppendNext(grayPage(t, val)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := root.ChainLen(); n != 3 {
+		t.Fatalf("Chain has %d nodes, expected 3", n)
+	}
+	if err := root.AppendNext(root.Next); err == nil {
+		t.Error("Appending a node already in the chain didn't cause an error")
+	}
+	root, removed, err := root.SpliceOut(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.Next != nil || root.ChainLen() != 2 {
+		t.Error("Spliced node not unlinked")
+	}
+	if _, _, err := root.SpliceOut(2); err == nil {
+		t.Error("Out of range splice didn't cause an error")
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := getTIFFTree(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals := pageValues(t, reread); !bytes.Equal(vals, []uint8{10, 30}) {
+		t.Errorf("Pages in wrong order: %v", vals)
+	}
+	root, removed, err = root.SpliceOut(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain := root.Chain(); len(chain) != 1 || removed.Next != nil {
+		t.Errorf("Removing the first node left %d nodes", len(chain))
+	}
+	root.Next = root
+	if n := root.ChainLen(); n != 1 {
+		t.Errorf("Looped chain has %d nodes", n)
+	}
+}
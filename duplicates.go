@@ -0,0 +1,147 @@
+package tiff66
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How FixDuplicates handles fields in an IFD with the same tag. The TIFF
+// specification doesn't permit duplicate tags, and readers disagree on
+// which of the fields to use.
+type DuplicatePolicy uint8
+
+const (
+	// Keep the first field with each tag, deleting the others and
+	// the sub-IFDs they point to. This is what Fix does.
+	DuplicateKeepFirst DuplicatePolicy = iota
+	// Append the values of later fields to the first field with the
+	// same tag, if they have the same type and their data is loaded,
+	// otherwise delete them as for DuplicateKeepFirst. Sub-IFDs
+	// pointed to by merged fields are kept, except for maker notes
+	// and other sub-IFDs stored in field data, which can't be merged.
+	DuplicateMerge
+	// Leave the fields unchanged, and return an error listing them.
+	DuplicateReport
+)
+
+// Return the tags that occur more than once in an IFD, in the order of
+// their first fields.
+func (node IFDNode) DuplicateTags() []Tag {
+	counts := make(map[Tag]int, len(node.Fields))
+	for _, field := range node.Fields {
+		counts[field.Tag]++
+	}
+	var dups []Tag
+	for _, field := range node.Fields {
+		if counts[field.Tag] > 1 {
+			dups = append(dups, field.Tag)
+			counts[field.Tag] = 0
+		}
+	}
+	return dups
+}
+
+// Find duplicate tags in all the IFDs of a tree and handle them
+// according to a policy. With DuplicateReport, the returned error lists
+// the IFDs and tags found; otherwise the error is always nil.
+func (node *IFDNode) FixDuplicates(policy DuplicatePolicy) error {
+	var problems []string
+	node.Walk(func(n *IFDNode, _ Tag) error {
+		dups := n.DuplicateTags()
+		if len(dups) == 0 {
+			return nil
+		}
+		if policy == DuplicateReport {
+			tags := make([]string, len(dups))
+			for i, tag := range dups {
+				tags[i] = fmt.Sprintf("%d(0x%X)", tag, tag)
+			}
+			problems = append(problems, fmt.Sprintf("%s IFD has duplicate tags %s", n.GetSpace().Name(), strings.Join(tags, ", ")))
+			return nil
+		}
+		for _, tag := range dups {
+			n.fixDuplicate(tag, policy)
+		}
+		return nil
+	})
+	if len(problems) > 0 {
+		return fmt.Errorf("FixDuplicates: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Indicate if a duplicate field can be merged into the first field with
+// its tag.
+func mergeable(first, dup Field, pointsToSubIFDs bool) bool {
+	if first.Type != dup.Type || first.Type.Size() == 0 || first.Count+dup.Count < first.Count {
+		return false
+	}
+	if pointsToSubIFDs && first.Type.Size() == 1 {
+		return false
+	}
+	return uint64(len(first.Data)) >= uint64(first.Size()) && uint64(len(dup.Data)) >= uint64(dup.Size())
+}
+
+// Remove the duplicates of a tag from an IFD, keeping or merging into
+// the first field, and delete the sub-IFDs of fields that are removed.
+// Sub-IFDs are assumed to be in the same order as the fields that
+// point to them, as when decoded.
+func (node *IFDNode) fixDuplicate(tag Tag, policy DuplicatePolicy) {
+	available := uint32(0) // Number of sub-IFDs with the tag.
+	for _, sub := range node.SubIFDs {
+		if sub.Tag == tag {
+			available++
+		}
+	}
+	pointsToSubIFDs := available > 0
+	first := -1
+	// Whether each sub-IFD with the tag is to be deleted.
+	var dropSubIFDs []bool
+	fields := node.Fields[:0]
+	for _, field := range node.Fields {
+		if field.Tag != tag {
+			fields = append(fields, field)
+			continue
+		}
+		keep := true
+		if first < 0 {
+			first = len(fields)
+			fields = append(fields, field)
+		} else if policy == DuplicateMerge && mergeable(fields[first], field, pointsToSubIFDs) {
+			merged := fields[first]
+			data := make([]byte, 0, uint64(merged.Size())+uint64(field.Size()))
+			data = append(data, merged.Data[:merged.Size()]...)
+			merged.Data = append(data, field.Data[:field.Size()]...)
+			merged.Count += field.Count
+			fields[first] = merged
+		} else {
+			keep = false
+		}
+		if pointsToSubIFDs {
+			subIFDs := field.Count
+			if field.Type.Size() == 1 {
+				subIFDs = 1
+			}
+			for i := uint32(0); i < subIFDs && uint32(len(dropSubIFDs)) < available; i++ {
+				dropSubIFDs = append(dropSubIFDs, !keep)
+			}
+		}
+	}
+	node.Fields = fields
+	if !pointsToSubIFDs {
+		return
+	}
+	idx := 0
+	subIFDs := node.SubIFDs[:0]
+	for _, sub := range node.SubIFDs {
+		if sub.Tag == tag {
+			drop := idx < len(dropSubIFDs) && dropSubIFDs[idx]
+			idx++
+			if drop {
+				continue
+			}
+		}
+		subIFDs = append(subIFDs, sub)
+	}
+	node.SubIFDs = subIFDs
+}
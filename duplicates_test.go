@@ -0,0 +1,104 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// Return a node with duplicate Software fields and two SubIFDs fields,
+// each pointing to a sub-IFD.
+func duplicatesTree() *IFDNode {
+	order := binary.LittleEndian
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	var subs [2]*IFDNode
+	for i := range subs {
+		subs[i] = NewIFDNode(TIFFSpace)
+		subs[i].Order = order
+		subs[i].SetShorts(Orientation, []uint16{uint16(i + 1)})
+	}
+	root.Fields = []Field{
+		{Software, ASCII, 4, []byte("One\000")},
+		{SubIFDs, LONG, 1, make([]byte, 4)},
+		{Orientation, SHORT, 1, []byte{1, 0}},
+		{Software, ASCII, 4, []byte("Two\000")},
+		{SubIFDs, LONG, 1, make([]byte, 4)},
+	}
+	root.SubIFDs = []SubIFD{{SubIFDs, subs[0]}, {SubIFDs, subs[1]}}
+	return root
+}
+
+func TestDuplicateTags(t *testing.T) {
+	root := duplicatesTree()
+	if dups := root.DuplicateTags(); len(dups) != 2 || dups[0] != Software || dups[1] != SubIFDs {
+		t.Errorf("Duplicate tags are %v", dups)
+	}
+	if err := root.Validate(); err == nil || !strings.Contains(err.Error(), "duplicate field 305(0x131)") {
+		t.Errorf("Duplicate not reported by Validate: %v", err)
+	}
+	err := root.FixDuplicates(DuplicateReport)
+	if err == nil || !strings.Contains(err.Error(), "305(0x131), 330(0x14A)") || len(root.Fields) != 5 {
+		t.Errorf("DuplicateReport returned %v", err)
+	}
+
+	root.Fix()
+	if len(root.Fields) != 3 || len(root.SubIFDs) != 1 {
+		t.Fatalf("Fix left %d fields and %d sub-IFDs", len(root.Fields), len(root.SubIFDs))
+	}
+	if s, _ := root.GetASCII(Software); s != "One" {
+		t.Errorf("Fix kept Software %q", s)
+	}
+	if v, _ := root.SubIFDs[0].Node.GetShorts(Orientation); v[0] != 1 {
+		t.Error("Fix kept the wrong sub-IFD")
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	root = duplicatesTree()
+	root.FixDuplicates(DuplicateMerge)
+	if len(root.Fields) != 3 || len(root.SubIFDs) != 2 {
+		t.Fatalf("Merge left %d fields and %d sub-IFDs", len(root.Fields), len(root.SubIFDs))
+	}
+	if f := root.MustField(SubIFDs); f.Count != 2 {
+		t.Errorf("Merged SubIFDs has count %d", f.Count)
+	}
+	if f := root.MustField(Software); f.Count != 8 || string(f.Data) != "One\000Two\000" {
+		t.Errorf("Merged Software is %q", f.Data)
+	}
+	root.Fix()
+	out.Reset()
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	decoded, err := GetIFDTree(buf, binary.LittleEndian, binary.LittleEndian.Uint32(buf[4:]), TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.SubIFDs) != 2 {
+		t.Errorf("Decoded %d sub-IFDs, expected 2", len(decoded.SubIFDs))
+	}
+
+	// A duplicate that isn't adjacent to the first field with its
+	// tag.
+	order := binary.BigEndian
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.SetASCII(ImageDescription, "A description")
+	node.SetASCII(Software, "Some software")
+	node.SetASCII(Artist, "An artist")
+	out.Reset()
+	if err := node.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf = out.Bytes()
+	ifd := order.Uint32(buf[4:])
+	order.PutUint16(buf[ifd+2+2*TableEntrySize:], uint16(ImageDescription))
+	if report := ValidateTIFF(buf); !hasProblem(report, SeverityError, ImageDescription, "Duplicate tag") {
+		t.Errorf("Duplicate tag not reported by ValidateTIFF: %v", report.Problems)
+	}
+}
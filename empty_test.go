@@ -21,7 +21,7 @@ func TestEmpty(t *testing.T) {
 	node4.Order = binary.LittleEndian
 	node5.Order = binary.LittleEndian
 	node1.Next = node2
-	node5size := node5.NodeSize()
+	node5size := uint32(node5.NodeSize())
 	node2.Fields = make([]Field, 2)
 	node2.Fields[0] = Field{888, IFD, 2, nil}
 	node2.Fields[0].Data = []byte("00000000")
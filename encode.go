@@ -0,0 +1,334 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Options for EncodeImage. A nil pointer or zero fields give the
+// defaults.
+type EncodeOptions struct {
+	Order binary.ByteOrder // Byte order, default little-endian.
+	// Compression value: CompressionNone (the default),
+	// CompressionLZW or CompressionDeflate.
+	Compression uint32
+	// Predictor value: PredictorNone (the default) or
+	// PredictorHorizontal, which is only allowed for 8 or 16 bit
+	// samples.
+	Predictor uint32
+	// Rows in each strip. The default gives strips of about 8K
+	// bytes before compression.
+	RowsPerStrip uint32
+	// If TileWidth and TileLength are set, the image is stored in
+	// tiles of that size instead of strips. Both must be multiples
+	// of 16. Tiles that extend past the image edges are padded
+	// with zeros.
+	TileWidth, TileLength uint32
+	// Resolution in pixels per ResolutionUnit. The defaults are
+	// 72 and ResolutionUnit 2 (inches).
+	XResolution, YResolution float64
+	ResolutionUnit           uint16
+}
+
+// Size of strips created by EncodeImage by default.
+const defaultStripSize = 8192
+
+// Return a LONG field with given values.
+func longField(tag Tag, order binary.ByteOrder, vals ...uint32) Field {
+	f := Field{tag, LONG, uint32(len(vals)), make([]byte, 4*len(vals))}
+	for i, v := range vals {
+		f.PutLong(v, uint32(i), order)
+	}
+	return f
+}
+
+// Return a SHORT field with given values.
+func shortField(tag Tag, order binary.ByteOrder, vals ...uint16) Field {
+	f := Field{tag, SHORT, uint32(len(vals)), make([]byte, 2*len(vals))}
+	for i, v := range vals {
+		f.PutShort(v, uint32(i), order)
+	}
+	return f
+}
+
+// Return a RATIONAL field containing an approximation of a
+// non-negative value.
+func rationalValueField(tag Tag, order binary.ByteOrder, val float64) Field {
+	f := Field{tag, RATIONAL, 1, make([]byte, 8)}
+	denom := uint32(1)
+	for val*float64(denom) != math.Trunc(val*float64(denom)) && denom < 10000 {
+		denom *= 10
+	}
+	f.PutRational(uint32(math.Round(val*float64(denom))), denom, 0, order)
+	return f
+}
+
+// Pixel data of an image in TIFF form, with samples interleaved.
+type encodedPixels struct {
+	photometric uint16
+	samples     uint16
+	bits        uint16
+	extraSample uint16 // ExtraSamples value if samples includes alpha.
+	colorMap    []uint16
+	data        []byte // Rows of width*samples*bits/8 bytes.
+}
+
+// Convert an image to TIFF samples. 16 bit samples are stored in the
+// given byte order.
+func encodePixels(img image.Image, order binary.ByteOrder) encodedPixels {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	var p encodedPixels
+	p.bits = 8
+	switch src := img.(type) {
+	case *image.Gray:
+		p.photometric, p.samples = PhotometricBlackIsZero, 1
+		p.data = make([]byte, 0, width*height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			start := src.PixOffset(b.Min.X, y)
+			p.data = append(p.data, src.Pix[start:start+width]...)
+		}
+		return p
+	case *image.Gray16:
+		p.photometric, p.samples, p.bits = PhotometricBlackIsZero, 1, 16
+		p.data = make([]byte, 0, 2*width*height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				p.data = appendUint16(p.data, src.Gray16At(x, y).Y, order)
+			}
+		}
+		return p
+	case *image.Paletted:
+		if len(src.Palette) <= 256 {
+			p.photometric, p.samples = PhotometricPalette, 1
+			p.colorMap = make([]uint16, 3*256)
+			for i, c := range src.Palette {
+				r, g, bl, _ := c.RGBA()
+				p.colorMap[i], p.colorMap[256+i], p.colorMap[512+i] = uint16(r), uint16(g), uint16(bl)
+			}
+			p.data = make([]byte, 0, width*height)
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				start := src.PixOffset(b.Min.X, y)
+				p.data = append(p.data, src.Pix[start:start+width]...)
+			}
+			return p
+		}
+	case *image.CMYK:
+		p.photometric, p.samples = PhotometricSeparated, 4
+		p.data = make([]byte, 0, 4*width*height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			start := src.PixOffset(b.Min.X, y)
+			p.data = append(p.data, src.Pix[start:start+4*width]...)
+		}
+		return p
+	case *image.RGBA64, *image.NRGBA64:
+		p.photometric, p.samples, p.bits = PhotometricRGB, 4, 16
+		p.extraSample = ExtraSampleAssociatedAlpha
+		at := func(x, y int) [4]uint16 {
+			c := src.(*image.RGBA64).RGBA64At(x, y)
+			return [4]uint16{c.R, c.G, c.B, c.A}
+		}
+		if n, ok := src.(*image.NRGBA64); ok {
+			p.extraSample = ExtraSampleUnassociatedAlpha
+			at = func(x, y int) [4]uint16 {
+				c := n.NRGBA64At(x, y)
+				return [4]uint16{c.R, c.G, c.B, c.A}
+			}
+		}
+		p.data = make([]byte, 0, 8*width*height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				for _, v := range at(x, y) {
+					p.data = appendUint16(p.data, v, order)
+				}
+			}
+		}
+		return p
+	case *image.NRGBA:
+		p.photometric, p.samples = PhotometricRGB, 4
+		p.extraSample = ExtraSampleUnassociatedAlpha
+		p.data = make([]byte, 0, 4*width*height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			start := src.PixOffset(b.Min.X, y)
+			p.data = append(p.data, src.Pix[start:start+4*width]...)
+		}
+		return p
+	}
+	// Anything else is converted to 8 bit RGB, with associated alpha
+	// unless the image is opaque.
+	opaque := false
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+	p.photometric, p.samples = PhotometricRGB, 4
+	p.extraSample = ExtraSampleAssociatedAlpha
+	if opaque {
+		p.samples = 3
+	}
+	p.data = make([]byte, 0, int(p.samples)*width*height)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			p.data = append(p.data, c.R, c.G, c.B)
+			if !opaque {
+				p.data = append(p.data, c.A)
+			}
+		}
+	}
+	return p
+}
+
+// Append a 16 bit value to a slice in the given byte order.
+func appendUint16(data []byte, val uint16, order binary.ByteOrder) []byte {
+	var buf [2]byte
+	order.PutUint16(buf[:], val)
+	return append(data, buf[:]...)
+}
+
+// Create a TIFF IFD containing an image, with the fields required for
+// a baseline TIFF file and the image data in strips or tiles, which
+// can be serialized with WriteIFDTree or PutIFDTree. Gray, Gray16, Paletted
+// (up to 256 colors), CMYK, NRGBA, RGBA64 and NRGBA64 images are
+// stored with the corresponding photometric interpretation and sample
+// size; other images are converted to 8 bit RGB, with an alpha sample
+// if the image isn't opaque.
+func EncodeImage(img image.Image, opts *EncodeOptions) (*IFDNode, error) {
+	var o EncodeOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Order == nil {
+		o.Order = binary.LittleEndian
+	}
+	if o.Compression == 0 {
+		o.Compression = CompressionNone
+	}
+	if o.Predictor == 0 {
+		o.Predictor = PredictorNone
+	}
+	if o.XResolution == 0 {
+		o.XResolution = 72
+	}
+	if o.YResolution == 0 {
+		o.YResolution = 72
+	}
+	if o.ResolutionUnit == 0 {
+		o.ResolutionUnit = 2
+	}
+	if o.XResolution < 0 || o.YResolution < 0 || o.XResolution > math.MaxUint32 || o.YResolution > math.MaxUint32 {
+		return nil, errors.New("EncodeImage: invalid resolution")
+	}
+	if o.TileWidth != 0 || o.TileLength != 0 {
+		if o.TileWidth == 0 || o.TileLength == 0 || o.TileWidth%16 != 0 || o.TileLength%16 != 0 {
+			return nil, errors.New("EncodeImage: tile width and length must be non-zero multiples of 16")
+		}
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, errors.New("EncodeImage: image is empty")
+	}
+	width, height := uint32(bounds.Dx()), uint32(bounds.Dy())
+	pixels := encodePixels(img, o.Order)
+	rowBytes := width * uint32(pixels.samples) * uint32(pixels.bits) / 8
+
+	order := o.Order
+	bits := make([]uint16, pixels.samples)
+	for i := range bits {
+		bits[i] = pixels.bits
+	}
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.Fields = []Field{
+		longField(ImageWidth, order, width),
+		longField(ImageLength, order, height),
+		shortField(BitsPerSample, order, bits...),
+		shortField(Compression, order, uint16(o.Compression)),
+		shortField(PhotometricInterpretation, order, pixels.photometric),
+		shortField(SamplesPerPixel, order, pixels.samples),
+		rationalValueField(XResolution, order, o.XResolution),
+		rationalValueField(YResolution, order, o.YResolution),
+		shortField(PlanarConfiguration, order, 1),
+		shortField(ResolutionUnit, order, o.ResolutionUnit),
+	}
+	var chunks [][]byte
+	offsetTag, sizeTag := Tag(StripOffsets), Tag(StripByteCounts)
+	if o.TileWidth != 0 {
+		offsetTag, sizeTag = TileOffsets, TileByteCounts
+		chunks = tileChunks(pixels.data, rowBytes, height, o.TileWidth*uint32(pixels.samples)*uint32(pixels.bits)/8, o.TileLength)
+		node.Fields = append(node.Fields,
+			longField(TileWidth, order, o.TileWidth),
+			longField(TileLength, order, o.TileLength))
+	} else {
+		rowsPerStrip := o.RowsPerStrip
+		if rowsPerStrip == 0 {
+			rowsPerStrip = defaultStripSize / rowBytes
+			if rowsPerStrip == 0 {
+				rowsPerStrip = 1
+			}
+		}
+		if rowsPerStrip > height {
+			rowsPerStrip = height
+		}
+		for start := uint32(0); start < height*rowBytes; start += rowsPerStrip * rowBytes {
+			end := start + rowsPerStrip*rowBytes
+			if end > height*rowBytes {
+				end = height * rowBytes
+			}
+			chunks = append(chunks, pixels.data[start:end])
+		}
+		node.Fields = append(node.Fields, longField(RowsPerStrip, order, rowsPerStrip))
+	}
+	node.Fields = append(node.Fields,
+		longField(offsetTag, order, make([]uint32, len(chunks))...),
+		longField(sizeTag, order, make([]uint32, len(chunks))...))
+	if o.Predictor != PredictorNone {
+		node.Fields = append(node.Fields, shortField(Predictor, order, uint16(o.Predictor)))
+	}
+	if pixels.colorMap != nil {
+		node.Fields = append(node.Fields, shortField(ColorMap, order, pixels.colorMap...))
+	}
+	if pixels.photometric == PhotometricRGB && pixels.samples == 4 {
+		node.Fields = append(node.Fields, shortField(ExtraSamples, order, pixels.extraSample))
+	}
+	node.fixIFD(&FixOptions{SortFields: true})
+
+	l, err := node.imageLayout()
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]ImageSegment, len(chunks))
+	sizes := node.FindFields([]Tag{sizeTag})[0]
+	for i := range segments {
+		if segments[i], err = l.encodeChunk(chunks[i]); err != nil {
+			return nil, fmt.Errorf("EncodeImage: %v", err)
+		}
+		sizes.PutLong(uint32(len(segments[i])), uint32(i), order)
+	}
+	node.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: offsetTag, SizeTag: sizeTag, Segments: segments}}}
+	return node, nil
+}
+
+// Divide rows of pixel data into tiles of tileRowBytes by tileLength
+// bytes, in row-major order, padding them with zeros at the right and
+// bottom edges of the image.
+func tileChunks(data []byte, rowBytes, height, tileRowBytes, tileLength uint32) [][]byte {
+	across := (rowBytes + tileRowBytes - 1) / tileRowBytes
+	down := (height + tileLength - 1) / tileLength
+	chunks := make([][]byte, 0, across*down)
+	for ty := uint32(0); ty < down; ty++ {
+		for tx := uint32(0); tx < across; tx++ {
+			tile := make([]byte, tileRowBytes*tileLength)
+			x0 := tx * tileRowBytes
+			for y := uint32(0); y < tileLength && ty*tileLength+y < height; y++ {
+				row := data[(ty*tileLength+y)*rowBytes : (ty*tileLength+y+1)*rowBytes]
+				copy(tile[y*tileRowBytes:(y+1)*tileRowBytes], row[x0:])
+			}
+			chunks = append(chunks, tile)
+		}
+	}
+	return chunks
+}
@@ -0,0 +1,112 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Write a node to a TIFF file and decode its image.
+func encodeRoundTrip(t *testing.T, node *IFDNode) image.Image {
+	var out bytes.Buffer
+	if err := node.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	root, err := getTIFFTree(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := root.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// Return true if two images have the same pixel colors.
+func sameImage(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, a1 := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestEncodeImage(t *testing.T) {
+	rect := image.Rect(0, 0, 37, 29)
+	gray := image.NewGray(rect)
+	gray16 := image.NewGray16(rect)
+	rgba := image.NewRGBA(rect)
+	nrgba64 := image.NewNRGBA64(rect)
+	cmyk := image.NewCMYK(rect)
+	paletted := image.NewPaletted(rect, color.Palette{color.Black, color.White, color.RGBA{255, 0, 0, 255}})
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			v := uint8(x*7 + y*3)
+			gray.SetGray(x, y, color.Gray{v})
+			gray16.SetGray16(x, y, color.Gray16{uint16(x) * 1000})
+			rgba.SetRGBA(x, y, color.RGBA{v, v / 2, v / 3, 255})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(x) << 8, 0x1234, uint16(y) << 8, 0x8000})
+			cmyk.SetCMYK(x, y, color.CMYK{v, 0, 255 - v, 10})
+			paletted.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+	// A sub-image, which doesn't start at the origin.
+	sub := gray.SubImage(image.Rect(5, 3, 20, 25))
+	opts := []*EncodeOptions{
+		nil,
+		{Order: binary.BigEndian, Compression: CompressionLZW, Predictor: PredictorHorizontal, RowsPerStrip: 5},
+		{Compression: CompressionDeflate, RowsPerStrip: 100, XResolution: 300.5, YResolution: 300.5, ResolutionUnit: 3},
+		{TileWidth: 16, TileLength: 32},
+		{Order: binary.BigEndian, Compression: CompressionLZW, Predictor: PredictorHorizontal, TileWidth: 32, TileLength: 16},
+	}
+	for i, o := range opts {
+		for _, img := range []image.Image{gray, gray16, rgba, nrgba64, cmyk, paletted, sub} {
+			node, err := EncodeImage(img, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameImage(img, encodeRoundTrip(t, node)) {
+				t.Errorf("Options %d: %T image changed", i, img)
+			}
+		}
+	}
+
+	node, err := EncodeImage(gray, &EncodeOptions{RowsPerStrip: 10, XResolution: 300.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strips := node.FindFields([]Tag{StripOffsets}); len(strips) != 1 || strips[0].Count != 3 {
+		t.Error("Wrong number of strips")
+	}
+	if num, denom := rationalField(node, XResolution); num != 3005 || denom != 10 {
+		t.Errorf("Wrong XResolution %d/%d", num, denom)
+	}
+	node, err = EncodeImage(gray, &EncodeOptions{TileWidth: 16, TileLength: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizes := node.FindFields([]Tag{TileByteCounts}); len(sizes) != 1 || sizes[0].Count != 6 || sizes[0].Long(5, node.Order) != 256 {
+		t.Error("Wrong tiles")
+	}
+	if len(node.FindFields([]Tag{StripOffsets, RowsPerStrip})) != 0 {
+		t.Error("Strip fields in tiled image")
+	}
+	if _, err := EncodeImage(gray, &EncodeOptions{TileWidth: 20, TileLength: 16}); err == nil {
+		t.Error("Invalid tile width didn't cause an error")
+	}
+	if _, err := EncodeImage(gray, &EncodeOptions{Compression: CompressionJPEG}); err == nil {
+		t.Error("Unsupported compression didn't cause an error")
+	}
+}
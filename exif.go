@@ -0,0 +1,206 @@
+package tiff66
+
+// Tags that may be found in Interoperability IFDs, from Exif 2.32 and
+// DCF.
+const (
+	InteroperabilityIndex   = 0x0001
+	InteroperabilityVersion = 0x0002
+	RelatedImageFileFormat  = 0x1000
+	RelatedImageWidth       = 0x1001
+	RelatedImageLength      = 0x1002
+)
+
+// Mappings from Interoperability tags to strings.
+var InteropTagNames = map[Tag]string{
+	InteroperabilityIndex:   "InteroperabilityIndex",
+	InteroperabilityVersion: "InteroperabilityVersion",
+	RelatedImageFileFormat:  "RelatedImageFileFormat",
+	RelatedImageWidth:       "RelatedImageWidth",
+	RelatedImageLength:      "RelatedImageLength",
+}
+
+// Tags that may be found in Exif IFDs, from Exif 2.32 and 3.0.
+const (
+	ExposureTime                        = 0x829A
+	FNumber                             = 0x829D
+	ExposureProgram                     = 0x8822
+	SpectralSensitivity                 = 0x8824
+	PhotographicSensitivity             = 0x8827
+	OECF                                = 0x8828
+	SensitivityType                     = 0x8830
+	StandardOutputSensitivity           = 0x8831
+	RecommendedExposureIndex            = 0x8832
+	ISOSpeed                            = 0x8833
+	ISOSpeedLatitudeyyy                 = 0x8834
+	ISOSpeedLatitudezzz                 = 0x8835
+	ExifVersion                         = 0x9000
+	DateTimeOriginal                    = 0x9003
+	DateTimeDigitized                   = 0x9004
+	OffsetTime                          = 0x9010
+	OffsetTimeOriginal                  = 0x9011
+	OffsetTimeDigitized                 = 0x9012
+	ComponentsConfiguration             = 0x9101
+	CompressedBitsPerPixel              = 0x9102
+	ShutterSpeedValue                   = 0x9201
+	ApertureValue                       = 0x9202
+	BrightnessValue                     = 0x9203
+	ExposureBiasValue                   = 0x9204
+	MaxApertureValue                    = 0x9205
+	SubjectDistance                     = 0x9206
+	MeteringMode                        = 0x9207
+	LightSource                         = 0x9208
+	Flash                               = 0x9209
+	FocalLength                         = 0x920A
+	SubjectArea                         = 0x9214
+	MakerNote                           = 0x927C
+	UserComment                         = 0x9286
+	SubSecTime                          = 0x9290
+	SubSecTimeOriginal                  = 0x9291
+	SubSecTimeDigitized                 = 0x9292
+	Temperature                         = 0x9400
+	Humidity                            = 0x9401
+	Pressure                            = 0x9402
+	WaterDepth                          = 0x9403
+	Acceleration                        = 0x9404
+	CameraElevationAngle                = 0x9405
+	FlashpixVersion                     = 0xA000
+	ColorSpace                          = 0xA001
+	PixelXDimension                     = 0xA002
+	PixelYDimension                     = 0xA003
+	RelatedSoundFile                    = 0xA004
+	InteroperabilityIFD                 = 0xA005
+	FlashEnergy                         = 0xA20B
+	SpatialFrequencyResponse            = 0xA20C
+	FocalPlaneXResolution               = 0xA20E
+	FocalPlaneYResolution               = 0xA20F
+	FocalPlaneResolutionUnit            = 0xA210
+	SubjectLocation                     = 0xA214
+	ExposureIndex                       = 0xA215
+	SensingMethod                       = 0xA217
+	FileSource                          = 0xA300
+	SceneType                           = 0xA301
+	CFAPattern                          = 0xA302
+	CustomRendered                      = 0xA401
+	ExposureMode                        = 0xA402
+	WhiteBalance                        = 0xA403
+	DigitalZoomRatio                    = 0xA404
+	FocalLengthIn35mmFilm               = 0xA405
+	SceneCaptureType                    = 0xA406
+	GainControl                         = 0xA407
+	Contrast                            = 0xA408
+	Saturation                          = 0xA409
+	Sharpness                           = 0xA40A
+	DeviceSettingDescription            = 0xA40B
+	SubjectDistanceRange                = 0xA40C
+	ImageUniqueID                       = 0xA420
+	CameraOwnerName                     = 0xA430
+	BodySerialNumber                    = 0xA431
+	LensSpecification                   = 0xA432
+	LensMake                            = 0xA433
+	LensModel                           = 0xA434
+	LensSerialNumber                    = 0xA435
+	ImageTitle                          = 0xA436
+	Photographer                        = 0xA437
+	ImageEditor                         = 0xA438
+	CameraFirmware                      = 0xA439
+	RAWDevelopingSoftware               = 0xA43A
+	ImageEditingSoftware                = 0xA43B
+	MetadataEditingSoftware             = 0xA43C
+	CompositeImage                      = 0xA460
+	SourceImageNumberOfCompositeImage   = 0xA461
+	SourceExposureTimesOfCompositeImage = 0xA462
+	Gamma                               = 0xA500
+)
+
+// Mappings from Exif tags to strings.
+var ExifTagNames = map[Tag]string{
+	ExposureTime:                        "ExposureTime",
+	FNumber:                             "FNumber",
+	ExposureProgram:                     "ExposureProgram",
+	SpectralSensitivity:                 "SpectralSensitivity",
+	PhotographicSensitivity:             "PhotographicSensitivity",
+	OECF:                                "OECF",
+	SensitivityType:                     "SensitivityType",
+	StandardOutputSensitivity:           "StandardOutputSensitivity",
+	RecommendedExposureIndex:            "RecommendedExposureIndex",
+	ISOSpeed:                            "ISOSpeed",
+	ISOSpeedLatitudeyyy:                 "ISOSpeedLatitudeyyy",
+	ISOSpeedLatitudezzz:                 "ISOSpeedLatitudezzz",
+	ExifVersion:                         "ExifVersion",
+	DateTimeOriginal:                    "DateTimeOriginal",
+	DateTimeDigitized:                   "DateTimeDigitized",
+	OffsetTime:                          "OffsetTime",
+	OffsetTimeOriginal:                  "OffsetTimeOriginal",
+	OffsetTimeDigitized:                 "OffsetTimeDigitized",
+	ComponentsConfiguration:             "ComponentsConfiguration",
+	CompressedBitsPerPixel:              "CompressedBitsPerPixel",
+	ShutterSpeedValue:                   "ShutterSpeedValue",
+	ApertureValue:                       "ApertureValue",
+	BrightnessValue:                     "BrightnessValue",
+	ExposureBiasValue:                   "ExposureBiasValue",
+	MaxApertureValue:                    "MaxApertureValue",
+	SubjectDistance:                     "SubjectDistance",
+	MeteringMode:                        "MeteringMode",
+	LightSource:                         "LightSource",
+	Flash:                               "Flash",
+	FocalLength:                         "FocalLength",
+	SubjectArea:                         "SubjectArea",
+	MakerNote:                           "MakerNote",
+	UserComment:                         "UserComment",
+	SubSecTime:                          "SubSecTime",
+	SubSecTimeOriginal:                  "SubSecTimeOriginal",
+	SubSecTimeDigitized:                 "SubSecTimeDigitized",
+	Temperature:                         "Temperature",
+	Humidity:                            "Humidity",
+	Pressure:                            "Pressure",
+	WaterDepth:                          "WaterDepth",
+	Acceleration:                        "Acceleration",
+	CameraElevationAngle:                "CameraElevationAngle",
+	FlashpixVersion:                     "FlashpixVersion",
+	ColorSpace:                          "ColorSpace",
+	PixelXDimension:                     "PixelXDimension",
+	PixelYDimension:                     "PixelYDimension",
+	RelatedSoundFile:                    "RelatedSoundFile",
+	InteroperabilityIFD:                 "InteroperabilityIFD",
+	FlashEnergy:                         "FlashEnergy",
+	SpatialFrequencyResponse:            "SpatialFrequencyResponse",
+	FocalPlaneXResolution:               "FocalPlaneXResolution",
+	FocalPlaneYResolution:               "FocalPlaneYResolution",
+	FocalPlaneResolutionUnit:            "FocalPlaneResolutionUnit",
+	SubjectLocation:                     "SubjectLocation",
+	ExposureIndex:                       "ExposureIndex",
+	SensingMethod:                       "SensingMethod",
+	FileSource:                          "FileSource",
+	SceneType:                           "SceneType",
+	CFAPattern:                          "CFAPattern",
+	CustomRendered:                      "CustomRendered",
+	ExposureMode:                        "ExposureMode",
+	WhiteBalance:                        "WhiteBalance",
+	DigitalZoomRatio:                    "DigitalZoomRatio",
+	FocalLengthIn35mmFilm:               "FocalLengthIn35mmFilm",
+	SceneCaptureType:                    "SceneCaptureType",
+	GainControl:                         "GainControl",
+	Contrast:                            "Contrast",
+	Saturation:                          "Saturation",
+	Sharpness:                           "Sharpness",
+	DeviceSettingDescription:            "DeviceSettingDescription",
+	SubjectDistanceRange:                "SubjectDistanceRange",
+	ImageUniqueID:                       "ImageUniqueID",
+	CameraOwnerName:                     "CameraOwnerName",
+	BodySerialNumber:                    "BodySerialNumber",
+	LensSpecification:                   "LensSpecification",
+	LensMake:                            "LensMake",
+	LensModel:                           "LensModel",
+	LensSerialNumber:                    "LensSerialNumber",
+	ImageTitle:                          "ImageTitle",
+	Photographer:                        "Photographer",
+	ImageEditor:                         "ImageEditor",
+	CameraFirmware:                      "CameraFirmware",
+	RAWDevelopingSoftware:               "RAWDevelopingSoftware",
+	ImageEditingSoftware:                "ImageEditingSoftware",
+	MetadataEditingSoftware:             "MetadataEditingSoftware",
+	CompositeImage:                      "CompositeImage",
+	SourceImageNumberOfCompositeImage:   "SourceImageNumberOfCompositeImage",
+	SourceExposureTimesOfCompositeImage: "SourceExposureTimesOfCompositeImage",
+	Gamma:                               "Gamma",
+}
@@ -0,0 +1,173 @@
+package tiff66
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Return the Exiftool family 1 group name for IFDs in a tag space, or
+// an empty string for TIFF IFDs, whose group depends on their
+// position in the tree.
+func exiftoolGroup(space TagSpace) string {
+	switch space {
+	case TIFFSpace, DNGSpace:
+		return ""
+	case ExifSpace:
+		return "ExifIFD"
+	case GPSSpace:
+		return "GPS"
+	case InteropSpace:
+		return "InteropIFD"
+	case MPFIndexSpace, MPFAttributeSpace:
+		return "MPF0"
+	case Canon1Space:
+		return "Canon"
+	case Fujifilm1Space:
+		return "FujiFilm"
+	case Nikon1Space, Nikon2Space:
+		return "Nikon"
+	case Nikon2PreviewSpace:
+		return "PreviewIFD"
+	case Nikon2ScanSpace:
+		return "NikonScan"
+	case Olympus1Space, Olympus1EquipmentSpace, Olympus1CameraSettingsSpace, Olympus1RawDevelopmentSpace, Olympus1RawDev2Space, Olympus1ImageProcessingSpace, Olympus1FocusInfoSpace:
+		return "Olympus"
+	case Panasonic1Space:
+		return "Panasonic"
+	case Sony1Space:
+		return "Sony"
+	case SR2PrivateSpace:
+		return "SR2"
+	case SonyIDCSpace:
+		return "SonyIDC"
+	case Pentax1Space:
+		return "Pentax"
+	case Leica1Space:
+		return "Leica"
+	case PhaseOneSpace:
+		return "PhaseOne"
+	case Hasselblad1Space:
+		return "Hasselblad"
+	}
+	return space.Name()
+}
+
+// Format a rational as Exiftool does with its -n option.
+func exiftoolRational(num, denom int64) string {
+	if denom == 0 {
+		if num == 0 {
+			return "undef"
+		}
+		return "inf"
+	}
+	return strconv.FormatFloat(float64(num)/float64(denom), 'g', 10, 64)
+}
+
+// Return a field's value formatted as Exiftool does with its -n
+// option: numbers separated by spaces, rationals as decimals, and
+// strings without trailing NULs or spaces. UNDEFINED data is shown as
+// a string if it's printable, otherwise as a binary data note. Only
+// the values present in the field's data are shown.
+func exiftoolValue(f Field, order binary.ByteOrder) string {
+	if f.Type.Size() == 0 {
+		return "(unknown type)"
+	}
+	if f.Data == nil && f.Size() > 0 {
+		return fmt.Sprintf("(Binary data %d bytes)", f.Size())
+	}
+	n := f.present()
+	switch {
+	case f.Type == ASCII:
+		return strings.TrimRight(f.ASCII(), " ")
+	case f.Type == UNDEFINED:
+		str := strings.TrimRight(string(f.Data[:n]), "\000 ")
+		for _, c := range []byte(str) {
+			if c < ' ' || c > '~' {
+				return fmt.Sprintf("(Binary data %d bytes)", n)
+			}
+		}
+		return str
+	}
+	vals := make([]string, n)
+	for i := range vals {
+		switch {
+		case f.Type.IsRational():
+			vals[i] = exiftoolRational(f.AnyRational(uint32(i), order))
+		case f.Type.IsFloat():
+			vals[i] = strconv.FormatFloat(f.AnyFloat(uint32(i), order), 'g', 15, 64)
+		default:
+			val, _ := f.Value(uint32(i), order)
+			vals[i] = strconv.FormatInt(val.Int, 10)
+		}
+	}
+	return strings.Join(vals, " ")
+}
+
+// Write one line per field of an IFD node to w, then recurse into
+// its sub-IFDs and Next chain. group is the name of the node's group
+// if it's a TIFF IFD; for other spaces the group comes from the space.
+// ifdNum is the node's index in a chain of IFDs.
+func exiftoolNode(w io.Writer, node *IFDNode, group string, ifdNum int) error {
+	space := node.GetSpace()
+	if g := exiftoolGroup(space); g != "" {
+		group = g
+	}
+	names := space.TagNames()
+	isSubIFD := make(map[Tag]bool)
+	for _, sub := range node.SubIFDs {
+		isSubIFD[sub.Tag] = true
+	}
+	for _, f := range node.Fields {
+		if isSubIFD[f.Tag] {
+			continue
+		}
+		name, found := names[f.Tag]
+		if !found {
+			name = fmt.Sprintf("%s_0x%04x", space.Name(), f.Tag)
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", group, name, exiftoolValue(f, node.Order)); err != nil {
+			return err
+		}
+	}
+	subNum := 0
+	for _, sub := range node.SubIFDs {
+		subGroup := group
+		if sub.Tag == SubIFDs {
+			subGroup = "SubIFD"
+			if subNum > 0 {
+				subGroup += strconv.Itoa(subNum)
+			}
+			subNum++
+		}
+		if err := exiftoolNode(w, sub.Node, subGroup, 0); err != nil {
+			return err
+		}
+	}
+	if node.Next != nil {
+		nextGroup := group
+		if strings.HasPrefix(group, "IFD") {
+			nextGroup = "IFD" + strconv.Itoa(ifdNum+1)
+		}
+		return exiftoolNode(w, node.Next, nextGroup, ifdNum+1)
+	}
+	return nil
+}
+
+// Write an IFD tree to w as text in the style of "exiftool -G1 -s -t
+// -n": one line per field, with the group name, tag name and value
+// separated by tabs. The group names follow Exiftool's, e.g., IFD0,
+// IFD1, ExifIFD, GPS and maker note vendor names. Fields which link
+// to sub-IFDs are omitted, and unknown tags are named with their tag
+// space and number. This is intended for comparing output with
+// Exiftool's, but there will be differences in detail.
+func ExportExiftool(w io.Writer, node *IFDNode) error {
+	bw := bufio.NewWriter(w)
+	if err := exiftoolNode(bw, node, "IFD0", 0); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
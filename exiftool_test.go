@@ -0,0 +1,52 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Check the Exiftool-style text output for a small tree with Exif and
+// GPS sub-IFDs and a Next IFD.
+func TestExiftool(t *testing.T) {
+	order := binary.LittleEndian
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = order
+	exif.Fields = []Field{
+		{ExposureTime, RATIONAL, 1, []byte{1, 0, 0, 0, 125, 0, 0, 0}},
+		{ExifVersion, UNDEFINED, 4, []byte("0230")},
+		{0x9999, UNDEFINED, 2, []byte{1, 2}},
+		{0x999A, Type(99), 0xFFFFFFFF, []byte{1, 2, 3, 4}},
+		{0x999B, SHORT, 0xFFFFFFFF, []byte{1, 0, 2, 0}},
+	}
+	gps := NewIFDNode(GPSSpace)
+	gps.Order = order
+	gps.Fields = []Field{{GPSVersionID, BYTE, 4, []byte{2, 3, 0, 0}}}
+	next := NewIFDNode(TIFFSpace)
+	next.Order = order
+	next.Fields = []Field{{Compression, SHORT, 1, []byte{6, 0}}}
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.Fields = []Field{
+		{Make, ASCII, 6, []byte("Foo  \000")},
+		{ExifIFD, LONG, 1, []byte{0, 0, 0, 0}},
+		{GPSIFD, LONG, 1, []byte{0, 0, 0, 0}},
+	}
+	node.SubIFDs = []SubIFD{{ExifIFD, exif}, {GPSIFD, gps}}
+	node.Next = next
+	var buf bytes.Buffer
+	if err := ExportExiftool(&buf, node); err != nil {
+		t.Fatal(err)
+	}
+	expected := "IFD0\tMake\tFoo\n" +
+		"ExifIFD\tExposureTime\t0.008\n" +
+		"ExifIFD\tExifVersion\t0230\n" +
+		"ExifIFD\tExif_0x9999\t(Binary data 2 bytes)\n" +
+		"ExifIFD\tExif_0x999a\t(unknown type)\n" +
+		"ExifIFD\tExif_0x999b\t1 2\n" +
+		"GPS\tGPSVersionID\t2 3 0 0\n" +
+		"IFD1\tCompression\t6\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
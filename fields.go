@@ -0,0 +1,150 @@
+package tiff66
+
+// Convenience methods for getting and setting the values of fields in
+// an IFD. The Set methods replace any existing field with the tag, or
+// add a new one, keeping the fields sorted by tag.
+
+// Set an ASCII field.
+func (node *IFDNode) SetASCII(tag Tag, val string) {
+	field := Field{Tag: tag, Type: ASCII}
+	field.PutASCII(val)
+	field.Count = uint32(len(field.Data))
+	node.replaceField(field)
+}
+
+// Set a BYTE field.
+func (node *IFDNode) SetBytes(tag Tag, vals []byte) {
+	node.replaceField(Field{tag, BYTE, uint32(len(vals)), append([]byte(nil), vals...)})
+}
+
+// Set an UNDEFINED field.
+func (node *IFDNode) SetUndefined(tag Tag, data []byte) {
+	node.replaceField(Field{tag, UNDEFINED, uint32(len(data)), append([]byte(nil), data...)})
+}
+
+// Set a SHORT field.
+func (node *IFDNode) SetShorts(tag Tag, vals []uint16) {
+	node.replaceField(shortField(tag, node.Order, vals...))
+}
+
+// Set a LONG field.
+func (node *IFDNode) SetLongs(tag Tag, vals []uint32) {
+	node.replaceField(longField(tag, node.Order, vals...))
+}
+
+// Set a RATIONAL field from numerator, denominator pairs.
+func (node *IFDNode) SetRationals(tag Tag, vals [][2]uint32) {
+	field := Field{tag, RATIONAL, uint32(len(vals)), make([]byte, 8*len(vals))}
+	for i, v := range vals {
+		field.PutRational(v[0], v[1], uint32(i), node.Order)
+	}
+	node.replaceField(field)
+}
+
+// Set a DOUBLE field.
+func (node *IFDNode) SetDoubles(tag Tag, vals []float64) {
+	field := Field{tag, DOUBLE, uint32(len(vals)), make([]byte, 8*len(vals))}
+	for i, v := range vals {
+		field.PutDouble(v, uint32(i), node.Order)
+	}
+	node.replaceField(field)
+}
+
+// Return the first field with a tag, if it has one of the given types.
+func (node IFDNode) typedField(tag Tag, types ...Type) *Field {
+	field, found := node.FindField(tag)
+	if !found || uint64(len(field.Data)) < uint64(field.Size()) {
+		return nil
+	}
+	for _, t := range types {
+		if field.Type == t {
+			return field
+		}
+	}
+	return nil
+}
+
+// Return the value of an ASCII field. The bool is false if the field
+// isn't found, doesn't have ASCII type, or its data isn't loaded.
+func (node IFDNode) GetASCII(tag Tag) (string, bool) {
+	field := node.typedField(tag, ASCII)
+	if field == nil {
+		return "", false
+	}
+	return field.ASCII(), true
+}
+
+// Return the data of a BYTE or UNDEFINED field. The returned slice
+// points to the field data. The bool is false if the field isn't found,
+// doesn't have one of these types, or its data isn't loaded.
+func (node IFDNode) GetBytes(tag Tag) ([]byte, bool) {
+	field := node.typedField(tag, BYTE, UNDEFINED)
+	if field == nil {
+		return nil, false
+	}
+	return field.Data[:field.Count], true
+}
+
+// Return the values of a BYTE or SHORT field. The bool is false if
+// the field isn't found, doesn't have one of these types, or its data
+// isn't loaded.
+func (node IFDNode) GetShorts(tag Tag) ([]uint16, bool) {
+	field := node.typedField(tag, BYTE, SHORT)
+	if field == nil {
+		return nil, false
+	}
+	vals := make([]uint16, field.Count)
+	for i := range vals {
+		vals[i] = uint16(field.AnyInteger(uint32(i), node.Order))
+	}
+	return vals, true
+}
+
+// Return the values of a BYTE, SHORT, LONG or IFD field. The bool is
+// false if the field isn't found, doesn't have one of these types, or
+// its data isn't loaded.
+func (node IFDNode) GetLongs(tag Tag) ([]uint32, bool) {
+	field := node.typedField(tag, BYTE, SHORT, LONG, IFD)
+	if field == nil {
+		return nil, false
+	}
+	vals := make([]uint32, field.Count)
+	for i := range vals {
+		if field.Type == IFD {
+			vals[i] = field.Long(uint32(i), node.Order)
+		} else {
+			vals[i] = uint32(field.AnyInteger(uint32(i), node.Order))
+		}
+	}
+	return vals, true
+}
+
+// Return the values of a RATIONAL field as numerator, denominator
+// pairs. The bool is false if the field isn't found, doesn't have
+// RATIONAL type, or its data isn't loaded.
+func (node IFDNode) GetRationals(tag Tag) ([][2]uint32, bool) {
+	field := node.typedField(tag, RATIONAL)
+	if field == nil {
+		return nil, false
+	}
+	vals := make([][2]uint32, field.Count)
+	for i := range vals {
+		vals[i][0], vals[i][1] = field.Rational(uint32(i), node.Order)
+	}
+	return vals, true
+}
+
+// Return the values of a FLOAT or DOUBLE field. The bool is false if
+// the field isn't found, doesn't have one of these types, or its data
+// isn't loaded.
+func (node IFDNode) GetDoubles(tag Tag) ([]float64, bool) {
+	field := node.typedField(tag, FLOAT, DOUBLE)
+	if field == nil {
+		return nil, false
+	}
+	vals := make([]float64, field.Count)
+	for i := range vals {
+		vals[i] = field.AnyFloat(uint32(i), node.Order)
+	}
+	return vals, true
+}
@@ -0,0 +1,203 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestSetGetFields(t *testing.T) {
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.BigEndian
+	node.SetShorts(Orientation, []uint16{1})
+	node.SetASCII(Artist, "Someone")
+	node.SetLongs(StripOffsets, []uint32{1, 70000})
+	node.SetRationals(XResolution, [][2]uint32{{300, 1}})
+	node.SetBytes(GPSVersionID, []byte{2, 3, 0, 0})
+	node.SetUndefined(JPEGTables, []byte{0xFF, 0xD8})
+	node.SetShorts(Orientation, []uint16{6, 7})
+	for i := 1; i < len(node.Fields); i++ {
+		if node.Fields[i-1].Tag >= node.Fields[i].Tag {
+			t.Fatal("Fields not sorted or duplicated")
+		}
+	}
+	if s, ok := node.GetASCII(Artist); !ok || s != "Someone" {
+		t.Errorf("GetASCII returned %q, %v", s, ok)
+	}
+	if v, ok := node.GetShorts(Orientation); !ok || len(v) != 2 || v[1] != 7 {
+		t.Errorf("GetShorts returned %v, %v", v, ok)
+	}
+	if v, ok := node.GetLongs(Orientation); !ok || len(v) != 2 || v[0] != 6 {
+		t.Errorf("GetLongs of SHORT field returned %v, %v", v, ok)
+	}
+	if v, ok := node.GetLongs(StripOffsets); !ok || v[1] != 70000 {
+		t.Errorf("GetLongs returned %v, %v", v, ok)
+	}
+	if _, ok := node.GetShorts(StripOffsets); ok {
+		t.Error("GetShorts accepted a LONG field")
+	}
+	if v, ok := node.GetRationals(XResolution); !ok || v[0] != [2]uint32{300, 1} {
+		t.Errorf("GetRationals returned %v, %v", v, ok)
+	}
+	if v, ok := node.GetBytes(JPEGTables); !ok || len(v) != 2 || v[1] != 0xD8 {
+		t.Errorf("GetBytes returned %v, %v", v, ok)
+	}
+	node.SetDoubles(ModelPixelScaleTag, []float64{0.5, 2})
+	if v, ok := node.GetDoubles(ModelPixelScaleTag); !ok || len(v) != 2 || v[0] != 0.5 {
+		t.Errorf("GetDoubles returned %v, %v", v, ok)
+	}
+	if _, ok := node.GetASCII(Copyright); ok {
+		t.Error("GetASCII found a missing field")
+	}
+	if f, ok := node.FindField(Artist); !ok || f != node.FindFields([]Tag{Artist})[0] {
+		t.Error("FindField didn't return the field")
+	}
+	if f, ok := node.FindField(Copyright); ok || f != nil {
+		t.Error("FindField found a missing field")
+	}
+	if f := node.MustField(Orientation); f.Count != 2 {
+		t.Errorf("MustField returned count %d", f.Count)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("MustField didn't panic for a missing field")
+			}
+		}()
+		node.MustField(Copyright)
+	}()
+	// Field with a truncated data slice.
+	node.Fields = append(node.Fields, Field{YResolution, RATIONAL, 2, make([]byte, 8)})
+	if _, ok := node.GetRationals(YResolution); ok {
+		t.Error("GetRationals accepted truncated data")
+	}
+}
+
+func TestAddField(t *testing.T) {
+	order := binary.BigEndian
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	for _, tag := range []Tag{Software, ImageWidth, Orientation} {
+		if err := node.AddField(Field{tag, SHORT, 1, []byte{0, 1}}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := node.AddField(Field{Orientation, SHORT, 1, []byte{0, 6}}, false); err == nil {
+		t.Error("Duplicate field was added")
+	}
+	if v, _ := node.GetShorts(Orientation); v[0] != 1 {
+		t.Error("Field was changed by a failed AddField")
+	}
+	if err := node.AddField(Field{Orientation, SHORT, 1, []byte{0, 6}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := node.GetShorts(Orientation); v[0] != 6 {
+		t.Error("Field wasn't replaced")
+	}
+	if len(node.Fields) != 3 {
+		t.Fatalf("%d fields, expected 3", len(node.Fields))
+	}
+	for i, tag := range []Tag{ImageWidth, Orientation, Software} {
+		if node.Fields[i].Tag != tag {
+			t.Errorf("Field %d has tag %d, expected %d", i, node.Fields[i].Tag, tag)
+		}
+	}
+}
+
+func TestCheckedAccessors(t *testing.T) {
+	order := binary.LittleEndian
+	// A LONG field whose count is larger than its data, as may be
+	// found in a malformed file.
+	bad := Field{StripOffsets, LONG, 3, []byte{1, 0, 0, 0, 2, 0, 0, 0}}
+	if v, err := bad.CheckedLong(1, order); err != nil || v != 2 {
+		t.Errorf("CheckedLong returned %d, %v", v, err)
+	}
+	if _, err := bad.CheckedLong(2, order); err == nil {
+		t.Error("Missing data didn't cause an error")
+	}
+	if _, err := bad.CheckedLong(3, order); err == nil {
+		t.Error("Index out of range didn't cause an error")
+	}
+	if _, err := bad.CheckedShort(0, order); err == nil {
+		t.Error("Wrong type didn't cause an error")
+	}
+	if _, err := bad.CheckedValues(order); err == nil {
+		t.Error("CheckedValues didn't return an error")
+	}
+	if v, err := bad.CheckedAnyInteger(0, order); err != nil || v != 1 {
+		t.Errorf("CheckedAnyInteger returned %d, %v", v, err)
+	}
+	if _, _, err := bad.CheckedAnyRational(0, order); err == nil {
+		t.Error("CheckedAnyRational of LONG field didn't return an error")
+	}
+	if _, err := bad.CheckedAnyFloat(0, order); err == nil {
+		t.Error("CheckedAnyFloat of LONG field didn't return an error")
+	}
+	if _, err := (Field{1, 99, 1, []byte{0}}).CheckedValue(0, order); err == nil {
+		t.Error("Unknown type didn't cause an error")
+	}
+	rat := Field{XResolution, RATIONAL, 1, []byte{3, 0, 0, 0, 2, 0, 0, 0}}
+	if n, d, err := rat.CheckedRational(0, order); err != nil || n != 3 || d != 2 {
+		t.Errorf("CheckedRational returned %d/%d, %v", n, d, err)
+	}
+	if vals, err := rat.CheckedValues(order); err != nil || len(vals) != 1 || vals[0].Num != 3 {
+		t.Errorf("CheckedValues returned %v, %v", vals, err)
+	}
+}
+
+func TestFixOptions(t *testing.T) {
+	order := binary.LittleEndian
+	newNode := func() *IFDNode {
+		node := NewIFDNode(TIFFSpace)
+		node.Order = order
+		node.Fields = []Field{
+			{Software, ASCII, 4, []byte("Soft")},
+			{StripOffsets, SHORT, 1, []byte{8, 0}},
+			{StripByteCounts, SHORT, 1, []byte{1, 0}},
+			{Artist, 99, 1, []byte{1, 2, 3, 4}},
+			{XResolution, RATIONAL, 2, make([]byte, 8)},
+		}
+		node.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{{0}}}}}
+		return node
+	}
+
+	node := newNode()
+	node.Fix()
+	if f := node.MustField(Software); f.Count != 5 || f.Data[4] != 0 {
+		t.Error("Fix didn't terminate ASCII field")
+	}
+	if f := node.MustField(StripOffsets); f.Type != LONG || f.Long(0, order) != 8 {
+		t.Error("Fix didn't promote offsets")
+	}
+	if _, found := node.FindField(Artist); !found {
+		t.Error("Fix dropped a field with unknown type")
+	}
+	if node.Fields[0].Tag != StripOffsets {
+		t.Error("Fix didn't sort fields")
+	}
+
+	node = newNode()
+	if err := node.FixWithOptions(&FixOptions{DropUnknownTypes: true, ClampCounts: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := node.FindField(Artist); found {
+		t.Error("Field with unknown type wasn't dropped")
+	}
+	if f := node.MustField(XResolution); f.Count != 1 || len(f.Data) != 8 {
+		t.Errorf("XResolution count is %d, expected 1", f.Count)
+	}
+	if f := node.MustField(Software); f.Count != 4 {
+		t.Error("ASCII field was terminated")
+	}
+	if f := node.MustField(StripOffsets); f.Type != SHORT {
+		t.Error("Offsets were promoted")
+	}
+	if node.Fields[0].Tag != Software {
+		t.Error("Fields were sorted")
+	}
+
+	node = newNode()
+	node.Fields = append(node.Fields, Field{Software, ASCII, 1, []byte{0}})
+	if err := node.FixWithOptions(&FixOptions{Duplicates: DuplicateReport}); err == nil || len(node.Fields) != 6 {
+		t.Error("Duplicate wasn't reported")
+	}
+}
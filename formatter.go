@@ -0,0 +1,61 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Renders the values of fields for Field.PrintSpace and
+// IFDNode.PrintTree, in place of the generic rendering.
+type Formatter interface {
+	// Return the text for a field's values, up to a given limit (or
+	// 0 for no limit), and whether the field was handled. If it
+	// wasn't, the values are printed generically. The field's data
+	// is loaded, but may be shorter than its count requires.
+	Format(f Field, order binary.ByteOrder, limit uint32) (string, bool)
+}
+
+// An adapter that allows a function to be used as a Formatter.
+type FormatterFunc func(f Field, order binary.ByteOrder, limit uint32) (string, bool)
+
+// Call the function.
+func (fn FormatterFunc) Format(f Field, order binary.ByteOrder, limit uint32) (string, bool) {
+	return fn(f, order, limit)
+}
+
+// Formatters registered with RegisterFormatter.
+var registeredFormatters = make(map[SpaceTag]Formatter)
+
+// Register a formatter for the fields with a given tag in a tag
+// namespace. A nil formatter removes a previous registration.
+func RegisterFormatter(space TagSpace, tag Tag, formatter Formatter) {
+	if formatter == nil {
+		delete(registeredFormatters, SpaceTag{space, tag})
+	} else {
+		registeredFormatters[SpaceTag{space, tag}] = formatter
+	}
+}
+
+// Return the formatter registered for a tag in a tag namespace, or nil.
+func (space TagSpace) Formatter(tag Tag) Formatter {
+	return registeredFormatters[SpaceTag{space, tag}]
+}
+
+// Return a formatter that renders the values of an integer field with
+// names from a map, such as those in ValueNames, e.g., "5 (LZW)".
+// Values without names are rendered as numbers. Fields of other types,
+// or whose data is too short, aren't handled.
+func ValueNameFormatter(names map[int64]string) Formatter {
+	return FormatterFunc(func(f Field, order binary.ByteOrder, limit uint32) (string, bool) {
+		if !f.Type.IsIntegral() || f.Count == 0 || f.CheckIndex(f.Count-1) != nil {
+			return "", false
+		}
+		return formatValues(f, order, limit, func(f Field, i uint32, order binary.ByteOrder) string {
+			val := f.AnyInteger(i, order)
+			if name, found := names[val]; found {
+				return fmt.Sprintf("%d (%s)", val, name)
+			}
+			return fmt.Sprintf("%d", val)
+		})[1:], true
+	})
+}
@@ -0,0 +1,55 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestFormatter(t *testing.T) {
+	RegisterFormatter(TIFFSpace, Compression, ValueNameFormatter(CompressionNames))
+	defer RegisterFormatter(TIFFSpace, Compression, nil)
+	// A formatter that only handles integer values.
+	RegisterFormatter(TIFFSpace, XResolution, FormatterFunc(func(f Field, order binary.ByteOrder, limit uint32) (string, bool) {
+		return "dpi", f.Type.IsIntegral()
+	}))
+	defer RegisterFormatter(TIFFSpace, XResolution, nil)
+	RegisterFormatter(TIFFSpace, ResolutionUnit, FormatterFunc(func(f Field, order binary.ByteOrder, limit uint32) (string, bool) {
+		return "per " + ResolutionUnitNames[f.AnyInteger(0, order)][:4], true
+	}))
+	if TIFFSpace.Formatter(ResolutionUnit) == nil || ExifSpace.Formatter(ResolutionUnit) != nil {
+		t.Error("Formatter registered for the wrong space")
+	}
+	RegisterFormatter(TIFFSpace, ResolutionUnit, nil)
+	if TIFFSpace.Formatter(ResolutionUnit) != nil {
+		t.Error("Formatter wasn't removed")
+	}
+	RegisterFormatter(TIFFSpace, Orientation, ValueNameFormatter(OrientationNames))
+	defer RegisterFormatter(TIFFSpace, Orientation, nil)
+
+	root := grayPage(t, 10)
+	root.SetShorts(Orientation, []uint16{6, 9})
+	var out bytes.Buffer
+	if err := root.PrintTree(&out, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"\n  Compression Short(1) 1 (Uncompressed)\n",
+		"\n  Orientation Short(2) 6 (Rotate 90 CW) 9\n",
+		// Not handled by the formatter.
+		"\n  XResolution Rational(1) 72/1\n",
+		"\n  ResolutionUnit Short(1) 2\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Tree doesn't contain %q:\n%s", line, out.String())
+		}
+	}
+	formatter := ValueNameFormatter(CompressionNames)
+	if _, ok := formatter.Format(Field{Compression, SHORT, 2, []byte{0, 5}}, binary.BigEndian, 0); ok {
+		t.Error("Formatter handled a field with short data")
+	}
+	if text, ok := formatter.Format(Field{Compression, LONG, 3, make([]byte, 12)}, binary.BigEndian, 2); !ok || text != "0 0..." {
+		t.Errorf("Formatter returned %q", text)
+	}
+}
@@ -0,0 +1,293 @@
+package tiff66
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mappings from GeoTIFF key IDs to strings, from the GeoTIFF 1.0
+// specification.
+var GeoKeyNames = map[uint16]string{
+	1024: "GTModelTypeGeoKey",
+	1025: "GTRasterTypeGeoKey",
+	1026: "GTCitationGeoKey",
+	2048: "GeographicTypeGeoKey",
+	2049: "GeogCitationGeoKey",
+	2050: "GeogGeodeticDatumGeoKey",
+	2051: "GeogPrimeMeridianGeoKey",
+	2052: "GeogLinearUnitsGeoKey",
+	2053: "GeogLinearUnitSizeGeoKey",
+	2054: "GeogAngularUnitsGeoKey",
+	2055: "GeogAngularUnitSizeGeoKey",
+	2056: "GeogEllipsoidGeoKey",
+	2057: "GeogSemiMajorAxisGeoKey",
+	2058: "GeogSemiMinorAxisGeoKey",
+	2059: "GeogInvFlatteningGeoKey",
+	2060: "GeogAzimuthUnitsGeoKey",
+	2061: "GeogPrimeMeridianLongGeoKey",
+	3072: "ProjectedCSTypeGeoKey",
+	3073: "PCSCitationGeoKey",
+	3074: "ProjectionGeoKey",
+	3075: "ProjCoordTransGeoKey",
+	3076: "ProjLinearUnitsGeoKey",
+	3077: "ProjLinearUnitSizeGeoKey",
+	3078: "ProjStdParallel1GeoKey",
+	3079: "ProjStdParallel2GeoKey",
+	3080: "ProjNatOriginLongGeoKey",
+	3081: "ProjNatOriginLatGeoKey",
+	3082: "ProjFalseEastingGeoKey",
+	3083: "ProjFalseNorthingGeoKey",
+	3084: "ProjFalseOriginLongGeoKey",
+	3085: "ProjFalseOriginLatGeoKey",
+	3086: "ProjFalseOriginEastingGeoKey",
+	3087: "ProjFalseOriginNorthingGeoKey",
+	3088: "ProjCenterLongGeoKey",
+	3089: "ProjCenterLatGeoKey",
+	3090: "ProjCenterEastingGeoKey",
+	3091: "ProjCenterNorthingGeoKey",
+	3092: "ProjScaleAtNatOriginGeoKey",
+	3093: "ProjScaleAtCenterGeoKey",
+	3094: "ProjAzimuthAngleGeoKey",
+	3095: "ProjStraightVertPoleLongGeoKey",
+	4096: "VerticalCSTypeGeoKey",
+	4097: "VerticalCitationGeoKey",
+	4098: "VerticalDatumGeoKey",
+	4099: "VerticalUnitsGeoKey",
+}
+
+// A key from a GeoTIFF key directory. Type is SHORT, DOUBLE or ASCII,
+// and the corresponding value is set.
+type GeoKey struct {
+	ID      uint16
+	Type    Type
+	Shorts  []uint16
+	Doubles []float64
+	ASCII   string // Without the terminating '|'.
+}
+
+// Return the name of a GeoKey, or "" if it isn't known.
+func (key GeoKey) Name() string {
+	return GeoKeyNames[key.ID]
+}
+
+// Version numbers written at the start of a GeoKey directory: the
+// directory version, key revision and minor revision.
+var geoKeyVersion = []uint16{1, 1, 0}
+
+// Return the keys from the GeoKeyDirectoryTag field of a node, with
+// their values taken from the directory itself or the
+// GeoDoubleParamsTag and GeoAsciiParamsTag fields.
+func (node IFDNode) GeoKeys() ([]GeoKey, error) {
+	dir, ok := node.GetShorts(GeoKeyDirectoryTag)
+	if !ok {
+		return nil, errors.New("GeoKeys: GeoKeyDirectoryTag field not found")
+	}
+	if len(dir) < 4 {
+		return nil, errors.New("GeoKeys: GeoKeyDirectoryTag field is too short")
+	}
+	if dir[0] != geoKeyVersion[0] {
+		return nil, fmt.Errorf("GeoKeys: unknown GeoKey directory version %d", dir[0])
+	}
+	count := int(dir[3])
+	if len(dir) < 4+count*4 {
+		return nil, errors.New("GeoKeys: GeoKeyDirectoryTag field is too short for its number of keys")
+	}
+	doubles, _ := node.GetDoubles(GeoDoubleParamsTag)
+	var ascii []byte
+	if field := node.typedField(GeoAsciiParamsTag, ASCII); field != nil {
+		ascii = field.Data[:field.Count]
+	}
+	keys := make([]GeoKey, count)
+	for i := range keys {
+		entry := dir[4+i*4 : 8+i*4]
+		key := &keys[i]
+		key.ID = entry[0]
+		location, n, offset := Tag(entry[1]), int(entry[2]), int(entry[3])
+		switch location {
+		case 0:
+			key.Type = SHORT
+			key.Shorts = []uint16{entry[3]}
+		case GeoKeyDirectoryTag:
+			if offset+n > len(dir) {
+				return nil, fmt.Errorf("GeoKeys: values of key %d are past end of directory", key.ID)
+			}
+			key.Type = SHORT
+			key.Shorts = append([]uint16(nil), dir[offset:offset+n]...)
+		case GeoDoubleParamsTag:
+			if offset+n > len(doubles) {
+				return nil, fmt.Errorf("GeoKeys: values of key %d are past end of GeoDoubleParamsTag", key.ID)
+			}
+			key.Type = DOUBLE
+			key.Doubles = append([]float64(nil), doubles[offset:offset+n]...)
+		case GeoAsciiParamsTag:
+			if offset+n > len(ascii) {
+				return nil, fmt.Errorf("GeoKeys: value of key %d is past end of GeoAsciiParamsTag", key.ID)
+			}
+			key.Type = ASCII
+			key.ASCII = strings.TrimRight(string(ascii[offset:offset+n]), "|\000")
+		default:
+			return nil, fmt.Errorf("GeoKeys: key %d has unknown location %d(0x%X)", key.ID, location, location)
+		}
+	}
+	return keys, nil
+}
+
+// Set the GeoKeyDirectoryTag field of a node from a list of keys, with
+// the GeoDoubleParamsTag and GeoAsciiParamsTag fields holding their
+// DOUBLE and ASCII values. The keys are sorted by ID. Parameter fields
+// that aren't needed are deleted.
+func (node *IFDNode) SetGeoKeys(keys []GeoKey) error {
+	sorted := append([]GeoKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	if len(sorted) > 0xFFFF/4 {
+		return errors.New("SetGeoKeys: too many keys")
+	}
+	dir := append([]uint16(nil), geoKeyVersion...)
+	dir = append(dir, uint16(len(sorted)))
+	var extra []uint16
+	var doubles []float64
+	var ascii string
+	for i, key := range sorted {
+		if i > 0 && key.ID == sorted[i-1].ID {
+			return fmt.Errorf("SetGeoKeys: duplicate key %d", key.ID)
+		}
+		var location Tag
+		var count, offset int
+		switch key.Type {
+		case SHORT:
+			count = len(key.Shorts)
+			if count == 1 {
+				offset = int(key.Shorts[0])
+				break
+			}
+			location = GeoKeyDirectoryTag
+			offset = len(extra)
+			extra = append(extra, key.Shorts...)
+		case DOUBLE:
+			location = GeoDoubleParamsTag
+			count = len(key.Doubles)
+			offset = len(doubles)
+			doubles = append(doubles, key.Doubles...)
+		case ASCII:
+			if strings.ContainsAny(key.ASCII, "|\000") {
+				return fmt.Errorf("SetGeoKeys: value of key %d contains '|' or NUL", key.ID)
+			}
+			location = GeoAsciiParamsTag
+			count = len(key.ASCII) + 1
+			offset = len(ascii)
+			ascii += key.ASCII + "|"
+		default:
+			return fmt.Errorf("SetGeoKeys: key %d has invalid type %s", key.ID, key.Type.Name())
+		}
+		if count == 0 || count > 0xFFFF || offset > 0xFFFF {
+			return fmt.Errorf("SetGeoKeys: key %d has an invalid number of values", key.ID)
+		}
+		dir = append(dir, key.ID, uint16(location), uint16(count), uint16(offset))
+	}
+	// Values stored in the directory follow the keys.
+	if len(dir)+len(extra) > 0xFFFF {
+		return errors.New("SetGeoKeys: directory is too large")
+	}
+	for i := 4; i < len(dir); i += 4 {
+		if Tag(dir[i+1]) == GeoKeyDirectoryTag {
+			dir[i+3] += uint16(len(dir))
+		}
+	}
+	node.SetShorts(GeoKeyDirectoryTag, append(dir, extra...))
+	if len(doubles) > 0 {
+		node.SetDoubles(GeoDoubleParamsTag, doubles)
+	} else {
+		node.DeleteFields([]Tag{GeoDoubleParamsTag})
+	}
+	if len(ascii) > 0 {
+		node.SetASCII(GeoAsciiParamsTag, ascii)
+	} else {
+		node.DeleteFields([]Tag{GeoAsciiParamsTag})
+	}
+	return nil
+}
+
+// GeoKey IDs and values used by SetGeoReference.
+const (
+	gtModelTypeGeoKey     = 1024
+	gtRasterTypeGeoKey    = 1025
+	geographicTypeGeoKey  = 2048
+	projectedCSTypeGeoKey = 3072
+
+	modelTypeProjected  = 1
+	modelTypeGeographic = 2
+	rasterPixelIsArea   = 1
+	rasterPixelIsPoint  = 2
+)
+
+// Parameters for georeferencing an image that isn't rotated, as set by
+// SetGeoReference.
+type GeoReference struct {
+	// Model coordinates of the upper left corner of the image, or
+	// of the center of the upper left pixel if PixelIsPoint is set.
+	OriginX, OriginY float64
+	// Size of a pixel in model units. Y coordinates decrease down
+	// the image.
+	PixelWidth, PixelHeight float64
+	// EPSG code of the coordinate reference system, which is a
+	// geographic system with latitude and longitude if Geographic
+	// is set, otherwise a projected system.
+	EPSG         uint16
+	Geographic   bool
+	PixelIsPoint bool
+}
+
+// Set the ModelPixelScaleTag field from the size of a pixel in model
+// units.
+func (node *IFDNode) SetGeoPixelScale(width, height float64) error {
+	if !(width > 0) || !(height > 0) {
+		return fmt.Errorf("SetGeoPixelScale: invalid pixel size %g x %g", width, height)
+	}
+	node.SetDoubles(ModelPixelScaleTag, []float64{width, height, 0})
+	return nil
+}
+
+// Set the ModelTiepointTag field to a single tie point, mapping the
+// raster position (i, j) to the model position (x, y).
+func (node *IFDNode) SetGeoTiepoint(i, j, x, y float64) {
+	node.SetDoubles(ModelTiepointTag, []float64{i, j, 0, x, y, 0})
+}
+
+// Set the ModelTransformationTag field from a 4x4 matrix in row-major
+// order, mapping raster to model coordinates. The ModelPixelScaleTag
+// and ModelTiepointTag fields, which it replaces, are deleted.
+func (node *IFDNode) SetGeoTransformation(matrix [16]float64) {
+	node.DeleteFields([]Tag{ModelPixelScaleTag, ModelTiepointTag})
+	node.SetDoubles(ModelTransformationTag, matrix[:])
+}
+
+// Set the fields that georeference an image: ModelPixelScaleTag,
+// ModelTiepointTag and the GeoKeys giving the model type, raster type
+// and coordinate reference system. Any ModelTransformationTag field is
+// deleted.
+func (node *IFDNode) SetGeoReference(ref GeoReference) error {
+	if !(ref.PixelWidth > 0) || !(ref.PixelHeight > 0) {
+		return fmt.Errorf("SetGeoReference: invalid pixel size %g x %g", ref.PixelWidth, ref.PixelHeight)
+	}
+	if ref.EPSG == 0 {
+		return errors.New("SetGeoReference: EPSG code not set")
+	}
+	node.DeleteFields([]Tag{ModelTransformationTag})
+	node.SetDoubles(ModelPixelScaleTag, []float64{ref.PixelWidth, ref.PixelHeight, 0})
+	node.SetGeoTiepoint(0, 0, ref.OriginX, ref.OriginY)
+	modelType, crsKey := uint16(modelTypeProjected), uint16(projectedCSTypeGeoKey)
+	if ref.Geographic {
+		modelType, crsKey = modelTypeGeographic, geographicTypeGeoKey
+	}
+	rasterType := uint16(rasterPixelIsArea)
+	if ref.PixelIsPoint {
+		rasterType = rasterPixelIsPoint
+	}
+	return node.SetGeoKeys([]GeoKey{
+		{gtModelTypeGeoKey, SHORT, []uint16{modelType}, nil, ""},
+		{gtRasterTypeGeoKey, SHORT, []uint16{rasterType}, nil, ""},
+		{crsKey, SHORT, []uint16{ref.EPSG}, nil, ""},
+	})
+}
@@ -0,0 +1,118 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestGeoKeys(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		node := NewIFDNode(TIFFSpace)
+		node.Order = order
+		if _, err := node.GeoKeys(); err == nil {
+			t.Error("Missing GeoKeyDirectoryTag didn't cause an error")
+		}
+		// Directory from the GeoTIFF specification's example of
+		// a UTM projection, with an added multi-valued key.
+		node.SetShorts(GeoKeyDirectoryTag, []uint16{
+			1, 1, 0, 5,
+			1024, 0, 1, 1,
+			1025, 0, 1, 1,
+			1026, GeoAsciiParamsTag, 18, 0,
+			3072, 0, 1, 32660,
+			3078, GeoDoubleParamsTag, 2, 0,
+		})
+		node.SetASCII(GeoAsciiParamsTag, "UTM Zone 60 N NAD|")
+		node.SetDoubles(GeoDoubleParamsTag, []float64{1.5, -2.25})
+		keys, err := node.GeoKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []GeoKey{
+			{1024, SHORT, []uint16{1}, nil, ""},
+			{1025, SHORT, []uint16{1}, nil, ""},
+			{1026, ASCII, nil, nil, "UTM Zone 60 N NAD"},
+			{3072, SHORT, []uint16{32660}, nil, ""},
+			{3078, DOUBLE, nil, []float64{1.5, -2.25}, ""},
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("Decoded keys %v", keys)
+		}
+		if keys[3].Name() != "ProjectedCSTypeGeoKey" {
+			t.Errorf("Key 3072 named %q", keys[3].Name())
+		}
+
+		// Encode them in a different order, with a multi-valued
+		// SHORT key, and decode again.
+		keys = append([]GeoKey{{4096, SHORT, []uint16{5, 6}, nil, ""}}, keys...)
+		enc := NewIFDNode(TIFFSpace)
+		enc.Order = order
+		if err := enc.SetGeoKeys(keys); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := enc.GeoKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, append(expected, keys[0])) {
+			t.Errorf("Re-encoded keys %v", decoded)
+		}
+		if err := enc.SetGeoKeys(keys[:2]); err != nil {
+			t.Fatal(err)
+		}
+		if len(enc.FindFields([]Tag{GeoDoubleParamsTag, GeoAsciiParamsTag})) != 0 {
+			t.Error("Unused parameter fields weren't deleted")
+		}
+		if err := enc.SetGeoKeys([]GeoKey{{1026, ASCII, nil, nil, "a|b"}}); err == nil {
+			t.Error("ASCII value with '|' didn't cause an error")
+		}
+	}
+}
+
+func TestSetGeoReference(t *testing.T) {
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.LittleEndian
+	node.SetGeoTransformation([16]float64{1, 0, 0, 10, 0, -1, 0, 20, 0, 0, 0, 0, 0, 0, 0, 1})
+	if m, ok := node.GetDoubles(ModelTransformationTag); !ok || len(m) != 16 || m[3] != 10 {
+		t.Errorf("ModelTransformationTag is %v", m)
+	}
+	ref := GeoReference{OriginX: 500000, OriginY: 4649776, PixelWidth: 30, PixelHeight: 30, EPSG: 32617}
+	if err := node.SetGeoReference(ref); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.FindFields([]Tag{ModelTransformationTag})) != 0 {
+		t.Error("ModelTransformationTag wasn't deleted")
+	}
+	if scale, ok := node.GetDoubles(ModelPixelScaleTag); !ok || !reflect.DeepEqual(scale, []float64{30, 30, 0}) {
+		t.Errorf("ModelPixelScaleTag is %v", scale)
+	}
+	if tie, ok := node.GetDoubles(ModelTiepointTag); !ok || !reflect.DeepEqual(tie, []float64{0, 0, 0, 500000, 4649776, 0}) {
+		t.Errorf("ModelTiepointTag is %v", tie)
+	}
+	keys, err := node.GeoKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []GeoKey{
+		{1024, SHORT, []uint16{1}, nil, ""},
+		{1025, SHORT, []uint16{1}, nil, ""},
+		{3072, SHORT, []uint16{32617}, nil, ""},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("GeoKeys are %v", keys)
+	}
+	ref.Geographic = true
+	ref.PixelIsPoint = true
+	ref.EPSG = 4326
+	if err := node.SetGeoReference(ref); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := node.GeoKeys(); len(keys) != 3 || keys[0].Shorts[0] != 2 || keys[1].Shorts[0] != 2 || keys[2].ID != 2048 {
+		t.Errorf("Geographic GeoKeys are %v", keys)
+	}
+	ref.PixelWidth = 0
+	if err := node.SetGeoReference(ref); err == nil {
+		t.Error("Zero pixel width didn't cause an error")
+	}
+}
@@ -0,0 +1,262 @@
+package tiff66
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Tags that may be found in GPS IFDs, from Exif 2.32.
+const (
+	GPSVersionID         = 0x00
+	GPSLatitudeRef       = 0x01
+	GPSLatitude          = 0x02
+	GPSLongitudeRef      = 0x03
+	GPSLongitude         = 0x04
+	GPSAltitudeRef       = 0x05
+	GPSAltitude          = 0x06
+	GPSTimeStamp         = 0x07
+	GPSSatellites        = 0x08
+	GPSStatus            = 0x09
+	GPSMeasureMode       = 0x0A
+	GPSDOP               = 0x0B
+	GPSSpeedRef          = 0x0C
+	GPSSpeed             = 0x0D
+	GPSTrackRef          = 0x0E
+	GPSTrack             = 0x0F
+	GPSImgDirectionRef   = 0x10
+	GPSImgDirection      = 0x11
+	GPSMapDatum          = 0x12
+	GPSDestLatitudeRef   = 0x13
+	GPSDestLatitude      = 0x14
+	GPSDestLongitudeRef  = 0x15
+	GPSDestLongitude     = 0x16
+	GPSDestBearingRef    = 0x17
+	GPSDestBearing       = 0x18
+	GPSDestDistanceRef   = 0x19
+	GPSDestDistance      = 0x1A
+	GPSProcessingMethod  = 0x1B
+	GPSAreaInformation   = 0x1C
+	GPSDateStamp         = 0x1D
+	GPSDifferential      = 0x1E
+	GPSHPositioningError = 0x1F
+)
+
+// Mappings from GPS tags to strings.
+var GPSTagNames = map[Tag]string{
+	GPSVersionID:         "GPSVersionID",
+	GPSLatitudeRef:       "GPSLatitudeRef",
+	GPSLatitude:          "GPSLatitude",
+	GPSLongitudeRef:      "GPSLongitudeRef",
+	GPSLongitude:         "GPSLongitude",
+	GPSAltitudeRef:       "GPSAltitudeRef",
+	GPSAltitude:          "GPSAltitude",
+	GPSTimeStamp:         "GPSTimeStamp",
+	GPSSatellites:        "GPSSatellites",
+	GPSStatus:            "GPSStatus",
+	GPSMeasureMode:       "GPSMeasureMode",
+	GPSDOP:               "GPSDOP",
+	GPSSpeedRef:          "GPSSpeedRef",
+	GPSSpeed:             "GPSSpeed",
+	GPSTrackRef:          "GPSTrackRef",
+	GPSTrack:             "GPSTrack",
+	GPSImgDirectionRef:   "GPSImgDirectionRef",
+	GPSImgDirection:      "GPSImgDirection",
+	GPSMapDatum:          "GPSMapDatum",
+	GPSDestLatitudeRef:   "GPSDestLatitudeRef",
+	GPSDestLatitude:      "GPSDestLatitude",
+	GPSDestLongitudeRef:  "GPSDestLongitudeRef",
+	GPSDestLongitude:     "GPSDestLongitude",
+	GPSDestBearingRef:    "GPSDestBearingRef",
+	GPSDestBearing:       "GPSDestBearing",
+	GPSDestDistanceRef:   "GPSDestDistanceRef",
+	GPSDestDistance:      "GPSDestDistance",
+	GPSProcessingMethod:  "GPSProcessingMethod",
+	GPSAreaInformation:   "GPSAreaInformation",
+	GPSDateStamp:         "GPSDateStamp",
+	GPSDifferential:      "GPSDifferential",
+	GPSHPositioningError: "GPSHPositioningError",
+}
+
+// Return a coordinate in degrees from a GPS field holding degrees,
+// minutes and seconds as three rationals, and its reference field. The
+// coordinate is negative if the reference matches neg, e.g., "S" or
+// "W".
+func (node IFDNode) gpsCoordinate(tag, refTag Tag, pos, neg string) (float64, error) {
+	fields := node.FindFields([]Tag{tag})
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("%s field not found", GPSTagNames[tag])
+	}
+	field := fields[0]
+	if !field.Type.IsRational() || field.Count != 3 || field.Data == nil {
+		return 0, fmt.Errorf("%s field should have 3 rational values", GPSTagNames[tag])
+	}
+	deg := 0.0
+	scale := 1.0
+	for i := uint32(0); i < 3; i++ {
+		num, denom := field.AnyRational(i, node.Order)
+		if denom == 0 {
+			if num == 0 {
+				// Some software writes 0/0 for unused minutes or seconds.
+				continue
+			}
+			return 0, fmt.Errorf("%s field has zero denominator", GPSTagNames[tag])
+		}
+		deg += float64(num) / float64(denom) / scale
+		scale *= 60
+	}
+	ref := strings.TrimRight(node.asciiField(refTag), " ")
+	switch ref {
+	case pos:
+	case neg:
+		deg = -deg
+	default:
+		return 0, fmt.Errorf("%s field has invalid value %q", GPSTagNames[refTag], ref)
+	}
+	return deg, nil
+}
+
+// Set a GPS coordinate field and its reference field from a value in
+// degrees. Degrees and minutes are stored as integers, and seconds with
+// a precision of 1/10000.
+func (node *IFDNode) putGPSCoordinate(tag, refTag Tag, deg float64, pos, neg string) {
+	ref := pos
+	if deg < 0 {
+		ref = neg
+		deg = -deg
+	}
+	d := math.Floor(deg)
+	m := math.Floor((deg - d) * 60)
+	s := math.Floor(((deg-d)*60-m)*60*10000 + 0.5)
+	if s >= 60*10000 {
+		s -= 60 * 10000
+		m++
+	}
+	if m >= 60 {
+		m -= 60
+		d++
+	}
+	field := Field{tag, RATIONAL, 3, make([]byte, 24)}
+	field.PutRational(uint32(d), 1, 0, node.Order)
+	field.PutRational(uint32(m), 1, 1, node.Order)
+	field.PutRational(uint32(s), 10000, 2, node.Order)
+	node.replaceField(field)
+	node.SetASCII(refTag, ref)
+}
+
+// Return the latitude and longitude in degrees from the fields in a GPS
+// IFD. Southern latitudes and western longitudes are negative.
+func (node IFDNode) GPSLatLong() (float64, float64, error) {
+	lat, err := node.gpsCoordinate(GPSLatitude, GPSLatitudeRef, "N", "S")
+	if err != nil {
+		return 0, 0, err
+	}
+	long, err := node.gpsCoordinate(GPSLongitude, GPSLongitudeRef, "E", "W")
+	if err != nil {
+		return 0, 0, err
+	}
+	return lat, long, nil
+}
+
+// Set the latitude and longitude fields, and their reference fields,
+// in a GPS IFD from values in degrees, as returned by GPSLatLong.
+func (node *IFDNode) SetGPSLatLong(lat, long float64) error {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return fmt.Errorf("Latitude %g out of range", lat)
+	}
+	if math.IsNaN(long) || long < -180 || long > 180 {
+		return fmt.Errorf("Longitude %g out of range", long)
+	}
+	node.putGPSCoordinate(GPSLatitude, GPSLatitudeRef, lat, "N", "S")
+	node.putGPSCoordinate(GPSLongitude, GPSLongitudeRef, long, "E", "W")
+	return nil
+}
+
+// Return the altitude in meters from the fields in a GPS IFD, which is
+// negative if below sea level.
+func (node IFDNode) GPSAltitudeMeters() (float64, error) {
+	fields := node.FindFields([]Tag{GPSAltitude})
+	if len(fields) == 0 {
+		return 0, errors.New("GPSAltitude field not found")
+	}
+	field := fields[0]
+	if !field.Type.IsRational() || field.Count != 1 || field.Data == nil {
+		return 0, errors.New("GPSAltitude field should have 1 rational value")
+	}
+	num, denom := field.AnyRational(0, node.Order)
+	if denom == 0 {
+		return 0, errors.New("GPSAltitude field has zero denominator")
+	}
+	alt := float64(num) / float64(denom)
+	if refs := node.FindFields([]Tag{GPSAltitudeRef}); len(refs) > 0 && refs[0].Count > 0 && refs[0].Data != nil && refs[0].Data[0] == 1 {
+		alt = -alt
+	}
+	return alt, nil
+}
+
+// Set the altitude and altitude reference fields in a GPS IFD from a
+// value in meters, with a precision of 1/1000.
+func (node *IFDNode) SetGPSAltitude(alt float64) error {
+	ref := byte(0)
+	if alt < 0 {
+		ref = 1
+		alt = -alt
+	}
+	scaled := math.Floor(alt*1000 + 0.5)
+	if math.IsNaN(alt) || scaled > math.MaxUint32 {
+		return fmt.Errorf("Altitude %g out of range", alt)
+	}
+	field := Field{GPSAltitude, RATIONAL, 1, make([]byte, 8)}
+	field.PutRational(uint32(scaled), 1000, 0, node.Order)
+	node.replaceField(field)
+	node.replaceField(Field{GPSAltitudeRef, BYTE, 1, []byte{ref}})
+	return nil
+}
+
+// Return the UTC time from the GPSDateStamp and GPSTimeStamp fields in
+// a GPS IFD.
+func (node IFDNode) GPSTime() (time.Time, error) {
+	date := strings.TrimRight(node.asciiField(GPSDateStamp), " ")
+	if date == "" {
+		return time.Time{}, errors.New("GPSDateStamp field not found")
+	}
+	day, err := time.Parse("2006:01:02", date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("GPSDateStamp field has invalid value %q", date)
+	}
+	fields := node.FindFields([]Tag{GPSTimeStamp})
+	if len(fields) == 0 {
+		return time.Time{}, errors.New("GPSTimeStamp field not found")
+	}
+	field := fields[0]
+	if !field.Type.IsRational() || field.Count != 3 || field.Data == nil {
+		return time.Time{}, errors.New("GPSTimeStamp field should have 3 rational values")
+	}
+	secs := 0.0
+	scale := 3600.0
+	for i := uint32(0); i < 3; i++ {
+		num, denom := field.AnyRational(i, node.Order)
+		if denom == 0 {
+			return time.Time{}, errors.New("GPSTimeStamp field has zero denominator")
+		}
+		secs += float64(num) / float64(denom) * scale
+		scale /= 60
+	}
+	return day.Add(time.Duration(secs*float64(time.Second) + 0.5)), nil
+}
+
+// Set the GPSDateStamp and GPSTimeStamp fields in a GPS IFD from a
+// time, which is converted to UTC. Seconds are stored with a precision
+// of 1/1000.
+func (node *IFDNode) SetGPSTime(t time.Time) {
+	t = t.UTC().Round(time.Millisecond)
+	node.SetASCII(GPSDateStamp, t.Format("2006:01:02"))
+	field := Field{GPSTimeStamp, RATIONAL, 3, make([]byte, 24)}
+	field.PutRational(uint32(t.Hour()), 1, 0, node.Order)
+	field.PutRational(uint32(t.Minute()), 1, 1, node.Order)
+	millis := uint32(t.Second()*1000 + t.Nanosecond()/1000000)
+	field.PutRational(millis, 1000, 2, node.Order)
+	node.replaceField(field)
+}
@@ -0,0 +1,71 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGPSCoordinates(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		gps := NewIFDNode(GPSSpace)
+		gps.Order = order
+		if _, _, err := gps.GPSLatLong(); err == nil {
+			t.Error("Missing latitude didn't cause an error")
+		}
+		lat, long := -33.8568, 151.21529999
+		if err := gps.SetGPSLatLong(lat, long); err != nil {
+			t.Fatal(err)
+		}
+		if ref := gps.asciiField(GPSLatitudeRef); ref != "S" {
+			t.Errorf("GPSLatitudeRef is %q", ref)
+		}
+		gotLat, gotLong, err := gps.GPSLatLong()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(gotLat-lat) > 1e-7 || math.Abs(gotLong-long) > 1e-7 {
+			t.Errorf("Got %v %v, expected %v %v", gotLat, gotLong, lat, long)
+		}
+		// Seconds that round up to a whole minute.
+		if err := gps.SetGPSLatLong(10.99999999, 0); err != nil {
+			t.Fatal(err)
+		}
+		if deg, _ := gps.FindFields([]Tag{GPSLatitude})[0].Rational(0, order); deg != 11 {
+			t.Errorf("Rounded latitude has %d degrees", deg)
+		}
+		if err := gps.SetGPSLatLong(91, 0); err == nil {
+			t.Error("Invalid latitude didn't cause an error")
+		}
+		if err := gps.SetGPSAltitude(-12.3456); err != nil {
+			t.Fatal(err)
+		}
+		if alt, err := gps.GPSAltitudeMeters(); err != nil || alt != -12.346 {
+			t.Errorf("Altitude %v, %v", alt, err)
+		}
+	}
+}
+
+func TestGPSTime(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		gps := NewIFDNode(GPSSpace)
+		gps.Order = order
+		if _, err := gps.GPSTime(); err == nil {
+			t.Error("Missing time didn't cause an error")
+		}
+		zone := time.FixedZone("", 10*3600)
+		tm := time.Date(2020, 1, 1, 8, 30, 15, 250000000, zone)
+		gps.SetGPSTime(tm)
+		if date := gps.asciiField(GPSDateStamp); date != "2019:12:31" {
+			t.Errorf("GPSDateStamp is %q", date)
+		}
+		got, err := gps.GPSTime()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tm) || got.Location() != time.UTC {
+			t.Errorf("Got %v, expected %v", got, tm.UTC())
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A box in an ISO base media file (HEIF, HEIC, AVIF etc.)
+type bmffBox struct {
+	typ   string
+	pos   uint32 // Position of the start of the box.
+	start uint32 // Position of the box contents, after the header.
+	end   uint32 // Position following the box.
+}
+
+// Return the boxes found in buf between start and end.
+func bmffBoxes(buf []byte, start, end uint32) ([]bmffBox, error) {
+	var boxes []bmffBox
+	pos := start
+	for pos < end {
+		if pos+8 < pos || pos+8 > end {
+			return boxes, errors.New("Box header extends past end of container")
+		}
+		box := bmffBox{typ: string(buf[pos+4 : pos+8]), pos: pos, start: pos + 8}
+		size := uint64(binary.BigEndian.Uint32(buf[pos:]))
+		switch size {
+		case 0:
+			// Box extends to end of container.
+			size = uint64(end - pos)
+		case 1:
+			if pos+16 < pos || pos+16 > end {
+				return boxes, errors.New("Box header extends past end of container")
+			}
+			size = binary.BigEndian.Uint64(buf[pos+8:])
+			box.start += 8
+		}
+		if size < uint64(box.start-pos) || uint64(pos)+size > uint64(end) {
+			return boxes, fmt.Errorf("Box %q at %d has invalid size", box.typ, pos)
+		}
+		box.end = pos + uint32(size)
+		boxes = append(boxes, box)
+		pos = box.end
+	}
+	return boxes, nil
+}
+
+// Find the first box with a given type.
+func findBox(boxes []bmffBox, typ string) *bmffBox {
+	for i := range boxes {
+		if boxes[i].typ == typ {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// Location of the Exif item in an ISO base media file.
+type heifExifItem struct {
+	id          uint32
+	offset      uint32 // Position of the item data in the file.
+	length      uint32
+	offsetPos   uint32 // Position of the extent offset in the iloc box.
+	offsetSize  uint32 // Size of the extent offset in the iloc box.
+	lengthSize  uint32 // Size of the extent length in the iloc box.
+	baseOffset  uint64
+	extentCount uint16
+}
+
+// Read an unsigned integer of 0, 4 or 8 bytes.
+func readBMFFInt(buf []byte, pos *uint32, end uint32, size uint32) (uint64, error) {
+	if *pos+size < *pos || *pos+size > end {
+		return 0, errors.New("iloc box truncated")
+	}
+	var val uint64
+	switch size {
+	case 0:
+	case 4:
+		val = uint64(binary.BigEndian.Uint32(buf[*pos:]))
+	case 8:
+		val = binary.BigEndian.Uint64(buf[*pos:])
+	default:
+		return 0, fmt.Errorf("Invalid integer size %d in iloc box", size)
+	}
+	*pos += size
+	return val, nil
+}
+
+// Return the item ID of the Exif item in an iinf box, or 0 if not found.
+func heifExifItemID(buf []byte, iinf *bmffBox) (uint32, error) {
+	if iinf.start+6 > iinf.end {
+		return 0, errors.New("iinf box truncated")
+	}
+	version := buf[iinf.start]
+	pos := iinf.start + 4
+	if version == 0 {
+		pos += 2
+	} else {
+		pos += 4
+	}
+	infes, err := bmffBoxes(buf, pos, iinf.end)
+	for _, infe := range infes {
+		if infe.typ != "infe" || infe.start+4 > infe.end {
+			continue
+		}
+		version := buf[infe.start]
+		if version < 2 {
+			// Older versions don't have an item type.
+			continue
+		}
+		pos := infe.start + 4
+		var id uint32
+		if version == 2 {
+			if pos+8 > infe.end {
+				continue
+			}
+			id = uint32(binary.BigEndian.Uint16(buf[pos:]))
+			pos += 2
+		} else {
+			if pos+10 > infe.end {
+				continue
+			}
+			id = binary.BigEndian.Uint32(buf[pos:])
+			pos += 4
+		}
+		pos += 2 // item_protection_index
+		if string(buf[pos:pos+4]) == "Exif" {
+			return id, nil
+		}
+	}
+	return 0, err
+}
+
+// Find the location of the Exif item, given its ID, in an iloc box.
+func heifFindItem(buf []byte, iloc *bmffBox, id uint32) (*heifExifItem, error) {
+	end := iloc.end
+	if iloc.start+8 > end {
+		return nil, errors.New("iloc box truncated")
+	}
+	version := buf[iloc.start]
+	if version > 2 {
+		return nil, fmt.Errorf("Unsupported iloc box version %d", version)
+	}
+	pos := iloc.start + 4
+	offsetSize := uint32(buf[pos] >> 4)
+	lengthSize := uint32(buf[pos] & 0xF)
+	baseOffsetSize := uint32(buf[pos+1] >> 4)
+	indexSize := uint32(0)
+	if version > 0 {
+		indexSize = uint32(buf[pos+1] & 0xF)
+	}
+	pos += 2
+	if version == 2 && pos+4 > end {
+		return nil, errors.New("iloc box truncated")
+	}
+	var count uint32
+	if version < 2 {
+		count = uint32(binary.BigEndian.Uint16(buf[pos:]))
+		pos += 2
+	} else {
+		count = binary.BigEndian.Uint32(buf[pos:])
+		pos += 4
+	}
+	for i := uint32(0); i < count; i++ {
+		var item heifExifItem
+		if version < 2 {
+			if pos+2 > end {
+				return nil, errors.New("iloc box truncated")
+			}
+			item.id = uint32(binary.BigEndian.Uint16(buf[pos:]))
+			pos += 2
+		} else {
+			if pos+4 > end {
+				return nil, errors.New("iloc box truncated")
+			}
+			item.id = binary.BigEndian.Uint32(buf[pos:])
+			pos += 4
+		}
+		method := uint16(0)
+		if version > 0 {
+			if pos+2 > end {
+				return nil, errors.New("iloc box truncated")
+			}
+			method = binary.BigEndian.Uint16(buf[pos:]) & 0xF
+			pos += 2
+		}
+		pos += 2 // data_reference_index
+		var err error
+		item.baseOffset, err = readBMFFInt(buf, &pos, end, baseOffsetSize)
+		if err != nil {
+			return nil, err
+		}
+		if pos+2 > end {
+			return nil, errors.New("iloc box truncated")
+		}
+		item.extentCount = binary.BigEndian.Uint16(buf[pos:])
+		pos += 2
+		for j := uint16(0); j < item.extentCount; j++ {
+			if _, err := readBMFFInt(buf, &pos, end, indexSize); err != nil {
+				return nil, err
+			}
+			offsetPos := pos
+			offset, err := readBMFFInt(buf, &pos, end, offsetSize)
+			if err != nil {
+				return nil, err
+			}
+			length, err := readBMFFInt(buf, &pos, end, lengthSize)
+			if err != nil {
+				return nil, err
+			}
+			if j == 0 && item.id == id {
+				if method != 0 {
+					return nil, fmt.Errorf("Unsupported construction method %d for Exif item", method)
+				}
+				start := item.baseOffset + offset
+				if start < offset || start > uint64(len(buf)) {
+					return nil, errors.New("Exif item starts past end of file")
+				}
+				if lengthSize == 0 {
+					// Extent extends to end of file.
+					length = uint64(len(buf)) - start
+				}
+				if length > uint64(len(buf))-start {
+					return nil, errors.New("Exif item extends past end of file")
+				}
+				item.offset = uint32(start)
+				item.length = uint32(length)
+				item.offsetPos = offsetPos
+				item.offsetSize = offsetSize
+				item.lengthSize = lengthSize
+			}
+		}
+		if item.id == id {
+			if item.extentCount != 1 {
+				return nil, fmt.Errorf("Unsupported number of extents %d for Exif item", item.extentCount)
+			}
+			return &item, nil
+		}
+	}
+	return nil, errors.New("Exif item not found in iloc box")
+}
+
+// Locate the Exif item in an ISO base media file. Returns nil if not
+// found.
+func findHEIFExif(buf []byte) (*heifExifItem, error) {
+	if uint64(len(buf)) > math.MaxUint32 {
+		return nil, errors.New("File too large")
+	}
+	boxes, err := bmffBoxes(buf, 0, uint32(len(buf)))
+	if findBox(boxes, "ftyp") == nil {
+		return nil, errors.New("Not an ISO base media file")
+	}
+	meta := findBox(boxes, "meta")
+	if meta == nil {
+		return nil, err
+	}
+	// meta is a full box, with version and flags.
+	children, err := bmffBoxes(buf, meta.start+4, meta.end)
+	iinf := findBox(children, "iinf")
+	iloc := findBox(children, "iloc")
+	if iinf == nil || iloc == nil {
+		return nil, err
+	}
+	id, err := heifExifItemID(buf, iinf)
+	if id == 0 {
+		return nil, err
+	}
+	return heifFindItem(buf, iloc, id)
+}
+
+// Return the TIFF block from the Exif item in a HEIF file (including
+// HEIC and AVIF), or nil if there is none. The returned slice points
+// into buf.
+func GetHEIFExif(buf []byte) ([]byte, error) {
+	item, err := findHEIFExif(buf)
+	if item == nil {
+		return nil, err
+	}
+	data := buf[item.offset : item.offset+item.length]
+	// The item starts with the offset to the TIFF header, which is
+	// usually preceded by the Exif header used in JPEG files.
+	if len(data) < 4 {
+		return nil, errors.New("Exif item truncated")
+	}
+	headerOffset := binary.BigEndian.Uint32(data)
+	if uint64(headerOffset)+4 > uint64(len(data)) {
+		return nil, errors.New("TIFF header offset in Exif item past end of item")
+	}
+	return data[4+headerOffset:], nil
+}
+
+// Decode the Exif item in a HEIF file into an IFDNode tree. Returns a
+// nil node if the file doesn't contain an Exif item.
+func GetHEIFExifTree(buf []byte) (*IFDNode, error) {
+	exif, err := GetHEIFExif(buf)
+	if exif == nil {
+		return nil, err
+	}
+	return getTIFFTree(exif)
+}
+
+// Return a new HEIF file in which the Exif item contains the given
+// TIFF block. The new item data is appended to the file in a new mdat
+// box, and the item location is updated to point to it; the old item
+// data is left in place, unreferenced. The file must already have an
+// Exif item with a single extent, located by file offset.
+func PutHEIFExif(buf []byte, exif []byte) ([]byte, error) {
+	item, err := findHEIFExif(buf)
+	if item == nil {
+		if err == nil {
+			err = errors.New("HEIF file has no Exif item")
+		}
+		return nil, err
+	}
+	itemSize := uint64(4 + len(ExifHeader) + len(exif))
+	newOffset := uint64(len(buf)) + 8
+	newLength := itemSize
+	if newOffset < item.baseOffset {
+		return nil, errors.New("Can't relocate Exif item before its base offset")
+	}
+	newOffset -= item.baseOffset
+	if uint64(len(buf))+8+itemSize > math.MaxUint32 {
+		return nil, errors.New("HEIF file too large to relocate Exif item")
+	}
+	if item.offsetSize == 0 {
+		// If the length size is 0, the item extends to the
+		// end of the file, which remains correct.
+		return nil, errors.New("Can't relocate Exif item with implicit offset")
+	}
+	out := make([]byte, 0, uint64(len(buf))+8+itemSize)
+	out = append(out, buf...)
+	switch item.offsetSize {
+	case 4:
+		binary.BigEndian.PutUint32(out[item.offsetPos:], uint32(newOffset))
+	case 8:
+		binary.BigEndian.PutUint64(out[item.offsetPos:], newOffset)
+	}
+	lengthPos := item.offsetPos + item.offsetSize
+	switch item.lengthSize {
+	case 4:
+		binary.BigEndian.PutUint32(out[lengthPos:], uint32(newLength))
+	case 8:
+		binary.BigEndian.PutUint64(out[lengthPos:], newLength)
+	}
+	var word [4]byte
+	binary.BigEndian.PutUint32(word[:], uint32(8+itemSize))
+	out = append(out, word[:]...)
+	out = append(out, "mdat"...)
+	binary.BigEndian.PutUint32(word[:], uint32(len(ExifHeader)))
+	out = append(out, word[:]...)
+	out = append(out, ExifHeader...)
+	out = append(out, exif...)
+	return out, nil
+}
+
+// Serialize an IFDNode tree and embed it in a HEIF file as for
+// PutHEIFExif.
+func PutHEIFExifTree(buf []byte, node *IFDNode) ([]byte, error) {
+	exif, err := putTIFFTree(node)
+	if err != nil {
+		return nil, err
+	}
+	return PutHEIFExif(buf, exif)
+}
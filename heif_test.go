@@ -0,0 +1,84 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Append a box with given type and contents.
+func appendBox(buf []byte, typ string, contents ...[]byte) []byte {
+	size := 8
+	for _, c := range contents {
+		size += len(c)
+	}
+	var word [4]byte
+	binary.BigEndian.PutUint32(word[:], uint32(size))
+	buf = append(buf, word[:]...)
+	buf = append(buf, typ...)
+	for _, c := range contents {
+		buf = append(buf, c...)
+	}
+	return buf
+}
+
+// Create a minimal HEIF file with an Exif item, read the item, then
+// replace it.
+func TestHEIFExif(t *testing.T) {
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.BigEndian
+	node.Fields = []Field{{Orientation, SHORT, 1, []byte{0, 3}}}
+	exif, err := putTIFFTree(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := append([]byte{0, 0, 0, 6}, ExifHeader...)
+	item = append(item, exif...)
+
+	ftyp := appendBox(nil, "ftyp", []byte("heic\000\000\000\000mif1heic"))
+	infe := appendBox(nil, "infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif"))
+	iinf := appendBox(nil, "iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	// Version 0, offset and length size 4, base offset size 0, one item
+	// with one extent.
+	ilocContents := []byte{0, 0, 0, 0, 0x44, 0, 0, 1, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+	iloc := appendBox(nil, "iloc", ilocContents)
+	meta := appendBox(nil, "meta", []byte{0, 0, 0, 0}, iinf, iloc)
+	heif := append(ftyp, meta...)
+	itemPos := len(heif) + 8
+	ilocPos := len(ftyp) + 12 + len(iinf) + 8
+	binary.BigEndian.PutUint32(heif[ilocPos+14:], uint32(itemPos))
+	binary.BigEndian.PutUint32(heif[ilocPos+18:], uint32(len(item)))
+	heif = appendBox(heif, "mdat", item)
+
+	getnode, err := GetHEIFExifTree(heif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getnode == nil || getnode.Fields[0].Short(0, getnode.Order) != 3 {
+		t.Fatal("Exif tree not read back")
+	}
+	getnode.Fields[0].PutShort(8, 0, getnode.Order)
+	out, err := PutHEIFExifTree(heif, getnode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getnode, err = GetHEIFExifTree(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getnode == nil || getnode.Fields[0].Short(0, getnode.Order) != 8 {
+		t.Error("Replaced Exif tree not read back")
+	}
+	if _, err := bmffBoxes(out, 0, uint32(len(out))); err != nil {
+		t.Error(err)
+	}
+
+	// Extents outside the file are errors, not panics.
+	for _, extent := range [][2]uint32{{1 << 20, 92}, {uint32(itemPos), 0xFFFFFFF0}} {
+		bad := append([]byte{}, heif...)
+		binary.BigEndian.PutUint32(bad[ilocPos+14:], extent[0])
+		binary.BigEndian.PutUint32(bad[ilocPos+18:], extent[1])
+		if _, err := GetHEIFExif(bad); err == nil {
+			t.Errorf("Extent at %d with length %d not detected", extent[0], extent[1])
+		}
+	}
+}
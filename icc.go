@@ -0,0 +1,105 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Size of the header of an ICC profile.
+const iccHeaderSize = 128
+
+// Information from the header and description of an ICC profile.
+type ICCInfo struct {
+	Size        uint32 // Profile size from the header.
+	Version     string // E.g., "4.3.0".
+	DeviceClass string // E.g., "mntr" for a display device.
+	ColorSpace  string // E.g., "RGB ".
+	PCS         string // Profile connection space, "XYZ " or "Lab ".
+	Description string // From the 'desc' tag, or "" if not found.
+}
+
+// Validate an ICC profile, checking its size and signature, and return
+// information from its header and description.
+func ParseICCProfile(data []byte) (*ICCInfo, error) {
+	if len(data) < iccHeaderSize+4 {
+		return nil, errors.New("ParseICCProfile: profile is too short")
+	}
+	if string(data[36:40]) != "acsp" {
+		return nil, errors.New("ParseICCProfile: profile signature not found")
+	}
+	order := binary.BigEndian
+	info := &ICCInfo{Size: order.Uint32(data)}
+	if uint64(info.Size) != uint64(len(data)) {
+		return nil, fmt.Errorf("ParseICCProfile: header gives size %d, but profile has %d bytes", info.Size, len(data))
+	}
+	info.Version = fmt.Sprintf("%d.%d.%d", data[8], data[9]>>4, data[9]&0xF)
+	info.DeviceClass = string(data[12:16])
+	info.ColorSpace = string(data[16:20])
+	info.PCS = string(data[20:24])
+	count := order.Uint32(data[iccHeaderSize:])
+	if uint64(count)*12 > uint64(len(data)-iccHeaderSize-4) {
+		return nil, errors.New("ParseICCProfile: tag table extends past end of profile")
+	}
+	for i := uint32(0); i < count; i++ {
+		entry := data[iccHeaderSize+4+i*12:]
+		if string(entry[:4]) != "desc" {
+			continue
+		}
+		offset, size := order.Uint32(entry[4:]), order.Uint32(entry[8:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, errors.New("ParseICCProfile: description extends past end of profile")
+		}
+		info.Description = iccText(data[offset : offset+size])
+	}
+	return info, nil
+}
+
+// Return the text from an ICC textDescriptionType or
+// multiLocalizedUnicodeType element, using the first record of the
+// latter.
+func iccText(elem []byte) string {
+	order := binary.BigEndian
+	if len(elem) < 12 {
+		return ""
+	}
+	switch string(elem[:4]) {
+	case "desc":
+		count := order.Uint32(elem[8:])
+		if uint64(count) > uint64(len(elem)-12) {
+			return ""
+		}
+		return strings.TrimRight(string(elem[12:12+count]), "\000")
+	case "mluc":
+		if order.Uint32(elem[8:]) == 0 || len(elem) < 28 {
+			return ""
+		}
+		size, offset := order.Uint32(elem[20:]), order.Uint32(elem[24:])
+		if uint64(offset)+uint64(size) > uint64(len(elem)) {
+			return ""
+		}
+		units := make([]uint16, size/2)
+		for i := range units {
+			units[i] = order.Uint16(elem[offset+uint32(i)*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\000")
+	}
+	return ""
+}
+
+// Return the ICC profile from the ICCProfile field of a node. The bool
+// is false if the field isn't found or its data isn't loaded.
+func (node IFDNode) GetICCProfile() ([]byte, bool) {
+	return node.GetBytes(ICCProfile)
+}
+
+// Set the ICCProfile field of a node, after validating the profile.
+func (node *IFDNode) SetICCProfile(profile []byte) error {
+	if _, err := ParseICCProfile(profile); err != nil {
+		return err
+	}
+	node.SetUndefined(ICCProfile, profile)
+	return nil
+}
@@ -0,0 +1,73 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// Create an ICC profile with a description element.
+func testICCProfile(desc []byte) []byte {
+	order := binary.BigEndian
+	profile := make([]byte, iccHeaderSize+4+12)
+	profile[8], profile[9] = 4, 0x30
+	copy(profile[12:], "mntrRGB XYZ ")
+	copy(profile[36:], "acsp")
+	order.PutUint32(profile[iccHeaderSize:], 1)
+	copy(profile[iccHeaderSize+4:], "desc")
+	order.PutUint32(profile[iccHeaderSize+8:], uint32(len(profile)))
+	order.PutUint32(profile[iccHeaderSize+12:], uint32(len(desc)))
+	profile = append(profile, desc...)
+	order.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+func TestICCProfile(t *testing.T) {
+	order := binary.BigEndian
+	text := "sRGB IEC61966-2.1"
+	v2 := make([]byte, 12)
+	copy(v2, "desc")
+	order.PutUint32(v2[8:], uint32(len(text)+1))
+	v2 = append(append(v2, text...), 0)
+	units := utf16.Encode([]rune(text))
+	v4 := make([]byte, 28)
+	copy(v4, "mluc")
+	order.PutUint32(v4[8:], 1)
+	order.PutUint32(v4[12:], 12)
+	copy(v4[16:], "enUS")
+	order.PutUint32(v4[20:], uint32(len(units)*2))
+	order.PutUint32(v4[24:], 28)
+	for _, u := range units {
+		v4 = append(v4, byte(u>>8), byte(u))
+	}
+	for _, desc := range [][]byte{v2, v4} {
+		profile := testICCProfile(desc)
+		info, err := ParseICCProfile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Version != "4.3.0" || info.DeviceClass != "mntr" || info.ColorSpace != "RGB " || info.PCS != "XYZ " {
+			t.Errorf("Header decoded as %+v", info)
+		}
+		if info.Description != text {
+			t.Errorf("Description is %q", info.Description)
+		}
+		node := NewIFDNode(TIFFSpace)
+		node.Order = binary.LittleEndian
+		if err := node.SetICCProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := node.GetICCProfile(); !ok || string(got) != string(profile) {
+			t.Error("ICCProfile field doesn't contain the profile")
+		}
+	}
+	profile := testICCProfile(v2)
+	if _, err := ParseICCProfile(profile[:len(profile)-1]); err == nil {
+		t.Error("Wrong profile size didn't cause an error")
+	}
+	profile[36] = 'x'
+	node := NewIFDNode(TIFFSpace)
+	if err := node.SetICCProfile(profile); err == nil {
+		t.Error("Missing signature didn't cause an error")
+	}
+}
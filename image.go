@@ -0,0 +1,622 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+)
+
+// Values of the Compression field.
+const (
+	CompressionNone       = 1
+	CompressionLZW        = 5
+	CompressionOldJPEG    = 6 // Obsolete JPEG compression.
+	CompressionJPEG       = 7
+	CompressionDeflate    = 8
+	CompressionDeflateOld = 32946 // Obsolete value for Deflate.
+)
+
+// Values of the Predictor field.
+const (
+	PredictorNone       = 1
+	PredictorHorizontal = 2
+)
+
+// Values of the PhotometricInterpretation field.
+const (
+	PhotometricWhiteIsZero = 0
+	PhotometricBlackIsZero = 1
+	PhotometricRGB         = 2
+	PhotometricPalette     = 3
+	PhotometricSeparated   = 5 // CMYK.
+	PhotometricYCbCr       = 6
+)
+
+// Values of the ExtraSamples field.
+const (
+	ExtraSampleUnspecified       = 0
+	ExtraSampleAssociatedAlpha   = 1
+	ExtraSampleUnassociatedAlpha = 2
+)
+
+// Limits on the images that can be decoded or encoded, so that crafted
+// dimensions can't cause huge allocations: the number of pixels, and
+// the total size of the assembled planes and of each strip or tile.
+const (
+	maxImagePixels = 1 << 28
+	maxImageBytes  = 1 << 30
+)
+
+// Image layout, from the fields of an IFD.
+type imageLayout struct {
+	width, height uint32
+	samples       uint32 // Samples per pixel.
+	bits          uint32 // Bits per sample, the same for all samples.
+	photometric   uint32
+	compression   uint32
+	predictor     uint32
+	order         binary.ByteOrder
+	planar        bool   // PlanarConfiguration is 2.
+	tiled         bool   // Tiles instead of strips.
+	chunkWidth    uint32 // Tile width, or image width for strips.
+	chunkHeight   uint32 // Tile length, or rows per strip.
+	extraSample   uint32 // The first ExtraSamples value, if any.
+	hasExtra      bool
+	colorMap      []uint16
+}
+
+// Return the value of an integer field, or a default if not present.
+func (node IFDNode) integerFieldDefault(tag Tag, def uint32) uint32 {
+	if val, found := node.integerField(tag); found {
+		return val
+	}
+	return def
+}
+
+// Read the image layout from the fields of an IFD.
+func (node IFDNode) imageLayout() (*imageLayout, error) {
+	var l imageLayout
+	var found bool
+	if l.width, found = node.integerField(ImageWidth); !found {
+		return nil, errors.New("ImageWidth field not found")
+	}
+	if l.height, found = node.integerField(ImageLength); !found {
+		return nil, errors.New("ImageLength field not found")
+	}
+	if l.photometric, found = node.integerField(PhotometricInterpretation); !found {
+		return nil, errors.New("PhotometricInterpretation field not found")
+	}
+	l.samples = node.integerFieldDefault(SamplesPerPixel, 1)
+	l.bits = 1
+	if fields := node.FindFields([]Tag{BitsPerSample}); len(fields) > 0 {
+		f := fields[0]
+		if !f.Type.IsIntegral() || f.Count == 0 {
+			return nil, errors.New("Invalid BitsPerSample field")
+		}
+		l.bits = uint32(f.AnyInteger(0, node.Order))
+		for i := uint32(1); i < f.Count; i++ {
+			if uint32(f.AnyInteger(i, node.Order)) != l.bits {
+				return nil, errors.New("Samples with different sizes are not supported")
+			}
+		}
+	}
+	if format := node.integerFieldDefault(SampleFormat, 1); format != 1 {
+		return nil, fmt.Errorf("SampleFormat %d is not supported", format)
+	}
+	if fill := node.integerFieldDefault(FillOrder, 1); fill != 1 {
+		return nil, fmt.Errorf("FillOrder %d is not supported", fill)
+	}
+	l.compression = node.integerFieldDefault(Compression, CompressionNone)
+	l.predictor = node.integerFieldDefault(Predictor, PredictorNone)
+	if l.predictor != PredictorNone && (l.predictor != PredictorHorizontal || l.bits != 8 && l.bits != 16) {
+		return nil, fmt.Errorf("Predictor %d with %d bit samples is not supported", l.predictor, l.bits)
+	}
+	l.order = node.Order
+	l.planar = node.integerFieldDefault(PlanarConfiguration, 1) == 2
+	if tw, found := node.integerField(TileWidth); found {
+		l.tiled = true
+		l.chunkWidth = tw
+		if l.chunkHeight, found = node.integerField(TileLength); !found {
+			return nil, errors.New("TileLength field not found")
+		}
+	} else {
+		l.chunkWidth = l.width
+		l.chunkHeight = node.integerFieldDefault(RowsPerStrip, l.height)
+		if l.chunkHeight > l.height {
+			l.chunkHeight = l.height
+		}
+	}
+	if l.width == 0 || l.height == 0 || l.samples == 0 || l.bits == 0 || l.chunkWidth == 0 || l.chunkHeight == 0 {
+		return nil, errors.New("Image has zero size")
+	}
+	if l.samples > 0xFFFF || l.bits > 32 {
+		return nil, fmt.Errorf("Image with %d samples of %d bits is not supported", l.samples, l.bits)
+	}
+	if uint64(l.width)*uint64(l.height) > maxImagePixels {
+		return nil, fmt.Errorf("Image size %dx%d is too large", l.width, l.height)
+	}
+	if l.rowSize(l.width)*uint64(l.height)*uint64(l.planes()) > maxImageBytes || l.rowSize(l.chunkWidth)*uint64(l.chunkHeight) > maxImageBytes {
+		return nil, errors.New("Image data is too large")
+	}
+	if fields := node.FindFields([]Tag{ExtraSamples}); len(fields) > 0 && fields[0].Count > 0 && fields[0].Type.IsIntegral() {
+		l.extraSample = uint32(fields[0].AnyInteger(0, node.Order))
+		l.hasExtra = true
+	}
+	if l.photometric == PhotometricPalette {
+		fields := node.FindFields([]Tag{ColorMap})
+		if len(fields) == 0 || fields[0].Type != SHORT || fields[0].Count != 3<<l.bits {
+			return nil, errors.New("Invalid or missing ColorMap field")
+		}
+		l.colorMap = make([]uint16, fields[0].Count)
+		for i := range l.colorMap {
+			l.colorMap[i] = fields[0].Short(uint32(i), node.Order)
+		}
+	}
+	return &l, nil
+}
+
+// Return the number of samples per pixel in each plane.
+func (l imageLayout) planeSamples() uint32 {
+	if l.planar {
+		return 1
+	}
+	return l.samples
+}
+
+// Return the number of bytes in a row of a given number of pixels in
+// one plane.
+func (l imageLayout) rowSize(width uint32) uint64 {
+	return (uint64(width)*uint64(l.planeSamples())*uint64(l.bits) + 7) / 8
+}
+
+// Return the number of bytes in a row of a plane. imageLayout ensures
+// that it fits in 32 bits.
+func (l imageLayout) rowBytes() uint32 {
+	return uint32(l.rowSize(l.width))
+}
+
+// Return the number of bytes in a row of a chunk (strip or tile) of
+// one plane.
+func (l imageLayout) chunkRowBytes() uint32 {
+	return uint32(l.rowSize(l.chunkWidth))
+}
+
+// Return the number of planes.
+func (l imageLayout) planes() uint32 {
+	if l.planar {
+		return l.samples
+	}
+	return 1
+}
+
+// Decompress a strip or tile, whose uncompressed size is at most limit
+// bytes. The result may be shorter than expected, in which case the
+// remainder is taken as zeros.
+func decompressChunk(compression uint32, data []byte, limit uint32) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionLZW:
+		return LZWDecode(data)
+	case CompressionDeflate, CompressionDeflateOld:
+		return DeflateDecode(data, limit)
+	}
+	return nil, fmt.Errorf("Compression %d is not supported", compression)
+}
+
+// Compress a strip or tile.
+func compressChunk(compression uint32, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionLZW:
+		return LZWEncode(data), nil
+	case CompressionDeflate, CompressionDeflateOld:
+		return DeflateEncode(data), nil
+	}
+	return nil, fmt.Errorf("Compression %d is not supported", compression)
+}
+
+// Apply horizontal differencing to the rows of a decompressed chunk in
+// place, or reverse it if undo is true.
+func (l imageLayout) horizontal(data []byte, undo bool) {
+	samples := int(l.planeSamples())
+	rowBytes := int(l.chunkRowBytes())
+	for start := 0; start < len(data); start += rowBytes {
+		end := start + rowBytes
+		if end > len(data) {
+			end = len(data)
+		}
+		row := data[start:end]
+		if l.bits == 8 {
+			if undo {
+				for i := samples; i < len(row); i++ {
+					row[i] += row[i-samples]
+				}
+			} else {
+				for i := len(row) - 1; i >= samples; i-- {
+					row[i] -= row[i-samples]
+				}
+			}
+			continue
+		}
+		n := len(row) / 2
+		if undo {
+			for i := samples; i < n; i++ {
+				l.order.PutUint16(row[2*i:], l.order.Uint16(row[2*i:])+l.order.Uint16(row[2*(i-samples):]))
+			}
+		} else {
+			for i := n - 1; i >= samples; i-- {
+				l.order.PutUint16(row[2*i:], l.order.Uint16(row[2*i:])-l.order.Uint16(row[2*(i-samples):]))
+			}
+		}
+	}
+}
+
+// Decompress a strip or tile and reverse any prediction.
+func (l imageLayout) decodeChunk(segment []byte) ([]byte, error) {
+	data, err := decompressChunk(l.compression, segment, l.chunkRowBytes()*l.chunkHeight)
+	if err != nil {
+		return nil, err
+	}
+	if l.predictor == PredictorHorizontal {
+		if l.compression == CompressionNone {
+			// Don't modify the segment.
+			data = append([]byte(nil), data...)
+		}
+		l.horizontal(data, true)
+	}
+	return data, nil
+}
+
+// Apply any prediction to a strip or tile and compress it.
+func (l imageLayout) encodeChunk(data []byte) ([]byte, error) {
+	if l.predictor == PredictorHorizontal {
+		data = append([]byte(nil), data...)
+		l.horizontal(data, false)
+	}
+	return compressChunk(l.compression, data)
+}
+
+// Decode a strip or tile of the image in a TIFF IFD, according to its
+// Compression and Predictor fields, returning the uncompressed data.
+func (node IFDNode) DecodeSegment(segment ImageSegment) ([]byte, error) {
+	l, err := node.imageLayout()
+	if err != nil {
+		return nil, err
+	}
+	return l.decodeChunk(segment)
+}
+
+// Encode uncompressed data for a strip or tile of the image in a TIFF
+// IFD, according to its Compression and Predictor fields. Supported
+// compression values are None, LZW and Deflate.
+func (node IFDNode) EncodeSegment(data []byte) (ImageSegment, error) {
+	l, err := node.imageLayout()
+	if err != nil {
+		return nil, err
+	}
+	return l.encodeChunk(data)
+}
+
+// Assemble the strips or tiles of an image into planes with rows of
+// (width*samples*bits+7)/8 bytes.
+func (l imageLayout) assemble(segments []ImageSegment) ([][]byte, error) {
+	across := (l.width + l.chunkWidth - 1) / l.chunkWidth
+	down := (l.height + l.chunkHeight - 1) / l.chunkHeight
+	perPlane := across * down
+	if uint64(len(segments)) < uint64(perPlane)*uint64(l.planes()) {
+		return nil, fmt.Errorf("Image has %d strips or tiles, expected %d", len(segments), uint64(perPlane)*uint64(l.planes()))
+	}
+	rowBytes := l.rowBytes()
+	chunkRowBytes := l.chunkRowBytes()
+	planes := make([][]byte, l.planes())
+	for p := range planes {
+		plane := make([]byte, rowBytes*l.height)
+		for c := uint32(0); c < perPlane; c++ {
+			data, err := l.decodeChunk(segments[uint32(p)*perPlane+c])
+			if err != nil {
+				return nil, err
+			}
+			x0 := (c % across) * l.chunkWidth
+			y0 := (c / across) * l.chunkHeight
+			// Tile widths are multiples of 16, so tiles start
+			// on byte boundaries.
+			xbyte := uint32(l.rowSize(x0))
+			if xbyte >= rowBytes {
+				continue
+			}
+			copyBytes := chunkRowBytes
+			if xbyte+copyBytes > rowBytes {
+				copyBytes = rowBytes - xbyte
+			}
+			for y := uint32(0); y < l.chunkHeight && y0+y < l.height; y++ {
+				start := y * chunkRowBytes
+				if start >= uint32(len(data)) {
+					break
+				}
+				end := start + copyBytes
+				if end > uint32(len(data)) {
+					end = uint32(len(data))
+				}
+				copy(plane[(y0+y)*rowBytes+xbyte:], data[start:end])
+			}
+		}
+		planes[p] = plane
+	}
+	return planes, nil
+}
+
+// Accessor for samples in assembled image planes.
+type sampleReader struct {
+	l        *imageLayout
+	planes   [][]byte
+	rowBytes uint32
+	order    func([]byte) uint16
+}
+
+// Return sample s of the pixel at (x, y).
+func (r sampleReader) sample(x, y, s uint32) uint32 {
+	plane := r.planes[0]
+	idx := x*r.l.samples + s
+	if r.l.planar {
+		plane = r.planes[s]
+		idx = x
+	}
+	row := plane[y*r.rowBytes:]
+	switch r.l.bits {
+	case 8:
+		return uint32(row[idx])
+	case 16:
+		return uint32(r.order(row[idx*2:]))
+	}
+	bit := idx * r.l.bits
+	shift := 8 - r.l.bits - bit%8
+	return uint32(row[bit/8]>>shift) & (1<<r.l.bits - 1)
+}
+
+// Return the strips or tiles of the image in a TIFF IFD, loading them
+// if required.
+func (node IFDNode) loadSegments(tiled bool) ([]ImageSegment, error) {
+	offsetTag := Tag(StripOffsets)
+	if tiled {
+		offsetTag = TileOffsets
+	}
+	for _, id := range node.GetImageData() {
+		if id.OffsetTag == offsetTag {
+			if err := id.Load(); err != nil {
+				return nil, err
+			}
+			return id.Segments, nil
+		}
+	}
+	return nil, errors.New("Image data not found")
+}
+
+// Combine the tables from a JPEGTables field, which is an abbreviated
+// JPEG stream containing only tables, with the JPEG stream for a strip
+// or tile, which may lack them.
+func jpegWithTables(tables, data []byte) []byte {
+	if len(tables) < 4 || len(data) < 2 || tables[0] != 0xFF || tables[1] != jpegSOI || data[0] != 0xFF || data[1] != jpegSOI {
+		return data
+	}
+	// Remove the EOI marker from the tables and the SOI marker from
+	// the data.
+	end := len(tables)
+	if tables[end-2] == 0xFF && tables[end-1] == jpegEOI {
+		end -= 2
+	}
+	stream := make([]byte, 0, end+len(data)-2)
+	stream = append(stream, tables[:end]...)
+	return append(stream, data[2:]...)
+}
+
+// Decode an image with JPEG compression, where each strip or tile is a
+// JPEG stream.
+func (node IFDNode) decodeJPEG(l *imageLayout, segments []ImageSegment) (image.Image, error) {
+	if l.planar && l.samples > 1 {
+		return nil, errors.New("JPEG compression with separate planes is not supported")
+	}
+	var tables []byte
+	if fields := node.FindFields([]Tag{JPEGTables}); len(fields) > 0 {
+		tables = fields[0].Data
+	}
+	across := (l.width + l.chunkWidth - 1) / l.chunkWidth
+	down := (l.height + l.chunkHeight - 1) / l.chunkHeight
+	if uint32(len(segments)) < across*down {
+		return nil, fmt.Errorf("Image has %d strips or tiles, expected %d", len(segments), across*down)
+	}
+	rect := image.Rect(0, 0, int(l.width), int(l.height))
+	var img draw.Image
+	for c := uint32(0); c < across*down; c++ {
+		chunk, err := jpeg.Decode(bytes.NewReader(jpegWithTables(tables, segments[c])))
+		if err != nil {
+			return nil, fmt.Errorf("Strip or tile %d: %v", c, err)
+		}
+		if img == nil {
+			if _, ok := chunk.(*image.Gray); ok {
+				img = image.NewGray(rect)
+			} else {
+				img = image.NewRGBA(rect)
+			}
+		}
+		origin := image.Pt(int((c%across)*l.chunkWidth), int((c/across)*l.chunkHeight))
+		bounds := chunk.Bounds()
+		draw.Draw(img, bounds.Sub(bounds.Min).Add(origin).Intersect(rect), chunk, bounds.Min, draw.Src)
+	}
+	return img, nil
+}
+
+// Decode an image with old-style JPEG compression. Only images stored
+// as a complete JPEG stream with JPEGInterchangeFormat are supported.
+func (node IFDNode) decodeOldJPEG() (image.Image, error) {
+	for _, id := range node.GetImageData() {
+		if id.OffsetTag == JPEGInterchangeFormat {
+			if err := id.Load(); err != nil {
+				return nil, err
+			}
+			if len(id.Segments) == 0 {
+				break
+			}
+			return jpeg.Decode(bytes.NewReader(id.Segments[0]))
+		}
+	}
+	return nil, errors.New("Old-style JPEG compression without JPEGInterchangeFormat is not supported")
+}
+
+// Decode the image in a TIFF IFD, using its fields and image data, into
+// an image.Image. Only uncompressed, LZW or Deflate compressed images
+// with unsigned integer samples are supported, optionally with
+// horizontal prediction. The photometric interpretation may be
+// WhiteIsZero or BlackIsZero (1, 2, 4, 8 or 16 bits), RGB (8 or 16
+// bits, optionally with alpha), Palette (1, 2, 4 or 8 bits) or CMYK (8
+// bits), in strips or tiles. JPEG compressed images are also supported,
+// using the JPEGTables field if present, as are old-style JPEG images
+// stored with JPEGInterchangeFormat, such as Exif thumbnails. If the
+// image data was decoded with GetIFDTreeReader, it's loaded as
+// required.
+func (node IFDNode) DecodeImage() (image.Image, error) {
+	if compression, _ := node.integerField(Compression); compression == CompressionOldJPEG {
+		return node.decodeOldJPEG()
+	}
+	l, err := node.imageLayout()
+	if err != nil {
+		return nil, err
+	}
+	segments, err := node.loadSegments(l.tiled)
+	if err != nil {
+		return nil, err
+	}
+	if l.compression == CompressionJPEG {
+		return node.decodeJPEG(l, segments)
+	}
+	planes, err := l.assemble(segments)
+	if err != nil {
+		return nil, err
+	}
+	r := sampleReader{l: l, planes: planes, rowBytes: l.rowBytes(), order: node.Order.Uint16}
+	rect := image.Rect(0, 0, int(l.width), int(l.height))
+	bitsOK := func(allowed ...uint32) bool {
+		for _, b := range allowed {
+			if l.bits == b {
+				return true
+			}
+		}
+		return false
+	}
+	switch l.photometric {
+	case PhotometricWhiteIsZero, PhotometricBlackIsZero:
+		if !bitsOK(1, 2, 4, 8, 16) {
+			break
+		}
+		max := uint32(1)<<l.bits - 1
+		if l.bits == 16 {
+			img := image.NewGray16(rect)
+			for y := uint32(0); y < l.height; y++ {
+				for x := uint32(0); x < l.width; x++ {
+					v := r.sample(x, y, 0)
+					if l.photometric == PhotometricWhiteIsZero {
+						v = max - v
+					}
+					img.SetGray16(int(x), int(y), color.Gray16{uint16(v)})
+				}
+			}
+			return img, nil
+		}
+		img := image.NewGray(rect)
+		for y := uint32(0); y < l.height; y++ {
+			for x := uint32(0); x < l.width; x++ {
+				v := r.sample(x, y, 0)
+				if l.photometric == PhotometricWhiteIsZero {
+					v = max - v
+				}
+				img.SetGray(int(x), int(y), color.Gray{uint8(v * 255 / max)})
+			}
+		}
+		return img, nil
+	case PhotometricRGB:
+		if !bitsOK(8, 16) || l.samples < 3 {
+			break
+		}
+		alpha := l.samples >= 4 && l.hasExtra && l.extraSample != ExtraSampleUnspecified
+		var img interface {
+			image.Image
+			Set(x, y int, c color.Color)
+		}
+		switch {
+		case l.bits == 8 && alpha && l.extraSample == ExtraSampleAssociatedAlpha:
+			img = image.NewRGBA(rect)
+		case l.bits == 8 && alpha:
+			img = image.NewNRGBA(rect)
+		case l.bits == 8:
+			img = image.NewRGBA(rect)
+		case alpha && l.extraSample == ExtraSampleAssociatedAlpha:
+			img = image.NewRGBA64(rect)
+		case alpha:
+			img = image.NewNRGBA64(rect)
+		default:
+			img = image.NewRGBA64(rect)
+		}
+		scale := uint32(1)
+		if l.bits == 8 {
+			scale = 0x101
+		}
+		for y := uint32(0); y < l.height; y++ {
+			for x := uint32(0); x < l.width; x++ {
+				c := color.RGBA64{
+					uint16(r.sample(x, y, 0) * scale),
+					uint16(r.sample(x, y, 1) * scale),
+					uint16(r.sample(x, y, 2) * scale),
+					0xFFFF}
+				if alpha {
+					c.A = uint16(r.sample(x, y, 3) * scale)
+				}
+				if alpha && l.extraSample == ExtraSampleUnassociatedAlpha {
+					img.Set(int(x), int(y), color.NRGBA64(c))
+				} else {
+					img.Set(int(x), int(y), c)
+				}
+			}
+		}
+		return img, nil
+	case PhotometricPalette:
+		if !bitsOK(1, 2, 4, 8) {
+			break
+		}
+		n := 1 << l.bits
+		palette := make(color.Palette, n)
+		for i := range palette {
+			palette[i] = color.RGBA64{l.colorMap[i], l.colorMap[n+i], l.colorMap[2*n+i], 0xFFFF}
+		}
+		img := image.NewPaletted(rect, palette)
+		for y := uint32(0); y < l.height; y++ {
+			for x := uint32(0); x < l.width; x++ {
+				img.SetColorIndex(int(x), int(y), uint8(r.sample(x, y, 0)))
+			}
+		}
+		return img, nil
+	case PhotometricSeparated:
+		if l.bits != 8 || l.samples < 4 {
+			break
+		}
+		img := image.NewCMYK(rect)
+		for y := uint32(0); y < l.height; y++ {
+			for x := uint32(0); x < l.width; x++ {
+				img.SetCMYK(int(x), int(y), color.CMYK{
+					uint8(r.sample(x, y, 0)),
+					uint8(r.sample(x, y, 1)),
+					uint8(r.sample(x, y, 2)),
+					uint8(r.sample(x, y, 3))})
+			}
+		}
+		return img, nil
+	default:
+		return nil, fmt.Errorf("PhotometricInterpretation %d is not supported", l.photometric)
+	}
+	return nil, fmt.Errorf("PhotometricInterpretation %d with %d samples of %d bits is not supported", l.photometric, l.samples, l.bits)
+}
@@ -0,0 +1,207 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// Create a TIFF node with given fields and image data segments.
+func imageNode(order binary.ByteOrder, fields []Field, offsetTag, sizeTag Tag, segments []ImageSegment) *IFDNode {
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.Fields = fields
+	node.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: offsetTag, SizeTag: sizeTag, Segments: segments}}}
+	return node
+}
+
+func TestDecodeImage(t *testing.T) {
+	le := binary.LittleEndian
+	be := binary.BigEndian
+
+	// 8 bit grayscale in two strips.
+	gray := imageNode(le, []Field{
+		shortField(ImageWidth, le, 3),
+		shortField(ImageLength, le, 3),
+		shortField(BitsPerSample, le, 8),
+		shortField(PhotometricInterpretation, le, PhotometricBlackIsZero),
+		shortField(RowsPerStrip, le, 2),
+	}, StripOffsets, StripByteCounts, []ImageSegment{{1, 2, 3, 4, 5, 6}, {7, 8, 9}})
+	img, err := gray.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, ok := img.(*image.Gray); !ok || g.GrayAt(1, 2).Y != 8 || img.Bounds() != image.Rect(0, 0, 3, 3) {
+		t.Error("Wrong 8 bit grayscale image")
+	}
+
+	// 1 bit WhiteIsZero.
+	bilevel := imageNode(le, []Field{
+		shortField(ImageWidth, le, 10),
+		shortField(ImageLength, le, 2),
+		shortField(PhotometricInterpretation, le, PhotometricWhiteIsZero),
+	}, StripOffsets, StripByteCounts, []ImageSegment{{0x80, 0x40, 0x00, 0x00}})
+	img, err = bilevel.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := img.(*image.Gray)
+	if g.GrayAt(0, 0).Y != 0 || g.GrayAt(1, 0).Y != 255 || g.GrayAt(9, 0).Y != 0 || g.GrayAt(9, 1).Y != 255 {
+		t.Error("Wrong bilevel image")
+	}
+
+	// 16 bit RGB, planar.
+	rgb := imageNode(be, []Field{
+		shortField(ImageWidth, be, 2),
+		shortField(ImageLength, be, 1),
+		shortField(BitsPerSample, be, 16, 16, 16),
+		shortField(PhotometricInterpretation, be, PhotometricRGB),
+		shortField(SamplesPerPixel, be, 3),
+		shortField(PlanarConfiguration, be, 2),
+	}, StripOffsets, StripByteCounts, []ImageSegment{{0x12, 0x34, 0, 0}, {0x56, 0x78, 0, 0}, {0x9A, 0xBC, 0xFF, 0xFF}})
+	img, err = rgb.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := img.(*image.RGBA64).RGBA64At(0, 0); c != (color.RGBA64{0x1234, 0x5678, 0x9ABC, 0xFFFF}) {
+		t.Errorf("Wrong RGB pixel %v", c)
+	}
+	if c := img.(*image.RGBA64).RGBA64At(1, 0); c != (color.RGBA64{0, 0, 0xFFFF, 0xFFFF}) {
+		t.Errorf("Wrong RGB pixel %v", c)
+	}
+
+	// 4 bit palette in 16x16 tiles, with a partial tile on the right.
+	colorMap := make([]uint16, 3*16)
+	colorMap[5] = 0xFFFF    // Red of entry 5.
+	colorMap[16+7] = 0xFFFF // Green of entry 7.
+	tile1 := make(ImageSegment, 8*16)
+	tile2 := make(ImageSegment, 8*16)
+	tile1[8*2] = 0x57   // Row 2, pixels 0 and 1.
+	tile2[8*1+1] = 0x05 // Row 1, pixel 19.
+	palette := imageNode(le, []Field{
+		shortField(ImageWidth, le, 20),
+		shortField(ImageLength, le, 3),
+		shortField(BitsPerSample, le, 4),
+		shortField(PhotometricInterpretation, le, PhotometricPalette),
+		shortField(TileWidth, le, 16),
+		shortField(TileLength, le, 16),
+		shortField(ColorMap, le, colorMap...),
+	}, TileOffsets, TileByteCounts, []ImageSegment{tile1, tile2})
+	img, err = palette.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := img.(*image.Paletted)
+	if p.ColorIndexAt(0, 2) != 5 || p.ColorIndexAt(1, 2) != 7 || p.ColorIndexAt(19, 1) != 5 || p.ColorIndexAt(18, 1) != 0 {
+		t.Error("Wrong palette image indexes")
+	}
+	if r, g, _, _ := p.At(1, 2).RGBA(); r != 0 || g != 0xFFFF {
+		t.Error("Wrong palette color")
+	}
+
+	// Compressed images aren't supported.
+	gray.Fields = append(gray.Fields, shortField(Compression, le, 99))
+	gray.Fix()
+	if _, err := gray.DecodeImage(); err == nil {
+		t.Error("Unsupported compression didn't cause an error")
+	}
+}
+
+// Images whose declared dimensions would overflow or require huge
+// allocations are rejected before any data is assembled.
+func TestDecodeImageLimits(t *testing.T) {
+	le := binary.LittleEndian
+	tests := [][]Field{
+		// Plane size wraps in 32 bits.
+		{longField(ImageWidth, le, 1<<16), longField(ImageLength, le, 1<<16), shortField(BitsPerSample, le, 8)},
+		// Row size wraps to 0 in 32 bits.
+		{longField(ImageWidth, le, 1<<29), longField(ImageLength, le, 1), shortField(BitsPerSample, le, 8, 8), shortField(SamplesPerPixel, le, 2)},
+		// Tile larger than the image.
+		{longField(ImageWidth, le, 16), longField(ImageLength, le, 16), shortField(BitsPerSample, le, 8), longField(TileWidth, le, 1<<16), longField(TileLength, le, 1<<16)},
+		// Zero bits per sample.
+		{longField(ImageWidth, le, 16), longField(ImageLength, le, 16), shortField(BitsPerSample, le, 0)},
+	}
+	for i, fields := range tests {
+		fields = append(fields, shortField(PhotometricInterpretation, le, PhotometricBlackIsZero))
+		node := imageNode(le, fields, StripOffsets, StripByteCounts, []ImageSegment{make(ImageSegment, 16)})
+		node.Fix()
+		if _, err := node.DecodeImage(); err == nil {
+			t.Errorf("Test %d: image with invalid size didn't cause an error", i)
+		}
+	}
+}
+
+// Encode a grayscale JPEG stream filled with a single value, and split
+// it into an abbreviated stream with the quantization and Huffman
+// tables, and a stream with the remainder.
+func jpegStrip(t *testing.T, width, height int, val uint8) ([]byte, []byte) {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = val
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+	segments, err := jpegSegments(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []byte{0xFF, jpegSOI}
+	data := []byte{0xFF, jpegSOI}
+	scan := uint32(2)
+	for _, seg := range segments {
+		if seg.marker == 0xDB || seg.marker == 0xC4 {
+			tables = append(tables, stream[seg.pos:seg.pos+seg.size]...)
+		} else {
+			data = append(data, stream[seg.pos:seg.pos+seg.size]...)
+		}
+		scan = seg.pos + seg.size
+	}
+	return append(tables, 0xFF, jpegEOI), append(data, stream[scan:]...)
+}
+
+func TestDecodeJPEGImage(t *testing.T) {
+	le := binary.LittleEndian
+	tables, strip1 := jpegStrip(t, 24, 16, 50)
+	_, strip2 := jpegStrip(t, 24, 16, 200)
+	node := imageNode(le, []Field{
+		shortField(ImageWidth, le, 24),
+		shortField(ImageLength, le, 20),
+		shortField(BitsPerSample, le, 8),
+		shortField(Compression, le, CompressionJPEG),
+		shortField(PhotometricInterpretation, le, PhotometricBlackIsZero),
+		shortField(RowsPerStrip, le, 16),
+		{JPEGTables, UNDEFINED, uint32(len(tables)), tables},
+	}, StripOffsets, StripByteCounts, []ImageSegment{strip1, strip2})
+	img, err := node.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	near := func(a, b uint8) bool {
+		return a >= b-2 && a <= b+2
+	}
+	g, ok := img.(*image.Gray)
+	if !ok || img.Bounds() != image.Rect(0, 0, 24, 20) {
+		t.Fatal("Wrong JPEG image type or size")
+	}
+	if !near(g.GrayAt(3, 15).Y, 50) || !near(g.GrayAt(23, 16).Y, 200) || !near(g.GrayAt(0, 19).Y, 200) {
+		t.Error("Wrong JPEG image pixels")
+	}
+
+	// Old-style JPEG, as used for Exif thumbnails.
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	thumb := imageNode(le, []Field{
+		shortField(Compression, le, CompressionOldJPEG),
+	}, JPEGInterchangeFormat, JPEGInterchangeFormatLength, []ImageSegment{buf.Bytes()})
+	if img, err := thumb.DecodeImage(); err != nil || img.Bounds() != image.Rect(0, 0, 8, 4) {
+		t.Errorf("Old-style JPEG not decoded: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// IPTC IIM record numbers.
+const (
+	IPTCEnvelopeRecord    = 1
+	IPTCApplicationRecord = 2
+)
+
+// Some IPTC IIM datasets in the application record.
+const (
+	IPTCObjectName      = 5
+	IPTCKeywords        = 25
+	IPTCDateCreated     = 55
+	IPTCByline          = 80
+	IPTCCity            = 90
+	IPTCCountryName     = 101
+	IPTCHeadline        = 105
+	IPTCCredit          = 110
+	IPTCSource          = 115
+	IPTCCopyrightNotice = 116
+	IPTCCaption         = 120
+)
+
+// Mappings from IPTC IIM record and dataset numbers to strings, as
+// named by Exiftool.
+var IPTCDatasetNames = map[uint8]map[uint8]string{
+	IPTCEnvelopeRecord: {
+		0:   "EnvelopeRecordVersion",
+		5:   "Destination",
+		20:  "FileFormat",
+		22:  "FileVersion",
+		30:  "ServiceIdentifier",
+		40:  "EnvelopeNumber",
+		50:  "ProductID",
+		60:  "EnvelopePriority",
+		70:  "DateSent",
+		80:  "TimeSent",
+		90:  "CodedCharacterSet",
+		100: "UniqueObjectName",
+	},
+	IPTCApplicationRecord: {
+		0:                   "ApplicationRecordVersion",
+		3:                   "ObjectTypeReference",
+		4:                   "ObjectAttributeReference",
+		IPTCObjectName:      "ObjectName",
+		7:                   "EditStatus",
+		10:                  "Urgency",
+		12:                  "SubjectReference",
+		15:                  "Category",
+		20:                  "SupplementalCategories",
+		22:                  "FixtureIdentifier",
+		IPTCKeywords:        "Keywords",
+		26:                  "ContentLocationCode",
+		27:                  "ContentLocationName",
+		30:                  "ReleaseDate",
+		35:                  "ReleaseTime",
+		37:                  "ExpirationDate",
+		38:                  "ExpirationTime",
+		40:                  "SpecialInstructions",
+		45:                  "ReferenceService",
+		47:                  "ReferenceDate",
+		50:                  "ReferenceNumber",
+		IPTCDateCreated:     "DateCreated",
+		60:                  "TimeCreated",
+		62:                  "DigitalCreationDate",
+		63:                  "DigitalCreationTime",
+		65:                  "OriginatingProgram",
+		70:                  "ProgramVersion",
+		75:                  "ObjectCycle",
+		IPTCByline:          "By-line",
+		85:                  "By-lineTitle",
+		IPTCCity:            "City",
+		92:                  "Sub-location",
+		95:                  "Province-State",
+		100:                 "Country-PrimaryLocationCode",
+		IPTCCountryName:     "Country-PrimaryLocationName",
+		103:                 "OriginalTransmissionReference",
+		IPTCHeadline:        "Headline",
+		IPTCCredit:          "Credit",
+		IPTCSource:          "Source",
+		IPTCCopyrightNotice: "CopyrightNotice",
+		118:                 "Contact",
+		IPTCCaption:         "Caption-Abstract",
+		121:                 "LocalCaption",
+		122:                 "Writer-Editor",
+	},
+}
+
+// A dataset from an IPTC IIM block.
+type IPTCDataset struct {
+	Record  uint8
+	Dataset uint8
+	Data    []byte
+}
+
+// Return the name of a dataset, or "" if it isn't known.
+func (d IPTCDataset) Name() string {
+	return IPTCDatasetNames[d.Record][d.Dataset]
+}
+
+// The datasets from an IPTC IIM block, in the order they appear.
+type IPTCData []IPTCDataset
+
+// Marker at the start of each IPTC dataset.
+const iptcMarker = 0x1C
+
+// Decode an IPTC IIM block into its datasets. Zero padding at the end
+// of the block is ignored. The data of each dataset points into the
+// block.
+func DecodeIPTC(buf []byte) (IPTCData, error) {
+	var data IPTCData
+	pos := 0
+	for pos < len(buf) && buf[pos] != 0 {
+		if buf[pos] != iptcMarker {
+			return data, fmt.Errorf("DecodeIPTC: no dataset marker at %d", pos)
+		}
+		if pos+5 > len(buf) {
+			return data, fmt.Errorf("DecodeIPTC: dataset header at %d is truncated", pos)
+		}
+		ds := IPTCDataset{Record: buf[pos+1], Dataset: buf[pos+2]}
+		size := uint64(binary.BigEndian.Uint16(buf[pos+3:]))
+		pos += 5
+		if size&0x8000 != 0 {
+			// Extended dataset: the size is in the following
+			// bytes.
+			n := int(size & 0x7FFF)
+			if n > 8 || pos+n > len(buf) {
+				return data, fmt.Errorf("DecodeIPTC: invalid extended size for dataset %d:%d", ds.Record, ds.Dataset)
+			}
+			size = 0
+			for i := 0; i < n; i++ {
+				size = size<<8 | uint64(buf[pos+i])
+			}
+			pos += n
+		}
+		if size > uint64(len(buf)-pos) {
+			return data, fmt.Errorf("DecodeIPTC: dataset %d:%d extends past end of block", ds.Record, ds.Dataset)
+		}
+		ds.Data = buf[pos : pos+int(size)]
+		pos += int(size)
+		data = append(data, ds)
+	}
+	return data, nil
+}
+
+// Encode datasets as an IPTC IIM block.
+func (data IPTCData) Encode() []byte {
+	var buf []byte
+	for _, ds := range data {
+		buf = append(buf, iptcMarker, ds.Record, ds.Dataset)
+		if len(ds.Data) < 0x8000 {
+			buf = append(buf, byte(len(ds.Data)>>8), byte(len(ds.Data)))
+		} else {
+			// Extended dataset, with a 4 byte size.
+			buf = append(buf, 0x80, 4)
+			buf = append(buf, byte(len(ds.Data)>>24), byte(len(ds.Data)>>16), byte(len(ds.Data)>>8), byte(len(ds.Data)))
+		}
+		buf = append(buf, ds.Data...)
+	}
+	return buf
+}
+
+// Return the values of all datasets with given record and dataset
+// numbers, such as the keywords. The text isn't converted from the
+// character set given by the CodedCharacterSet dataset.
+func (data IPTCData) Strings(record, dataset uint8) []string {
+	var vals []string
+	for _, ds := range data {
+		if ds.Record == record && ds.Dataset == dataset {
+			vals = append(vals, string(ds.Data))
+		}
+	}
+	return vals
+}
+
+// Return the value of the first dataset with given record and dataset
+// numbers, such as the caption, and whether it was found.
+func (data IPTCData) String(record, dataset uint8) (string, bool) {
+	for _, ds := range data {
+		if ds.Record == record && ds.Dataset == dataset {
+			return string(ds.Data), true
+		}
+	}
+	return "", false
+}
+
+// Replace all datasets with given record and dataset numbers with new
+// datasets holding vals, or delete them if vals is empty. New datasets
+// are placed in order of record and dataset number.
+func (data *IPTCData) SetStrings(record, dataset uint8, vals []string) {
+	var kept IPTCData
+	for _, ds := range *data {
+		if ds.Record != record || ds.Dataset != dataset {
+			kept = append(kept, ds)
+		}
+	}
+	pos := len(kept)
+	for i, ds := range kept {
+		if ds.Record > record || ds.Record == record && ds.Dataset > dataset {
+			pos = i
+			break
+		}
+	}
+	added := make(IPTCData, len(vals))
+	for i, val := range vals {
+		added[i] = IPTCDataset{record, dataset, []byte(val)}
+	}
+	*data = append(kept[:pos], append(added, kept[pos:]...)...)
+}
+
+// Return the datasets from the IPTC field of a node.
+func (node IFDNode) GetIPTC() (IPTCData, error) {
+	fields := node.FindFields([]Tag{IPTC})
+	if len(fields) == 0 {
+		return nil, errors.New("GetIPTC: IPTC field not found")
+	}
+	field := fields[0]
+	if uint64(len(field.Data)) < uint64(field.Size()) {
+		return nil, errors.New("GetIPTC: IPTC field data isn't loaded")
+	}
+	return DecodeIPTC(field.Data[:field.Size()])
+}
+
+// Set the IPTC field of a node from datasets, deleting it if there are
+// none. An existing field with LONG type, as written by Photoshop, keeps
+// its type and is padded to a multiple of 4 bytes; otherwise the field
+// has UNDEFINED type.
+func (node *IFDNode) SetIPTC(data IPTCData) {
+	if len(data) == 0 {
+		node.DeleteFields([]Tag{IPTC})
+		return
+	}
+	buf := data.Encode()
+	if fields := node.FindFields([]Tag{IPTC}); len(fields) > 0 && fields[0].Type == LONG {
+		for len(buf)%4 != 0 {
+			buf = append(buf, 0)
+		}
+		node.replaceField(Field{IPTC, LONG, uint32(len(buf) / 4), buf})
+		return
+	}
+	node.SetUndefined(IPTC, buf)
+}
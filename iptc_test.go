@@ -0,0 +1,75 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestIPTC(t *testing.T) {
+	block := []byte{
+		0x1C, 1, 90, 0, 3, 0x1B, '%', 'G',
+		0x1C, 2, 0, 0, 2, 0, 4,
+		0x1C, 2, 25, 0, 3, 'o', 'n', 'e',
+		0x1C, 2, 25, 0, 3, 't', 'w', 'o',
+		0x1C, 2, 120, 0, 7, 'C', 'a', 'p', 't', 'i', 'o', 'n',
+		0, 0, 0,
+	}
+	data, err := DecodeIPTC(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 5 || data[0].Name() != "CodedCharacterSet" || data[4].Name() != "Caption-Abstract" {
+		t.Fatalf("Decoded datasets %v", data)
+	}
+	if kw := data.Strings(IPTCApplicationRecord, IPTCKeywords); !reflect.DeepEqual(kw, []string{"one", "two"}) {
+		t.Errorf("Keywords are %q", kw)
+	}
+	if c, ok := data.String(IPTCApplicationRecord, IPTCCaption); !ok || c != "Caption" {
+		t.Errorf("Caption is %q, %v", c, ok)
+	}
+	if !bytes.Equal(data.Encode(), block[:len(block)-3]) {
+		t.Error("Encoded block differs from original")
+	}
+
+	// Edit the datasets and store them in a field.
+	data.SetStrings(IPTCApplicationRecord, IPTCKeywords, []string{"three"})
+	data.SetStrings(IPTCApplicationRecord, IPTCCredit, []string{"Someone"})
+	data.SetStrings(IPTCApplicationRecord, IPTCCaption, nil)
+	expected := []string{"CodedCharacterSet", "ApplicationRecordVersion", "Keywords", "Credit"}
+	var names []string
+	for _, ds := range data {
+		names = append(names, ds.Name())
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Edited datasets are %q", names)
+	}
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.LittleEndian
+	if _, err := node.GetIPTC(); err == nil {
+		t.Error("Missing IPTC field didn't cause an error")
+	}
+	node.replaceField(Field{IPTC, LONG, 0, nil})
+	node.SetIPTC(data)
+	field := node.FindFields([]Tag{IPTC})[0]
+	if field.Type != LONG || field.Size()%4 != 0 {
+		t.Errorf("IPTC field has type %s and size %d", field.Type.Name(), field.Size())
+	}
+	got, err := node.GetIPTC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("IPTC field decoded as %v", got)
+	}
+
+	// Extended dataset.
+	long := IPTCData{{IPTCApplicationRecord, IPTCCaption, make([]byte, 0x9000)}}
+	if got, err := DecodeIPTC(long.Encode()); err != nil || len(got) != 1 || len(got[0].Data) != 0x9000 {
+		t.Errorf("Extended dataset decoded as %d datasets, %v", len(got), err)
+	}
+	if _, err := DecodeIPTC([]byte{0x1C, 2, 5, 0, 10, 'x'}); err == nil {
+		t.Error("Truncated dataset didn't cause an error")
+	}
+}
@@ -0,0 +1,187 @@
+package tiff66
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// JPEG markers.
+const (
+	jpegSOI  = 0xD8
+	jpegEOI  = 0xD9
+	jpegSOS  = 0xDA
+	jpegAPP0 = 0xE0
+	jpegAPP1 = 0xE1
+	jpegAPP2 = 0xE2
+	jpegTEM  = 0x01
+	jpegRST0 = 0xD0
+	jpegRST7 = 0xD7
+)
+
+// The header at the start of an Exif APP1 segment, which is followed
+// by a TIFF block.
+var ExifHeader = []byte("Exif\000\000")
+
+// Maximum size of a TIFF block in a JPEG APP1 segment. The segment
+// length is 16 bits and includes the length itself and the Exif
+// header.
+const MaxJPEGExifSize = math.MaxUint16 - 2 - 6
+
+// Position and size of a marker segment in a JPEG file.
+type jpegSegment struct {
+	marker byte
+	pos    uint32 // Position of the 0xFF that starts the marker.
+	size   uint32 // Size including the marker and length.
+}
+
+// Return the marker segments in a JPEG file that precede the image
+// scan data.
+func jpegSegments(buf []byte) ([]jpegSegment, error) {
+	if len(buf) < 2 || buf[0] != 0xFF || buf[1] != jpegSOI {
+		return nil, errors.New("Not a JPEG file")
+	}
+	var segments []jpegSegment
+	bufsize := uint32(len(buf))
+	pos := uint32(2)
+	for {
+		// Markers may be preceded by any number of 0xFF fill bytes.
+		for pos+1 < bufsize && buf[pos] == 0xFF && buf[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+1 >= bufsize {
+			return segments, errors.New("JPEG file truncated before image data")
+		}
+		if buf[pos] != 0xFF {
+			return segments, fmt.Errorf("JPEG marker not found at %d", pos)
+		}
+		marker := buf[pos+1]
+		if marker == jpegSOS || marker == jpegEOI {
+			return segments, nil
+		}
+		if marker == jpegTEM || (marker >= jpegRST0 && marker <= jpegRST7) {
+			// Markers without a length.
+			pos += 2
+			continue
+		}
+		if pos+4 > bufsize {
+			return segments, errors.New("JPEG file truncated in marker segment")
+		}
+		size := 2 + uint32(buf[pos+2])<<8 + uint32(buf[pos+3])
+		if size < 4 {
+			return segments, fmt.Errorf("Invalid JPEG marker segment length at %d", pos)
+		}
+		if pos+size > bufsize {
+			return segments, errors.New("JPEG marker segment extends past end of file")
+		}
+		segments = append(segments, jpegSegment{marker, pos, size})
+		pos += size
+	}
+}
+
+// Find the Exif APP1 segment in a JPEG file.
+func findJPEGExif(buf []byte) (*jpegSegment, error) {
+	segments, err := jpegSegments(buf)
+	for i := range segments {
+		seg := segments[i]
+		if seg.marker == jpegAPP1 && bytes.HasPrefix(buf[seg.pos+4:seg.pos+seg.size], ExifHeader) {
+			return &seg, nil
+		}
+	}
+	return nil, err
+}
+
+// Return the TIFF block from the Exif APP1 segment in a JPEG file, or
+// nil if there is none. The returned slice points into buf.
+func GetJPEGExif(buf []byte) ([]byte, error) {
+	seg, err := findJPEGExif(buf)
+	if seg == nil {
+		return nil, err
+	}
+	return buf[seg.pos+4+uint32(len(ExifHeader)) : seg.pos+seg.size], nil
+}
+
+// Decode the Exif block in a JPEG file into an IFDNode tree. Returns
+// a nil node if the file doesn't contain an Exif block. As with
+// GetIFDTree, the error may be a multierror structure and the node
+// may still be useful if an error is returned.
+func GetJPEGExifTree(buf []byte) (*IFDNode, error) {
+	exif, err := GetJPEGExif(buf)
+	if exif == nil {
+		return nil, err
+	}
+	return getTIFFTree(exif)
+}
+
+// Return a new JPEG file in which the Exif APP1 segment is replaced
+// by one containing the given TIFF block. If the file has no Exif
+// segment, one will be inserted after the JFIF APP0 segment, if
+// present, or otherwise at the start of the file. The TIFF block must
+// not be larger than MaxJPEGExifSize.
+func PutJPEGExif(buf []byte, exif []byte) ([]byte, error) {
+	if len(exif) > MaxJPEGExifSize {
+		return nil, fmt.Errorf("Exif block size %d exceeds maximum for a JPEG segment, %d", len(exif), MaxJPEGExifSize)
+	}
+	segments, err := jpegSegments(buf)
+	if err != nil {
+		return nil, err
+	}
+	start := uint32(2) // Position for new segment, after SOI.
+	end := start       // End of replaced segment.
+	for _, seg := range segments {
+		if seg.marker == jpegAPP1 && bytes.HasPrefix(buf[seg.pos+4:seg.pos+seg.size], ExifHeader) {
+			start = seg.pos
+			end = seg.pos + seg.size
+			break
+		}
+		if seg.marker == jpegAPP0 && seg.pos == start {
+			start = seg.pos + seg.size
+			end = start
+		}
+	}
+	length := 2 + len(ExifHeader) + len(exif)
+	out := make([]byte, 0, len(buf)-int(end-start)+2+length)
+	out = append(out, buf[:start]...)
+	out = append(out, 0xFF, jpegAPP1, byte(length>>8), byte(length))
+	out = append(out, ExifHeader...)
+	out = append(out, exif...)
+	out = append(out, buf[end:]...)
+	return out, nil
+}
+
+// Serialize an IFDNode tree and embed it in a JPEG file as for
+// PutJPEGExif. An error is returned if the serialized tree won't fit
+// in a JPEG segment.
+func PutJPEGExifTree(buf []byte, node *IFDNode) ([]byte, error) {
+	exif, err := putTIFFTree(node)
+	if err != nil {
+		return nil, err
+	}
+	return PutJPEGExif(buf, exif)
+}
+
+// Decode a TIFF block, including its header, into an IFDNode tree.
+func getTIFFTree(buf []byte) (*IFDNode, error) {
+	valid, order, pos := GetHeader(buf)
+	if !valid {
+		return nil, errors.New("TIFF header not valid")
+	}
+	return GetIFDTree(buf, order, pos, TIFFSpace)
+}
+
+// Serialize an IFDNode tree into a new TIFF block, including the
+// header.
+func putTIFFTree(node *IFDNode) ([]byte, error) {
+	size := HeaderSize + node.TreeSize()
+	if size > math.MaxUint32 {
+		return nil, ErrNeedsBigTIFF{"putTIFFTree", size}
+	}
+	buf := make([]byte, size)
+	PutHeader(buf, node.Order, HeaderSize)
+	next, err := node.PutIFDTree(buf, HeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:next], nil
+}
@@ -0,0 +1,58 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Embed an Exif tree in a minimal JPEG file, read it back, then
+// replace it.
+func TestJPEGExif(t *testing.T) {
+	jfif := []byte{0xFF, jpegAPP0, 0, 7, 'J', 'F', 'I', 'F', 0}
+	scan := []byte{0xFF, jpegSOS, 0, 2, 1, 2, 3, 0xFF, jpegEOI}
+	jpeg := append([]byte{0xFF, jpegSOI}, jfif...)
+	jpeg = append(jpeg, scan...)
+	if exif, err := GetJPEGExif(jpeg); exif != nil || err != nil {
+		t.Error("Found Exif in JPEG without Exif")
+	}
+
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.BigEndian
+	node.Fields = make([]Field, 1)
+	node.Fields[0] = Field{Artist, ASCII, 0, nil}
+	node.Fields[0].PutASCII("Someone")
+	node.Fields[0].Count = uint32(len(node.Fields[0].Data))
+	out, err := PutJPEGExifTree(jpeg, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out[2:], jfif) || !bytes.HasSuffix(out, scan) {
+		t.Error("Exif segment not inserted after JFIF segment")
+	}
+	getnode, err := GetJPEGExifTree(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getnode == nil || getnode.Fields[0].ASCII() != "Someone" {
+		t.Fatal("Exif tree not read back")
+	}
+
+	getnode.Fields[0].PutASCII("Someone else")
+	getnode.Fields[0].Count = uint32(len(getnode.Fields[0].Data))
+	out2, err := PutJPEGExifTree(out, getnode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out2) != len(out)+5 {
+		t.Error("Exif segment not replaced")
+	}
+	getnode, err = GetJPEGExifTree(out2)
+	if err != nil || getnode.Fields[0].ASCII() != "Someone else" {
+		t.Error("Replaced Exif tree not read back")
+	}
+
+	if _, err := PutJPEGExif(jpeg, make([]byte, MaxJPEGExifSize+1)); err == nil {
+		t.Error("Oversized Exif block accepted")
+	}
+}
@@ -0,0 +1,116 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// JSON representation of an IFD node.
+type jsonNode struct {
+	Space   string       `json:"space"`
+	Order   string       `json:"byteOrder"`
+	Fields  []jsonField  `json:"fields"`
+	SubIFDs []jsonSubIFD `json:"subIFDs,omitempty"`
+	Next    *IFDNode     `json:"next,omitempty"`
+}
+
+// JSON representation of a field.
+type jsonField struct {
+	Tag   Tag         `json:"tag"`
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Count uint32      `json:"count"`
+	Value interface{} `json:"value"`
+}
+
+// JSON representation of a link to a sub-IFD.
+type jsonSubIFD struct {
+	Tag  Tag      `json:"tag"`
+	Name string   `json:"name,omitempty"`
+	Node *IFDNode `json:"node"`
+}
+
+// Return a field's data in a form suitable for encoding as JSON.
+// ASCII data is a string and UNDEFINED data a hex string. Other types
+// are arrays of numbers, except that rationals are arrays of
+// [numerator, denominator] pairs, and non-finite floating point
+// numbers are strings. Only the values present in the field's data are
+// included. Returns nil if the data hasn't been loaded or has an
+// unknown type.
+func jsonValue(f Field, order binary.ByteOrder) interface{} {
+	if f.Data == nil && f.Size() > 0 {
+		return nil
+	}
+	n := f.present()
+	switch {
+	case f.Type == ASCII:
+		return f.ASCII()
+	case f.Type == UNDEFINED:
+		return hex.EncodeToString(f.Data[:n])
+	case f.Type.IsIntegral() || f.Type == IFD:
+		vals := make([]int64, n)
+		for i := range vals {
+			val, _ := f.Value(uint32(i), order)
+			vals[i] = val.Int
+		}
+		return vals
+	case f.Type.IsRational():
+		vals := make([][2]int64, n)
+		for i := range vals {
+			vals[i][0], vals[i][1] = f.AnyRational(uint32(i), order)
+		}
+		return vals
+	case f.Type.IsFloat():
+		vals := make([]interface{}, n)
+		for i := range vals {
+			val := f.AnyFloat(uint32(i), order)
+			if math.IsNaN(val) || math.IsInf(val, 0) {
+				vals[i] = fmt.Sprint(val)
+			} else {
+				vals[i] = val
+			}
+		}
+		return vals
+	}
+	return nil
+}
+
+// Encode an IFD node and everything linked from it, via sub-IFDs and
+// Next pointers, as JSON. Tags are given as numbers, along with their
+// names if known for the node's tag space.
+func (node IFDNode) MarshalJSON() ([]byte, error) {
+	space := node.GetSpace()
+	names := space.TagNames()
+	jnode := jsonNode{Space: space.Name(), Next: node.Next}
+	switch node.Order {
+	case binary.BigEndian:
+		jnode.Order = "big"
+	case binary.LittleEndian:
+		jnode.Order = "little"
+	}
+	jnode.Fields = make([]jsonField, len(node.Fields))
+	for i, f := range node.Fields {
+		jnode.Fields[i] = jsonField{
+			Tag:   f.Tag,
+			Name:  names[f.Tag],
+			Type:  f.Type.Name(),
+			Count: f.Count,
+			Value: jsonValue(f, node.Order)}
+	}
+	for _, sub := range node.SubIFDs {
+		jnode.SubIFDs = append(jnode.SubIFDs, jsonSubIFD{sub.Tag, names[sub.Tag], sub.Node})
+	}
+	return json.Marshal(jnode)
+}
+
+// Write an IFD tree to w as indented JSON, as encoded by
+// IFDNode.MarshalJSON.
+func ExportJSON(w io.Writer, node *IFDNode) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(node)
+}
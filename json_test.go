@@ -0,0 +1,76 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Encode a small tree with an Exif sub-IFD and a Next IFD as JSON and
+// check the decoded structure.
+func TestJSON(t *testing.T) {
+	order := binary.BigEndian
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = order
+	exif.Fields = []Field{{ExposureTime, RATIONAL, 1, []byte{0, 0, 0, 1, 0, 0, 0, 125}}}
+	next := NewIFDNode(TIFFSpace)
+	next.Order = order
+	next.Fields = []Field{{999, UNDEFINED, 2, []byte{0xAB, 0xCD}}}
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.Fields = []Field{
+		{ImageWidth, SHORT, 2, []byte{0, 1, 0, 2}},
+		{Make, ASCII, 4, []byte("Foo\000")},
+		{ExifIFD, LONG, 1, []byte{0, 0, 0, 0}},
+	}
+	node.SubIFDs = []SubIFD{{ExifIFD, exif}}
+	node.Next = next
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf, node); err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	var expected interface{}
+	if err := json.Unmarshal([]byte(`{
+	  "space": "TIFF", "byteOrder": "big",
+	  "fields": [
+	    {"tag": 256, "name": "ImageWidth", "type": "Short", "count": 2, "value": [1, 2]},
+	    {"tag": 271, "name": "Make", "type": "ASCII", "count": 4, "value": "Foo"},
+	    {"tag": 34665, "name": "ExifIFD", "type": "Long", "count": 1, "value": [0]}
+	  ],
+	  "subIFDs": [
+	    {"tag": 34665, "name": "ExifIFD", "node": {
+	      "space": "Exif", "byteOrder": "big",
+	      "fields": [{"tag": 33434, "name": "ExposureTime", "type": "Rational", "count": 1, "value": [[1, 125]]}]
+	    }}
+	  ],
+	  "next": {
+	    "space": "TIFF", "byteOrder": "big",
+	    "fields": [{"tag": 999, "type": "Undefined", "count": 2, "value": "abcd"}]
+	  }
+	}`), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected JSON:\n%s", buf.String())
+	}
+}
+
+// Fields whose count exceeds their data are encoded with the values
+// present, rather than allocating for the count.
+func TestJSONShortData(t *testing.T) {
+	order := binary.LittleEndian
+	f := Field{ImageWidth, SHORT, 0xFFFFFFFF, []byte{1, 0, 2, 0}}
+	if vals, ok := jsonValue(f, order).([]int64); !ok || !reflect.DeepEqual(vals, []int64{1, 2}) {
+		t.Errorf("Unexpected value %v", jsonValue(f, order))
+	}
+	f = Field{999, UNDEFINED, 0xFFFFFFFF, []byte{0xAB}}
+	if val := jsonValue(f, order); val != "ab" {
+		t.Errorf("Unexpected value %v", val)
+	}
+}
@@ -0,0 +1,208 @@
+package tiff66
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Kind of a region of a file, as returned by Layout.
+type RegionKind uint8
+
+const (
+	RegionHeader          RegionKind = 0 // The TIFF header.
+	RegionIFD             RegionKind = 1 // An IFD table, including its count and Next pointer.
+	RegionFieldData       RegionKind = 2 // Field data stored outside the IFD table.
+	RegionImageData       RegionKind = 3 // A segment of image data.
+	RegionMakerNoteHeader RegionKind = 4 // A maker note label or header before its IFD.
+)
+
+// Return the name of a region kind.
+func (kind RegionKind) String() string {
+	switch kind {
+	case RegionHeader:
+		return "Header"
+	case RegionIFD:
+		return "IFD"
+	case RegionFieldData:
+		return "FieldData"
+	case RegionImageData:
+		return "ImageData"
+	case RegionMakerNoteHeader:
+		return "MakerNoteHeader"
+	}
+	return "Unknown"
+}
+
+// A range of bytes in a file that was read by the parser, and the IFD
+// and field that own it. Regions may be nested: for example, the data
+// of a MakerNote field contains the maker note's header and IFDs.
+type LayoutRegion struct {
+	Start, End uint32 // Byte range, excluding End.
+	Kind       RegionKind
+	Space      TagSpace // Tag space of the owning IFD.
+	IFDPos     uint32   // Position of the owning IFD.
+	Tag        Tag      // Field that refers to the data, or 0.
+	Segment    int      // Index of an image data segment.
+}
+
+// Record a region read by the parser, if a layout is being collected.
+func (src source) addRegion(region LayoutRegion) {
+	state := src.state
+	if state == nil || !state.layout || region.End <= region.Start {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.regions = append(state.regions, region)
+}
+
+// Record the IFD table and image data of a node that has been read.
+func (node *IFDNode) addLayout(src source, pos, tabsize uint32) {
+	if src.state == nil || !src.state.layout {
+		return
+	}
+	space := node.GetSpace()
+	ifdpos := src.base + pos
+	src.addRegion(LayoutRegion{Start: ifdpos, End: ifdpos + tabsize, Kind: RegionIFD, Space: space, IFDPos: ifdpos})
+	for _, id := range node.GetImageData() {
+		for i := range id.Offsets {
+			if i < len(id.Sizes) {
+				src.addRegion(LayoutRegion{Start: id.Offsets[i], End: id.Offsets[i] + id.Sizes[i], Kind: RegionImageData, Space: space, IFDPos: ifdpos, Tag: id.OffsetTag, Segment: i})
+			}
+		}
+	}
+}
+
+// Describe a region for an error message.
+func (region LayoutRegion) describe() string {
+	str := region.Kind.String()
+	switch region.Kind {
+	case RegionFieldData, RegionMakerNoteHeader:
+		str += fmt.Sprintf(" of field %d(0x%X)", region.Tag, region.Tag)
+	case RegionImageData:
+		str += fmt.Sprintf(" segment %d of field %d(0x%X)", region.Segment, region.Tag, region.Tag)
+	}
+	if region.Kind != RegionHeader {
+		str += fmt.Sprintf(" in %s IFD at %d", region.Space.Name(), region.IFDPos)
+	}
+	return fmt.Sprintf("%s (%d-%d)", str, region.Start, region.End)
+}
+
+// Sort regions by position, with enclosing regions before the regions
+// they contain.
+func sortRegions(regions []LayoutRegion) {
+	sort.SliceStable(regions, func(i, j int) bool {
+		if regions[i].Start != regions[j].Start {
+			return regions[i].Start < regions[j].Start
+		}
+		return regions[i].End > regions[j].End
+	})
+}
+
+// Return an error for each pair of overlapping regions, after sorting
+// them. Identical regions of the same kind are allowed, since field
+// and image data may be shared, as are regions inside field data that
+// contains an IFD or maker note header, such as a maker note.
+func findOverlaps(regions []LayoutRegion) []error {
+	sortRegions(regions)
+	// Field data regions that contain embedded IFDs.
+	containers := make(map[int]bool)
+	var open []int // Regions that may overlap the current one.
+	for i, region := range regions {
+		kept := open[:0]
+		for _, j := range open {
+			if regions[j].End > region.Start {
+				kept = append(kept, j)
+			}
+		}
+		open = append(kept, i)
+		if region.Kind != RegionIFD && region.Kind != RegionMakerNoteHeader {
+			continue
+		}
+		for _, j := range open[:len(open)-1] {
+			if regions[j].Kind == RegionFieldData && regions[j].End >= region.End {
+				containers[j] = true
+			}
+		}
+	}
+	var problems []error
+	open = open[:0]
+	for i, region := range regions {
+		kept := open[:0]
+		for _, j := range open {
+			if regions[j].End > region.Start {
+				kept = append(kept, j)
+			}
+		}
+		open = kept
+		for _, j := range open {
+			other := regions[j]
+			if other.Start == region.Start && other.End == region.End && other.Kind == region.Kind {
+				continue
+			}
+			if containers[j] && other.End >= region.End {
+				continue
+			}
+			problems = append(problems, fmt.Errorf("%s overlaps %s", other.describe(), region.describe()))
+		}
+		open = append(open, i)
+	}
+	return problems
+}
+
+// Return the regions of a TIFF file that are read when it's decoded
+// with GetIFDTree: the header, each IFD table, field data stored
+// outside the tables, image data segments and maker note headers,
+// sorted by position, with enclosing regions before the regions they
+// contain. Bytes not covered by any region aren't referenced by the
+// file's IFDs. This may be useful for debugging offset problems or
+// finding hidden data. Any error from decoding the file is also
+// returned.
+func Layout(buf []byte) ([]LayoutRegion, error) {
+	valid, order, pos := GetHeader(buf)
+	if !valid {
+		return nil, errors.New("Layout: TIFF header not valid")
+	}
+	src := bufSource(buf)
+	src.state = newParseState(nil)
+	src.state.layout = true
+	src.addRegion(LayoutRegion{Start: 0, End: HeaderSize, Kind: RegionHeader})
+	_, err := getIFDTreeIter(src, order, pos, NewSpaceRec(TIFFSpace), make(posMap))
+	regions := src.state.regions
+	sortRegions(regions)
+	return regions, err
+}
+
+// A range of bytes in a file that isn't read by the parser.
+type UnreferencedRange struct {
+	Start, End uint32 // Byte range, excluding End.
+	// Whether the range extends to the end of the file, such as a
+	// trailer appended by a vendor or editing application.
+	Trailer bool
+}
+
+// Return the ranges of a TIFF file that aren't part of any region
+// returned by Layout: alignment padding, data that's no longer
+// referenced after the file was edited, hidden data and trailers. Any
+// error from decoding the file is also returned.
+func Unreferenced(buf []byte) ([]UnreferencedRange, error) {
+	regions, err := Layout(buf)
+	if regions == nil {
+		return nil, err
+	}
+	var ranges []UnreferencedRange
+	covered := uint32(0)
+	for _, region := range regions {
+		if region.Start > covered {
+			ranges = append(ranges, UnreferencedRange{Start: covered, End: region.Start})
+		}
+		if region.End > covered {
+			covered = region.End
+		}
+	}
+	if size := uint32(len(buf)); covered < size {
+		ranges = append(ranges, UnreferencedRange{Start: covered, End: size, Trailer: true})
+	}
+	return ranges, err
+}
@@ -0,0 +1,235 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	order := binary.BigEndian
+	raw := []byte("raw sensor data")
+	preview := []byte("preview image")
+	label := []byte("PENTAX \000MM")
+	maker := NewIFDNode(Pentax1Space)
+	maker.Order = order
+	maker.Fields = []Field{
+		{pentax1PreviewImageLength, LONG, 1, make([]byte, 4)},
+		{pentax1PreviewImageStart, LONG, 1, make([]byte, 4)},
+	}
+	maker.Fields[0].PutLong(uint32(len(preview)), 0, order)
+	rec := maker.SpaceRec.(*Pentax1SpaceRec)
+	rec.label = label
+	rec.relative = true
+	rec.imageData = []ImageData{{OffsetTag: pentax1PreviewImageStart, SizeTag: pentax1PreviewImageLength, Segments: []ImageSegment{preview}}}
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = order
+	exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+	exif.SubIFDs = []SubIFD{{makerNote, maker}}
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.Fields = []Field{
+		{StripOffsets, LONG, 1, make([]byte, 4)},
+		{StripByteCounts, LONG, 1, make([]byte, 4)},
+		{ExifIFD, LONG, 1, make([]byte, 4)},
+	}
+	root.Fields[1].PutLong(uint32(len(raw)), 0, order)
+	root.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{raw}}}}
+	root.SubIFDs = []SubIFD{{ExifIFD, exif}}
+	root.SetASCII(Software, "Some software or other")
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	regions, err := Layout(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(kind RegionKind, space TagSpace, tag Tag) *LayoutRegion {
+		for i := range regions {
+			r := &regions[i]
+			if r.Kind == kind && r.Space == space && r.Tag == tag {
+				return r
+			}
+		}
+		t.Errorf("%v region in %s IFD for tag %d not found", kind, space.Name(), tag)
+		return nil
+	}
+	if r := find(RegionHeader, TIFFSpace, 0); r != nil && (r.Start != 0 || r.End != HeaderSize) {
+		t.Error("Wrong header region")
+	}
+	if r := find(RegionIFD, TIFFSpace, 0); r != nil && (r.Start != HeaderSize || r.End != HeaderSize+TableSize(4)) {
+		t.Errorf("Wrong IFD region %d-%d", r.Start, r.End)
+	}
+	find(RegionIFD, ExifSpace, 0)
+	find(RegionIFD, Pentax1Space, 0)
+	if r := find(RegionFieldData, TIFFSpace, Software); r != nil && string(buf[r.Start:r.End]) != "Some software or other\000" {
+		t.Error("Wrong field data region")
+	}
+	makerData := find(RegionFieldData, ExifSpace, makerNote)
+	if r := find(RegionMakerNoteHeader, Pentax1Space, makerNote); r != nil && string(buf[r.Start:r.End]) != string(label) {
+		t.Errorf("Wrong maker note header %q", buf[r.Start:r.End])
+	}
+	if r := find(RegionImageData, TIFFSpace, StripOffsets); r != nil && string(buf[r.Start:r.End]) != string(raw) {
+		t.Error("Wrong image data region")
+	}
+	if r := find(RegionImageData, Pentax1Space, pentax1PreviewImageStart); r != nil && makerData != nil {
+		if string(buf[r.Start:r.End]) != string(preview) || r.Start < makerData.Start || r.End > makerData.End {
+			t.Error("Wrong maker note image data region")
+		}
+	}
+	if _, ok := root.Position(); ok {
+		t.Error("Position of unencoded node reported")
+	}
+	decoded, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := find(RegionIFD, ExifSpace, 0); r != nil {
+		if pos, ok := decoded.SubIFDs[0].Node.Position(); !ok || pos != r.Start {
+			t.Errorf("Exif IFD position %d, expected %d", pos, r.Start)
+		}
+	}
+	// The file is written without gaps, except for alignment.
+	covered := uint32(0)
+	for i, r := range regions {
+		if i > 0 && r.Start < regions[i-1].Start {
+			t.Fatal("Regions aren't sorted")
+		}
+		if r.Start > covered+1 {
+			t.Errorf("Gap at %d-%d", covered, r.Start)
+		}
+		if r.End > covered {
+			covered = r.End
+		}
+	}
+	if covered+1 < uint32(len(buf)) {
+		t.Errorf("Gap at end, from %d", covered)
+	}
+	// The maker note's contents are inside its field data, which
+	// isn't an overlap.
+	if _, err := GetIFDTreeOptions(buf, order, HeaderSize, TIFFSpace, &ParseOptions{CheckOverlaps: true}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckOverlaps(t *testing.T) {
+	order := binary.LittleEndian
+	var root, last *IFDNode
+	for i := 0; i < 2; i++ {
+		node, err := EncodeImage(image.NewGray(image.Rect(0, 0, 8, 8)), &EncodeOptions{Order: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.SetASCII(Software, "Some software or other")
+		if root == nil {
+			root = node
+		} else {
+			last.Next = node
+		}
+		last = node
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTreeShared(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	pos := order.Uint32(buf[4:])
+	opts := &ParseOptions{CheckOverlaps: true}
+	// Field data shared between IFDs isn't an overlap.
+	node, err := GetIFDTreeOptions(buf, order, pos, TIFFSpace, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Move the Software data into the pixels of the first page.
+	strip := node.GetImageData()[0].Offsets[0]
+	for i := uint32(0); i < uint32(order.Uint16(buf[pos:])); i++ {
+		epos := pos + 2 + i*TableEntrySize
+		if Tag(order.Uint16(buf[epos:])) == Software {
+			order.PutUint32(buf[epos+8:], strip+4)
+		}
+	}
+	if _, err := GetIFDTree(buf, order, pos, TIFFSpace); err != nil {
+		t.Fatal(err)
+	}
+	_, err = GetIFDTreeOptions(buf, order, pos, TIFFSpace, opts)
+	if countErrors(err) != 1 {
+		t.Fatalf("Found %d problems, expected 1: %v", countErrors(err), err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "ImageData segment 0 of field 273(0x111)") || !strings.Contains(msg, "FieldData of field 305(0x131)") {
+		t.Errorf("Unexpected problem: %s", msg)
+	}
+}
+
+func TestUnreferenced(t *testing.T) {
+	order := binary.LittleEndian
+	root, err := EncodeImage(image.NewGray(image.Rect(0, 0, 8, 8)), &EncodeOptions{Order: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.SetASCII(Software, "Some software or other")
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	// Hide some data in the middle of the file by moving the pixels,
+	// and add a trailer.
+	hidden := []byte("hidden")
+	trailer := []byte("vendor trailer")
+	buf := out.Bytes()
+	id := root.GetImageData()[0]
+	pixels := uint32(len(id.Segments[0]))
+	start := uint32(len(buf)) - pixels
+	buf = append(buf[:start], append(append(hidden, buf[start:]...), trailer...)...)
+	offsets := root.FindFields([]Tag{id.OffsetTag})[0]
+	pos := order.Uint32(buf[4:])
+	for i := uint32(0); i < uint32(order.Uint16(buf[pos:])); i++ {
+		epos := pos + 2 + i*TableEntrySize
+		if Tag(order.Uint16(buf[epos:])) == offsets.Tag {
+			order.PutUint32(buf[epos+8:], start+uint32(len(hidden)))
+		}
+	}
+
+	ranges, err := Unreferenced(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, r := range ranges {
+		data := string(buf[r.Start:r.End])
+		if r.Trailer != (r.End == uint32(len(buf))) {
+			t.Errorf("Range %d-%d has wrong trailer flag", r.Start, r.End)
+		}
+		if r.End-r.Start > 1 {
+			found = append(found, data)
+		}
+	}
+	if len(found) != 2 || found[0] != string(hidden) || found[1] != string(trailer) {
+		t.Errorf("Unreferenced ranges are %q", found)
+	}
+
+	node, err := GetIFDTree(buf, order, pos, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rewritten bytes.Buffer
+	if err := node.WriteIFDTreePreserving(&rewritten, buf, ranges); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(rewritten.Bytes(), trailer) {
+		t.Error("Trailer wasn't preserved")
+	}
+	if !bytes.Contains(rewritten.Bytes(), hidden) {
+		t.Error("Hidden data wasn't preserved")
+	}
+	if _, err := GetIFDTree(rewritten.Bytes(), order, order.Uint32(rewritten.Bytes()[4:]), TIFFSpace); err != nil {
+		t.Error(err)
+	}
+	if err := node.WriteIFDTreePreserving(&rewritten, buf, []UnreferencedRange{{Start: 0, End: uint32(len(buf)) + 1}}); err == nil {
+		t.Error("Range outside the buffer didn't cause an error")
+	}
+}
@@ -0,0 +1,156 @@
+package tiff66
+
+import (
+	"fmt"
+)
+
+// Special codes and limits for TIFF LZW compression.
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMaxCode  = 4095
+	lzwMinWidth = 9
+	lzwMaxWidth = 12
+)
+
+// Decompress data with the LZW variant used in TIFF image data
+// (Compression 5), which packs codes starting with the most significant
+// bit and increases the code width one code early. The old-style LZW
+// written by some early software, with codes packed starting with the
+// least significant bit, isn't supported. Data that ends without an
+// EndOfInformation code is accepted, returning the bytes decoded so
+// far.
+func LZWDecode(data []byte) ([]byte, error) {
+	var prefix [lzwMaxCode + 1]uint16
+	var suffix [lzwMaxCode + 1]byte
+	var length [lzwMaxCode + 1]uint16
+	for i := 0; i < 256; i++ {
+		suffix[i] = byte(i)
+		length[i] = 1
+	}
+	out := make([]byte, 0, 2*len(data))
+	width := uint(lzwMinWidth)
+	next := uint16(lzwFirst)
+	prev := -1
+	var acc uint32
+	var bits uint
+	pos := 0
+	for {
+		for bits < width && pos < len(data) {
+			acc = acc<<8 | uint32(data[pos])
+			bits += 8
+			pos++
+		}
+		if bits < width {
+			return out, nil
+		}
+		code := uint16(acc>>(bits-width)) & (1<<width - 1)
+		bits -= width
+		switch {
+		case code == lzwClear:
+			width = lzwMinWidth
+			next = lzwFirst
+			prev = -1
+			continue
+		case code == lzwEOI:
+			return out, nil
+		case prev < 0:
+			if code >= 256 {
+				return out, fmt.Errorf("LZW: code %d follows Clear", code)
+			}
+			out = append(out, byte(code))
+			prev = int(code)
+			continue
+		case code > next || code == next && next > lzwMaxCode:
+			return out, fmt.Errorf("LZW: invalid code %d", code)
+		}
+		// Expand the string for the code, or for prev if the code
+		// is the one about to be added.
+		str := code
+		if code == next {
+			str = uint16(prev)
+		}
+		start := len(out)
+		n := int(length[str])
+		out = append(out, make([]byte, n)...)
+		for i := start + n - 1; i >= start; i-- {
+			out[i] = suffix[str]
+			str = prefix[str]
+		}
+		first := out[start]
+		if code == next {
+			out = append(out, first)
+		}
+		if next <= lzwMaxCode {
+			prefix[next] = uint16(prev)
+			suffix[next] = first
+			length[next] = length[prev] + 1
+			next++
+			if next+1 >= 1<<width && width < lzwMaxWidth {
+				width++
+			}
+		}
+		prev = int(code)
+	}
+}
+
+// Compress data with the LZW variant used in TIFF image data, as
+// decoded by LZWDecode.
+func LZWEncode(data []byte) []byte {
+	out := make([]byte, 0, len(data)/2+8)
+	var acc uint32
+	var bits uint
+	width := uint(lzwMinWidth)
+	emit := func(code int) {
+		acc = acc<<width | uint32(code)
+		bits += width
+		for bits >= 8 {
+			out = append(out, byte(acc>>(bits-8)))
+			bits -= 8
+		}
+	}
+	dict := make(map[int]int)
+	next := lzwFirst
+	// Add a dictionary entry, increasing the code width one code
+	// early, as the decoder does.
+	advance := func() {
+		next++
+		if next >= 1<<width && width < lzwMaxWidth {
+			width++
+		}
+	}
+	emit(lzwClear)
+	cur := -1
+	for _, c := range data {
+		if cur < 0 {
+			cur = int(c)
+			continue
+		}
+		key := cur<<8 | int(c)
+		if code, ok := dict[key]; ok {
+			cur = code
+			continue
+		}
+		emit(cur)
+		dict[key] = next
+		advance()
+		if next >= lzwMaxCode {
+			emit(lzwClear)
+			dict = make(map[int]int)
+			next = lzwFirst
+			width = lzwMinWidth
+		}
+		cur = int(c)
+	}
+	if cur >= 0 {
+		emit(cur)
+		// The decoder adds an entry after this code.
+		advance()
+	}
+	emit(lzwEOI)
+	if bits > 0 {
+		out = append(out, byte(acc<<(8-bits)))
+	}
+	return out
+}
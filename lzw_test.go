@@ -0,0 +1,74 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestLZW(t *testing.T) {
+	// Clear, 'A', 'B', 258, EndOfInformation in 9 bit codes.
+	abab := []byte{0x80, 0x10, 0x48, 0x50, 0x28, 0x08}
+	if enc := LZWEncode([]byte("ABAB")); !bytes.Equal(enc, abab) {
+		t.Errorf("LZWEncode returned % X", enc)
+	}
+	if dec, err := LZWDecode(abab); err != nil || string(dec) != "ABAB" {
+		t.Errorf("LZWDecode returned %q, %v", dec, err)
+	}
+	// Without the EndOfInformation code.
+	if dec, err := LZWDecode(abab[:4]); err != nil || string(dec) != "AB" {
+		t.Errorf("LZWDecode of truncated data returned %q, %v", dec, err)
+	}
+	// Clear, 'A', 300.
+	if _, err := LZWDecode([]byte{0x80, 0x10, 0x65, 0x80}); err == nil {
+		t.Error("Invalid code didn't cause an error")
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	rnd.Read(random)
+	runs := make([]byte, 100000)
+	for i := range runs {
+		runs[i] = byte(i / 1000)
+	}
+	// Few symbols, producing long strings.
+	small := make([]byte, 50000)
+	for i := range small {
+		small[i] = byte(rnd.Intn(3))
+	}
+	for _, data := range [][]byte{nil, {7}, []byte("TOBEORNOTTOBEORTOBEORNOT"), random, runs, small} {
+		enc := LZWEncode(data)
+		dec, err := LZWDecode(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dec, data) {
+			t.Errorf("LZW round trip failed for %d bytes", len(data))
+		}
+	}
+}
+
+func TestDecodeLZWImage(t *testing.T) {
+	le := binary.LittleEndian
+	pixels := make([]byte, 64*64)
+	for i := range pixels {
+		pixels[i] = byte(i % 61)
+	}
+	node := imageNode(le, []Field{
+		shortField(ImageWidth, le, 64),
+		shortField(ImageLength, le, 64),
+		shortField(BitsPerSample, le, 8),
+		shortField(Compression, le, CompressionLZW),
+		shortField(PhotometricInterpretation, le, PhotometricBlackIsZero),
+		shortField(RowsPerStrip, le, 32),
+	}, StripOffsets, StripByteCounts, []ImageSegment{LZWEncode(pixels[:32*64]), LZWEncode(pixels[32*64:])})
+	img, err := node.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, ok := img.(*image.Gray); !ok || !bytes.Equal(g.Pix, pixels) {
+		t.Error("Wrong LZW image")
+	}
+}
@@ -1,36 +1,85 @@
 package tiff66
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"strings"
+	"sync"
 )
 
-// Identify a maker note and return its TagSpace, or TagSpace(0) if not found.
-func identifyMakerNote(buf []byte, pos uint32, make, model string) TagSpace {
+// A maker note format registered with RegisterMakerNote.
+type makerNotePlugin struct {
+	matcher func(buf []byte, make, model string) bool
+	factory func() SpaceRec
+}
+
+var makerNotePlugins struct {
+	mu      sync.Mutex
+	plugins []makerNotePlugin
+}
+
+// Register a maker note format that isn't supported by this package.
+// When a maker note isn't recognized as one of the built-in formats,
+// matcher is called with the maker note data and the Make and Model
+// fields from the TIFF IFD, and if it returns true, factory is called
+// to create the SpaceRec used to decode it. The SpaceRec may be one of
+// the built-in types, as returned by NewSpaceRec, for formats that are
+// compatible with it. Matchers are tried in the order they were
+// registered.
+func RegisterMakerNote(matcher func(buf []byte, make, model string) bool, factory func() SpaceRec) {
+	makerNotePlugins.mu.Lock()
+	defer makerNotePlugins.mu.Unlock()
+	makerNotePlugins.plugins = append(makerNotePlugins.plugins, makerNotePlugin{matcher, factory})
+}
+
+// Return a SpaceRec for a maker note from the first registered format
+// that matches it, or nil if none do.
+func registeredMakerNote(buf []byte, make, model string) SpaceRec {
+	makerNotePlugins.mu.Lock()
+	plugins := makerNotePlugins.plugins
+	makerNotePlugins.mu.Unlock()
+	for _, plugin := range plugins {
+		if plugin.matcher(buf, make, model) {
+			return plugin.factory()
+		}
+	}
+	return nil
+}
+
+// Identify a maker note of a given size and return a new SpaceRec for
+// it, or nil if not found.
+func identifyMakerNote(src source, pos, size uint32, make, model string) SpaceRec {
 	var space TagSpace
 	lcMake := strings.ToLower(make)
 	switch {
-	case bytes.HasPrefix(buf[pos:], fujifilm1Label):
+	case src.hasPrefix(pos, fujifilm1Label):
 		space = Fujifilm1Space
-	case bytes.HasPrefix(buf[pos:], generaleLabel):
+	case src.hasPrefix(pos, generaleLabel):
 		space = Fujifilm1Space
-	case bytes.HasPrefix(buf[pos:], nikon1Label):
+	case src.hasPrefix(pos, nikon1Label):
 		space = Nikon1Space
-	case bytes.HasPrefix(buf[pos:], nikon2LabelPrefix):
+	case src.hasPrefix(pos, nikon2LabelPrefix):
 		space = Nikon2Space
-	case bytes.HasPrefix(buf[pos:], panasonic1Label):
+	case src.hasPrefix(pos, panasonic1Label):
 		space = Panasonic1Space
+	case src.hasPrefix(pos, leicaLabelPrefix):
+		space = identifyLeica(src, pos, lcMake)
+	case isPhaseOne(src, pos):
+		space = PhaseOneSpace
 	default:
 		for i := range olympus1Labels {
-			if bytes.HasPrefix(buf[pos:], olympus1Labels[i].prefix) {
+			if src.hasPrefix(pos, olympus1Labels[i].prefix) {
 				space = Olympus1Space
 			}
 		}
+		for i := range pentax1Labels {
+			if src.hasPrefix(pos, pentax1Labels[i].prefix) {
+				space = Pentax1Space
+			}
+		}
 		if space == TagSpace(0) {
 			for i := range sony1Labels {
-				if bytes.HasPrefix(buf[pos:], sony1Labels[i]) {
+				if src.hasPrefix(pos, sony1Labels[i]) {
 					space = Sony1Space
 				}
 			}
@@ -44,25 +93,127 @@ func identifyMakerNote(buf []byte, pos uint32, make, model string) TagSpace {
 				space = Nikon2Space
 			case strings.HasPrefix(lcMake, "canon"):
 				space = Canon1Space
+			case strings.HasPrefix(lcMake, "leica camera ag") && !src.hasPrefix(pos, leicaLabelPrefix):
+				// Leica R8 and R9 digital backs.
+				space = Leica1Space
+			case lcMake == "hasselblad":
+				// Native Hasselblad cameras. Those based on
+				// Sony cameras have a "VHAB" label and Sony1
+				// maker notes.
+				space = Hasselblad1Space
 			}
 		}
 	}
-	return space
+	if space != TagSpace(0) {
+		return NewSpaceRec(space)
+	}
+	buf, err := src.data(pos, size)
+	if err != nil {
+		return nil
+	}
+	return registeredMakerNote(buf, make, model)
 }
 
-// Given a buffer pointing to a an IFD entry count, guess the byte
-// order of the IFD. The number of entries is usually small,
-// usually less than 256.
-func detectByteOrder(buf []byte) binary.ByteOrder {
+// Given the position of an IFD entry count, guess the byte order of
+// the IFD. The number of entries is usually small, usually less than
+// 256.
+func detectByteOrder(src source, pos uint32) (binary.ByteOrder, error) {
+	buf, err := src.data(pos, 2)
+	if err != nil {
+		return nil, errors.New("Can't detect byte order of maker note: past end of input")
+	}
 	big := binary.BigEndian.Uint16(buf)
 	little := binary.LittleEndian.Uint16(buf)
 	if little < big {
-		return binary.LittleEndian
+		return binary.LittleEndian, nil
 	} else {
-		return binary.BigEndian
+		return binary.BigEndian, nil
 	}
 }
 
+// Mappings from Canon1 tags to strings, as named by Exiftool.
+var Canon1TagNames = map[Tag]string{
+	0x0001: "CanonCameraSettings",
+	0x0002: "CanonFocalLength",
+	0x0003: "CanonFlashInfo",
+	0x0004: "CanonShotInfo",
+	0x0005: "CanonPanorama",
+	0x0006: "CanonImageType",
+	0x0007: "CanonFirmwareVersion",
+	0x0008: "FileNumber",
+	0x0009: "OwnerName",
+	0x000A: "UnknownD30",
+	0x000C: "SerialNumber",
+	0x000D: "CanonCameraInfo",
+	0x000E: "CanonFileLength",
+	0x000F: "CustomFunctions",
+	0x0010: "CanonModelID",
+	0x0011: "MovieInfo",
+	0x0012: "CanonAFInfo",
+	0x0013: "ThumbnailImageValidArea",
+	0x0015: "SerialNumberFormat",
+	0x001A: "SuperMacro",
+	0x001C: "DateStampMode",
+	0x001D: "MyColors",
+	0x001E: "FirmwareRevision",
+	0x0023: "Categories",
+	0x0024: "FaceDetect1",
+	0x0025: "FaceDetect2",
+	0x0026: "CanonAFInfo2",
+	0x0027: "ContrastInfo",
+	0x0028: "ImageUniqueID",
+	0x002F: "FaceDetect3",
+	0x0035: "TimeInfo",
+	0x0038: "BatteryType",
+	0x003C: "AFInfo3",
+	0x0081: "RawDataOffset",
+	0x0083: "OriginalDecisionDataOffset",
+	0x0090: "CustomFunctions1D",
+	0x0091: "PersonalFunctions",
+	0x0092: "PersonalFunctionValues",
+	0x0093: "CanonFileInfo",
+	0x0094: "AFPointsInFocus1D",
+	0x0095: "LensModel",
+	0x0096: "SerialInfo",
+	0x0097: "DustRemovalData",
+	0x0098: "CropInfo",
+	0x0099: "CustomFunctions2",
+	0x009A: "AspectInfo",
+	0x00A0: "ProcessingInfo",
+	0x00A1: "ToneCurveTable",
+	0x00A2: "SharpnessTable",
+	0x00A3: "SharpnessFreqTable",
+	0x00A4: "WhiteBalanceTable",
+	0x00A9: "ColorBalance",
+	0x00AA: "MeasuredColor",
+	0x00AE: "ColorTemperature",
+	0x00B0: "CanonFlags",
+	0x00B1: "ModifiedInfo",
+	0x00B2: "ToneCurveMatching",
+	0x00B3: "WhiteBalanceMatching",
+	0x00B4: "ColorSpace",
+	0x00B6: "PreviewImageInfo",
+	0x00D0: "VRDOffset",
+	0x00E0: "SensorInfo",
+	0x4001: "ColorData",
+	0x4002: "CRWParam",
+	0x4003: "ColorInfo",
+	0x4005: "Flavor",
+	0x4008: "PictureStyleUserDef",
+	0x4009: "PictureStylePC",
+	0x4010: "CustomPictureStyleFileName",
+	0x4013: "AFMicroAdj",
+	0x4015: "VignettingCorr",
+	0x4016: "VignettingCorr2",
+	0x4018: "LightingOpt",
+	0x4019: "LensInfo",
+	0x4020: "AmbienceInfo",
+	0x4021: "MultiExp",
+	0x4024: "FilterInfo",
+	0x4025: "HDRInfo",
+	0x4028: "AFConfig",
+}
+
 // SpaceRec for Canon1 maker notes.
 type Canon1SpaceRec struct {
 }
@@ -75,20 +226,20 @@ func (*Canon1SpaceRec) IsMakerNote() bool {
 	return true
 }
 
-func (*Canon1SpaceRec) nodeSize(node IFDNode) uint32 {
+func (*Canon1SpaceRec) nodeSize(node IFDNode) uint64 {
 	return node.genericSize()
 }
 
-func (*Canon1SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (*Canon1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	return nil, nil
 }
 
-func (*Canon1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+func (*Canon1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 }
 
-func (*Canon1SpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.unexpectedFooter(buf, pos, ifdPositions)
+func (*Canon1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
 }
 
 func (*Canon1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -99,6 +250,70 @@ func (*Canon1SpaceRec) GetImageData() []ImageData {
 	return nil
 }
 
+// Mappings from Fujifilm1 tags to strings, as named by Exiftool.
+var Fujifilm1TagNames = map[Tag]string{
+	0x0000: "Version",
+	0x0010: "InternalSerialNumber",
+	0x1000: "Quality",
+	0x1001: "Sharpness",
+	0x1002: "WhiteBalance",
+	0x1003: "Saturation",
+	0x1004: "Contrast",
+	0x1005: "ColorTemperature",
+	0x1006: "Contrast2",
+	0x100A: "WhiteBalanceFineTune",
+	0x100B: "NoiseReduction",
+	0x100E: "HighISONoiseReduction",
+	0x1010: "FujiFlashMode",
+	0x1011: "FlashExposureComp",
+	0x1020: "Macro",
+	0x1021: "FocusMode",
+	0x1022: "AFMode",
+	0x1023: "FocusPixel",
+	0x1030: "SlowSync",
+	0x1031: "PictureMode",
+	0x1032: "ExposureCount",
+	0x1033: "EXRAuto",
+	0x1034: "EXRMode",
+	0x1040: "ShadowTone",
+	0x1041: "HighlightTone",
+	0x1044: "DigitalZoom",
+	0x1050: "ShutterType",
+	0x1100: "AutoBracketing",
+	0x1101: "SequenceNumber",
+	0x1103: "DriveSettings",
+	0x1153: "PanoramaAngle",
+	0x1154: "PanoramaDirection",
+	0x1201: "AdvancedFilter",
+	0x1210: "ColorMode",
+	0x1300: "BlurWarning",
+	0x1301: "FocusWarning",
+	0x1302: "ExposureWarning",
+	0x1304: "GEImageSize",
+	0x1400: "DynamicRange",
+	0x1401: "FilmMode",
+	0x1402: "DynamicRangeSetting",
+	0x1403: "DevelopmentDynamicRange",
+	0x1404: "MinFocalLength",
+	0x1405: "MaxFocalLength",
+	0x1406: "MaxApertureAtMinFocal",
+	0x1407: "MaxApertureAtMaxFocal",
+	0x140B: "AutoDynamicRange",
+	0x1422: "ImageStabilization",
+	0x1425: "SceneRecognition",
+	0x1431: "Rating",
+	0x1436: "ImageGeneration",
+	0x1438: "ImageCount",
+	0x1443: "DRangePriority",
+	0x1444: "DRangePriorityAuto",
+	0x1445: "DRangePriorityFixed",
+	0x4100: "FacesDetected",
+	0x8000: "FileSource",
+	0x8002: "OrderNumber",
+	0x8003: "FrameNumber",
+	0xB211: "Parallax",
+}
+
 // SpaceRec for Fujifilm1 maker notes.
 type Fujifilm1SpaceRec struct {
 	label []byte
@@ -115,21 +330,21 @@ func (*Fujifilm1SpaceRec) IsMakerNote() bool {
 var fujifilm1Label = []byte("FUJIFILM")
 var generaleLabel = []byte("GENERALE") // GE E1255W
 
-func (rec *Fujifilm1SpaceRec) nodeSize(node IFDNode) uint32 {
+func (rec *Fujifilm1SpaceRec) nodeSize(node IFDNode) uint64 {
 	// Label, IFD position, and IFD.
-	return uint32(len(rec.label)) + 4 + node.genericSize()
+	return uint64(len(rec.label)) + 4 + node.genericSize()
 }
 
-func (*Fujifilm1SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (*Fujifilm1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	return nil, nil
 }
 
-func (rec *Fujifilm1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *Fujifilm1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	// Offsets are relative to start of the makernote.
-	tiff := buf[pos:]
-	if bytes.HasPrefix(tiff, fujifilm1Label) {
+	tiff := src.sub(pos)
+	if tiff.hasPrefix(0, fujifilm1Label) {
 		rec.label = append([]byte{}, fujifilm1Label...)
-	} else if bytes.HasPrefix(tiff, generaleLabel) {
+	} else if tiff.hasPrefix(0, generaleLabel) {
 		rec.label = append([]byte{}, generaleLabel...)
 	} else {
 		// Shouldn't reach this point if we already know it's a Fujifilm1SpaceRec.
@@ -140,12 +355,16 @@ func (rec *Fujifilm1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32,
 	node.Order = binary.LittleEndian
 	// Only the 2nd half of the TIFF header is present, the position
 	// of the IFD.
-	pos = node.Order.Uint32(tiff[len(rec.label):])
+	posData, err := tiff.data(uint32(len(rec.label)), 4)
+	if err != nil {
+		return errors.New("IFD position not found in Fujifilm1 maker note")
+	}
+	pos = node.Order.Uint32(posData)
 	return node.genericGetIFDTreeIter(tiff, pos, ifdPositions)
 }
 
-func (*Fujifilm1SpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.unexpectedFooter(buf, pos, ifdPositions)
+func (*Fujifilm1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
 }
 
 func (rec *Fujifilm1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -165,6 +384,18 @@ func (*Fujifilm1SpaceRec) GetImageData() []ImageData {
 	return nil
 }
 
+// Mappings from Nikon1 tags to strings, as named by Exiftool.
+var Nikon1TagNames = map[Tag]string{
+	0x0003: "Quality",
+	0x0004: "ColorMode",
+	0x0005: "ImageAdjustment",
+	0x0006: "CCDSensitivity",
+	0x0007: "WhiteBalance",
+	0x0008: "Focus",
+	0x000A: "DigitalZoom",
+	0x000B: "Converter",
+}
+
 // SpaceRec for Nikon1 maker notes.
 type Nikon1SpaceRec struct {
 }
@@ -179,20 +410,20 @@ func (*Nikon1SpaceRec) IsMakerNote() bool {
 
 var nikon1Label = []byte("Nikon\000\001\000")
 
-func (*Nikon1SpaceRec) nodeSize(node IFDNode) uint32 {
-	return uint32(len(nikon1Label)) + node.genericSize()
+func (*Nikon1SpaceRec) nodeSize(node IFDNode) uint64 {
+	return uint64(len(nikon1Label)) + node.genericSize()
 }
 
-func (*Nikon1SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (*Nikon1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	return nil, nil
 }
 
-func (*Nikon1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos+uint32(len(nikon1Label)), ifdPositions)
+func (*Nikon1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos+uint32(len(nikon1Label)), ifdPositions)
 }
 
-func (*Nikon1SpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.unexpectedFooter(buf, pos, ifdPositions)
+func (*Nikon1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
 }
 
 func (*Nikon1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -209,6 +440,109 @@ func (*Nikon1SpaceRec) GetImageData() []ImageData {
 const nikon2PreviewIFD = 0x11
 const nikon2NikonScanIFD = 0xE10
 
+// Mappings from Nikon2 tags to strings, as named by Exiftool.
+var Nikon2TagNames = map[Tag]string{
+	0x0001:             "MakerNoteVersion",
+	0x0002:             "ISO",
+	0x0003:             "ColorMode",
+	0x0004:             "Quality",
+	0x0005:             "WhiteBalance",
+	0x0006:             "Sharpness",
+	0x0007:             "FocusMode",
+	0x0008:             "FlashSetting",
+	0x0009:             "FlashType",
+	0x000B:             "WhiteBalanceFineTune",
+	0x000C:             "WB_RBLevels",
+	0x000D:             "ProgramShift",
+	0x000E:             "ExposureDifference",
+	0x000F:             "ISOSelection",
+	0x0010:             "DataDump",
+	nikon2PreviewIFD:   "PreviewIFD",
+	0x0012:             "FlashExposureComp",
+	0x0013:             "ISOSetting",
+	0x0014:             "ColorBalanceA",
+	0x0016:             "ImageBoundary",
+	0x0017:             "ExternalFlashExposureComp",
+	0x0018:             "FlashExposureBracketValue",
+	0x0019:             "ExposureBracketValue",
+	0x001A:             "ImageProcessing",
+	0x001B:             "CropHiSpeed",
+	0x001C:             "ExposureTuning",
+	0x001D:             "SerialNumber",
+	0x001E:             "ColorSpace",
+	0x001F:             "VRInfo",
+	0x0020:             "ImageAuthentication",
+	0x0021:             "FaceDetect",
+	0x0022:             "ActiveD-Lighting",
+	0x0023:             "PictureControlData",
+	0x0024:             "WorldTime",
+	0x0025:             "ISOInfo",
+	0x002A:             "VignetteControl",
+	0x002B:             "DistortInfo",
+	0x0035:             "HDRInfo",
+	0x0037:             "MechanicalShutterCount",
+	0x0039:             "LocationInfo",
+	0x003D:             "BlackLevel",
+	0x004F:             "ColorTemperatureAuto",
+	0x0080:             "ImageAdjustment",
+	0x0081:             "ToneComp",
+	0x0082:             "AuxiliaryLens",
+	0x0083:             "LensType",
+	0x0084:             "Lens",
+	0x0085:             "ManualFocusDistance",
+	0x0086:             "DigitalZoom",
+	0x0087:             "FlashMode",
+	0x0088:             "AFInfo",
+	0x0089:             "ShootingMode",
+	0x008B:             "LensFStops",
+	0x008C:             "ContrastCurve",
+	0x008D:             "ColorHue",
+	0x008F:             "SceneMode",
+	0x0090:             "LightSource",
+	0x0091:             "ShotInfo",
+	0x0092:             "HueAdjustment",
+	0x0093:             "NEFCompression",
+	0x0094:             "SaturationAdj",
+	0x0095:             "NoiseReduction",
+	0x0096:             "NEFLinearizationTable",
+	0x0097:             "ColorBalance",
+	0x0098:             "LensData",
+	0x0099:             "RawImageCenter",
+	0x009A:             "SensorPixelSize",
+	0x009C:             "SceneAssist",
+	0x009D:             "DateStampMode",
+	0x009E:             "RetouchHistory",
+	0x00A0:             "SerialNumber2",
+	0x00A2:             "ImageDataSize",
+	0x00A5:             "ImageCount",
+	0x00A6:             "DeletedImageCount",
+	0x00A7:             "ShutterCount",
+	0x00A8:             "FlashInfo",
+	0x00A9:             "ImageOptimization",
+	0x00AA:             "Saturation",
+	0x00AB:             "VariProgram",
+	0x00AC:             "ImageStabilization",
+	0x00AD:             "AFResponse",
+	0x00B0:             "MultiExposure",
+	0x00B1:             "HighISONoiseReduction",
+	0x00B3:             "ToningEffect",
+	0x00B6:             "PowerUpTime",
+	0x00B7:             "AFInfo2",
+	0x00B8:             "FileInfo",
+	0x00B9:             "AFTune",
+	0x00BB:             "RetouchInfo",
+	0x00C3:             "BarometerInfo",
+	0x0E00:             "PrintIM",
+	0x0E01:             "NikonCaptureData",
+	0x0E09:             "NikonCaptureVersion",
+	0x0E0E:             "NikonCaptureOffsets",
+	nikon2NikonScanIFD: "NikonScanIFD",
+	0x0E13:             "NikonCaptureEditVersions",
+	0x0E1D:             "NikonICCProfile",
+	0x0E1E:             "NikonCaptureOutput",
+	0x0E22:             "NEFBitDepth",
+}
+
 // SpaceRec for Nikon2 maker notes.
 type Nikon2SpaceRec struct {
 	// The maker note header/label varies, but the tags are
@@ -230,16 +564,16 @@ func (*Nikon2SpaceRec) IsMakerNote() bool {
 
 var nikon2LabelPrefix = []byte("Nikon\000")
 
-func (rec *Nikon2SpaceRec) nodeSize(node IFDNode) uint32 {
+func (rec *Nikon2SpaceRec) nodeSize(node IFDNode) uint64 {
 	labelLen := len(rec.label)
 	if labelLen == 0 {
 		// maker note without label or TIFF header.
 		return node.genericSize()
 	}
-	return uint32(labelLen) + HeaderSize + node.genericSize()
+	return uint64(labelLen) + HeaderSize + node.genericSize()
 }
 
-func (*Nikon2SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (*Nikon2SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	// SubIFDs.
 	if field.Type == IFD || field.Tag == nikon2PreviewIFD || field.Tag == nikon2NikonScanIFD {
 		subspace := Nikon2Space
@@ -248,21 +582,29 @@ func (*Nikon2SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPosition
 		} else if field.Tag == nikon2NikonScanIFD {
 			subspace = Nikon2ScanSpace
 		}
-		return recurseSubIFDs(buf, order, ifdPositions, field, NewSpaceRec(subspace))
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(subspace))
 	}
 	return nil, nil
 }
 
-func (rec *Nikon2SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *Nikon2SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	// A few early cameras like Coolpix 775 and 990 use the Nikon
 	// 2 tags, but encode the maker note without a label or TIFF
 	// header.  If the label is present, the maker note contains a
 	// new TIFF block and uses relative offsets within the block.
-	if bytes.HasPrefix(buf[pos:], nikon2LabelPrefix) {
+	if src.hasPrefix(pos, nikon2LabelPrefix) {
 		lablen := uint32(len(nikon2LabelPrefix) + 4)
-		rec.label = append([]byte{}, buf[pos:pos+lablen]...)
-		tiff := buf[pos+lablen:]
-		valid, order, pos := GetHeader(tiff)
+		label, err := src.data(pos, lablen)
+		if err != nil {
+			return errors.New("Label truncated in Nikon2 maker note")
+		}
+		rec.label = append([]byte{}, label...)
+		tiff := src.sub(pos + lablen)
+		header, err := tiff.data(0, HeaderSize)
+		if err != nil {
+			return errors.New("TIFF header not found in Nikon2 maker note")
+		}
+		valid, order, pos := GetHeader(header)
 		if !valid {
 			return errors.New("TIFF header not found in Nikon2 maker note")
 		}
@@ -270,13 +612,17 @@ func (rec *Nikon2SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifd
 		return node.genericGetIFDTreeIter(tiff, pos, ifdPositions)
 	} else {
 		// Byte order may differ from Exif block.
-		node.Order = detectByteOrder(buf[pos:])
-		return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+		order, err := detectByteOrder(src, pos)
+		if err != nil {
+			return err
+		}
+		node.Order = order
+		return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 	}
 }
 
-func (*Nikon2SpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.unexpectedFooter(buf, pos, ifdPositions)
+func (*Nikon2SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
 }
 
 func (rec *Nikon2SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -302,6 +648,34 @@ func (rec *Nikon2SpaceRec) GetImageData() []ImageData {
 const nikon2PreviewImageStart = 0x201
 const nikon2PreviewImageLength = 0x202
 
+// Mappings from Nikon2Preview tags to strings, as named by Exiftool.
+var Nikon2PreviewTagNames = map[Tag]string{
+	NewSubfileType:           "NewSubfileType",
+	Compression:              "Compression",
+	XResolution:              "XResolution",
+	YResolution:              "YResolution",
+	ResolutionUnit:           "ResolutionUnit",
+	nikon2PreviewImageStart:  "PreviewImageStart",
+	nikon2PreviewImageLength: "PreviewImageLength",
+	YCbCrPositioning:         "YCbCrPositioning",
+}
+
+// Mappings from Nikon2Scan tags to strings, as named by Exiftool.
+var Nikon2ScanTagNames = map[Tag]string{
+	0x0002: "FilmType",
+	0x0040: "MultiSample",
+	0x0041: "BitDepth",
+	0x0050: "MasterGain",
+	0x0051: "ColorGain",
+	0x0060: "ScanImageEnhancer",
+	0x0100: "DigitalICE",
+	0x0110: "ROCInfo",
+	0x0120: "GEMInfo",
+	0x0200: "DigitalDEEShadowAdj",
+	0x0201: "DigitalDEEThreshold",
+	0x0202: "DigitalDEEHighlightAdj",
+}
+
 // SpaceRec for Nikon2 Preview IFDs.
 type Nikon2PreviewSpaceRec struct {
 	offsetField Field
@@ -317,13 +691,13 @@ func (*Nikon2PreviewSpaceRec) IsMakerNote() bool {
 	return false
 }
 
-func (*Nikon2PreviewSpaceRec) nodeSize(node IFDNode) uint32 {
+func (*Nikon2PreviewSpaceRec) nodeSize(node IFDNode) uint64 {
 	return node.genericSize()
 }
 
 // Store preview image in the space rec.
-func (rec *Nikon2PreviewSpaceRec) appendImageData(buf []byte, order binary.ByteOrder, offsetField, sizeField Field) error {
-	imageData, err := newImageData(buf, order, offsetField, sizeField)
+func (rec *Nikon2PreviewSpaceRec) appendImageData(src source, order binary.ByteOrder, offsetField, sizeField Field) error {
+	imageData, err := newImageData(src, order, offsetField, sizeField)
 	if err != nil {
 		return err
 	}
@@ -331,10 +705,10 @@ func (rec *Nikon2PreviewSpaceRec) appendImageData(buf []byte, order binary.ByteO
 	return nil
 }
 
-func (rec *Nikon2PreviewSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (rec *Nikon2PreviewSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	// IFD fields aren't usually present in this IFD.
 	if field.Type == IFD {
-		return recurseSubIFDs(buf, order, ifdPositions, field, NewSpaceRec(Nikon2PreviewSpace))
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(Nikon2PreviewSpace))
 	}
 	if field.Tag == nikon2PreviewImageStart {
 		rec.offsetField = field
@@ -342,19 +716,19 @@ func (rec *Nikon2PreviewSpaceRec) takeField(buf []byte, order binary.ByteOrder,
 		rec.lengthField = field
 	}
 	if rec.offsetField.Tag != 0 && rec.lengthField.Tag != 0 {
-		rec.appendImageData(buf, order, rec.offsetField, rec.lengthField)
-		rec.offsetField.Tag = 0
-		rec.lengthField.Tag = 0
+		rec.appendImageData(src, order, rec.offsetField, rec.lengthField)
+		rec.offsetField = Field{}
+		rec.lengthField = Field{}
 	}
 	return nil, nil
 }
 
-func (*Nikon2PreviewSpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+func (*Nikon2PreviewSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 }
 
-func (*Nikon2PreviewSpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.unexpectedFooter(buf, pos, ifdPositions)
+func (*Nikon2PreviewSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
 }
 
 func (*Nikon2PreviewSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -392,62 +766,408 @@ var olympus1Labels = []struct {
 	{[]byte("MINOL\000"), 8, false},     // Minolta DiMAGE E323.
 }
 
+// Mappings from Olympus1 tags to strings, as named by Exiftool.
+var Olympus1TagNames = map[Tag]string{
+	0x0000:                     "MakerNoteVersion",
+	0x0001:                     "MinoltaCameraSettingsOld",
+	0x0003:                     "MinoltaCameraSettings",
+	0x0040:                     "CompressedImageSize",
+	0x0081:                     "PreviewImageData",
+	0x0088:                     "PreviewImageStart",
+	0x0089:                     "PreviewImageLength",
+	0x0100:                     "ThumbnailImage",
+	0x0104:                     "BodyFirmwareVersion",
+	0x0200:                     "SpecialMode",
+	0x0201:                     "Quality",
+	0x0202:                     "Macro",
+	0x0203:                     "BWMode",
+	0x0204:                     "DigitalZoom",
+	0x0205:                     "FocalPlaneDiagonal",
+	0x0206:                     "LensDistortionParams",
+	0x0207:                     "CameraType",
+	0x0208:                     "TextInfo",
+	0x0209:                     "CameraID",
+	0x020B:                     "EpsonImageWidth",
+	0x020C:                     "EpsonImageHeight",
+	0x020D:                     "EpsonSoftware",
+	0x0280:                     "PreviewImage",
+	0x0300:                     "PreCaptureFrames",
+	0x0301:                     "WhiteBoard",
+	0x0302:                     "OneTouchWB",
+	0x0303:                     "WhiteBalanceBracket",
+	0x0304:                     "WhiteBalanceBias",
+	0x0403:                     "SceneMode",
+	0x0404:                     "SerialNumber",
+	0x0405:                     "Firmware",
+	0x0E00:                     "PrintIM",
+	0x0F00:                     "DataDump",
+	0x0F01:                     "DataDump2",
+	0x0F04:                     "ZoomedPreviewStart",
+	0x0F05:                     "ZoomedPreviewLength",
+	0x0F06:                     "ZoomedPreviewSize",
+	0x1000:                     "ShutterSpeedValue",
+	0x1001:                     "ISOValue",
+	0x1002:                     "ApertureValue",
+	0x1003:                     "BrightnessValue",
+	0x1004:                     "FlashMode",
+	0x1005:                     "FlashDevice",
+	0x1006:                     "ExposureCompensation",
+	0x1007:                     "SensorTemperature",
+	0x1008:                     "LensTemperature",
+	0x1009:                     "LightCondition",
+	0x100A:                     "FocusRange",
+	0x100B:                     "FocusMode",
+	0x100C:                     "ManualFocusDistance",
+	0x100D:                     "ZoomStepCount",
+	0x100E:                     "FocusStepCount",
+	0x100F:                     "Sharpness",
+	0x1010:                     "FlashChargeLevel",
+	0x1011:                     "ColorMatrix",
+	0x1012:                     "BlackLevel",
+	0x1013:                     "ColorTemperatureBG",
+	0x1014:                     "ColorTemperatureRG",
+	0x1015:                     "WBMode",
+	0x1017:                     "RedBalance",
+	0x1018:                     "BlueBalance",
+	0x1019:                     "ColorMatrixNumber",
+	0x101A:                     "SerialNumber2",
+	0x1023:                     "FlashExposureComp",
+	0x1024:                     "InternalFlashTable",
+	0x1025:                     "ExternalFlashGValue",
+	0x1026:                     "ExternalFlashBounce",
+	0x1027:                     "ExternalFlashZoom",
+	0x1028:                     "ExternalFlashMode",
+	0x1029:                     "Contrast",
+	0x102A:                     "SharpnessFactor",
+	0x102B:                     "ColorControl",
+	0x102C:                     "ValidBits",
+	0x102D:                     "CoringFilter",
+	0x102E:                     "OlympusImageWidth",
+	0x102F:                     "OlympusImageHeight",
+	0x1030:                     "SceneDetect",
+	0x1031:                     "SceneArea",
+	0x1033:                     "SceneDetectData",
+	0x1034:                     "CompressionRatio",
+	0x1035:                     "PreviewImageValid",
+	0x1036:                     "PreviewImageStart2",
+	0x1037:                     "PreviewImageLength2",
+	0x1038:                     "AFResult",
+	0x1039:                     "CCDScanMode",
+	0x103A:                     "NoiseReduction",
+	0x103B:                     "FocusStepInfinity",
+	0x103C:                     "FocusStepNear",
+	0x103D:                     "LightValueCenter",
+	0x103E:                     "LightValuePeriphery",
+	0x103F:                     "FieldCount",
+	olympus1EquipmentIFD:       "Equipment",
+	olympus1CameraSettingsIFD:  "CameraSettings",
+	olympus1RawDevelopmentIFD:  "RawDevelopment",
+	olympus1RawDev2IFD:         "RawDev2",
+	olympus1ImageProcessingIFD: "ImageProcessing",
+	olympus1FocusInfo:          "FocusInfo",
+	0x3000:                     "RawInfo",
+	0x4000:                     "MainInfo",
+	0x5000:                     "UnknownInfo",
+}
+
+// Mappings from Olympus1Equipment tags to strings, as named by Exiftool.
+var Olympus1EquipmentTagNames = map[Tag]string{
+	0x0000: "EquipmentVersion",
+	0x0100: "CameraType2",
+	0x0101: "SerialNumber",
+	0x0102: "InternalSerialNumber",
+	0x0103: "FocalPlaneDiagonal",
+	0x0104: "BodyFirmwareVersion",
+	0x0201: "LensType",
+	0x0202: "LensSerialNumber",
+	0x0203: "LensModel",
+	0x0204: "LensFirmwareVersion",
+	0x0205: "MaxApertureAtMinFocal",
+	0x0206: "MaxApertureAtMaxFocal",
+	0x0207: "MinFocalLength",
+	0x0208: "MaxFocalLength",
+	0x020A: "MaxAperture",
+	0x020B: "LensProperties",
+	0x0301: "Extender",
+	0x0302: "ExtenderSerialNumber",
+	0x0303: "ExtenderModel",
+	0x0304: "ExtenderFirmwareVersion",
+	0x0403: "ConversionLens",
+	0x1000: "FlashType",
+	0x1001: "FlashModel",
+	0x1002: "FlashFirmwareVersion",
+	0x1003: "FlashSerialNumber",
+}
+
+// Mappings from Olympus1CameraSettings tags to strings, as named by Exiftool.
+var Olympus1CameraSettingsTagNames = map[Tag]string{
+	0x0000: "CameraSettingsVersion",
+	0x0100: "PreviewImageValid",
+	0x0101: "PreviewImageStart",
+	0x0102: "PreviewImageLength",
+	0x0200: "ExposureMode",
+	0x0201: "AELock",
+	0x0202: "MeteringMode",
+	0x0203: "ExposureShift",
+	0x0204: "NDFilter",
+	0x0300: "MacroMode",
+	0x0301: "FocusMode",
+	0x0302: "FocusProcess",
+	0x0303: "AFSearch",
+	0x0304: "AFAreas",
+	0x0305: "AFPointSelected",
+	0x0306: "AFFineTune",
+	0x0307: "AFFineTuneAdj",
+	0x0400: "FlashMode",
+	0x0401: "FlashExposureComp",
+	0x0403: "FlashRemoteControl",
+	0x0404: "FlashControlMode",
+	0x0405: "FlashIntensity",
+	0x0406: "ManualFlashStrength",
+	0x0500: "WhiteBalance2",
+	0x0501: "WhiteBalanceTemperature",
+	0x0502: "WhiteBalanceBracket",
+	0x0503: "CustomSaturation",
+	0x0504: "ModifiedSaturation",
+	0x0505: "ContrastSetting",
+	0x0506: "SharpnessSetting",
+	0x0507: "ColorSpace",
+	0x0509: "SceneMode",
+	0x050A: "NoiseReduction",
+	0x050B: "DistortionCorrection",
+	0x050C: "ShadingCompensation",
+	0x050D: "CompressionFactor",
+	0x050F: "Gradation",
+	0x0520: "PictureMode",
+	0x0521: "PictureModeSaturation",
+	0x0522: "PictureModeHue",
+	0x0523: "PictureModeContrast",
+	0x0524: "PictureModeSharpness",
+	0x0525: "PictureModeBWFilter",
+	0x0526: "PictureModeTone",
+	0x0527: "NoiseFilter",
+	0x0529: "ArtFilter",
+	0x052C: "MagicFilter",
+	0x052D: "PictureModeEffect",
+	0x052E: "ToneLevel",
+	0x052F: "ArtFilterEffect",
+	0x0532: "ColorCreatorEffect",
+	0x0537: "MonochromeProfileSettings",
+	0x0600: "DriveMode",
+	0x0601: "PanoramaMode",
+	0x0603: "ImageQuality2",
+	0x0604: "ImageStabilization",
+	0x0804: "StackedImage",
+	0x0900: "ManometerPressure",
+	0x0901: "ManometerReading",
+	0x0902: "ExtendedWBDetect",
+	0x0903: "RollAngle",
+	0x0904: "PitchAngle",
+	0x0908: "DateTimeUTC",
+}
+
+// Mappings from Olympus1RawDevelopment tags to strings, as named by Exiftool.
+var Olympus1RawDevelopmentTagNames = map[Tag]string{
+	0x0000: "RawDevVersion",
+	0x0100: "RawDevExposureBiasValue",
+	0x0101: "RawDevWhiteBalanceValue",
+	0x0102: "RawDevWBFineAdjustment",
+	0x0103: "RawDevGrayPoint",
+	0x0104: "RawDevSaturationEmphasis",
+	0x0105: "RawDevMemoryColorEmphasis",
+	0x0106: "RawDevContrastValue",
+	0x0107: "RawDevSharpnessValue",
+	0x0108: "RawDevColorSpace",
+	0x0109: "RawDevEngine",
+	0x010A: "RawDevNoiseReduction",
+	0x010B: "RawDevEditStatus",
+	0x010C: "RawDevSettings",
+}
+
+// Mappings from Olympus1RawDev2 tags to strings, as named by Exiftool.
+var Olympus1RawDev2TagNames = map[Tag]string{
+	0x0000: "RawDevVersion",
+	0x0100: "RawDevExposureBiasValue",
+	0x0101: "RawDevWhiteBalance",
+	0x0102: "RawDevWhiteBalanceValue",
+	0x0103: "RawDevWBFineAdjustment",
+	0x0104: "RawDevGrayPoint",
+	0x0105: "RawDevContrastValue",
+	0x0106: "RawDevSharpnessValue",
+	0x0107: "RawDevSaturationEmphasis",
+	0x0108: "RawDevMemoryColorEmphasis",
+	0x0109: "RawDevColorSpace",
+	0x010A: "RawDevNoiseReduction",
+	0x010B: "RawDevEngine",
+	0x010C: "RawDevPictureMode",
+	0x010D: "RawDevPMSaturation",
+	0x010E: "RawDevPMContrast",
+	0x010F: "RawDevPMSharpness",
+	0x0110: "RawDevPM_BWFilter",
+	0x0111: "RawDevPMPictureTone",
+	0x0112: "RawDevGradation",
+	0x0113: "RawDevSaturation3",
+	0x0119: "RawDevAutoGradation",
+	0x0120: "RawDevPMNoiseFilter",
+	0x0121: "RawDevArtFilter",
+}
+
+// Mappings from Olympus1ImageProcessing tags to strings, as named by Exiftool.
+var Olympus1ImageProcessingTagNames = map[Tag]string{
+	0x0000: "ImageProcessingVersion",
+	0x0100: "WB_RBLevels",
+	0x0200: "ColorMatrix",
+	0x0300: "Enhancer",
+	0x0301: "EnhancerValues",
+	0x0310: "CoringFilter",
+	0x0311: "CoringValues",
+	0x0600: "BlackLevel2",
+	0x0610: "GainBase",
+	0x0611: "ValidBits",
+	0x0612: "CropLeft",
+	0x0613: "CropTop",
+	0x0614: "CropWidth",
+	0x0615: "CropHeight",
+	0x0805: "SensorCalibration",
+	0x1010: "NoiseReduction2",
+	0x1011: "DistortionCorrection2",
+	0x1012: "ShadingCompensation2",
+	0x101C: "MultipleExposureMode",
+	0x1112: "AspectRatio",
+	0x1113: "AspectFrame",
+	0x1200: "FacesDetected",
+	0x1201: "FaceDetectArea",
+	0x1202: "MaxFaces",
+	0x1203: "FaceDetectFrameSize",
+	0x1207: "FaceDetectFrameCrop",
+	0x1306: "CameraTemperature",
+	0x1900: "KeystoneCompensation",
+	0x1901: "KeystoneDirection",
+	0x1906: "KeystoneValue",
+}
+
+// Mappings from Olympus1FocusInfo tags to strings, as named by Exiftool.
+var Olympus1FocusInfoTagNames = map[Tag]string{
+	0x0000: "FocusInfoVersion",
+	0x0209: "AutoFocus",
+	0x0210: "SceneDetect",
+	0x0211: "SceneArea",
+	0x0212: "SceneDetectData",
+	0x0300: "ZoomStepCount",
+	0x0301: "FocusStepCount",
+	0x0303: "FocusStepInfinity",
+	0x0304: "FocusStepNear",
+	0x0305: "FocusDistance",
+	0x0308: "AFPoint",
+	0x0328: "AFInfo",
+	0x1201: "ExternalFlash",
+	0x1203: "ExternalFlashGuideNumber",
+	0x1204: "ExternalFlashBounce",
+	0x1205: "ExternalFlashZoom",
+	0x1208: "InternalFlash",
+	0x1209: "ManualFlash",
+	0x120A: "MacroLED",
+	0x1500: "SensorTemperature",
+	0x1600: "ImageStabilization",
+}
+
 // SpaceRec for Olympus1 maker notes.
 type Olympus1SpaceRec struct {
-	label    []byte
-	relative bool // True if offsets relative to start of maker note, instead of entire Tiff block.
+	label     []byte
+	relative  bool          // True if offsets relative to start of maker note, instead of entire Tiff block.
+	focusInfo FocusInfoMode // How the FocusInfo field was decoded.
 }
 
 func (*Olympus1SpaceRec) GetSpace() TagSpace {
 	return Olympus1Space
 }
 
+// Return how the FocusInfo field was interpreted when the maker note
+// was decoded: FocusInfoIFD or FocusInfoArray, or FocusInfoGuess if
+// there was no such field. When a file written from the tree is read
+// again, passing this as ParseOptions.FocusInfo ensures that the field
+// is interpreted the same way.
+func (rec *Olympus1SpaceRec) FocusInfo() FocusInfoMode {
+	return rec.focusInfo
+}
+
 func (*Olympus1SpaceRec) IsMakerNote() bool {
 	return true
 }
 
-func (rec *Olympus1SpaceRec) nodeSize(node IFDNode) uint32 {
+func (rec *Olympus1SpaceRec) nodeSize(node IFDNode) uint64 {
 	labelLen := len(rec.label)
-	return uint32(labelLen) + node.genericSize()
+	return uint64(labelLen) + node.genericSize()
+}
+
+// Guess whether an UNDEFINED Olympus1 FocusInfo field holds an IFD,
+// checking the types of up to check entries. Some camera models make
+// this an IFD, but in others it's just an array of data.
+func olympus1FocusInfoIsIFD(src source, order binary.ByteOrder, field Field, dataPos uint32, check int) bool {
+	// The field size is often just the TableEntrySize times the number of entries
+	// in the table. I.e., it omits the table overhead and the external data.
+	if field.Size() < TableEntrySize {
+		// Too small to be an IFD.
+		return false
+	}
+	data := field.Data
+	if data == nil {
+		// Field data wasn't read by GetIFDTreeLazy.
+		var err error
+		if data, err = src.data(dataPos, field.Size()); err != nil {
+			return false
+		}
+	}
+	entries := order.Uint16(data)
+	if entries == 0 {
+		// IFD should have entries.
+		return false
+	}
+	if field.Size() < uint32(entries)*TableEntrySize {
+		// Field is too small to be an IFD with the specified number of fields.
+		return false
+	}
+	end := dataPos + TableSize(entries)
+	if end < dataPos || end > src.len() {
+		// IFD with specified number of fields would run past end of buffer.
+		return false
+	}
+	// Check the types of the first fields, allow for slightly damaged IFDs.
+	if check > int(entries) {
+		check = int(entries)
+	}
+	for i := 0; i < check; i++ {
+		typePos := 2 + uint32(i)*TableEntrySize + 2
+		if typePos+2 > uint32(len(data)) {
+			// Truncated data.
+			return false
+		}
+		typ := Type(order.Uint16(data[typePos:]))
+		if typ == 0 || typ > IFD {
+			// Not an offficial data type: probably not an IFD field.
+			return false
+		}
+	}
+	return true
 }
 
-func (*Olympus1SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (rec *Olympus1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	// SubIFDs.
 	if field.Type == IFD || field.Tag == olympus1EquipmentIFD || field.Tag == olympus1CameraSettingsIFD || field.Tag == olympus1RawDevelopmentIFD || field.Tag == olympus1RawDev2IFD || field.Tag == olympus1ImageProcessingIFD || field.Tag == olympus1FocusInfo {
 		if field.Tag == olympus1FocusInfo && field.Type == UNDEFINED {
-			// Some camera models make this is an IFD, but in others it's just an array of data. Make a guess.
-			// The field size is often just the TableEntrySize times the number of entries
-			// in the table. I.e., it omits the table overhead and the external data.
-			if field.Size() < TableEntrySize {
-				// Too small to be an IFD.
-				return nil, nil
-			}
-			data := field.Data
-			entries := order.Uint16(data)
-			if entries == 0 {
-				// IFD should have entries.
-				return nil, nil
-			}
-			if field.Size() < uint32(entries)*TableEntrySize {
-				// Field is too small to be an IFD with the specified number of fields.
-				return nil, nil
+			mode, check := src.focusInfoOptions()
+			if mode == FocusInfoGuess {
+				mode = FocusInfoArray
+				if olympus1FocusInfoIsIFD(src, order, field, dataPos, check) {
+					mode = FocusInfoIFD
+				}
 			}
-			end := dataPos + TableSize(entries)
-			if end < dataPos || end > uint32(len(buf)) {
-				// IFD with specified number of fields would run past end of buffer.
+			rec.focusInfo = mode
+			if mode == FocusInfoArray {
 				return nil, nil
 			}
-			check := uint16(3) // Check the types of the first fields, allow for slightly damaged IFDs.
-			if check > entries {
-				check = entries
-			}
-			for i := uint16(0); i < check; i++ {
-				typ := Type(order.Uint16(data[2+i*TableEntrySize+2:]))
-				if typ == 0 || typ > IFD {
-					// Not an offficial data type: probably not an IFD field.
-					return nil, nil
-				}
-			}
+		} else if field.Tag == olympus1FocusInfo {
+			rec.focusInfo = FocusInfoIFD
 		}
 		subspace := Olympus1Space
 		switch field.Tag {
@@ -472,29 +1192,36 @@ func (*Olympus1SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositi
 		// UNDEFINED, but contain IFDs that point to data
 		// outside the arrays.
 		if field.Type == IFD {
-			return recurseSubIFDs(buf, order, ifdPositions, field, NewSpaceRec(subspace))
+			return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(subspace))
 		}
-		sub.Node, err = getIFDTreeIter(buf, order, dataPos, NewSpaceRec(subspace), ifdPositions)
+		sub.Node, err = getIFDTreeIter(src, order, dataPos, NewSpaceRec(subspace), ifdPositions)
 		return []SubIFD{sub}, err
 	}
 	return nil, nil
 }
 
-func (rec *Olympus1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *Olympus1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	for i := range olympus1Labels {
-		if bytes.HasPrefix(buf[pos:], olympus1Labels[i].prefix) {
-			rec.label = append([]byte{}, buf[pos:pos+olympus1Labels[i].length]...)
+		if src.hasPrefix(pos, olympus1Labels[i].prefix) {
+			label, err := src.data(pos, olympus1Labels[i].length)
+			if err != nil {
+				return errors.New("Label truncated in Olympus1 maker note")
+			}
+			rec.label = append([]byte{}, label...)
 			// Byte order varies by camera model, and may differ from Exif order.
-			node.Order = detectByteOrder(buf[pos+olympus1Labels[i].length:])
+			node.Order, err = detectByteOrder(src, pos+olympus1Labels[i].length)
+			if err != nil {
+				return err
+			}
 			if olympus1Labels[i].relative {
 				// Offsets are relative to start of maker note.
-				tiff := buf[pos:]
+				tiff := src.sub(pos)
 				rec.relative = true
 				return node.genericGetIFDTreeIter(tiff, olympus1Labels[i].length, ifdPositions)
 			} else {
 				// Offsets are relative to start of buffer.
 				rec.relative = false
-				return node.genericGetIFDTreeIter(buf, pos+olympus1Labels[i].length, ifdPositions)
+				return node.genericGetIFDTreeIter(src, pos+olympus1Labels[i].length, ifdPositions)
 			}
 		}
 	}
@@ -502,8 +1229,8 @@ func (rec *Olympus1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, i
 	return errors.New("Invalid label for Olympus1 maker note")
 }
 
-func (*Olympus1SpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.unexpectedFooter(buf, pos, ifdPositions)
+func (*Olympus1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
 }
 
 func (rec *Olympus1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -527,8 +1254,112 @@ func (*Olympus1SpaceRec) GetImageData() []ImageData {
 	return nil
 }
 
-// SpaceRec for Panasonic1 maker notes.
+// Mappings from Panasonic1 tags to strings, as named by Exiftool.
+var Panasonic1TagNames = map[Tag]string{
+	0x0001: "ImageQuality",
+	0x0002: "FirmwareVersion",
+	0x0003: "WhiteBalance",
+	0x0007: "FocusMode",
+	0x000F: "AFAreaMode",
+	0x001A: "ImageStabilization",
+	0x001C: "MacroMode",
+	0x001F: "ShootingMode",
+	0x0020: "Audio",
+	0x0021: "DataDump",
+	0x0023: "WhiteBalanceBias",
+	0x0024: "FlashBias",
+	0x0025: "InternalSerialNumber",
+	0x0026: "PanasonicExifVersion",
+	0x0028: "ColorEffect",
+	0x0029: "TimeSincePowerOn",
+	0x002A: "BurstMode",
+	0x002B: "SequenceNumber",
+	0x002C: "ContrastMode",
+	0x002D: "NoiseReduction",
+	0x002E: "SelfTimer",
+	0x0030: "Rotation",
+	0x0031: "AFAssistLamp",
+	0x0032: "ColorMode",
+	0x0033: "BabyAge",
+	0x0034: "OpticalZoomMode",
+	0x0035: "ConversionLens",
+	0x0036: "TravelDay",
+	0x0039: "Contrast",
+	0x003A: "WorldTimeLocation",
+	0x003B: "TextStamp",
+	0x003C: "ProgramISO",
+	0x003D: "AdvancedSceneType",
+	0x003E: "TextStamp2",
+	0x003F: "FacesDetected",
+	0x0040: "Saturation",
+	0x0041: "Sharpness",
+	0x0042: "FilmMode",
+	0x0044: "ColorTempKelvin",
+	0x0045: "BracketSettings",
+	0x0046: "WBShiftAB",
+	0x0047: "WBShiftGM",
+	0x0048: "FlashCurtain",
+	0x0049: "LongExposureNoiseReduction",
+	0x004B: "PanasonicImageWidth",
+	0x004C: "PanasonicImageHeight",
+	0x004D: "AFPointPosition",
+	0x004E: "FaceDetInfo",
+	0x0051: "LensType",
+	0x0052: "LensSerialNumber",
+	0x0053: "AccessoryType",
+	0x0054: "AccessorySerialNumber",
+	0x0059: "Transform",
+	0x005D: "IntelligentExposure",
+	0x0060: "LensFirmwareVersion",
+	0x0061: "FaceRecInfo",
+	0x0062: "FlashWarning",
+	0x0063: "RecognizedFaceFlags",
+	0x0065: "Title",
+	0x0066: "BabyName",
+	0x0067: "Location",
+	0x0069: "Country",
+	0x006B: "State",
+	0x006D: "City",
+	0x006F: "Landmark",
+	0x0070: "IntelligentResolution",
+	0x0077: "BurstSpeed",
+	0x0079: "IntelligentD-Range",
+	0x007C: "ClearRetouch",
+	0x0080: "City2",
+	0x0086: "ManometerPressure",
+	0x0089: "PhotoStyle",
+	0x008A: "ShadingCompensation",
+	0x008C: "AccelerometerZ",
+	0x008D: "AccelerometerX",
+	0x008E: "AccelerometerY",
+	0x008F: "CameraOrientation",
+	0x0090: "RollAngle",
+	0x0091: "PitchAngle",
+	0x0093: "SweepPanoramaDirection",
+	0x0094: "SweepPanoramaFieldOfView",
+	0x0096: "TimerRecording",
+	0x009D: "InternalNDFilter",
+	0x009E: "HDR",
+	0x009F: "ShutterType",
+	0x00A3: "ClearRetouchValue",
+	0x00AB: "TouchAE",
+	0x0E00: "PrintIM",
+	0x2003: "TimeInfo",
+	0x8000: "MakerNoteVersion",
+	0x8001: "SceneMode",
+	0x8004: "WBRedLevel",
+	0x8005: "WBGreenLevel",
+	0x8006: "WBBlueLevel",
+	0x8007: "FlashFired",
+	0x8008: "TextStamp3",
+	0x8009: "TextStamp4",
+	0x8010: "BabyAge2",
+}
+
+// SpaceRec for Panasonic1 maker notes, which are also used by Leica
+// cameras made by Panasonic.
 type Panasonic1SpaceRec struct {
+	label []byte // Nil for the usual Panasonic label.
 }
 
 func (*Panasonic1SpaceRec) GetSpace() TagSpace {
@@ -541,27 +1372,61 @@ func (*Panasonic1SpaceRec) IsMakerNote() bool {
 
 var panasonic1Label = []byte("Panasonic\000\000\000")
 
-func (*Panasonic1SpaceRec) nodeSize(node IFDNode) uint32 {
-	return uint32(len(panasonic1Label)) + node.genericSize()
+// Labels of Leica maker notes with Panasonic tags, with their full
+// lengths.
+var panasonic1LeicaLabels = []struct {
+	prefix []byte
+	length uint32
+}{
+	{[]byte("LEICA\000\000\000"), 8},
+	{[]byte("LEICA CAMERA AG\000"), 18}, // Leica D-Lux 7.
+}
+
+// Return the maker note label.
+func (rec *Panasonic1SpaceRec) getLabel() []byte {
+	if rec.label == nil {
+		return panasonic1Label
+	}
+	return rec.label
+}
+
+func (rec *Panasonic1SpaceRec) nodeSize(node IFDNode) uint64 {
+	return uint64(len(rec.getLabel())) + node.genericSize()
 }
 
-func (*Panasonic1SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (*Panasonic1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	return nil, nil
 }
 
-func (*Panasonic1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *Panasonic1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	for _, leica := range panasonic1LeicaLabels {
+		if src.hasPrefix(pos, leica.prefix) {
+			label, err := src.data(pos, leica.length)
+			if err != nil {
+				return errors.New("Label truncated in Leica maker note")
+			}
+			rec.label = append([]byte{}, label...)
+			// Byte order may differ from Exif block.
+			node.Order, err = detectByteOrder(src, pos+leica.length)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
 	// Offsets are relative to start of buf.
-	return node.genericGetIFDTreeIter(buf, pos+uint32(len(panasonic1Label)), ifdPositions)
+	return node.genericGetIFDTreeIter(src, pos+uint32(len(rec.getLabel())), ifdPositions)
 }
 
-func (rec *Panasonic1SpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *Panasonic1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	// Next pointer is generally missing, don't try to read it.
 	return nil
 }
 
-func (*Panasonic1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
-	copy(buf[pos:], panasonic1Label)
-	pos += uint32(len(panasonic1Label))
+func (rec *Panasonic1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	label := rec.getLabel()
+	copy(buf[pos:], label)
+	pos += uint32(len(label))
 	return node.genericPutIFDTree(buf, pos)
 }
 
@@ -569,6 +1434,251 @@ func (*Panasonic1SpaceRec) GetImageData() []ImageData {
 	return nil
 }
 
+// Mappings from Leica1 tags to strings, as named by Exiftool for the
+// Leica M8, M9, X and later models.
+var Leica1TagNames = map[Tag]string{
+	0x0300: "Quality",
+	0x0302: "UserProfile",
+	0x0303: "SerialNumber",
+	0x0304: "WhiteBalance",
+	0x0310: "LensType",
+	0x0311: "ExternalSensorBrightnessValue",
+	0x0312: "MeasuredLV",
+	0x0313: "ApproximateFNumber",
+	0x0320: "CameraTemperature",
+	0x0321: "ColorTemperature",
+	0x0322: "WBRedLevel",
+	0x0323: "WBGreenLevel",
+	0x0324: "WBBlueLevel",
+	0x0325: "UV-IRFilterCorrection",
+	0x0330: "CCDVersion",
+	0x0331: "CCDBoardVersion",
+	0x0332: "ControllerBoardVersion",
+	0x0333: "M16CVersion",
+	0x0340: "ImageIDNumber",
+	0x3000: "Subdir3000",
+	0x3100: "Subdir3100",
+	0x3400: "Subdir3400",
+	0x3900: "Subdir3900",
+}
+
+var leicaLabelPrefix = []byte("LEICA")
+
+// Leica maker notes that don't use Panasonic tags. Most start with
+// "LEICA", a null and a version byte, and are decoded with offsets
+// relative to the start of the maker note. The R8 and R9 digital backs
+// have no label.
+var leica1Labels = []struct {
+	prefix   []byte // Identifying prefix of maker note label.
+	length   uint32 // Full length of maker note label.
+	relative bool   // True if offsets are relative to the start of the maker note, instead of the entire Tiff block.
+}{
+	{[]byte("LEICA\000\000\000"), 8, true}, // M8, if the make is Leica Camera AG.
+	{[]byte("LEICA0\003\000"), 8, true},    // M9.
+	{[]byte("LEICA\000\001\000"), 8, true}, // X1.
+	{[]byte("LEICA\000\004\000"), 8, true}, // X2, X Vario.
+	{[]byte("LEICA\000\005\000"), 8, true},
+	{[]byte("LEICA\000\006\000"), 8, true},  // T.
+	{[]byte("LEICA\000\007\000"), 8, true},  // X.
+	{[]byte("LEICA\000\010\000"), 8, false}, // Q.
+	{[]byte("LEICA\000\011\000"), 8, true},  // M10.
+	{[]byte("LEICA\000\020\000"), 8, true},  // M Typ 240.
+	{[]byte("LEICA\000\032\000"), 8, true},
+}
+
+// Identify the tag space of a maker note starting with "LEICA", or
+// return TagSpace(0) if the variant isn't recognized.
+func identifyLeica(src source, pos uint32, lcMake string) TagSpace {
+	for _, leica := range panasonic1LeicaLabels {
+		if src.hasPrefix(pos, leica.prefix) {
+			// The M8 uses the same label as older models made
+			// by Panasonic.
+			if leica.length == 8 && strings.HasPrefix(lcMake, "leica camera ag") {
+				return Leica1Space
+			}
+			return Panasonic1Space
+		}
+	}
+	for _, leica := range leica1Labels {
+		if src.hasPrefix(pos, leica.prefix) {
+			return Leica1Space
+		}
+	}
+	return TagSpace(0)
+}
+
+// SpaceRec for Leica1 maker notes.
+type Leica1SpaceRec struct {
+	label    []byte
+	relative bool // True if offsets relative to start of maker note, instead of entire Tiff block.
+}
+
+func (*Leica1SpaceRec) GetSpace() TagSpace {
+	return Leica1Space
+}
+
+func (*Leica1SpaceRec) IsMakerNote() bool {
+	return true
+}
+
+func (rec *Leica1SpaceRec) nodeSize(node IFDNode) uint64 {
+	return uint64(len(rec.label)) + node.genericSize()
+}
+
+func (*Leica1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	return nil, nil
+}
+
+func (rec *Leica1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	length := uint32(0)
+	rec.relative = false
+	for i := range leica1Labels {
+		if src.hasPrefix(pos, leica1Labels[i].prefix) {
+			length = leica1Labels[i].length
+			rec.relative = leica1Labels[i].relative
+			break
+		}
+	}
+	label, err := src.data(pos, length)
+	if err != nil {
+		return errors.New("Label truncated in Leica1 maker note")
+	}
+	rec.label = append([]byte{}, label...)
+	// Byte order may differ from Exif block.
+	node.Order, err = detectByteOrder(src, pos+length)
+	if err != nil {
+		return err
+	}
+	if rec.relative {
+		// Offsets are relative to start of maker note.
+		return node.genericGetIFDTreeIter(src.sub(pos), length, ifdPositions)
+	}
+	// Offsets are relative to start of buffer.
+	return node.genericGetIFDTreeIter(src, pos+length, ifdPositions)
+}
+
+func (*Leica1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	// Next pointer isn't reliably present, don't try to read it.
+	return nil
+}
+
+func (rec *Leica1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	copy(buf[pos:], rec.label)
+	labelLen := uint32(len(rec.label))
+	if rec.relative {
+		next, err := node.genericPutIFDTree(buf[pos:], labelLen)
+		if err != nil {
+			return 0, err
+		}
+		return pos + next, nil
+	}
+	return node.genericPutIFDTree(buf, pos+labelLen)
+}
+
+func (*Leica1SpaceRec) GetImageData() []ImageData {
+	return nil
+}
+
+// Mappings from Sony1 tags to strings, as named by Exiftool.
+var Sony1TagNames = map[Tag]string{
+	0x0010:            "CameraInfo",
+	0x0020:            "FocusInfo",
+	0x0102:            "Quality",
+	0x0104:            "FlashExposureComp",
+	0x0105:            "Teleconverter",
+	0x0112:            "WhiteBalanceFineTune",
+	0x0114:            "CameraSettings",
+	0x0115:            "WhiteBalance",
+	0x0116:            "ExtraInfo",
+	0x0E00:            "PrintIM",
+	0x1000:            "MultiBurstMode",
+	0x1001:            "MultiBurstImageWidth",
+	0x1002:            "MultiBurstImageHeight",
+	0x1003:            "Panorama",
+	sony1PreviewImage: "PreviewImage",
+	0x2002:            "Rating",
+	0x2004:            "Contrast",
+	0x2005:            "Saturation",
+	0x2006:            "Sharpness",
+	0x2007:            "Brightness",
+	0x2008:            "LongExposureNoiseReduction",
+	0x2009:            "HighISONoiseReduction",
+	0x200A:            "HDR",
+	0x200B:            "MultiFrameNoiseReduction",
+	0x200E:            "PictureEffect",
+	0x200F:            "SoftSkinEffect",
+	0x2010:            "Tag2010",
+	0x2011:            "VignettingCorrection",
+	0x2012:            "LateralChromaticAberration",
+	0x2013:            "DistortionCorrectionSetting",
+	0x2014:            "WBShiftAB_GM",
+	0x2016:            "AutoPortraitFramed",
+	0x2017:            "FlashAction",
+	0x201A:            "ElectronicFrontCurtainShutter",
+	0x201B:            "FocusMode",
+	0x201C:            "AFAreaModeSetting",
+	0x201D:            "FlexibleSpotPosition",
+	0x201E:            "AFPointSelected",
+	0x2020:            "AFPointsUsed",
+	0x2021:            "AFTracking",
+	0x2022:            "FocalPlaneAFPointsUsed",
+	0x2023:            "MultiFrameNREffect",
+	0x2026:            "WBShiftAB_GM_Precise",
+	0x2027:            "FocusLocation",
+	0x2028:            "VariableLowPassFilter",
+	0x2029:            "RAWFileType",
+	0x202B:            "PrioritySetInAWB",
+	0x202C:            "MeteringMode2",
+	0x202D:            "ExposureStandardAdjustment",
+	0x202E:            "Quality2",
+	0x202F:            "PixelShiftInfo",
+	0x2031:            "SerialNumber",
+	0x3000:            "ShotInfo",
+	0x900B:            "Tag900b",
+	0x9050:            "Tag9050",
+	0x9400:            "Tag9400",
+	0x9401:            "Tag9401",
+	0x9402:            "Tag9402",
+	0x9403:            "Tag9403",
+	0x9404:            "Tag9404",
+	0x9405:            "Tag9405",
+	0x9406:            "Tag9406",
+	0x940A:            "Tag940a",
+	0x940C:            "Tag940c",
+	0x940E:            "AFInfo",
+	0x9416:            "Tag9416",
+	0xB000:            "FileFormat",
+	0xB001:            "SonyModelID",
+	0xB020:            "CreativeStyle",
+	0xB021:            "ColorTemperature",
+	0xB022:            "ColorCompensationFilter",
+	0xB023:            "SceneMode",
+	0xB024:            "ZoneMatching",
+	0xB025:            "DynamicRangeOptimizer",
+	0xB026:            "ImageStabilization",
+	0xB027:            "LensType",
+	0xB028:            "MinoltaMakerNote",
+	0xB029:            "ColorMode",
+	0xB02A:            "LensSpec",
+	0xB02B:            "FullImageSize",
+	0xB02C:            "PreviewImageSize",
+	0xB040:            "Macro",
+	0xB041:            "ExposureMode",
+	0xB042:            "FocusMode2",
+	0xB043:            "AFAreaMode",
+	0xB044:            "AFIlluminator",
+	0xB047:            "JPEGQuality",
+	0xB048:            "FlashLevel",
+	0xB049:            "ReleaseMode",
+	0xB04A:            "SequenceNumber",
+	0xB04B:            "Anti-Blur",
+	0xB04E:            "FocusMode3",
+	0xB04F:            "DynamicRangeOptimizer2",
+	0xB050:            "HighISONoiseReduction2",
+	0xB052:            "IntelligentAuto",
+	0xB054:            "WhiteBalance2",
+}
+
 // SpaceRec for Sony1 maker notes.
 type Sony1SpaceRec struct {
 	label []byte
@@ -593,29 +1703,33 @@ var sony1Labels = [][]byte{
 // Fields in Sony1 IFD.
 const sony1PreviewImage = 0x2001
 
-func (rec *Sony1SpaceRec) nodeSize(node IFDNode) uint32 {
-	return uint32(len(rec.label)) + node.genericSize()
+func (rec *Sony1SpaceRec) nodeSize(node IFDNode) uint64 {
+	return uint64(len(rec.label)) + node.genericSize()
 }
 
-func (*Sony1SpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (*Sony1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	return nil, nil
 }
 
-func (rec *Sony1SpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *Sony1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	for _, label := range sony1Labels {
-		if bytes.HasPrefix(buf[pos:], label) {
+		if src.hasPrefix(pos, label) {
 			rec.label = append([]byte{}, label...)
 			ifdpos := pos + uint32(len(rec.label))
 			// Byte order varies by camera model, and may differ from Exif order.
-			node.Order = detectByteOrder(buf[ifdpos:])
-			return node.genericGetIFDTreeIter(buf, ifdpos, ifdPositions)
+			order, err := detectByteOrder(src, ifdpos)
+			if err != nil {
+				return err
+			}
+			node.Order = order
+			return node.genericGetIFDTreeIter(src, ifdpos, ifdPositions)
 		}
 	}
 	// Shouldn't reach this point if we already know it's a Sony1SpaceRec.
 	return errors.New("Invalid label for Sony1 maker note")
 }
 
-func (rec *Sony1SpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *Sony1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	// Next pointer is often invalid, don't try to read it.
 	return nil
 }
@@ -629,3 +1743,251 @@ func (rec *Sony1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint
 func (*Sony1SpaceRec) GetImageData() []ImageData {
 	return nil
 }
+
+// Fields in Pentax1 IFD.
+const pentax1PreviewImageLength = 0x3
+const pentax1PreviewImageStart = 0x4
+
+// Mappings from Hasselblad1 tags to strings. Exiftool doesn't decode
+// these maker notes, but identifies a few of their fields.
+var Hasselblad1TagNames = map[Tag]string{
+	0x0011: "SensorCode",
+	0x0012: "CameraModelID",
+	0x0015: "CameraModelName",
+	0x0016: "CoatingCode",
+}
+
+// SpaceRec for maker notes from native Hasselblad cameras and backs,
+// such as the H and X series. They have no label, and offsets are
+// relative to the start of the Tiff block.
+type Hasselblad1SpaceRec struct {
+}
+
+func (*Hasselblad1SpaceRec) GetSpace() TagSpace {
+	return Hasselblad1Space
+}
+
+func (*Hasselblad1SpaceRec) IsMakerNote() bool {
+	return true
+}
+
+func (*Hasselblad1SpaceRec) nodeSize(node IFDNode) uint64 {
+	return node.genericSize()
+}
+
+func (*Hasselblad1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	return nil, nil
+}
+
+func (*Hasselblad1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	// Byte order may differ from Exif block.
+	order, err := detectByteOrder(src, pos)
+	if err != nil {
+		return err
+	}
+	node.Order = order
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
+}
+
+func (*Hasselblad1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	// Next pointer isn't reliably present, don't try to read it.
+	return nil
+}
+
+func (*Hasselblad1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	return node.genericPutIFDTree(buf, pos)
+}
+
+func (*Hasselblad1SpaceRec) GetImageData() []ImageData {
+	return nil
+}
+
+// Mappings from Pentax1 tags to strings, as named by Exiftool.
+var Pentax1TagNames = map[Tag]string{
+	0x0000: "PentaxVersion",
+	0x0001: "PentaxModelType",
+	0x0002: "PreviewImageSize",
+	0x0003: "PreviewImageLength",
+	0x0004: "PreviewImageStart",
+	0x0005: "PentaxModelID",
+	0x0006: "Date",
+	0x0007: "Time",
+	0x0008: "Quality",
+	0x0009: "PentaxImageSize",
+	0x000B: "PictureMode",
+	0x000C: "FlashMode",
+	0x000D: "FocusMode",
+	0x000E: "AFPointSelected",
+	0x000F: "AFPointsInFocus",
+	0x0010: "FocusPosition",
+	0x0012: "ExposureTime",
+	0x0013: "FNumber",
+	0x0014: "ISO",
+	0x0015: "LightReading",
+	0x0016: "ExposureCompensation",
+	0x0017: "MeteringMode",
+	0x0018: "AutoBracketing",
+	0x0019: "WhiteBalance",
+	0x001A: "WhiteBalanceMode",
+	0x001B: "BlueBalance",
+	0x001C: "RedBalance",
+	0x001D: "FocalLength",
+	0x001E: "DigitalZoom",
+	0x001F: "Saturation",
+	0x0020: "Contrast",
+	0x0021: "Sharpness",
+	0x0022: "WorldTimeLocation",
+	0x0023: "HometownCity",
+	0x0024: "DestinationCity",
+	0x0025: "HometownDST",
+	0x0026: "DestinationDST",
+	0x0027: "DSPFirmwareVersion",
+	0x0028: "CPUFirmwareVersion",
+	0x0029: "FrameNumber",
+	0x002D: "EffectiveLV",
+	0x0032: "ImageEditing",
+	0x0033: "PictureMode2",
+	0x0034: "DriveMode",
+	0x0035: "SensorSize",
+	0x0037: "ColorSpace",
+	0x0038: "ImageAreaOffset",
+	0x0039: "RawImageSize",
+	0x003C: "AFPointsInFocus2",
+	0x003E: "PreviewImageBorders",
+	0x003F: "LensRec",
+	0x0040: "SensitivityAdjust",
+	0x0041: "ImageEditCount",
+	0x0047: "CameraTemperature",
+	0x0048: "AELock",
+	0x0049: "NoiseReduction",
+	0x004D: "FlashExposureComp",
+	0x004F: "ImageTone",
+	0x0050: "ColorTemperature",
+	0x005C: "ShakeReductionInfo",
+	0x005D: "ShutterCount",
+	0x0069: "DynamicRangeExpansion",
+	0x0071: "HighISONoiseReduction",
+	0x0072: "AFAdjustment",
+	0x0200: "BlackPoint",
+	0x0201: "WhitePoint",
+	0x0203: "ColorMatrixA",
+	0x0204: "ColorMatrixB",
+	0x0205: "CameraSettings",
+	0x0206: "AEInfo",
+	0x0207: "LensInfo",
+	0x0208: "FlashInfo",
+	0x0209: "AEMeteringSegments",
+	0x020A: "FlashMeteringSegments",
+	0x020B: "SlaveFlashMeteringSegments",
+	0x0215: "CameraInfo",
+	0x0216: "BatteryInfo",
+	0x021F: "AFInfo",
+	0x0222: "ColorInfo",
+	0x0229: "SerialNumber",
+	0x03FE: "DataDump",
+	0x0402: "ToneCurve",
+	0x0403: "ToneCurves",
+	0x0E00: "PrintIM",
+}
+
+// The Pentax1 maker note label varies. The older type starts with
+// "AOC\0" followed by a byte order mark, and is decoded with offsets
+// relative to the start of the Tiff block. The newer type, used in
+// PEF files from later models, starts with "PENTAX \0" and a byte
+// order mark and is decoded relative to the start of the maker note.
+var pentax1Labels = []struct {
+	prefix   []byte // Identifying prefix of maker note label.
+	length   uint32 // Full length of maker note label.
+	relative bool   // True if offsets are relative to the start of the maker note, instead of the entire Tiff block.
+}{
+	{[]byte("AOC\000"), 6, false},
+	{[]byte("PENTAX \000"), 10, true},
+}
+
+// SpaceRec for Pentax1 maker notes, which are found in JPEG and PEF
+// files from Pentax cameras.
+type Pentax1SpaceRec struct {
+	label       []byte
+	relative    bool // True if offsets relative to start of maker note, instead of entire Tiff block.
+	offsetField Field
+	lengthField Field
+	imageData   []ImageData // Preview image.
+}
+
+func (*Pentax1SpaceRec) GetSpace() TagSpace {
+	return Pentax1Space
+}
+
+func (*Pentax1SpaceRec) IsMakerNote() bool {
+	return true
+}
+
+func (rec *Pentax1SpaceRec) nodeSize(node IFDNode) uint64 {
+	return uint64(len(rec.label)) + node.genericSize()
+}
+
+func (rec *Pentax1SpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	if field.Tag == pentax1PreviewImageStart {
+		rec.offsetField = field
+	} else if field.Tag == pentax1PreviewImageLength {
+		rec.lengthField = field
+	}
+	if rec.offsetField.Tag != 0 && rec.lengthField.Tag != 0 {
+		imageData, err := newImageData(src, order, rec.offsetField, rec.lengthField)
+		rec.offsetField = Field{}
+		rec.lengthField = Field{}
+		if err != nil {
+			return nil, err
+		}
+		rec.imageData = append(rec.imageData, *imageData)
+	}
+	return nil, nil
+}
+
+func (rec *Pentax1SpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	for i := range pentax1Labels {
+		if src.hasPrefix(pos, pentax1Labels[i].prefix) {
+			label, err := src.data(pos, pentax1Labels[i].length)
+			if err != nil {
+				return errors.New("Label truncated in Pentax1 maker note")
+			}
+			rec.label = append([]byte{}, label...)
+			// The byte order mark in the label may be
+			// blank, so detect it from the IFD instead.
+			node.Order, err = detectByteOrder(src, pos+pentax1Labels[i].length)
+			if err != nil {
+				return err
+			}
+			rec.relative = pentax1Labels[i].relative
+			if rec.relative {
+				// Offsets are relative to start of maker note.
+				return node.genericGetIFDTreeIter(src.sub(pos), pentax1Labels[i].length, ifdPositions)
+			}
+			// Offsets are relative to start of buffer.
+			return node.genericGetIFDTreeIter(src, pos+pentax1Labels[i].length, ifdPositions)
+		}
+	}
+	// Shouldn't reach this point if we already know it's a Pentax1SpaceRec.
+	return errors.New("Invalid label for Pentax1 maker note")
+}
+
+func (*Pentax1SpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
+}
+
+func (rec *Pentax1SpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	copy(buf[pos:], rec.label)
+	labelLen := uint32(len(rec.label))
+	if rec.relative {
+		next, err := node.genericPutIFDTree(buf[pos:], labelLen)
+		if err != nil {
+			return 0, err
+		}
+		return pos + next, nil
+	}
+	return node.genericPutIFDTree(buf, pos+labelLen)
+}
+
+func (rec *Pentax1SpaceRec) GetImageData() []ImageData {
+	return rec.imageData
+}
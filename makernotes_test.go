@@ -0,0 +1,353 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Create Exif trees with each variant of Leica maker note, and check
+// that they're identified, decoded and survive repacking.
+func TestLeicaMakerNotes(t *testing.T) {
+	tests := []struct {
+		make     string
+		label    string
+		space    TagSpace
+		relative bool
+	}{
+		{"Leica Camera AG", "LEICA\000\000\000", Leica1Space, true},
+		{"Leica Camera AG", "LEICA0\003\000", Leica1Space, true},
+		{"Leica Camera AG", "LEICA\000\010\000", Leica1Space, false},
+		{"Leica Camera AG", "", Leica1Space, false},
+		{"LEICA", "LEICA\000\000\000", Panasonic1Space, false},
+		{"LEICA", "LEICA CAMERA AG\000II", Panasonic1Space, false},
+	}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for _, test := range tests {
+			maker := NewIFDNode(test.space)
+			maker.Order = order
+			switch rec := maker.SpaceRec.(type) {
+			case *Leica1SpaceRec:
+				rec.label = []byte(test.label)
+				rec.relative = test.relative
+			case *Panasonic1SpaceRec:
+				rec.label = []byte(test.label)
+			}
+			maker.SetShorts(0x0300, []uint16{2})
+			maker.SetASCII(0x0303, "Serial number 1234")
+			exif := NewIFDNode(ExifSpace)
+			exif.Order = order
+			exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+			exif.SubIFDs = []SubIFD{{makerNote, maker}}
+			root := NewIFDNode(TIFFSpace)
+			root.Order = order
+			root.SetASCII(Make, test.make)
+			if err := root.SetExifIFD(exif); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, HeaderSize+root.TreeSize())
+			PutHeader(buf, order, HeaderSize)
+			if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+				t.Fatal(err)
+			}
+
+			getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+			if err != nil {
+				t.Fatalf("Label %q: %v", test.label, err)
+			}
+			if len(getroot.SubIFDs) != 1 || len(getroot.SubIFDs[0].Node.SubIFDs) != 1 {
+				t.Fatalf("Maker note with label %q not found", test.label)
+			}
+			getmaker := getroot.SubIFDs[0].Node.SubIFDs[0].Node
+			if getmaker.GetSpace() != test.space {
+				t.Errorf("Maker note with label %q identified as %s", test.label, getmaker.GetSpace().Name())
+				continue
+			}
+			if s, _ := getmaker.GetASCII(0x0303); s != "Serial number 1234" {
+				t.Errorf("Label %q: field decoded as %q", test.label, s)
+			}
+			out := make([]byte, HeaderSize+getroot.TreeSize())
+			PutHeader(out, order, HeaderSize)
+			if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != string(buf) {
+				t.Errorf("Repacked maker note with label %q differs from original", test.label)
+			}
+		}
+	}
+}
+
+// Create an Exif tree with a native Hasselblad maker note in the
+// opposite byte order to the Tiff block, and check that it's identified,
+// decoded and survives repacking.
+func TestHasselbladMakerNote(t *testing.T) {
+	maker := NewIFDNode(Hasselblad1Space)
+	maker.Order = binary.LittleEndian
+	maker.SetASCII(0x0015, "Hasselblad X1D")
+	maker.SetLongs(0x0011, []uint32{7})
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = binary.BigEndian
+	exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+	exif.SubIFDs = []SubIFD{{makerNote, maker}}
+	root := NewIFDNode(TIFFSpace)
+	root.Order = binary.BigEndian
+	root.SetASCII(Make, "Hasselblad")
+	if err := root.SetExifIFD(exif); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, root.Order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	getroot, err := GetIFDTree(buf, root.Order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getroot.SubIFDs) != 1 || len(getroot.SubIFDs[0].Node.SubIFDs) != 1 {
+		t.Fatal("Maker note not found")
+	}
+	getmaker := getroot.SubIFDs[0].Node.SubIFDs[0].Node
+	if getmaker.GetSpace() != Hasselblad1Space {
+		t.Fatalf("Maker note identified as %s", getmaker.GetSpace().Name())
+	}
+	if getmaker.Order != binary.LittleEndian {
+		t.Error("Maker note byte order not detected")
+	}
+	if s, _ := getmaker.GetASCII(0x0015); s != "Hasselblad X1D" {
+		t.Errorf("Field decoded as %q", s)
+	}
+	out := make([]byte, HeaderSize+getroot.TreeSize())
+	PutHeader(out, root.Order, HeaderSize)
+	if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(buf) {
+		t.Error("Repacked maker note differs from original")
+	}
+
+	// With OpaqueMakerNotes, the maker note is kept as field data.
+	opaque, err := GetIFDTreeOptions(buf, root.Order, HeaderSize, TIFFSpace, &ParseOptions{OpaqueMakerNotes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opaqueExif := opaque.SubIFDs[0].Node
+	if len(opaqueExif.SubIFDs) != 0 {
+		t.Fatal("Opaque maker note was decoded")
+	}
+	fields := opaqueExif.FindFields([]Tag{makerNote})
+	if len(fields) != 1 || uint64(len(fields[0].Data)) != maker.TreeSize() {
+		t.Fatal("Opaque maker note data not found")
+	}
+	out = make([]byte, HeaderSize+opaque.TreeSize())
+	PutHeader(out, root.Order, HeaderSize)
+	if _, err := opaque.PutIFDTree(out, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(buf) {
+		t.Error("Repacked opaque maker note differs from original")
+	}
+}
+
+// Register a maker note format for an unknown make, which is compatible
+// with Canon1, and check that it's used for decoding.
+func TestRegisterMakerNote(t *testing.T) {
+	const testMake = "RegisterMakerNote test camera"
+	RegisterMakerNote(func(buf []byte, make, model string) bool {
+		return make == testMake && len(buf) > 0
+	}, func() SpaceRec {
+		return NewSpaceRec(Canon1Space)
+	})
+	order := binary.BigEndian
+	maker := NewIFDNode(Canon1Space)
+	maker.Order = order
+	maker.SetASCII(0x0006, "Image type")
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = order
+	exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+	exif.SubIFDs = []SubIFD{{makerNote, maker}}
+	for _, camera := range []string{testMake, "Some other camera"} {
+		root := NewIFDNode(TIFFSpace)
+		root.Order = order
+		root.SetASCII(Make, camera)
+		if err := root.SetExifIFD(exif); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, HeaderSize+root.TreeSize())
+		PutHeader(buf, order, HeaderSize)
+		if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := len(getroot.SubIFDs) == 1 && len(getroot.SubIFDs[0].Node.SubIFDs) == 1
+		if found != (camera == testMake) {
+			t.Errorf("Make %q: maker note found is %v", camera, found)
+		}
+		if found {
+			getmaker := getroot.SubIFDs[0].Node.SubIFDs[0].Node
+			if s, _ := getmaker.GetASCII(0x0006); s != "Image type" {
+				t.Errorf("Field decoded as %q", s)
+			}
+		}
+	}
+}
+
+// Create an Exif tree with an Olympus maker note whose FocusInfo field
+// is an UNDEFINED array containing an IFD, and check how it's decoded
+// with each FocusInfo mode.
+func TestOlympusFocusInfo(t *testing.T) {
+	order := binary.LittleEndian
+	// A FocusInfo IFD with two entries, the second with an unknown
+	// type, as might be found in a damaged file.
+	focus := make([]byte, TableSize(2))
+	order.PutUint16(focus, 2)
+	order.PutUint16(focus[2:], 0x0000)
+	order.PutUint16(focus[4:], uint16(UNDEFINED))
+	order.PutUint32(focus[6:], 4)
+	copy(focus[10:], "0100")
+	order.PutUint16(focus[2+TableEntrySize:], 0x0209)
+	order.PutUint16(focus[4+TableEntrySize:], 99)
+	order.PutUint32(focus[6+TableEntrySize:], 1)
+	maker := NewIFDNode(Olympus1Space)
+	maker.Order = order
+	maker.SpaceRec.(*Olympus1SpaceRec).label = []byte("OLYMP\000\001\000")
+	maker.SetASCII(0x0207, "Camera type")
+	maker.Fields = append(maker.Fields, Field{olympus1FocusInfo, UNDEFINED, uint32(len(focus)), focus})
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = order
+	exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+	exif.SubIFDs = []SubIFD{{makerNote, maker}}
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.SetASCII(Make, "OLYMPUS IMAGING CORP.")
+	if err := root.SetExifIFD(exif); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts     ParseOptions
+		expected FocusInfoMode
+	}{
+		{ParseOptions{}, FocusInfoArray},
+		{ParseOptions{FocusInfoCheckEntries: 1}, FocusInfoIFD},
+		{ParseOptions{FocusInfo: FocusInfoIFD}, FocusInfoIFD},
+		{ParseOptions{FocusInfo: FocusInfoArray, FocusInfoCheckEntries: 1}, FocusInfoArray},
+	}
+	for _, test := range tests {
+		getroot, _ := GetIFDTreeOptions(buf, order, HeaderSize, TIFFSpace, &test.opts)
+		getmaker := getroot.SubIFDs[0].Node.SubIFDs[0].Node
+		rec, ok := getmaker.SpaceRec.(*Olympus1SpaceRec)
+		if !ok {
+			t.Fatalf("Maker note identified as %s", getmaker.GetSpace().Name())
+		}
+		if rec.FocusInfo() != test.expected {
+			t.Errorf("Options %+v: FocusInfo decoded as %d, expected %d", test.opts, rec.FocusInfo(), test.expected)
+		}
+		if found := getmaker.nthSubIFD(olympus1FocusInfo, 0) >= 0; found != (test.expected == FocusInfoIFD) {
+			t.Errorf("Options %+v: FocusInfo sub-IFD found is %v", test.opts, found)
+		}
+		if test.expected == FocusInfoArray {
+			if f := getmaker.MustField(olympus1FocusInfo); !bytes.Equal(f.Data, focus) {
+				t.Errorf("Options %+v: FocusInfo data changed", test.opts)
+			}
+		}
+	}
+	if rec := maker.SpaceRec.(*Olympus1SpaceRec); rec.FocusInfo() != FocusInfoGuess {
+		t.Error("FocusInfo mode recorded for a maker note that wasn't decoded")
+	}
+}
+
+// Create an Exif tree with an Olympus maker note that uses offsets
+// relative to the start of the file, move the maker note as editing
+// software might without adjusting its offsets, and check that
+// FixMakerNoteBase corrects them.
+func TestFixMakerNoteBase(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		maker := NewIFDNode(Olympus1Space)
+		maker.Order = order
+		maker.SpaceRec.(*Olympus1SpaceRec).label = []byte("OLYMP\000\001\000")
+		maker.SetASCII(0x0207, "Camera type")
+		maker.SetShorts(0x0201, []uint16{1, 2, 3})
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = order
+		exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+		exif.SubIFDs = []SubIFD{{makerNote, maker}}
+		root := NewIFDNode(TIFFSpace)
+		root.Order = order
+		root.SetASCII(Make, "OLYMPUS IMAGING CORP.")
+		if err := root.SetExifIFD(exif); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, HeaderSize+root.TreeSize())
+		PutHeader(buf, order, HeaderSize)
+		if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		opts := ParseOptions{Mode: ParseSalvage, FixMakerNoteBase: true}
+		if _, err := GetIFDTreeOptions(buf, order, HeaderSize, TIFFSpace, &opts); err != nil {
+			t.Errorf("Valid maker note: %v", err)
+		}
+
+		// Move the maker note to the end of the file, clearing
+		// its original location.
+		getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exifPos := getroot.SubIFDs[0].Node.decodedPos
+		entry := exifPos + 2
+		if Tag(order.Uint16(buf[entry:])) != makerNote {
+			t.Fatal("MakerNote entry not found")
+		}
+		count := order.Uint32(buf[entry+4:])
+		oldPos := order.Uint32(buf[entry+8:])
+		moved := append([]byte{}, buf...)
+		moved = append(moved, make([]byte, 100)...)
+		newPos := uint32(len(moved))
+		moved = append(moved, buf[oldPos:oldPos+count]...)
+		copy(moved[oldPos:oldPos+count], make([]byte, count))
+		order.PutUint32(moved[entry+8:], newPos)
+
+		getroot, _ = GetIFDTree(moved, order, HeaderSize, TIFFSpace)
+		if s, _ := getroot.SubIFDs[0].Node.SubIFDs[0].Node.GetASCII(0x0207); s == "Camera type" {
+			t.Error("Moved maker note decoded without correction")
+		}
+		getroot, err = GetIFDTreeOptions(moved, order, HeaderSize, TIFFSpace, &opts)
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("correcting them by %d", newPos-oldPos)) {
+			t.Errorf("Correction not reported: %v", err)
+		}
+		getmaker := getroot.SubIFDs[0].Node.SubIFDs[0].Node
+		if s, _ := getmaker.GetASCII(0x0207); s != "Camera type" {
+			t.Errorf("Corrected maker note field decoded as %q", s)
+		}
+		if f := getmaker.MustField(0x0201); f.Short(2, order) != 3 {
+			t.Errorf("Corrected maker note field decoded as %v", f)
+		}
+
+		// The repacked file has valid offsets.
+		out := make([]byte, HeaderSize+getroot.TreeSize())
+		PutHeader(out, order, HeaderSize)
+		if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		outroot, err := GetIFDTree(out, order, HeaderSize, TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, _ := outroot.SubIFDs[0].Node.SubIFDs[0].Node.GetASCII(0x0207); s != "Camera type" {
+			t.Errorf("Repacked maker note field decoded as %q", s)
+		}
+	}
+}
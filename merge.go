@@ -0,0 +1,242 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Policy for Merge when a field is present in both trees with
+// different values.
+type MergePolicy uint8
+
+const (
+	MergeReplace MergePolicy = 0 // Replace the field in the destination.
+	MergeKeep    MergePolicy = 1 // Keep the field in the destination.
+	MergeFail    MergePolicy = 2 // Return an error.
+)
+
+// Return a copy of a field with its data converted from one byte
+// order to another. The data is shared if no conversion is needed.
+func convertFieldOrder(f Field, from, to binary.ByteOrder) (Field, error) {
+	if from == to || f.Type.Size() <= 1 || f.Count == 0 {
+		return f, nil
+	}
+	if f.Data == nil {
+		return f, fmt.Errorf("Can't convert byte order of field %d(0x%X), data not loaded", f.Tag, f.Tag)
+	}
+	conv := Field{f.Tag, f.Type, f.Count, make([]byte, f.Size())}
+	for i := uint32(0); i < f.Count; i++ {
+		val, _ := f.Value(i, from)
+		if err := conv.SetValue(val, i, to); err != nil {
+			return f, err
+		}
+	}
+	return conv, nil
+}
+
+// Return a copy of an IFD tree with its fields converted to the given
+// byte order. Maker notes that record their own byte order, and the
+// IFDs within them, keep it. Field data and SpaceRecs are shared with
+// the original where possible.
+func cloneTree(node *IFDNode, order binary.ByteOrder) (*IFDNode, error) {
+	if node.ownByteOrder() {
+		order = node.Order
+	}
+	clone := *node
+	clone.Order = order
+	if order != node.Order {
+		// The clone no longer matches the original IFD.
+		clone.decoded = false
+	}
+	clone.stream = nil
+	clone.sharing = nil
+	clone.Fields = make([]Field, len(node.Fields))
+	for i, f := range node.Fields {
+		var err error
+		if clone.Fields[i], err = convertFieldOrder(f, node.Order, order); err != nil {
+			return nil, err
+		}
+	}
+	clone.SubIFDs = make([]SubIFD, len(node.SubIFDs))
+	for i, sub := range node.SubIFDs {
+		subClone, err := cloneTree(sub.Node, order)
+		if err != nil {
+			return nil, err
+		}
+		clone.SubIFDs[i] = SubIFD{sub.Tag, subClone}
+	}
+	if node.Next != nil {
+		next, err := cloneTree(node.Next, order)
+		if err != nil {
+			return nil, err
+		}
+		clone.Next = next
+	}
+	return &clone, nil
+}
+
+// Return the index in node.SubIFDs of the nth sub-IFD with a given
+// tag, or -1 if there isn't one.
+func (node IFDNode) nthSubIFD(tag Tag, n int) int {
+	for i, sub := range node.SubIFDs {
+		if sub.Tag == tag {
+			if n == 0 {
+				return i
+			}
+			n--
+		}
+	}
+	return -1
+}
+
+// Ensure that node has a field with the given tag that can point to
+// all its sub-IFDs with that tag, creating or extending it if
+// required. field is the corresponding field in the source tree.
+func (node *IFDNode) addSubIFDPointer(field Field) {
+	count := uint32(0)
+	for _, sub := range node.SubIFDs {
+		if sub.Tag == field.Tag {
+			count++
+		}
+	}
+	fields := node.FindFields([]Tag{field.Tag})
+	if len(fields) == 0 {
+		if field.Type.Size() == 4 {
+			// Data will be filled in when written.
+			field = Field{field.Tag, field.Type, count, make([]byte, 4*count)}
+		}
+		node.AddField(field, true)
+		return
+	}
+	ptr := fields[0]
+	if ptr.Type.Size() == 4 && ptr.Count < count {
+		data := make([]byte, 4*count)
+		copy(data, ptr.Data)
+		ptr.Count = count
+		ptr.Data = data
+	}
+}
+
+// Helper for Merge.
+func mergeNode(dst, src *IFDNode, selected func(TagSpace) bool, policy MergePolicy) error {
+	space := src.GetSpace()
+	// Fields that link to sub-IFDs and image data are handled
+	// separately or not at all, since they contain positions.
+	structural := make(map[Tag]bool)
+	for _, sub := range src.SubIFDs {
+		structural[sub.Tag] = true
+	}
+	for _, id := range src.GetImageData() {
+		structural[id.OffsetTag] = true
+		structural[id.SizeTag] = true
+	}
+	if selected(space) {
+		var add []Field
+		for _, f := range src.Fields {
+			if structural[f.Tag] {
+				continue
+			}
+			f, err := convertFieldOrder(f, src.Order, dst.Order)
+			if err != nil {
+				return err
+			}
+			existing := dst.FindFields([]Tag{f.Tag})
+			if len(existing) == 0 {
+				add = append(add, f)
+				continue
+			}
+			old := existing[0]
+			if old.Type == f.Type && old.Count == f.Count && old.Data != nil && bytes.Equal(old.Data, f.Data) {
+				continue
+			}
+			switch policy {
+			case MergeReplace:
+				*old = f
+			case MergeKeep:
+			case MergeFail:
+				return fmt.Errorf("Merge: %s field %d(0x%X) has conflicting values", space.Name(), f.Tag, f.Tag)
+			}
+		}
+		dst.AddFields(add)
+	}
+	seen := make(map[Tag]int)
+	for _, sub := range src.SubIFDs {
+		n := seen[sub.Tag]
+		seen[sub.Tag]++
+		subSpace := sub.Node.GetSpace()
+		idx := dst.nthSubIFD(sub.Tag, n)
+		if idx >= 0 && dst.SubIFDs[idx].Node.GetSpace() == subSpace {
+			if err := mergeNode(dst.SubIFDs[idx].Node, sub.Node, selected, policy); err != nil {
+				return err
+			}
+			continue
+		}
+		if !selected(subSpace) {
+			continue
+		}
+		if idx >= 0 {
+			// The sub-IFDs have different spaces, e.g., maker
+			// notes from different manufacturers.
+			switch policy {
+			case MergeKeep:
+				continue
+			case MergeFail:
+				return fmt.Errorf("Merge: %s sub-IFD %d(0x%X) has conflicting spaces %s and %s", space.Name(), sub.Tag, sub.Tag, dst.SubIFDs[idx].Node.GetSpace().Name(), subSpace.Name())
+			}
+		}
+		clone, err := cloneTree(sub.Node, dst.Order)
+		if err != nil {
+			return err
+		}
+		if idx >= 0 {
+			dst.SubIFDs[idx].Node = clone
+			continue
+		}
+		dst.SubIFDs = append(dst.SubIFDs, SubIFD{sub.Tag, clone})
+		if fields := src.FindFields([]Tag{sub.Tag}); len(fields) > 0 {
+			dst.addSubIFDPointer(*fields[0])
+		}
+	}
+	if src.Next != nil {
+		if dst.Next != nil {
+			return mergeNode(dst.Next, src.Next, selected, policy)
+		}
+		if selected(src.Next.GetSpace()) {
+			next, err := cloneTree(src.Next, dst.Order)
+			if err != nil {
+				return err
+			}
+			dst.Next = next
+		}
+	}
+	return nil
+}
+
+// Overlay the fields from one IFD tree onto another, e.g., to copy the
+// Exif and GPS fields from one file to another. IFDs in the two trees
+// are matched by their position: the root nodes, sub-IFDs with the
+// same tag, and the Next chains. Only fields in IFDs with the given
+// tag spaces are merged, or all if spaces is nil, although IFDs in
+// other spaces are traversed to find them. IFDs in src with a selected
+// space that are missing from dst are copied in their entirety, and
+// the fields pointing to them are added to the parents. Fields are
+// converted to the byte order of dst, except in maker notes. Fields
+// that point to sub-IFDs or image data are never merged as ordinary
+// fields. policy determines what happens if a field is present in both
+// trees with different values. Field data and SpaceRecs may be shared
+// between the two trees after merging.
+func Merge(dst, src *IFDNode, spaces []TagSpace, policy MergePolicy) error {
+	selected := func(space TagSpace) bool {
+		if spaces == nil {
+			return true
+		}
+		for _, s := range spaces {
+			if s == space {
+				return true
+			}
+		}
+		return false
+	}
+	return mergeNode(dst, src, selected, policy)
+}
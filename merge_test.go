@@ -0,0 +1,110 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Create a pair of trees with different byte orders, for merging.
+func mergeTrees() (*IFDNode, *IFDNode) {
+	dstExif := NewIFDNode(ExifSpace)
+	dstExif.Order = binary.LittleEndian
+	dstExif.Fields = []Field{{ExposureTime, RATIONAL, 1, []byte{1, 0, 0, 0, 125, 0, 0, 0}}}
+	dst := NewIFDNode(TIFFSpace)
+	dst.Order = binary.LittleEndian
+	dst.Fields = []Field{
+		{Make, ASCII, 2, []byte("A\000")},
+		{ExifIFD, LONG, 1, []byte{0, 0, 0, 0}},
+	}
+	dst.SubIFDs = []SubIFD{{ExifIFD, dstExif}}
+
+	srcExif := NewIFDNode(ExifSpace)
+	srcExif.Order = binary.BigEndian
+	srcExif.Fields = []Field{
+		{ExposureTime, RATIONAL, 1, []byte{0, 0, 0, 1, 0, 0, 0, 60}},
+		{FNumber, RATIONAL, 1, []byte{0, 0, 0, 28, 0, 0, 0, 10}},
+	}
+	srcGPS := NewIFDNode(GPSSpace)
+	srcGPS.Order = binary.BigEndian
+	srcGPS.Fields = []Field{
+		{GPSVersionID, BYTE, 4, []byte{2, 3, 0, 0}},
+		{GPSAltitude, RATIONAL, 1, []byte{0, 0, 1, 0, 0, 0, 0, 1}},
+	}
+	src := NewIFDNode(TIFFSpace)
+	src.Order = binary.BigEndian
+	src.Fields = []Field{
+		{Make, ASCII, 2, []byte("B\000")},
+		{Software, ASCII, 2, []byte("S\000")},
+		{ExifIFD, LONG, 1, []byte{0, 0, 0, 0}},
+		{GPSIFD, LONG, 1, []byte{0, 0, 0, 0}},
+	}
+	src.SubIFDs = []SubIFD{{ExifIFD, srcExif}, {GPSIFD, srcGPS}}
+	return dst, src
+}
+
+// Return the first value of a field as a rational, or 0/0 if missing.
+func rationalField(node *IFDNode, tag Tag) (int64, int64) {
+	fields := node.FindFields([]Tag{tag})
+	if len(fields) == 0 {
+		return 0, 0
+	}
+	return fields[0].AnyRational(0, node.Order)
+}
+
+func TestMerge(t *testing.T) {
+	dst, src := mergeTrees()
+	if err := Merge(dst, src, []TagSpace{ExifSpace, GPSSpace}, MergeKeep); err != nil {
+		t.Fatal(err)
+	}
+	if makes := dst.FindFields([]Tag{Make}); makes[0].ASCII() != "A" {
+		t.Errorf("Make changed to %q", makes[0].ASCII())
+	}
+	if len(dst.FindFields([]Tag{Software})) != 0 {
+		t.Error("TIFF field merged when not selected")
+	}
+	exif := dst.SubIFDs[0].Node
+	if num, denom := rationalField(exif, ExposureTime); num != 1 || denom != 125 {
+		t.Errorf("ExposureTime replaced with MergeKeep: %d/%d", num, denom)
+	}
+	if num, denom := rationalField(exif, FNumber); num != 28 || denom != 10 {
+		t.Errorf("FNumber not merged with converted byte order: %d/%d", num, denom)
+	}
+	if len(dst.SubIFDs) != 2 || dst.SubIFDs[1].Tag != GPSIFD || len(dst.FindFields([]Tag{GPSIFD})) != 1 {
+		t.Fatal("GPS IFD not added")
+	}
+	gps := dst.SubIFDs[1].Node
+	if gps.Order != binary.LittleEndian {
+		t.Error("GPS IFD byte order not converted")
+	}
+	if num, denom := rationalField(gps, GPSAltitude); num != 256 || denom != 1 {
+		t.Errorf("GPSAltitude has wrong value %d/%d", num, denom)
+	}
+	// The merged tree should be serializable.
+	buf, err := putTIFFTree(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reread, err := getTIFFTree(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reread.SubIFDs) != 2 || reread.SubIFDs[1].Node.GetSpace() != GPSSpace {
+		t.Error("GPS IFD not found after writing merged tree")
+	}
+
+	dst, src = mergeTrees()
+	if err := Merge(dst, src, nil, MergeReplace); err != nil {
+		t.Fatal(err)
+	}
+	if makes := dst.FindFields([]Tag{Make}); makes[0].ASCII() != "B" {
+		t.Errorf("Make not replaced, is %q", makes[0].ASCII())
+	}
+	if num, denom := rationalField(dst.SubIFDs[0].Node, ExposureTime); num != 1 || denom != 60 {
+		t.Errorf("ExposureTime not replaced: %d/%d", num, denom)
+	}
+
+	dst, src = mergeTrees()
+	if err := Merge(dst, src, []TagSpace{ExifSpace}, MergeFail); err == nil {
+		t.Error("Conflicting ExposureTime didn't cause an error")
+	}
+}
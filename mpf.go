@@ -0,0 +1,293 @@
+package tiff66
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Tags that may be found in MPF Index IFDs, from CIPA DC-007.
+const (
+	MPFVersion     = 0xB000
+	NumberOfImages = 0xB001
+	MPImageList    = 0xB002 // MP Entry table.
+	ImageUIDList   = 0xB003
+	TotalFrames    = 0xB004
+)
+
+// Mapping from MPF Index tags to strings.
+var MPFIndexTagNames = map[Tag]string{
+	MPFVersion:     "MPFVersion",
+	NumberOfImages: "NumberOfImages",
+	MPImageList:    "MPImageList",
+	ImageUIDList:   "ImageUIDList",
+	TotalFrames:    "TotalFrames",
+}
+
+// Mapping from MPF Attribute tags to strings.
+var MPFAttributeTagNames = map[Tag]string{
+	MPFVersion: "MPFVersion",
+	0xB101:     "MPIndividualNum",
+	0xB201:     "PanOrientation",
+	0xB202:     "PanOverlapH",
+	0xB203:     "PanOverlapV",
+	0xB204:     "BaseViewpointNum",
+	0xB205:     "ConvergenceAngle",
+	0xB206:     "BaselineLength",
+	0xB207:     "VerticalDivergence",
+	0xB208:     "AxisDistanceX",
+	0xB209:     "AxisDistanceY",
+	0xB20A:     "AxisDistanceZ",
+	0xB20B:     "YawAngle",
+	0xB20C:     "PitchAngle",
+	0xB20D:     "RollAngle",
+}
+
+// The header at the start of an MPF APP2 segment, which is followed by
+// a TIFF block.
+var MPFHeader = []byte("MPF\000")
+
+// Size of each entry in the MPImageList field.
+const mpEntrySize = 16
+
+// An entry from the MP Entry table of an MPF Index IFD, describing one
+// of the images in the file.
+type MPEntry struct {
+	Attribute uint32 // Flags, image format and type code.
+	Size      uint32
+	// Position of the image, relative to the start of the TIFF
+	// header in the MPF segment. It's zero for the first image,
+	// which starts at the beginning of the file.
+	Offset     uint32
+	Dependent1 uint16 // Entry numbers of dependent images, starting from 1, or 0.
+	Dependent2 uint16
+}
+
+// Indicate if the entry is for the representative image.
+func (e MPEntry) Representative() bool {
+	return e.Attribute&0x20000000 != 0
+}
+
+// Return the type code of the entry, e.g., 0x030000 for the baseline
+// primary image or 0x020002 for a disparity (stereo) image.
+func (e MPEntry) TypeCode() uint32 {
+	return e.Attribute & 0xFFFFFF
+}
+
+// Return the entries from the MPImageList field of an MPF Index node.
+func (node IFDNode) MPEntries() ([]MPEntry, error) {
+	fields := node.FindFields([]Tag{MPImageList})
+	if len(fields) == 0 {
+		return nil, errors.New("MPEntries: MPImageList field not found")
+	}
+	field := fields[0]
+	size := field.Size()
+	if uint32(len(field.Data)) < size {
+		return nil, errors.New("MPEntries: MPImageList field data isn't loaded")
+	}
+	if size%mpEntrySize != 0 {
+		return nil, fmt.Errorf("MPEntries: MPImageList field size %d isn't a multiple of %d", size, mpEntrySize)
+	}
+	entries := make([]MPEntry, size/mpEntrySize)
+	for i := range entries {
+		data := field.Data[i*mpEntrySize:]
+		entries[i] = MPEntry{
+			Attribute:  node.Order.Uint32(data),
+			Size:       node.Order.Uint32(data[4:]),
+			Offset:     node.Order.Uint32(data[8:]),
+			Dependent1: node.Order.Uint16(data[12:]),
+			Dependent2: node.Order.Uint16(data[14:]),
+		}
+	}
+	return entries, nil
+}
+
+// Find the MPF APP2 segment in a JPEG file.
+func findJPEGMPF(buf []byte) (*jpegSegment, error) {
+	segments, err := jpegSegments(buf)
+	for i := range segments {
+		seg := segments[i]
+		if seg.marker == jpegAPP2 && bytes.HasPrefix(buf[seg.pos+4:seg.pos+seg.size], MPFHeader) {
+			return &seg, nil
+		}
+	}
+	return nil, err
+}
+
+// Return the TIFF block from the MPF APP2 segment in a JPEG file, and
+// its position in the file, which is the base for the MP Entry
+// offsets. The block is nil if there is none. The returned slice
+// points into buf.
+func GetJPEGMPF(buf []byte) ([]byte, uint32, error) {
+	seg, err := findJPEGMPF(buf)
+	if seg == nil {
+		return nil, 0, err
+	}
+	base := seg.pos + 4 + uint32(len(MPFHeader))
+	return buf[base : seg.pos+seg.size], base, nil
+}
+
+// Decode the MPF block in a JPEG file into an IFDNode tree. The root
+// is an MPF Index node, followed by an MPF Attribute node, for the
+// first image in an MPO file. Other images have only an MPF Attribute
+// node. Returns a nil node if the file doesn't contain an MPF block.
+func GetJPEGMPFTree(buf []byte) (*IFDNode, error) {
+	mpf, _, err := GetJPEGMPF(buf)
+	if mpf == nil {
+		return nil, err
+	}
+	valid, order, pos := GetHeader(mpf)
+	if !valid {
+		return nil, errors.New("GetJPEGMPFTree: TIFF header not valid")
+	}
+	node, err := GetIFDTree(mpf, order, pos, MPFIndexSpace)
+	if err != nil || len(node.FindFields([]Tag{MPImageList})) > 0 {
+		return node, err
+	}
+	return GetIFDTree(mpf, order, pos, MPFAttributeSpace)
+}
+
+// Return the entries from the MPF block in a JPEG file, such as the
+// first image of an MPO file, along with the images they refer to.
+// The image data has a segment for each entry, pointing into buf,
+// and each image is a complete JPEG file. Offsets in the image data
+// are positions in buf.
+func GetMPFImages(buf []byte) ([]MPEntry, ImageData, error) {
+	var images ImageData
+	node, err := GetJPEGMPFTree(buf)
+	if err != nil {
+		return nil, images, err
+	}
+	if node == nil || node.GetSpace() != MPFIndexSpace {
+		return nil, images, errors.New("GetMPFImages: MPF Index IFD not found")
+	}
+	entries, err := node.MPEntries()
+	if err != nil {
+		return nil, images, err
+	}
+	_, base, _ := GetJPEGMPF(buf)
+	images.OffsetTag = MPImageList
+	images.SizeTag = MPImageList
+	for i, e := range entries {
+		pos := uint64(0)
+		if e.Offset != 0 {
+			pos = uint64(base) + uint64(e.Offset)
+		}
+		if pos+uint64(e.Size) > uint64(len(buf)) {
+			return entries, images, fmt.Errorf("GetMPFImages: image %d extends past end of file", i+1)
+		}
+		images.Segments = append(images.Segments, ImageSegment(buf[pos:pos+uint64(e.Size)]))
+		images.Offsets = append(images.Offsets, uint32(pos))
+		images.Sizes = append(images.Sizes, e.Size)
+	}
+	return entries, images, nil
+}
+
+// Set the MPImageList and NumberOfImages fields of an MPF Index node
+// from entries.
+func (node *IFDNode) SetMPEntries(entries []MPEntry) {
+	data := make([]byte, len(entries)*mpEntrySize)
+	for i, e := range entries {
+		buf := data[i*mpEntrySize:]
+		node.Order.PutUint32(buf, e.Attribute)
+		node.Order.PutUint32(buf[4:], e.Size)
+		node.Order.PutUint32(buf[8:], e.Offset)
+		node.Order.PutUint16(buf[12:], e.Dependent1)
+		node.Order.PutUint16(buf[14:], e.Dependent2)
+	}
+	node.SetUndefined(MPImageList, data)
+	node.SetLongs(NumberOfImages, []uint32{uint32(len(entries))})
+}
+
+// Maximum size of a TIFF block in a JPEG APP2 segment, allowing for
+// the length and the MPF header.
+const MaxJPEGMPFSize = math.MaxUint16 - 2 - 4
+
+// Return a new JPEG file in which the MPF APP2 segment is replaced by
+// one containing the given TIFF block. If the file has no MPF
+// segment, one will be inserted after any APP0 and APP1 segments at
+// the start of the file, such as JFIF and Exif.
+func PutJPEGMPF(buf []byte, mpf []byte) ([]byte, error) {
+	if len(mpf) > MaxJPEGMPFSize {
+		return nil, fmt.Errorf("MPF block size %d exceeds maximum for a JPEG segment, %d", len(mpf), MaxJPEGMPFSize)
+	}
+	segments, err := jpegSegments(buf)
+	if err != nil {
+		return nil, err
+	}
+	start := uint32(2) // Position for new segment, after SOI.
+	end := start       // End of replaced segment.
+	for _, seg := range segments {
+		if seg.marker == jpegAPP2 && bytes.HasPrefix(buf[seg.pos+4:seg.pos+seg.size], MPFHeader) {
+			start = seg.pos
+			end = seg.pos + seg.size
+			break
+		}
+		if (seg.marker == jpegAPP0 || seg.marker == jpegAPP1) && seg.pos == start {
+			start = seg.pos + seg.size
+			end = start
+		}
+	}
+	length := 2 + len(MPFHeader) + len(mpf)
+	out := make([]byte, 0, len(buf)-int(end-start)+2+length)
+	out = append(out, buf[:start]...)
+	out = append(out, 0xFF, jpegAPP2, byte(length>>8), byte(length))
+	out = append(out, MPFHeader...)
+	out = append(out, mpf...)
+	out = append(out, buf[end:]...)
+	return out, nil
+}
+
+// Return a new MPO file made from a sequence of JPEG images and an MPF
+// Index node tree, which is embedded in the first image. The node
+// needs an entry for each image, whose sizes and offsets are
+// recalculated, so that images can be edited or replaced before
+// repacking; the other entry details are kept.
+func PutMPO(node *IFDNode, images []ImageSegment) ([]byte, error) {
+	if node.GetSpace() != MPFIndexSpace {
+		return nil, errors.New("PutMPO: root node isn't in MPFIndex space")
+	}
+	entries, err := node.MPEntries()
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 || len(entries) != len(images) {
+		return nil, fmt.Errorf("PutMPO: %d MP entries for %d images", len(entries), len(images))
+	}
+	// The size of the MPF block doesn't depend on the entry values,
+	// so the first image can be built to find the positions, then
+	// rebuilt with the corrected entries.
+	var first []byte
+	for pass := 0; pass < 2; pass++ {
+		node.SetMPEntries(entries)
+		mpf, err := putTIFFTree(node)
+		if err != nil {
+			return nil, err
+		}
+		if first, err = PutJPEGMPF(images[0], mpf); err != nil {
+			return nil, err
+		}
+		seg, err := findJPEGMPF(first)
+		if err != nil {
+			return nil, err
+		}
+		base := seg.pos + 4 + uint32(len(MPFHeader))
+		pos := uint64(len(first))
+		entries[0].Size = uint32(len(first))
+		entries[0].Offset = 0
+		for i := 1; i < len(images); i++ {
+			if pos+uint64(len(images[i])) > math.MaxUint32 {
+				return nil, errors.New("PutMPO: images exceed 4 GB")
+			}
+			entries[i].Size = uint32(len(images[i]))
+			entries[i].Offset = uint32(pos) - base
+			pos += uint64(len(images[i]))
+		}
+	}
+	out := first
+	for _, image := range images[1:] {
+		out = append(out, image...)
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Return a minimal JPEG file with an MPF segment holding a TIFF block.
+func mpfJPEG(block []byte) []byte {
+	length := 2 + len(MPFHeader) + len(block)
+	jpeg := []byte{0xFF, jpegSOI, 0xFF, jpegAPP2, byte(length >> 8), byte(length)}
+	jpeg = append(jpeg, MPFHeader...)
+	jpeg = append(jpeg, block...)
+	return append(jpeg, 0xFF, jpegEOI)
+}
+
+// Build an MPO file from two images and extract them.
+func TestMPFImages(t *testing.T) {
+	second := []byte{0xFF, jpegSOI, 0xFF, jpegAPP0, 0, 7, 'J', 'F', 'I', 'F', 0, 0xFF, jpegEOI}
+	order := binary.BigEndian
+	node := NewIFDNode(MPFIndexSpace)
+	node.Order = order
+	node.SetUndefined(MPFVersion, []byte("0100"))
+	node.SetLongs(NumberOfImages, []uint32{2})
+	entries := make([]byte, 2*mpEntrySize)
+	node.SetUndefined(MPImageList, entries)
+	block, err := putTIFFTree(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := mpfJPEG(block)
+	base := uint32(6 + len(MPFHeader))
+	order.PutUint32(entries, 0x20030000)
+	order.PutUint32(entries[4:], uint32(len(first)))
+	order.PutUint32(entries[16:], 0x020002)
+	order.PutUint32(entries[20:], uint32(len(second)))
+	order.PutUint32(entries[24:], uint32(len(first))-base)
+	node.SetUndefined(MPImageList, entries)
+	if block, err = putTIFFTree(node); err != nil {
+		t.Fatal(err)
+	}
+	first = mpfJPEG(block)
+	mpo := append(append([]byte{}, first...), second...)
+
+	tree, err := GetJPEGMPFTree(mpo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.GetSpace() != MPFIndexSpace {
+		t.Errorf("MPF tree has space %s", tree.GetSpace().Name())
+	}
+	got, images, err := GetMPFImages(mpo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].Representative() || got[1].TypeCode() != 0x020002 {
+		t.Errorf("MP entries are %+v", got)
+	}
+	if len(images.Segments) != 2 || !bytes.Equal(images.Segments[0], first) || !bytes.Equal(images.Segments[1], second) {
+		t.Fatal("MPF images not extracted")
+	}
+	if images.Offsets[1] != uint32(len(first)) {
+		t.Errorf("Second image at %d", images.Offsets[1])
+	}
+
+	// A file that isn't the first image has only an attribute IFD.
+	attr := NewIFDNode(MPFAttributeSpace)
+	attr.Order = order
+	attr.SetUndefined(MPFVersion, []byte("0100"))
+	attr.SetLongs(0xB101, []uint32{2})
+	if block, err = putTIFFTree(attr); err != nil {
+		t.Fatal(err)
+	}
+	if tree, err = GetJPEGMPFTree(mpfJPEG(block)); err != nil || tree.GetSpace() != MPFAttributeSpace {
+		t.Errorf("Attribute-only MPF tree decoded as %v, %v", tree, err)
+	}
+	if _, _, err := GetMPFImages(mpfJPEG(block)); err == nil {
+		t.Error("MPF without index IFD didn't cause an error")
+	}
+}
+
+// Replace an image in an MPO file and repack it.
+func TestPutMPO(t *testing.T) {
+	order := binary.LittleEndian
+	node := NewIFDNode(MPFIndexSpace)
+	node.Order = order
+	node.SetUndefined(MPFVersion, []byte("0100"))
+	node.SetMPEntries([]MPEntry{{0x20030000, 0, 0, 0, 0}, {0x020002, 0, 0, 0, 0}})
+	jfif := []byte{0xFF, jpegAPP0, 0, 7, 'J', 'F', 'I', 'F', 0}
+	first := append(append([]byte{0xFF, jpegSOI}, jfif...), 0xFF, jpegEOI)
+	second := []byte{0xFF, jpegSOI, 0xFF, jpegEOI}
+	mpo, err := PutMPO(node, []ImageSegment{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(mpo[2:], jfif) || !bytes.HasSuffix(mpo, second) {
+		t.Error("MPF segment not inserted after JFIF segment")
+	}
+	entries, images, err := GetMPFImages(mpo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images.Segments) != 2 || !bytes.Equal(images.Segments[1], second) || entries[1].TypeCode() != 0x020002 {
+		t.Fatalf("Repacked MPO has entries %+v", entries)
+	}
+
+	// Replace the second image with a larger one.
+	tree, err := GetJPEGMPFTree(mpo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	larger := append(append([]byte{0xFF, jpegSOI}, jfif...), 0xFF, jpegEOI)
+	mpo2, err := PutMPO(tree, []ImageSegment{images.Segments[0], larger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mpo2) != len(mpo)+len(jfif) {
+		t.Errorf("Repacked MPO has size %d, expected %d", len(mpo2), len(mpo)+len(jfif))
+	}
+	if _, images, err = GetMPFImages(mpo2); err != nil || !bytes.Equal(images.Segments[1], larger) {
+		t.Errorf("Replaced image not found, %v", err)
+	}
+	if _, err := PutMPO(tree, []ImageSegment{first}); err == nil {
+		t.Error("Image count mismatch didn't cause an error")
+	}
+}
@@ -0,0 +1,59 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestNikonBlock(t *testing.T) {
+	node := NewIFDNode(Nikon2Space)
+	node.Order = binary.BigEndian
+	node.SetUndefined(nikon2VRInfo, []byte{'0', '1', '0', '0', 1, 0, 2, 0})
+	version, vals, err := node.NikonBlock(nikon2VRInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "0100" {
+		t.Errorf("Version is %q", version)
+	}
+	if v, _ := vals.Get("VibrationReduction"); v != 1 {
+		t.Errorf("VibrationReduction is %d", v)
+	}
+	if v, _ := vals.Get("VRMode"); v != 2 {
+		t.Errorf("VRMode is %d", v)
+	}
+
+	flash := make([]byte, 21)
+	copy(flash, "0102")
+	flash[9] = 0x81
+	flash[17] = 0x23
+	node.SetUndefined(nikon2FlashInfo, flash)
+	_, vals, err = node.NikonBlock(nikon2FlashInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int64{"FlashCommanderMode": 1, "FlashControlMode": 1, "FlashGroupBControlMode": 2, "FlashGroupCControlMode": 3} {
+		if v, ok := vals.Get(name); !ok || v != want {
+			t.Errorf("%s is %d, want %d", name, v, want)
+		}
+	}
+	// Values past the end of a short block are omitted.
+	node.SetUndefined(nikon2FlashInfo, flash[:13])
+	if _, vals, err = node.NikonBlock(nikon2FlashInfo); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vals.Get("FlashFocalLength"); !ok {
+		t.Error("FlashFocalLength not decoded")
+	}
+	if _, ok := vals.Get("FlashGNDistance"); ok {
+		t.Error("FlashGNDistance decoded past end of block")
+	}
+
+	node.SetUndefined(nikon2ShotInfo, []byte("0210encrypted"))
+	if version, _, err := node.NikonBlock(nikon2ShotInfo); err == nil || version != "0210" {
+		t.Error("Encrypted ShotInfo didn't cause an error")
+	}
+	if _, _, err := node.NikonBlock(0x0002); err == nil {
+		t.Error("Field that isn't a block didn't cause an error")
+	}
+}
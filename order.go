@@ -0,0 +1,188 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Indicate if a node is a maker note whose byte order is found when
+// decoding from its header or IFD, and so doesn't need to follow the
+// Exif block.
+func (node IFDNode) ownByteOrder() bool {
+	switch node.GetSpace() {
+	case Fujifilm1Space, Nikon2Space, Olympus1Space, Sony1Space, Pentax1Space, Leica1Space, PhaseOneSpace, Hasselblad1Space:
+		return true
+	case Panasonic1Space:
+		// Leica maker notes decoded in Panasonic1 space.
+		rec, ok := node.SpaceRec.(*Panasonic1SpaceRec)
+		return ok && rec.label != nil
+	}
+	return false
+}
+
+// Return a copy of data with the bytes of each unit of a given size
+// reversed, or data itself if the size is 1.
+func swapUnits(data []byte, size uint32) []byte {
+	if size < 2 {
+		return data
+	}
+	out := make([]byte, len(data))
+	copy(out, data)
+	for pos := uint32(0); pos+size <= uint32(len(out)); pos += size {
+		unit := out[pos : pos+size]
+		for i, j := 0, len(unit)-1; i < j; i, j = i+1, j-1 {
+			unit[i], unit[j] = unit[j], unit[i]
+		}
+	}
+	return out
+}
+
+// Return the size of the units whose bytes are swapped when converting
+// data of a given type to the other byte order.
+func swapSize(t Type) uint32 {
+	if t.IsRational() {
+		return 4
+	}
+	return t.Size()
+}
+
+// Indicate if a node has image data that depends on the byte order:
+// 16, 32 or 64 bit samples that are uncompressed or compressed with LZW
+// or Deflate. Data compressed with other methods is assumed not to
+// depend on the byte order.
+func (node IFDNode) orderedImageData() bool {
+	bits := node.integerFieldDefault(BitsPerSample, 1)
+	if len(node.GetImageData()) == 0 || bits != 16 && bits != 32 && bits != 64 {
+		return false
+	}
+	switch node.integerFieldDefault(Compression, CompressionNone) {
+	case CompressionNone, CompressionLZW, CompressionDeflate, CompressionDeflateOld:
+		return true
+	}
+	return false
+}
+
+// Convert the image data of a node with 16, 32 or 64 bit samples from
+// a previous byte order to the node's order, which its fields have
+// already been converted to.
+func (node *IFDNode) convertImageData(from binary.ByteOrder) error {
+	if !node.orderedImageData() {
+		return nil
+	}
+	imageData := node.GetImageData()
+	bits := node.integerFieldDefault(BitsPerSample, 1)
+	compression := node.integerFieldDefault(Compression, CompressionNone)
+	decode := func(data []byte) ([]byte, error) {
+		return decompressChunk(compression, data, maxImageBytes)
+	}
+	encode := func(data []byte) ([]byte, error) {
+		return compressChunk(compression, data)
+	}
+	switch predictor := node.integerFieldDefault(Predictor, PredictorNone); predictor {
+	case PredictorNone:
+	case PredictorHorizontal:
+		l, err := node.imageLayout()
+		if err != nil {
+			return fmt.Errorf("ConvertByteOrder: %s", err)
+		}
+		old := *l
+		old.order = from
+		decode = old.decodeChunk
+		encode = l.encodeChunk
+	default:
+		return fmt.Errorf("ConvertByteOrder: Predictor %d is not supported", predictor)
+	}
+	for _, id := range imageData {
+		if !id.IsLoaded() {
+			return errors.New("ConvertByteOrder: image data isn't loaded")
+		}
+		sizes := make([]uint32, len(id.Segments))
+		for i, segment := range id.Segments {
+			data, err := decode(segment)
+			if err != nil {
+				return fmt.Errorf("ConvertByteOrder: %s", err)
+			}
+			if id.Segments[i], err = encode(swapUnits(data, bits/8)); err != nil {
+				return fmt.Errorf("ConvertByteOrder: %s", err)
+			}
+			sizes[i] = uint32(len(id.Segments[i]))
+		}
+		if compression != CompressionNone {
+			// Compressed sizes may have changed.
+			node.SetLongs(id.SizeTag, sizes)
+		}
+	}
+	return nil
+}
+
+// Convert an IFDNode tree to a given byte order, so that PutIFDTree
+// will serialize it in that order. The data of each field is
+// converted according to its type, along with uncompressed, LZW or
+// Deflate compressed image data with 16, 32 or 64 bit samples. Maker
+// notes that record their own byte order, such as Nikon's, are left in
+// that order along with their sub-IFDs, while others, such as Canon's,
+// follow the Exif block and are converted. Multi-byte values in
+// UNDEFINED fields can't be found, and aren't converted.
+func (node *IFDNode) ConvertByteOrder(order binary.ByteOrder) error {
+	if node.ownByteOrder() {
+		return nil
+	}
+	if from := node.Order; from != order {
+		if len(node.pending) > 0 {
+			return errors.New("ConvertByteOrder: field data isn't loaded")
+		}
+		for i := range node.Fields {
+			field := &node.Fields[i]
+			if size := field.Size(); uint32(len(field.Data)) >= size {
+				field.Data = swapUnits(field.Data[:size], swapSize(field.Type))
+			}
+		}
+		node.Order = order
+		if err := node.convertImageData(from); err != nil {
+			return err
+		}
+	}
+	for _, sub := range node.SubIFDs {
+		if err := sub.Node.ConvertByteOrder(order); err != nil {
+			return err
+		}
+	}
+	if node.Next != nil {
+		return node.Next.ConvertByteOrder(order)
+	}
+	return nil
+}
+
+// Return the node to write in place of a node whose parent has a given
+// byte order. IFDs are read in the order of their parent, except for
+// maker notes that record their own order, so a node with a different
+// order is written as a copy converted with cloneTree, leaving the
+// original unchanged. Image data isn't converted, so an error is
+// returned if the copy would have image data that depends on the
+// order.
+func (node *IFDNode) inOrder(order binary.ByteOrder) (*IFDNode, error) {
+	if node.Order == order || node.ownByteOrder() {
+		return node, nil
+	}
+	err := node.Walk(func(n *IFDNode, _ Tag) error {
+		if n.ownByteOrder() {
+			return SkipSubIFDs
+		}
+		if n.orderedImageData() {
+			return fmt.Errorf("PutIFDTree: %s IFD has a different byte order from its parent, and image data that must be converted with ConvertByteOrder", n.GetSpace().Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	clone, err := cloneTree(node, order)
+	if err != nil {
+		return nil, fmt.Errorf("PutIFDTree: %s", err)
+	}
+	if node.stream != nil {
+		clone.setImageStream(node.stream)
+	}
+	return clone, nil
+}
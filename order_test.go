@@ -0,0 +1,161 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// Convert 16 bit images to big-endian, with each compression and
+// predictor, and check that they decode to the same pixels.
+func TestConvertByteOrder(t *testing.T) {
+	img := image.NewGray16(image.Rect(0, 0, 7, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			img.SetGray16(x, y, color.Gray16{uint16(x*1000 + y*300 + 1)})
+		}
+	}
+	for _, compression := range []uint32{CompressionNone, CompressionLZW, CompressionDeflate} {
+		for _, predictor := range []uint32{PredictorNone, PredictorHorizontal} {
+			root, err := EncodeImage(img, &EncodeOptions{Order: binary.LittleEndian, Compression: compression, Predictor: predictor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := root.ConvertByteOrder(binary.BigEndian); err != nil {
+				t.Fatal(err)
+			}
+			if root.Order != binary.BigEndian {
+				t.Fatal("Order not converted")
+			}
+			if width, _ := root.integerField(ImageWidth); width != 7 {
+				t.Errorf("ImageWidth converted to %d", width)
+			}
+			if got := encodeRoundTrip(t, root); !sameImage(got, img) {
+				t.Errorf("Compression %d, predictor %d: converted image differs", compression, predictor)
+			}
+		}
+	}
+
+	// Exif fields are converted, but maker notes with their own
+	// byte order are left alone.
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = binary.LittleEndian
+	exif.SetRationals(ExposureTime, [][2]uint32{{1, 250}})
+	maker := NewIFDNode(Fujifilm1Space)
+	maker.Order = binary.LittleEndian
+	maker.SetShorts(0x1000, []uint16{3})
+	exif.SubIFDs = []SubIFD{{makerNote, maker}}
+	if err := exif.ConvertByteOrder(binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := exif.GetRationals(ExposureTime); r[0] != [2]uint32{1, 250} {
+		t.Errorf("ExposureTime converted to %v", r[0])
+	}
+	if s, _ := maker.GetShorts(0x1000); maker.Order != binary.LittleEndian || s[0] != 3 {
+		t.Error("Fujifilm1 maker note converted")
+	}
+}
+
+// Write trees whose IFDs have different byte orders, and check that
+// each is read back in the order of its parent, unless it's a maker
+// note that records its own order.
+func TestMixedOrderTree(t *testing.T) {
+	tests := []struct {
+		make       string
+		space      TagSpace
+		label      string
+		ownOrder   bool
+		rootOrder  binary.ByteOrder
+		otherOrder binary.ByteOrder
+	}{
+		{"Canon", Canon1Space, "", false, binary.LittleEndian, binary.BigEndian},
+		{"Leica Camera AG", Leica1Space, "LEICA\000\000\000", true, binary.BigEndian, binary.LittleEndian},
+	}
+	for _, test := range tests {
+		maker := NewIFDNode(test.space)
+		maker.Order = test.otherOrder
+		if rec, ok := maker.SpaceRec.(*Leica1SpaceRec); ok {
+			rec.label = []byte(test.label)
+			rec.relative = true
+		}
+		maker.SetShorts(0x0300, []uint16{2})
+		maker.SetLongs(0x0301, []uint32{70000, 5})
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = test.otherOrder
+		exif.SetRationals(ExposureTime, [][2]uint32{{1, 250}})
+		if err := exif.AddSubIFD(makerNote, maker); err != nil {
+			t.Fatal(err)
+		}
+		root := NewIFDNode(TIFFSpace)
+		root.Order = test.rootOrder
+		root.SetASCII(Make, test.make)
+		if err := root.SetExifIFD(exif); err != nil {
+			t.Fatal(err)
+		}
+		next := NewIFDNode(TIFFSpace)
+		next.Order = test.otherOrder
+		next.SetShorts(Orientation, []uint16{6})
+		next.SetRationals(XResolution, [][2]uint32{{1, 250}})
+		root.Next = next
+		exposure := append([]byte{}, exif.Fields[0].Data...)
+
+		for _, shared := range []bool{false, true} {
+			var out bytes.Buffer
+			write := root.WriteIFDTree
+			if shared {
+				write = root.WriteIFDTreeShared
+			}
+			if err := write(&out); err != nil {
+				t.Fatal(err)
+			}
+			if exif.Order != test.otherOrder || !bytes.Equal(exif.Fields[0].Data, exposure) || next.Order != test.otherOrder {
+				t.Fatal("Writing changed the tree")
+			}
+			decoded, err := getTIFFTree(out.Bytes())
+			if err != nil {
+				t.Fatalf("%s: %v", test.space.Name(), err)
+			}
+			if len(decoded.SubIFDs) != 1 || len(decoded.SubIFDs[0].Node.SubIFDs) != 1 || decoded.Next == nil {
+				t.Fatalf("%s: IFDs not found", test.space.Name())
+			}
+			getexif := decoded.SubIFDs[0].Node
+			if r, _ := getexif.GetRationals(ExposureTime); getexif.Order != test.rootOrder || r[0] != [2]uint32{1, 250} {
+				t.Errorf("%s: ExposureTime read as %v", test.space.Name(), r)
+			}
+			if s, _ := decoded.Next.GetShorts(Orientation); len(s) != 1 || s[0] != 6 {
+				t.Errorf("%s: Orientation read as %v", test.space.Name(), s)
+			}
+			getmaker := getexif.SubIFDs[0].Node
+			expected := test.rootOrder
+			if test.ownOrder {
+				expected = test.otherOrder
+			}
+			if getmaker.GetSpace() != test.space || getmaker.Order != expected {
+				t.Errorf("%s: maker note read as %s with order %v", test.space.Name(), getmaker.GetSpace().Name(), getmaker.Order)
+				continue
+			}
+			if l, _ := getmaker.GetLongs(0x0301); len(l) != 2 || l[0] != 70000 {
+				t.Errorf("%s: maker note field read as %v", test.space.Name(), l)
+			}
+		}
+	}
+
+	// 16 bit image data can't be converted when writing.
+	img := image.NewGray16(image.Rect(0, 0, 2, 2))
+	root, err := EncodeImage(img, &EncodeOptions{Order: binary.LittleEndian})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := EncodeImage(img, &EncodeOptions{Order: binary.BigEndian})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Next = page
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err == nil || !strings.Contains(err.Error(), "ConvertByteOrder") {
+		t.Errorf("16 bit image data in a different order was written: %v", err)
+	}
+}
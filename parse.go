@@ -0,0 +1,522 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"io"
+	"sync"
+)
+
+// How GetIFDTreeOptions handles malformed input.
+type ParseMode uint8
+
+const (
+	// Read as much data as possible, skipping invalid fields and
+	// IFDs and returning all the problems found. This is the
+	// behaviour of GetIFDTree.
+	ParseLenient ParseMode = 0
+	// Stop at the first problem found, returning it as the error.
+	ParseStrict ParseMode = 1
+	// As for ParseLenient, but with the tolerances in ParseOptions.
+	ParseSalvage ParseMode = 2
+)
+
+// Options for GetIFDTreeOptions. The zero value selects lenient mode.
+type ParseOptions struct {
+	Mode ParseMode
+	// Tolerances for salvage mode, which are ignored in other
+	// modes.
+
+	// Stop reading after this many problems, or 0 for no limit.
+	MaxProblems int
+	// Keep the part of field data that lies within the input,
+	// reducing the field count, instead of skipping fields whose
+	// data extends past the end of the input.
+	TruncateFields bool
+	// Score each IFD entry for plausibility, by whether its type
+	// is known, its tag follows the previous tag, and its data lies
+	// within the input and outside the IFD table, and skip entries
+	// that fail more than one test. Also end an IFD table early if
+	// it runs into field data referenced by its own entries, as
+	// happens when a writer stores a wrong entry count.
+	ScoreEntries bool
+	// If an IFD table isn't plausible, with no more than half its
+	// entries passing every test above, search up to this many
+	// bytes before and after its position for a plausible table,
+	// and read that instead. 0 disables the search.
+	ResyncRange uint32
+	// Check the offsets in each maker note IFD, and if the field
+	// data they point to would start before the end of the table or
+	// run past the end of the input, look for a different offset
+	// base that puts the data just after the table, as Exiftool
+	// does, and apply the correction to the maker note's offsets.
+	// Software that doesn't understand maker notes can move them
+	// without adjusting their offsets. The corrected IFDs are
+	// written with valid offsets when the tree is encoded.
+	FixMakerNoteBase bool
+
+	// Limits on resource use when reading untrusted input, in any
+	// mode. Reading stops with an error if a limit is exceeded. A
+	// zero value means no limit.
+
+	// Maximum number of IFDs, including maker note IFDs.
+	MaxIFDs int
+	// Maximum number of fields in an IFD.
+	MaxFields int
+	// Maximum total size in bytes of IFD tables, field data and
+	// the lists of image data offsets and sizes.
+	MaxDataBytes uint64
+	// Maximum nesting of IFDs, counting both sub-IFDs and Next
+	// IFDs, which are read recursively.
+	MaxDepth int
+
+	// Maximum number of goroutines used to read IFDs. If greater
+	// than 1, the sub-IFDs of fields that point to more than one,
+	// such as SubIFDs, are read in parallel. Errors are returned in
+	// the same order as when reading sequentially, but in malformed
+	// files where IFDs are shared, or when MaxProblems or a limit is
+	// exceeded, the IFDs that are reported may differ.
+	Workers int
+
+	// Copy field and image data out of the input buffer, as for
+	// IFDNode.Detach, so that the buffer needn't be retained.
+	Detach bool
+
+	// Track the byte ranges of IFD tables, field data and image
+	// data segments, as returned by Layout, and report a problem
+	// for each pair that overlap, which indicates corruption or a
+	// crafted file. Identical ranges, such as field data shared
+	// between IFDs, and the contents of field data that holds
+	// embedded IFDs, such as maker notes, aren't reported.
+	CheckOverlaps bool
+
+	// Leave maker notes as UNDEFINED fields instead of decoding
+	// them, so that they are written byte-identically. Offsets in
+	// maker notes that are relative to the start of the file, rather
+	// than the maker note, won't be valid if the maker note moves.
+	OpaqueMakerNotes bool
+
+	// Interpretation of the UNDEFINED FocusInfo field in Olympus
+	// maker notes, which is an IFD in some camera models and an
+	// array of data in others. By default it's guessed from the
+	// field's contents.
+	FocusInfo FocusInfoMode
+	// When guessing, the number of leading FocusInfo entries whose
+	// types must be valid TIFF types for the field to be taken as an
+	// IFD, or 0 for the default of 3. Smaller values allow for more
+	// damage to the IFD, larger values make a false match less
+	// likely.
+	FocusInfoCheckEntries int
+
+	// Record only the positions and sizes of image data segments,
+	// as GetIFDTreeReader does, instead of slices of the input.
+	// Segments can be read when needed with ImageData.Load, or
+	// copied to a writer with ImageData.Copy. This avoids holding
+	// strips and tiles during metadata-only work, and Detach won't
+	// copy them, but the input is still used to read them.
+	LazyImageData bool
+}
+
+// Interpretation of the Olympus FocusInfo maker note field, for
+// ParseOptions.FocusInfo.
+type FocusInfoMode uint8
+
+const (
+	// Guess whether the field holds an IFD from its contents.
+	FocusInfoGuess FocusInfoMode = iota
+	// Always decode the field as an IFD.
+	FocusInfoIFD
+	// Never decode the field, leaving it as an array of data.
+	FocusInfoArray
+)
+
+// Default for ParseOptions.FocusInfoCheckEntries.
+const defaultFocusInfoCheckEntries = 3
+
+// State of a parse, shared by all the sources used for an input.
+type parseState struct {
+	opts     ParseOptions
+	workers  chan struct{} // Tokens for extra goroutines, or nil.
+	mu       sync.Mutex    // Protects the following and the posMap.
+	problems int           // Number of problems found so far.
+	stopped  bool          // Whether reading should stop.
+	ifds     int           // Number of IFDs read.
+	data     uint64        // Bytes of tables and field data read.
+	layout   bool          // Whether to record regions for Layout.
+	regions  []LayoutRegion
+	input    io.ReaderAt // The whole input, for LazyImageData.
+}
+
+// Create the state for a parse with given options.
+func newParseState(opts *ParseOptions) *parseState {
+	state := &parseState{}
+	if opts != nil {
+		state.opts = *opts
+	}
+	state.layout = state.opts.CheckOverlaps
+	if state.opts.Workers > 1 {
+		state.workers = make(chan struct{}, state.opts.Workers-1)
+	}
+	return state
+}
+
+// Stop reading and return an error for an exceeded limit. The mutex
+// must be held.
+func (state *parseState) limitError(format string, args ...interface{}) error {
+	state.stopped = true
+	return fmt.Errorf(format, args...)
+}
+
+// Record the start of reading an IFD at pos, with src.depth already
+// incremented, returning an error if the number or nesting of IFDs is
+// exceeded.
+func (src source) enterIFD(pos uint32) error {
+	state := src.state
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.stopped {
+		return nil
+	}
+	state.ifds++
+	if state.opts.MaxIFDs > 0 && state.ifds > state.opts.MaxIFDs {
+		return state.limitError("IFD at %d exceeds limit of %d IFDs", pos, state.opts.MaxIFDs)
+	}
+	if state.opts.MaxDepth > 0 && src.depth > state.opts.MaxDepth {
+		return state.limitError("IFD at %d exceeds nesting limit of %d", pos, state.opts.MaxDepth)
+	}
+	return nil
+}
+
+// Record that the IFD at pos is being read, returning false if it was
+// already read, indicating a cycle.
+func (src source) visitIFD(ifdPositions posMap, pos uint32) bool {
+	if src.state != nil {
+		src.state.mu.Lock()
+		defer src.state.mu.Unlock()
+	}
+	key := posKey(src, pos)
+	if ifdPositions[key] {
+		return false
+	}
+	ifdPositions[key] = true
+	return true
+}
+
+// Return an error if an IFD has more fields than permitted.
+func (src source) checkFields(pos uint32, entries uint16) error {
+	state := src.state
+	if state == nil || state.opts.MaxFields <= 0 || int(entries) <= state.opts.MaxFields {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.limitError("IFD at %d has %d fields, exceeding limit of %d", pos, entries, state.opts.MaxFields)
+}
+
+// Record size bytes of data to be read or allocated, returning an error
+// if the total exceeds the limit.
+func (src source) useData(size uint64) error {
+	state := src.state
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.data += size
+	if state.opts.MaxDataBytes > 0 && state.data > state.opts.MaxDataBytes {
+		return state.limitError("Data exceeds limit of %d bytes", state.opts.MaxDataBytes)
+	}
+	return nil
+}
+
+// Append a problem found while reading to err, returning the new error
+// and whether reading should stop.
+func (src source) addProblem(err error, problem error) (error, bool) {
+	return multierror.Append(err, problem), src.countProblem(problem)
+}
+
+// Count a problem found while reading, returning true if reading
+// should stop. Multierrors are assumed to have been counted when their
+// contents were appended.
+func (src source) countProblem(problem error) bool {
+	state := src.state
+	if state == nil {
+		return false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if _, ok := problem.(*multierror.Error); !ok {
+		state.problems++
+	}
+	switch state.opts.Mode {
+	case ParseStrict:
+		state.stopped = true
+	case ParseSalvage:
+		if state.opts.MaxProblems > 0 && state.problems >= state.opts.MaxProblems {
+			state.stopped = true
+		}
+	}
+	return state.stopped
+}
+
+// Indicate if reading has stopped due to an earlier problem.
+func (src source) stopped() bool {
+	if src.state == nil {
+		return false
+	}
+	src.state.mu.Lock()
+	defer src.state.mu.Unlock()
+	return src.state.stopped
+}
+
+// Try to reserve a goroutine for reading an IFD in parallel, returning
+// false if none is available. releaseWorker must be called when a
+// goroutine that was reserved has finished.
+func (src source) reserveWorker() bool {
+	if src.state == nil || src.state.workers == nil {
+		return false
+	}
+	select {
+	case src.state.workers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release a goroutine reserved with reserveWorker.
+func (src source) releaseWorker() {
+	<-src.state.workers
+}
+
+// Return the reduced count for a field whose data at dataPos extends
+// past the end of the input, if it should be truncated instead of
+// skipped, otherwise 0.
+func (src source) truncatedCount(field Field, dataPos uint32) uint32 {
+	if src.state == nil || src.state.opts.Mode != ParseSalvage || !src.state.opts.TruncateFields {
+		return 0
+	}
+	typeSize := field.Type.Size()
+	size := field.Size()
+	if typeSize == 0 || dataPos >= src.len() || (dataPos+size >= dataPos && dataPos+size <= src.len()) {
+		return 0
+	}
+	return (src.len() - dataPos) / typeSize
+}
+
+// Create an IFDNode tree as for GetIFDTree, but with options that
+// control the handling of malformed input and limit the resources
+// used. If opts is nil, the defaults are used. In strict mode, or if a
+// limit is exceeded, the returned tree contains the data read before
+// reading stopped.
+func GetIFDTreeOptions(buf []byte, order binary.ByteOrder, pos uint32, space TagSpace, opts *ParseOptions) (*IFDNode, error) {
+	src := bufSource(buf)
+	src.state = newParseState(opts)
+	if src.state.opts.LazyImageData {
+		src.state.input = bytes.NewReader(buf)
+	}
+	ifdPositions := make(posMap)
+	node, err := getIFDTreeIter(src, order, pos, NewSpaceRec(space), ifdPositions)
+	if src.state.opts.CheckOverlaps {
+		for _, problem := range findOverlaps(src.state.regions) {
+			err = multierror.Append(err, problem)
+		}
+	}
+	if src.state.opts.Detach {
+		node.Detach()
+	}
+	return node, err
+}
+
+// Maximum plausibility score of an IFD entry, and the score below which
+// ScoreEntries skips an entry.
+const (
+	fullEntryScore      = 3
+	plausibleEntryScore = 2
+)
+
+// Return the plausibility score of an IFD entry, with data at dataPos if
+// stored outside a table that occupies [tabStart, tabEnd) in an input of
+// size bufsize. An entry with an unknown type scores 0, otherwise a
+// point each is given for the type, for ordered, which indicates if the
+// tag follows the previous one, and for the location of the data.
+func entryScore(field Field, ordered bool, dataPos, tabStart, tabEnd, bufsize uint32) int {
+	if field.Type.Size() == 0 {
+		return 0
+	}
+	score := 1
+	if ordered {
+		score++
+	}
+	size := uint64(field.Type.Size()) * uint64(field.Count)
+	if size <= 4 || (uint64(dataPos)+size <= uint64(bufsize) && (dataPos >= tabEnd || uint64(dataPos)+size <= uint64(tabStart))) {
+		score++
+	}
+	return score
+}
+
+// Indicate if IFD entries should be scored for plausibility.
+func (src source) scoringEntries() bool {
+	return src.state != nil && src.state.opts.Mode == ParseSalvage && src.state.opts.ScoreEntries
+}
+
+// Return the FocusInfo mode and the number of entries to check when
+// guessing, from the parse options.
+func (src source) focusInfoOptions() (FocusInfoMode, int) {
+	if src.state == nil {
+		return FocusInfoGuess, defaultFocusInfoCheckEntries
+	}
+	check := src.state.opts.FocusInfoCheckEntries
+	if check <= 0 {
+		check = defaultFocusInfoCheckEntries
+	}
+	return src.state.opts.FocusInfo, check
+}
+
+// Return the number of entries in the IFD table at pos and whether
+// the table is plausible: it must lie within the input and have more
+// than half of its entries with full scores.
+func (src source) plausibleTable(order binary.ByteOrder, pos uint32) (uint16, bool) {
+	countData, err := src.data(pos, 2)
+	if err != nil {
+		return 0, false
+	}
+	entries := order.Uint16(countData)
+	tabsize := TableSize(entries)
+	if entries == 0 || pos+tabsize < pos || pos+tabsize > src.len() {
+		return entries, false
+	}
+	table, err := src.data(pos+2, tabsize-2)
+	if err != nil {
+		return entries, false
+	}
+	full := 0
+	last := Tag(0)
+	for i := uint32(0); i < uint32(entries); i++ {
+		entry := table[i*TableEntrySize:]
+		field := Field{Tag: Tag(order.Uint16(entry)), Type: Type(order.Uint16(entry[2:])), Count: order.Uint32(entry[4:])}
+		if entryScore(field, i == 0 || field.Tag > last, order.Uint32(entry[8:]), pos, pos+tabsize, src.len()) == fullEntryScore {
+			full++
+		}
+		last = field.Tag
+	}
+	return entries, full*2 > int(entries)
+}
+
+// If the IFD table at pos isn't plausible and ResyncRange is set in
+// salvage mode, return the nearest position within the range with a
+// plausible table, its number of entries, and true. Positions are
+// tried at even distances from pos, before and then after it.
+func (src source) resyncIFD(order binary.ByteOrder, pos uint32) (uint32, uint16, bool) {
+	state := src.state
+	if state == nil || state.opts.Mode != ParseSalvage || state.opts.ResyncRange == 0 {
+		return 0, 0, false
+	}
+	if _, ok := src.plausibleTable(order, pos); ok {
+		return 0, 0, false
+	}
+	for dist := uint32(2); dist <= state.opts.ResyncRange && dist < src.len(); dist += 2 {
+		if dist <= pos {
+			if entries, ok := src.plausibleTable(order, pos-dist); ok {
+				return pos - dist, entries, true
+			}
+		}
+		if pos+dist > pos && pos+dist < src.len() {
+			if entries, ok := src.plausibleTable(order, pos+dist); ok {
+				return pos + dist, entries, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Return the correction for the offsets of the maker note IFD table at
+// pos, and true, if FixMakerNoteBase is set in salvage mode and the
+// offsets are wrong but a correction can be found. Maker note data
+// follows the table, so the offsets are taken to be wrong if the data
+// of any entry starts before the end of the table or runs past the end
+// of the input. The data is assumed to start after the table's Next
+// pointer, or after its last entry if the pointer is omitted, and the
+// correction that moves the lowest offset there is accepted if it puts
+// the data of every entry after the table and within the input. table
+// excludes the entry count.
+func (src source) makerNoteDelta(order binary.ByteOrder, table []byte, pos uint32, entries uint16, space TagSpace) (int64, bool) {
+	state := src.state
+	if state == nil || state.opts.Mode != ParseSalvage || !state.opts.FixMakerNoteBase {
+		return 0, false
+	}
+	type extent struct {
+		off  uint32
+		size uint64
+	}
+	var data []extent
+	for i := uint32(0); i < uint32(entries); i++ {
+		entry := table[i*TableEntrySize:]
+		field := Field{Tag: Tag(order.Uint16(entry)), Type: Type(order.Uint16(entry[2:])), Count: order.Uint32(entry[4:])}
+		size := uint64(field.Type.Size()) * uint64(field.Count)
+		if size <= 4 || (space == Sony1Space && field.Tag == sony1PreviewImage) {
+			// Data in the table, or in the case of Sony1
+			// PreviewImage, usually outside the Exif block.
+			continue
+		}
+		data = append(data, extent{order.Uint32(entry[8:]), size})
+	}
+	if len(data) == 0 {
+		return 0, false
+	}
+	tabEnd := int64(pos) + 2 + int64(entries)*TableEntrySize
+	valid := func(delta int64) bool {
+		for _, d := range data {
+			start := int64(d.off) + delta
+			end := start + int64(d.size)
+			if start < tabEnd || end > int64(src.len()) {
+				return false
+			}
+		}
+		return true
+	}
+	if valid(0) {
+		return 0, false
+	}
+	lowest := data[0].off
+	for _, d := range data[1:] {
+		if d.off < lowest {
+			lowest = d.off
+		}
+	}
+	for _, start := range []int64{tabEnd + 4, tabEnd} {
+		if delta := start - int64(lowest); valid(delta) {
+			return delta, true
+		}
+	}
+	return 0, false
+}
+
+// Return the number of entries of an IFD table at pos that can be read
+// without overlapping field data referenced by the entries themselves,
+// and the position of the overlapping data, or entries and 0 if there's
+// no overlap. table excludes the entry count.
+func (src source) overlapEntries(order binary.ByteOrder, table []byte, pos uint32, entries uint16) (uint16, uint32) {
+	limit := uint32(entries)
+	overlap := uint32(0)
+	for i := uint32(0); i < limit; i++ {
+		entry := table[i*TableEntrySize:]
+		field := Field{Type: Type(order.Uint16(entry[2:])), Count: order.Uint32(entry[4:])}
+		if uint64(field.Type.Size())*uint64(field.Count) <= 4 {
+			continue
+		}
+		// Data after the end of this entry but inside the table.
+		dataPos := src.offset(order.Uint32(entry[8:]))
+		entryEnd := pos + 2 + (i+1)*TableEntrySize
+		if dataPos >= entryEnd && dataPos < pos+TableSize(uint16(limit)) {
+			limit = (dataPos - pos - 2) / TableEntrySize
+			overlap = dataPos
+		}
+	}
+	return uint16(limit), overlap
+}
@@ -0,0 +1,384 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/hashicorp/go-multierror"
+	"image"
+	"strings"
+	"testing"
+)
+
+// Return the number of errors in an error returned by GetIFDTree.
+func countErrors(err error) int {
+	if err == nil {
+		return 0
+	}
+	if merr, ok := err.(*multierror.Error); ok {
+		return len(merr.Errors)
+	}
+	return 1
+}
+
+func TestParseOptions(t *testing.T) {
+	order := binary.BigEndian
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.SetASCII(ImageDescription, "A description of the image")
+	root.SetASCII(Software, "Some software or other")
+	next := NewIFDNode(TIFFSpace)
+	next.Order = order
+	next.SetASCII(Artist, "An artist")
+	root.Next = next
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	// Make the data of both fields in the root extend past the end
+	// of the file.
+	ifd := order.Uint32(buf[4:])
+	for i := uint32(0); i < 2; i++ {
+		order.PutUint32(buf[ifd+2+i*TableEntrySize+4:], 0x10000000)
+	}
+
+	node, err := GetIFDTree(buf, order, ifd, TIFFSpace)
+	lenient, lenientErr := GetIFDTreeOptions(buf, order, ifd, TIFFSpace, nil)
+	if countErrors(err) != 2 || countErrors(lenientErr) != 2 {
+		t.Errorf("Lenient mode found %d and %d errors, expected 2", countErrors(err), countErrors(lenientErr))
+	}
+	for _, n := range []*IFDNode{node, lenient} {
+		if len(n.Fields) != 0 || n.Next == nil || len(n.Next.Fields) != 1 {
+			t.Error("Lenient mode didn't read the next IFD")
+		}
+	}
+
+	node, err = GetIFDTreeOptions(buf, order, ifd, TIFFSpace, &ParseOptions{Mode: ParseStrict})
+	if countErrors(err) != 1 {
+		t.Errorf("Strict mode found %d errors, expected 1", countErrors(err))
+	}
+	if node.Next != nil {
+		t.Error("Strict mode read the next IFD")
+	}
+
+	node, err = GetIFDTreeOptions(buf, order, ifd, TIFFSpace, &ParseOptions{Mode: ParseSalvage, MaxProblems: 2})
+	if countErrors(err) != 2 || node.Next != nil {
+		t.Error("Salvage mode didn't stop after 2 problems")
+	}
+
+	node, err = GetIFDTreeOptions(buf, order, ifd, TIFFSpace, &ParseOptions{Mode: ParseSalvage, TruncateFields: true})
+	if countErrors(err) != 2 {
+		t.Errorf("Salvage mode found %d errors, expected 2", countErrors(err))
+	}
+	if len(node.Fields) != 2 || node.Next == nil {
+		t.Fatal("Salvage mode didn't keep truncated fields")
+	}
+	for _, f := range node.Fields {
+		if f.Count == 0 || f.Count >= 0x10000000 || uint32(len(f.Data)) != f.Count {
+			t.Errorf("Field %d truncated to %d values", f.Tag, f.Count)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	order := binary.LittleEndian
+	var root, last *IFDNode
+	for i := 0; i < 10; i++ {
+		node := NewIFDNode(TIFFSpace)
+		node.Order = order
+		node.SetASCII(ImageDescription, "A description of the image")
+		node.SetShorts(Orientation, []uint16{1})
+		node.SetLongs(NewSubfileType, []uint32{1})
+		if root == nil {
+			root = node
+		} else {
+			last.Next = node
+		}
+		last = node
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	ifd := order.Uint32(buf[4:])
+	chainLen := func(node *IFDNode) int {
+		n := 0
+		for ; node != nil && len(node.Fields) > 0; node = node.Next {
+			n++
+		}
+		return n
+	}
+
+	node, err := GetIFDTreeOptions(buf, order, ifd, TIFFSpace, &ParseOptions{MaxIFDs: 10, MaxFields: 3, MaxDepth: 10})
+	if err != nil || chainLen(node) != 10 {
+		t.Errorf("Limits that weren't exceeded caused an error: %v", err)
+	}
+	tests := []struct {
+		opts    ParseOptions
+		pages   int
+		message string
+	}{
+		{ParseOptions{MaxIFDs: 4}, 4, "limit of 4 IFDs"},
+		{ParseOptions{MaxDepth: 3}, 3, "nesting limit of 3"},
+		{ParseOptions{MaxFields: 2}, 0, "exceeding limit of 2"},
+		// Each IFD has a table of 42 bytes and 27 bytes of data.
+		{ParseOptions{MaxDataBytes: 100}, 1, "limit of 100 bytes"},
+		// Limits also apply in lenient mode.
+		{ParseOptions{Mode: ParseLenient, MaxIFDs: 1}, 1, "limit of 1 IFDs"},
+	}
+	for _, test := range tests {
+		node, err := GetIFDTreeOptions(buf, order, ifd, TIFFSpace, &test.opts)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%+v: expected error containing %q, got %v", test.opts, test.message, err)
+		}
+		if chainLen(node) != test.pages {
+			t.Errorf("%+v: read %d IFDs, expected %d", test.opts, chainLen(node), test.pages)
+		}
+	}
+}
+
+// Build a little-endian TIFF file with a single IFD containing the
+// given entries, each with a tag, type, count and value, followed by
+// extra data.
+func entriesFile(entries [][4]uint32, extra []byte) []byte {
+	order := binary.LittleEndian
+	buf := make([]byte, HeaderSize+TableSize(uint16(len(entries))))
+	PutHeader(buf, order, HeaderSize)
+	order.PutUint16(buf[HeaderSize:], uint16(len(entries)))
+	for i, e := range entries {
+		entry := buf[HeaderSize+2+i*TableEntrySize:]
+		order.PutUint16(entry, uint16(e[0]))
+		order.PutUint16(entry[2:], uint16(e[1]))
+		order.PutUint32(entry[4:], e[2])
+		order.PutUint32(entry[8:], e[3])
+	}
+	return append(buf, extra...)
+}
+
+// Counts that can't be valid are rejected, and the lists of image
+// data offsets and sizes count against MaxDataBytes, so that a small
+// file can't cause huge allocations.
+func TestParseCounts(t *testing.T) {
+	order := binary.LittleEndian
+	buf := entriesFile([][4]uint32{
+		{uint32(ImageWidth), uint32(SHORT), 1, 16},
+		{uint32(StripOffsets), 99, 0xFFFFFFFF, 0},
+		{uint32(StripByteCounts), uint32(LONG), 0x40000001, 0},
+	}, nil)
+	node, err := GetIFDTreeOptions(buf, order, HeaderSize, TIFFSpace, &ParseOptions{MaxDataBytes: 1000})
+	if countErrors(err) != 2 || len(node.Fields) != 1 {
+		t.Errorf("Invalid counts: %d errors and %d fields, expected 2 and 1", countErrors(err), len(node.Fields))
+	}
+
+	// 100 strips, whose offsets and sizes take 800 bytes in the file.
+	strips := uint32(100)
+	tableEnd := HeaderSize + TableSize(2)
+	buf = entriesFile([][4]uint32{
+		{uint32(StripOffsets), uint32(LONG), strips, tableEnd},
+		{uint32(StripByteCounts), uint32(LONG), strips, tableEnd + 4*strips},
+	}, make([]byte, 8*strips))
+	if node, err = GetIFDTreeOptions(buf, order, HeaderSize, TIFFSpace, &ParseOptions{MaxDataBytes: 5000}); err != nil || len(node.GetImageData()) != 1 {
+		t.Errorf("Image data not read: %v", err)
+	}
+	if _, err = GetIFDTreeOptions(buf, order, HeaderSize, TIFFSpace, &ParseOptions{MaxDataBytes: 1000}); err == nil || !strings.Contains(err.Error(), "limit of 1000 bytes") {
+		t.Errorf("Image data lists not counted against MaxDataBytes: %v", err)
+	}
+}
+
+func TestParseWorkers(t *testing.T) {
+	order := binary.BigEndian
+	root, err := EncodeImage(image.NewGray(image.Rect(0, 0, 4, 4)), &EncodeOptions{Order: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numSubs = 8
+	for i := 0; i < numSubs; i++ {
+		sub, err := EncodeImage(image.NewGray(image.Rect(0, 0, i+1, 2)), &EncodeOptions{Order: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddSubIFD(SubIFDs, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	ifd := order.Uint32(buf[4:])
+	// Corrupt the pointers to two sub-IFDs in a copy of the file,
+	// to check that errors are returned in a consistent order.
+	bad := append([]byte(nil), buf...)
+	count := uint32(order.Uint16(bad[ifd:]))
+	for i := uint32(0); i < count; i++ {
+		entry := bad[ifd+2+i*TableEntrySize:]
+		if Tag(order.Uint16(entry)) == SubIFDs {
+			pos := order.Uint32(entry[8:])
+			order.PutUint32(bad[pos+2*4:], 0xFFFFFF00)
+			order.PutUint32(bad[pos+5*4:], 0xFFFFFF10)
+		}
+	}
+	_, badErr := GetIFDTree(bad, order, ifd, TIFFSpace)
+	if countErrors(badErr) != 2 {
+		t.Fatalf("Corrupt sub-IFDs gave %d errors, expected 2", countErrors(badErr))
+	}
+	for _, workers := range []int{0, 3, numSubs * 2} {
+		node, err := GetIFDTreeOptions(buf, order, ifd, TIFFSpace, &ParseOptions{Workers: workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(node.SubIFDs) != numSubs {
+			t.Fatalf("Workers %d: %d sub-IFDs, expected %d", workers, len(node.SubIFDs), numSubs)
+		}
+		for i, sub := range node.SubIFDs {
+			width, ok := sub.Node.GetLongs(ImageWidth)
+			if !ok || width[0] != uint32(i+1) {
+				t.Errorf("Workers %d: sub-IFD %d has wrong width", workers, i)
+			}
+			// Each sub-IFD has its own image data.
+			if imageData := sub.Node.GetImageData(); len(imageData) != 1 || len(imageData[0].Segments[0]) != (i+1)*2 {
+				t.Errorf("Workers %d: sub-IFD %d has wrong image data", workers, i)
+			}
+		}
+		_, err = GetIFDTreeOptions(bad, order, ifd, TIFFSpace, &ParseOptions{Workers: workers})
+		if err == nil || err.Error() != badErr.Error() {
+			t.Errorf("Workers %d: errors differ from sequential reading: %v", workers, err)
+		}
+	}
+}
+
+// Decode with LazyImageData, and check that the image data is read on
+// demand and written unchanged.
+func TestLazyImageData(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	root, err := EncodeImage(gray, &EncodeOptions{Order: binary.LittleEndian, RowsPerStrip: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	order := binary.LittleEndian
+	node, err := GetIFDTreeOptions(buf, order, order.Uint32(buf[4:]), TIFFSpace, &ParseOptions{LazyImageData: true, Detach: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := node.GetImageData()[0]
+	if id.IsLoaded() || id.Segments != nil || len(id.Sizes) != 4 {
+		t.Fatal("Image data was captured")
+	}
+	var copied bytes.Buffer
+	if err := id.Copy(&copied); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(copied.Bytes(), gray.Pix) {
+		t.Error("Copied image data differs")
+	}
+	var rewritten bytes.Buffer
+	if err := node.WriteIFDTree(&rewritten); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rewritten.Bytes(), buf) {
+		t.Error("Rewritten file differs")
+	}
+	if err := id.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(id.Segments) != 4 || !bytes.Equal(id.Segments[3], gray.Pix[48:]) {
+		t.Error("Loaded image data differs")
+	}
+}
+
+func TestSalvageIFDs(t *testing.T) {
+	order := binary.BigEndian
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.SetASCII(ImageDescription, "A description of the image")
+	root.SetShorts(Orientation, []uint16{1})
+	root.SetASCII(Software, "Some software or other")
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	orig := out.Bytes()
+	ifd := order.Uint32(orig[4:])
+	salvage := &ParseOptions{Mode: ParseSalvage, ScoreEntries: true, ResyncRange: 16}
+
+	// An entry count that's too large, so that the table runs into
+	// the field data.
+	buf := append([]byte{}, orig...)
+	order.PutUint16(buf[ifd:], 5)
+	node, err := GetIFDTreeOptions(buf, order, ifd, TIFFSpace, salvage)
+	if countErrors(err) != 1 || len(node.Fields) != 3 {
+		t.Errorf("Overlapping table: %d errors and %d fields, expected 1 and 3", countErrors(err), len(node.Fields))
+	}
+
+	// An entry with an unknown type, which lenient mode keeps.
+	buf = append([]byte{}, orig...)
+	order.PutUint16(buf[ifd+2+TableEntrySize+2:], 99)
+	node, err = GetIFDTree(buf, order, ifd, TIFFSpace)
+	if len(node.Fields) != 3 {
+		t.Error("Lenient mode skipped an entry with unknown type")
+	}
+	node, err = GetIFDTreeOptions(buf, order, ifd, TIFFSpace, salvage)
+	if countErrors(err) != 1 || len(node.Fields) != 2 || len(node.FindFields([]Tag{Orientation})) != 0 {
+		t.Errorf("Implausible entry: %d errors and %d fields, expected 1 and 2", countErrors(err), len(node.Fields))
+	}
+
+	// An IFD pointer that's a few bytes out.
+	node, err = GetIFDTree(orig, order, ifd+4, TIFFSpace)
+	if len(node.FindFields([]Tag{Software})) != 0 {
+		t.Error("Lenient mode read the misplaced IFD")
+	}
+	for _, pos := range []uint32{ifd - 6, ifd + 4} {
+		node, err = GetIFDTreeOptions(orig, order, pos, TIFFSpace, salvage)
+		if countErrors(err) != 1 || len(node.Fields) != 3 || !strings.Contains(err.Error(), "reading table found at") {
+			t.Errorf("IFD pointer %d wasn't resynchronized: %v", pos, err)
+		}
+	}
+	if node, _ = GetIFDTreeOptions(orig, order, ifd+20, TIFFSpace, salvage); len(node.Fields) == 3 {
+		t.Error("IFD was found outside the resync range")
+	}
+}
+
+// Sub-IFD pointers are read only from the data present, whatever the
+// field's count, and each sub-IFD gets its own SpaceRec, so that image
+// data recorded while reading one isn't attributed to the others.
+func TestRecurseSubIFDs(t *testing.T) {
+	order := binary.LittleEndian
+	strip := func(offset uint32) [][4]uint32 {
+		return [][4]uint32{
+			{uint32(StripOffsets), uint32(LONG), 1, offset},
+			{uint32(StripByteCounts), uint32(LONG), 1, 2},
+		}
+	}
+	// Two sub-IFDs after the header, each with one strip.
+	buf := entriesFile(strip(0), nil)
+	second := uint32(len(buf))
+	buf = append(buf, entriesFile(strip(0), nil)[HeaderSize:]...)
+	order.PutUint32(buf[HeaderSize+2+8:], 0)
+	order.PutUint32(buf[second+2+8:], 2)
+	pointers := make([]byte, 8)
+	order.PutUint32(pointers, HeaderSize)
+	order.PutUint32(pointers[4:], second)
+	field := Field{SubIFDs, LONG, 0xFFFFFFFF, pointers}
+	subs, err := recurseSubIFDs(bufSource(buf), order, make(posMap), field, NewSpaceRec(TIFFSpace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("Read %d sub-IFDs, expected 2", len(subs))
+	}
+	for i, sub := range subs {
+		if ids := sub.Node.GetImageData(); len(ids) != 1 || ids[0].Offsets[0] != uint32(2*i) {
+			t.Errorf("Sub-IFD %d has wrong image data %v", i, ids)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A change to be made to the buffer by PatchIFDTree.
+type patch struct {
+	pos  uint32
+	data []byte
+}
+
+// State for PatchIFDTree.
+type patcher struct {
+	buf     []byte
+	order   binary.ByteOrder
+	patches []patch
+	visited map[uint32]bool
+}
+
+// Record a change to the buffer, if the data differs. The data is
+// copied, since it may point into the buffer.
+func (p *patcher) add(pos uint32, data []byte) {
+	if !bytes.Equal(p.buf[pos:pos+uint32(len(data))], data) {
+		p.patches = append(p.patches, patch{pos, append([]byte(nil), data...)})
+	}
+}
+
+// Return the original IFD entry at pos, with its data pointing into
+// the buffer, and the position of its data.
+func (p *patcher) entry(pos uint32) (Field, uint32, error) {
+	entry := Field{
+		Tag:   Tag(p.order.Uint16(p.buf[pos:])),
+		Type:  Type(p.order.Uint16(p.buf[pos+2:])),
+		Count: p.order.Uint32(p.buf[pos+4:])}
+	size := uint64(entry.Size())
+	dataPos := uint64(pos + 8)
+	if size > 4 {
+		dataPos = uint64(p.order.Uint32(p.buf[pos+8:]))
+	}
+	if dataPos+size > uint64(len(p.buf)) {
+		return entry, 0, fmt.Errorf("Data for field %d(0x%X) at %d extends past end of input", entry.Tag, entry.Tag, dataPos)
+	}
+	entry.Data = p.buf[dataPos : dataPos+size]
+	return entry, uint32(dataPos), nil
+}
+
+// Return the values of an original integer or IFD entry.
+func (p *patcher) entryValues(entry Field) ([]uint32, error) {
+	if !entry.Type.IsIntegral() && entry.Type != IFD {
+		return nil, fmt.Errorf("Field %d(0x%X) has type %s, expected an integer type", entry.Tag, entry.Tag, entry.Type.Name())
+	}
+	vals := make([]uint32, entry.Count)
+	for i := range vals {
+		if entry.Type == IFD {
+			vals[i] = entry.Long(uint32(i), p.order)
+		} else {
+			vals[i] = uint32(entry.AnyInteger(uint32(i), p.order))
+		}
+	}
+	return vals, nil
+}
+
+// Compare a node with the original IFD at pos, recording the changes
+// needed to update it and the IFDs to which it refers.
+func (p *patcher) patchIFD(node *IFDNode, pos uint32) error {
+	if p.visited[pos] {
+		return fmt.Errorf("IFD at %d is referenced more than once", pos)
+	}
+	p.visited[pos] = true
+	bufsize := uint64(len(p.buf))
+	if uint64(pos)+2 > bufsize {
+		return fmt.Errorf("IFD at %d is past end of input", pos)
+	}
+	count := p.order.Uint16(p.buf[pos:])
+	if uint64(pos)+uint64(TableSize(count)) > bufsize {
+		return fmt.Errorf("IFD at %d extends past end of input", pos)
+	}
+	if int(count) != len(node.Fields) {
+		return fmt.Errorf("IFD at %d has %d fields, originally %d", pos, len(node.Fields), count)
+	}
+	// Offsets of image data aren't changed, since the data stays in
+	// place.
+	imageData := node.GetImageData()
+	offsetTags := make(map[Tag]bool)
+	for _, id := range imageData {
+		offsetTags[id.OffsetTag] = true
+	}
+	entries := make(map[Tag]Field)
+	for i, field := range node.Fields {
+		epos := pos + 2 + uint32(i)*TableEntrySize
+		entry, dataPos, err := p.entry(epos)
+		if err != nil {
+			return err
+		}
+		if entry.Tag != field.Tag {
+			return fmt.Errorf("Field %d in IFD at %d has tag %d(0x%X), originally %d(0x%X)", i, pos, field.Tag, field.Tag, entry.Tag, entry.Tag)
+		}
+		entries[entry.Tag] = entry
+		var subs []*IFDNode
+		for _, sub := range node.SubIFDs {
+			if sub.Tag == field.Tag {
+				subs = append(subs, sub.Node)
+			}
+		}
+		if len(subs) > 0 {
+			if subs[0].IsMakerNote() {
+				// Maker notes may use offsets relative to
+				// their own start, and aren't patched.
+				continue
+			}
+			ptrs, err := p.entryValues(entry)
+			if err != nil {
+				return err
+			}
+			if len(ptrs) != len(subs) {
+				return fmt.Errorf("Field %d(0x%X) in IFD at %d has %d sub-IFDs, originally %d", field.Tag, field.Tag, pos, len(subs), len(ptrs))
+			}
+			for j, sub := range subs {
+				if err := p.patchIFD(sub, ptrs[j]); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if offsetTags[field.Tag] {
+			continue
+		}
+		if err := p.patchField(field, entry, epos, dataPos); err != nil {
+			return fmt.Errorf("Field %d(0x%X) in IFD at %d: %v", field.Tag, field.Tag, pos, err)
+		}
+	}
+	for _, id := range imageData {
+		if err := p.patchImageData(id, entries); err != nil {
+			return fmt.Errorf("IFD at %d: %v", pos, err)
+		}
+	}
+	nextPos := pos + 2 + uint32(count)*TableEntrySize
+	next := p.order.Uint32(p.buf[nextPos:])
+	if node.Next == nil {
+		// A removed Next IFD is simply unlinked.
+		p.add(nextPos, []byte{0, 0, 0, 0})
+		return nil
+	}
+	if next == 0 {
+		return fmt.Errorf("IFD at %d has a Next IFD, which it didn't originally", pos)
+	}
+	return p.patchIFD(node.Next, next)
+}
+
+// Record the changes needed to update a field, given its original
+// entry at epos and the original position of its data.
+func (p *patcher) patchField(field, entry Field, epos, dataPos uint32) error {
+	if field.Type != entry.Type {
+		return fmt.Errorf("type changed from %s to %s", entry.Type.Name(), field.Type.Name())
+	}
+	if field.Type.Size() == 0 {
+		// Unknown type, with data that can't be located.
+		return nil
+	}
+	size := field.Size()
+	if field.Data == nil && size > 0 {
+		// Not loaded, so can't have changed.
+		return nil
+	}
+	if uint64(len(field.Data)) < uint64(size) {
+		return fmt.Errorf("data is shorter than its count requires")
+	}
+	origSize := entry.Size()
+	if size > 4 && size > origSize {
+		return fmt.Errorf("data has grown from %d to %d bytes", origSize, size)
+	}
+	var countData [4]byte
+	p.order.PutUint32(countData[:], field.Count)
+	p.add(epos+4, countData[:])
+	if size <= 4 && origSize <= 4 {
+		p.add(epos+8, field.Data[:size])
+	} else if size <= 4 {
+		// The data pointer is replaced by the data.
+		var inline [4]byte
+		copy(inline[:], field.Data[:size])
+		p.add(epos+8, inline[:])
+	} else {
+		p.add(dataPos, field.Data[:size])
+	}
+	return nil
+}
+
+// Record the changes needed to update image data in place, which must
+// have the same number and sizes of segments as the original.
+func (p *patcher) patchImageData(id ImageData, entries map[Tag]Field) error {
+	if !id.IsLoaded() {
+		return nil
+	}
+	offsets, err := p.entryValues(entries[id.OffsetTag])
+	if err != nil {
+		return err
+	}
+	sizes, err := p.entryValues(entries[id.SizeTag])
+	if err != nil {
+		return err
+	}
+	if len(offsets) != len(id.Segments) || len(sizes) != len(id.Segments) {
+		return fmt.Errorf("Image data for field %d(0x%X) has %d segments, originally %d", id.OffsetTag, id.OffsetTag, len(id.Segments), len(offsets))
+	}
+	for i, seg := range id.Segments {
+		if uint32(len(seg)) != sizes[i] {
+			return fmt.Errorf("Image data segment %d for field %d(0x%X) has size %d, originally %d", i, id.OffsetTag, id.OffsetTag, len(seg), sizes[i])
+		}
+		if uint64(offsets[i])+uint64(sizes[i]) > uint64(len(p.buf)) {
+			return fmt.Errorf("Image data segment %d for field %d(0x%X) extends past end of input", i, id.OffsetTag, id.OffsetTag)
+		}
+		p.add(offsets[i], seg)
+	}
+	return nil
+}
+
+// Update a TIFF file in place to match a tree that was decoded from it
+// and then modified, instead of repacking the entire file with
+// PutIFDTree. Only the IFD entries, field data and image data that
+// have changed are overwritten, so the layout of the file is
+// preserved. pos is the position of the root IFD. This is only
+// possible if the tree has the same IFDs and fields as the file, and
+// data that's larger than 4 bytes hasn't grown. A Next IFD may be
+// removed, but not added. Maker notes aren't updated. If an error is
+// returned, the buffer isn't modified, and the caller can write the
+// tree with PutIFDTree instead.
+func PatchIFDTree(buf []byte, node *IFDNode, pos uint32) error {
+	p := patcher{buf: buf, order: node.Order, visited: make(map[uint32]bool)}
+	if err := p.patchIFD(node, pos); err != nil {
+		return err
+	}
+	for _, change := range p.patches {
+		copy(buf[change.pos:], change.data)
+	}
+	return nil
+}
+
+// Write the value of a field directly into the buffer from which its
+// IFD was decoded, at the field's original position, without
+// serializing the tree. This is suitable for edits that don't change
+// the size of the data, such as changing Orientation or DateTime: the
+// field must have the same type and count as in the buffer. An error
+// is returned if the node wasn't decoded from a buffer, in which case
+// its position isn't known, or the field isn't found.
+func UpdateInBuffer(buf []byte, node *IFDNode, tag Tag) error {
+	if !node.decoded {
+		return errors.New("UpdateInBuffer: IFD wasn't decoded from a buffer")
+	}
+	fields := node.FindFields([]Tag{tag})
+	if len(fields) == 0 {
+		return fmt.Errorf("UpdateInBuffer: field %d(0x%X) not found", tag, tag)
+	}
+	field := fields[0]
+	p := patcher{buf: buf, order: node.Order}
+	pos := node.decodedPos
+	if uint64(pos)+2 > uint64(len(buf)) {
+		return fmt.Errorf("UpdateInBuffer: IFD at %d is past end of buffer", pos)
+	}
+	count := p.order.Uint16(buf[pos:])
+	if uint64(pos)+uint64(TableSize(count)) > uint64(len(buf)) {
+		return fmt.Errorf("UpdateInBuffer: IFD at %d extends past end of buffer", pos)
+	}
+	for i := uint32(0); i < uint32(count); i++ {
+		epos := pos + 2 + i*TableEntrySize
+		if Tag(p.order.Uint16(buf[epos:])) != tag {
+			continue
+		}
+		entry, dataPos, err := p.entry(epos)
+		if err != nil {
+			return fmt.Errorf("UpdateInBuffer: %v", err)
+		}
+		if entry.Type != field.Type || entry.Count != field.Count {
+			return fmt.Errorf("UpdateInBuffer: field %d(0x%X) has changed from %d %s values to %d %s values", tag, tag, entry.Count, entry.Type.Name(), field.Count, field.Type.Name())
+		}
+		size := field.Size()
+		if uint64(len(field.Data)) < uint64(size) {
+			return fmt.Errorf("UpdateInBuffer: field %d(0x%X) data isn't loaded or is too short", tag, tag)
+		}
+		copy(buf[dataPos:dataPos+size], field.Data)
+		return nil
+	}
+	return fmt.Errorf("UpdateInBuffer: field %d(0x%X) not found in IFD at %d", tag, tag, pos)
+}
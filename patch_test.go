@@ -0,0 +1,163 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+	"time"
+)
+
+func TestPatchIFDTree(t *testing.T) {
+	order := binary.LittleEndian
+	root, err := EncodeImage(image.NewGray(image.Rect(0, 0, 8, 8)), &EncodeOptions{Order: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.SetShorts(Orientation, []uint16{1})
+	root.SetASCII(Software, "Some software or other")
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = order
+	exif.SetLongs(ISOSpeed, []uint32{100})
+	if err := root.SetExifIFD(exif); err != nil {
+		t.Fatal(err)
+	}
+	next, err := EncodeImage(image.NewGray(image.Rect(0, 0, 4, 4)), &EncodeOptions{Order: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Next = next
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	orig := out.Bytes()
+	decode := func(buf []byte) *IFDNode {
+		node, err := GetIFDTree(buf, order, order.Uint32(buf[4:]), TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+
+	buf := append([]byte(nil), orig...)
+	node := decode(buf)
+	// Field data isn't modified in place, so that the changes are
+	// made by PatchIFDTree.
+	node.Detach()
+	node.SetShorts(Orientation, []uint16{6})
+	node.SetASCII(Software, "Other")
+	node.SubIFDs[0].Node.SetLongs(ISOSpeed, []uint32{400})
+	node.GetImageData()[0].Segments[0][0] = 0x80
+	if err := PatchIFDTree(buf, node, order.Uint32(buf[4:])); err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != len(orig) {
+		t.Fatal("Buffer size changed")
+	}
+	changed := 0
+	for i := range buf {
+		if buf[i] != orig[i] {
+			changed++
+		}
+	}
+	// Orientation, Software count and data, ISOSpeed and a pixel.
+	if changed > 10 {
+		t.Errorf("%d bytes changed", changed)
+	}
+	patched := decode(buf)
+	if v, _ := patched.GetShorts(Orientation); v[0] != 6 {
+		t.Error("Orientation wasn't patched")
+	}
+	if s, _ := patched.GetASCII(Software); s != "Other" {
+		t.Errorf("Software patched to %q", s)
+	}
+	if v, _ := patched.SubIFDs[0].Node.GetLongs(ISOSpeed); v[0] != 400 {
+		t.Error("ISOSpeed wasn't patched")
+	}
+	if patched.GetImageData()[0].Segments[0][0] != 0x80 {
+		t.Error("Image data wasn't patched")
+	}
+	if patched.Next == nil {
+		t.Error("Next IFD was lost")
+	}
+
+	// Changes that can't be made in place.
+	buf = append([]byte(nil), orig...)
+	node = decode(buf)
+	node.Detach()
+	node.SetASCII(Software, "Some longer software or other")
+	if err := PatchIFDTree(buf, node, order.Uint32(buf[4:])); err == nil {
+		t.Error("Grown field didn't cause an error")
+	}
+	node = decode(buf)
+	node.SetASCII(Artist, "Someone")
+	if err := PatchIFDTree(buf, node, order.Uint32(buf[4:])); err == nil {
+		t.Error("Added field didn't cause an error")
+	}
+	if !bytes.Equal(buf, orig) {
+		t.Error("Buffer modified after error")
+	}
+
+	// Removing the next IFD only clears its pointer.
+	node = decode(buf)
+	node.Next = nil
+	if err := PatchIFDTree(buf, node, order.Uint32(buf[4:])); err != nil {
+		t.Fatal(err)
+	}
+	if decode(buf).Next != nil {
+		t.Error("Next IFD wasn't removed")
+	}
+}
+
+func TestUpdateInBuffer(t *testing.T) {
+	order := binary.BigEndian
+	root, err := EncodeImage(image.NewGray(image.Rect(0, 0, 8, 8)), &EncodeOptions{Order: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.SetShorts(Orientation, []uint16{1})
+	if err := root.SetDateTime(DateTime, time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC), false); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	node, err := GetIFDTree(buf, order, order.Uint32(buf[4:]), TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Detach()
+	node.SetShorts(Orientation, []uint16{8})
+	if err := node.SetDateTime(DateTime, time.Date(2019, 12, 31, 23, 59, 58, 0, time.UTC), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []Tag{Orientation, DateTime} {
+		if err := UpdateInBuffer(buf, node, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updated, err := GetIFDTree(buf, order, order.Uint32(buf[4:]), TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := updated.GetShorts(Orientation); v[0] != 8 {
+		t.Error("Orientation wasn't updated")
+	}
+	if s, _ := updated.GetASCII(DateTime); s != "2019:12:31 23:59:58" {
+		t.Errorf("DateTime updated to %q", s)
+	}
+
+	node.SetASCII(DateTime, "Too long to fit")
+	if err := UpdateInBuffer(buf, node, DateTime); err == nil {
+		t.Error("Changed size didn't cause an error")
+	}
+	if err := UpdateInBuffer(buf, node, Artist); err == nil {
+		t.Error("Missing field didn't cause an error")
+	}
+	if err := UpdateInBuffer(buf, root, Orientation); err == nil {
+		t.Error("Node that wasn't decoded didn't cause an error")
+	}
+}
@@ -0,0 +1,207 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Mappings from PhaseOne tags to strings, as named by Exiftool.
+var PhaseOneTagNames = map[Tag]string{
+	0x0100: "CameraOrientation",
+	0x0102: "SerialNumber",
+	0x0105: "ISO",
+	0x0106: "ColorMatrix1",
+	0x0107: "WB_RGBLevels",
+	0x0108: "SensorWidth",
+	0x0109: "SensorHeight",
+	0x010A: "SensorLeftMargin",
+	0x010B: "SensorTopMargin",
+	0x010C: "ImageWidth",
+	0x010D: "ImageHeight",
+	0x010E: "RawFormat",
+	0x010F: "RawData",
+	0x0110: "SensorCalibration",
+	0x0112: "DateTimeOriginal",
+	0x0113: "ImageNumber",
+	0x0203: "Software",
+	0x0204: "System",
+	0x0210: "SensorTemperature",
+	0x0211: "SensorTemperature2",
+	0x021C: "StripOffsets",
+	0x021D: "BlackLevel",
+	0x0222: "SplitColumn",
+	0x0223: "BlackLevelColumns",
+	0x0226: "ColorMatrix2",
+	0x0301: "FirmwareVersions",
+	0x0400: "ShutterSpeedValue",
+	0x0401: "ApertureValue",
+	0x0402: "ExposureCompensation",
+	0x0403: "FocalLength",
+	0x0410: "CameraModel",
+	0x0412: "LensModel",
+	0x0414: "MaxApertureValue",
+	0x0415: "MinApertureValue",
+}
+
+// Size of the header of a Phase One maker note: a byte order mark of
+// "IIII" or "MMMM", a magic number containing "Raw", and the offset of
+// the directory.
+const phaseOneHeaderSize = 12
+
+// Size of an entry in a Phase One directory: tag, type, size and value
+// or offset, each 4 bytes.
+const phaseOneEntrySize = 16
+
+// Indicate if a maker note is in Phase One format, as found in IIQ
+// raw files.
+func isPhaseOne(src source, pos uint32) bool {
+	head, err := src.data(pos, 8)
+	if err != nil {
+		return false
+	}
+	switch string(head[:4]) {
+	case "IIII":
+		return string(head[5:8]) == "waR"
+	case "MMMM":
+		return string(head[4:7]) == "Raw"
+	}
+	return false
+}
+
+// An entry in a Phase One directory.
+type phaseOneEntry struct {
+	tag  uint32
+	pos  uint32 // Position of the data, relative to the maker note.
+	size uint32
+}
+
+// SpaceRec for Phase One maker notes. These aren't TIFF IFDs: the
+// directory has 32-bit tags and sizes, and offsets are relative to
+// the start of the maker note. The maker note also contains the raw
+// image data, referred to by fields such as RawData and StripOffsets.
+// To keep these offsets valid, the maker note is written as it was
+// read, with only the values of fields that haven't changed size
+// updated. Fields can't be added or removed.
+type PhaseOneSpaceRec struct {
+	size    uint32 // Size of the maker note, from the MakerNote field.
+	data    []byte // The original maker note.
+	entries []phaseOneEntry
+}
+
+func (*PhaseOneSpaceRec) GetSpace() TagSpace {
+	return PhaseOneSpace
+}
+
+func (*PhaseOneSpaceRec) IsMakerNote() bool {
+	return true
+}
+
+func (rec *PhaseOneSpaceRec) nodeSize(node IFDNode) uint64 {
+	return uint64(len(rec.data))
+}
+
+func (*PhaseOneSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	return nil, nil
+}
+
+// Return the field type and count for data of a given Phase One type
+// and size.
+func phaseOneType(ptype, size uint32) (Type, uint32) {
+	switch {
+	case ptype == 1:
+		return ASCII, size
+	case ptype == 2 && size%2 == 0:
+		return SHORT, size / 2
+	case ptype == 4 && size%4 == 0:
+		return LONG, size / 4
+	}
+	return UNDEFINED, size
+}
+
+func (rec *PhaseOneSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	if rec.size < phaseOneHeaderSize {
+		return errors.New("PhaseOne maker note is too short")
+	}
+	data, err := src.data(pos, rec.size)
+	if err != nil {
+		return errors.New("PhaseOne maker note extends past end of input")
+	}
+	rec.data = data
+	if string(data[:4]) == "IIII" {
+		node.Order = binary.LittleEndian
+	} else {
+		node.Order = binary.BigEndian
+	}
+	order := node.Order
+	dirPos := order.Uint32(data[8:])
+	if uint64(dirPos)+8 > uint64(len(data)) {
+		return fmt.Errorf("PhaseOne directory at %d is past end of maker note", dirPos)
+	}
+	count := order.Uint32(data[dirPos:])
+	if uint64(dirPos)+8+uint64(count)*phaseOneEntrySize > uint64(len(data)) {
+		return fmt.Errorf("PhaseOne directory at %d extends past end of maker note", dirPos)
+	}
+	var errs error
+	for i := uint32(0); i < count; i++ {
+		epos := dirPos + 8 + i*phaseOneEntrySize
+		entry := phaseOneEntry{tag: order.Uint32(data[epos:]), size: order.Uint32(data[epos+8:])}
+		ptype := order.Uint32(data[epos+4:])
+		entry.pos = epos + 12
+		if entry.size > 4 {
+			entry.pos = order.Uint32(data[epos+12:])
+		}
+		if uint64(entry.pos)+uint64(entry.size) > uint64(len(data)) {
+			var stop bool
+			if errs, stop = src.addProblem(errs, fmt.Errorf("Data for PhaseOne field %d(0x%X) extends past end of maker note", entry.tag, entry.tag)); stop {
+				return errs
+			}
+			continue
+		}
+		rec.entries = append(rec.entries, entry)
+		if entry.tag > 0xFFFF {
+			// Can't be represented as a field, but will be
+			// preserved.
+			continue
+		}
+		ftype, fcount := phaseOneType(ptype, entry.size)
+		node.Fields = append(node.Fields, Field{Tag(entry.tag), ftype, fcount, data[entry.pos : entry.pos+entry.size]})
+	}
+	return errs
+}
+
+func (*PhaseOneSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return nil
+}
+
+func (rec *PhaseOneSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	if rec.data == nil {
+		return 0, errors.New("PhaseOne maker note can only be written if it was read from a file")
+	}
+	entries := make(map[Tag]phaseOneEntry)
+	for _, entry := range rec.entries {
+		if entry.tag <= 0xFFFF {
+			entries[Tag(entry.tag)] = entry
+		}
+	}
+	if len(node.Fields) != len(entries) {
+		return 0, errors.New("PhaseOne maker note fields can't be added or removed")
+	}
+	copy(buf[pos:], rec.data)
+	for _, field := range node.Fields {
+		entry, ok := entries[field.Tag]
+		if !ok {
+			return 0, fmt.Errorf("PhaseOne field %d(0x%X) can't be added", field.Tag, field.Tag)
+		}
+		size := field.Size()
+		if size != entry.size || uint32(len(field.Data)) < size {
+			return 0, fmt.Errorf("PhaseOne field %d(0x%X) has changed size", field.Tag, field.Tag)
+		}
+		copy(buf[pos+entry.pos:], field.Data[:size])
+	}
+	return pos + uint32(len(rec.data)), nil
+}
+
+func (*PhaseOneSpaceRec) GetImageData() []ImageData {
+	return nil
+}
@@ -0,0 +1,95 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Create a Phase One maker note with external and inline field data
+// and raw image data, and check that it's decoded and its raw data is
+// preserved when repacking.
+func TestPhaseOne(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		note := make([]byte, 80)
+		if order == binary.LittleEndian {
+			copy(note, "IIII\001waR")
+		} else {
+			copy(note, "MMMMRaw\001")
+		}
+		order.PutUint32(note[8:], 12)
+		order.PutUint32(note[12:], 3)
+		entries := []struct{ tag, ptype, size, value uint32 }{
+			{0x0102, 1, 8, 64},
+			{0x0108, 4, 4, 6000},
+			{0x010F, 4, 4, 72},
+		}
+		for i, e := range entries {
+			epos := 20 + i*phaseOneEntrySize
+			order.PutUint32(note[epos:], e.tag)
+			order.PutUint32(note[epos+4:], e.ptype)
+			order.PutUint32(note[epos+8:], e.size)
+			order.PutUint32(note[epos+12:], e.value)
+		}
+		copy(note[64:], "AB123456")
+		copy(note[72:], "RAWDATA!")
+
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = order
+		exif.SetUndefined(makerNote, note)
+		root := NewIFDNode(TIFFSpace)
+		root.Order = order
+		root.SetASCII(Make, "Phase One")
+		if err := root.SetExifIFD(exif); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, HeaderSize+root.TreeSize())
+		PutHeader(buf, order, HeaderSize)
+		if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+
+		getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		getexif := getroot.SubIFDs[0].Node
+		if len(getexif.SubIFDs) != 1 || getexif.SubIFDs[0].Node.GetSpace() != PhaseOneSpace {
+			t.Fatal("PhaseOne maker note not identified")
+		}
+		maker := getexif.SubIFDs[0].Node
+		if s, _ := maker.GetASCII(0x0102); s != "AB123456" {
+			t.Errorf("SerialNumber is %q", s)
+		}
+		if v, _ := maker.GetLongs(0x0108); len(v) != 1 || v[0] != 6000 {
+			t.Errorf("SensorWidth is %v", v)
+		}
+
+		// Change a value, and add a field to the Exif IFD so that
+		// the maker note moves.
+		getroot.Detach()
+		maker.SetLongs(0x0108, []uint32{6100})
+		getexif.SetASCII(0xA420, "Unique ID that moves the maker note")
+		out := make([]byte, HeaderSize+getroot.TreeSize())
+		PutHeader(out, order, HeaderSize)
+		if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		outroot, err := GetIFDTree(out, order, HeaderSize, TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outmaker := outroot.SubIFDs[0].Node.SubIFDs[0].Node
+		if v, _ := outmaker.GetLongs(0x0108); len(v) != 1 || v[0] != 6100 {
+			t.Errorf("SensorWidth was written as %v", v)
+		}
+		data, _ := outroot.SubIFDs[0].Node.GetBytes(makerNote)
+		if string(data[72:80]) != "RAWDATA!" {
+			t.Error("Raw data not preserved")
+		}
+
+		outmaker.SetASCII(0x0102, "Longer serial number")
+		if _, err := outroot.PutIFDTree(make([]byte, HeaderSize+outroot.TreeSize()+32), HeaderSize); err == nil {
+			t.Error("Resized field didn't cause an error")
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Header at the start of the ImageSourceData field written by
+// Photoshop, which holds the document's layers.
+const photoshopDataBlockHeader = "Adobe Photoshop Document Data Block\000"
+
+// A section of an Adobe Photoshop Document Data Block, such as the
+// layer information ("Layr") or global layer mask ("LMsk").
+type PhotoshopSection struct {
+	Key    string // Four characters, as they would appear in a big-endian file.
+	Offset uint32 // Position of the data in the block.
+	Data   []byte // Points into the block.
+}
+
+// Reverse a 4 byte string, as for signatures and keys written in a
+// little-endian file.
+func reverse4(b []byte) string {
+	return string([]byte{b[3], b[2], b[1], b[0]})
+}
+
+// Decode the sections of an Adobe Photoshop Document Data Block, from
+// an ImageSourceData field in a file with the given byte order. The
+// block isn't modified, so it's preserved exactly when the file is
+// rewritten.
+func ParseImageSourceData(block []byte, order binary.ByteOrder) ([]PhotoshopSection, error) {
+	if len(block) < len(photoshopDataBlockHeader) || string(block[:len(photoshopDataBlockHeader)]) != photoshopDataBlockHeader {
+		return nil, errors.New("ParseImageSourceData: not an Adobe Photoshop Document Data Block")
+	}
+	text := func(b []byte) string {
+		if order == binary.LittleEndian {
+			return reverse4(b)
+		}
+		return string(b)
+	}
+	var sections []PhotoshopSection
+	pos := uint32(len(photoshopDataBlockHeader))
+	size := uint32(len(block))
+	for pos < size {
+		if size-pos < 12 {
+			return sections, fmt.Errorf("ParseImageSourceData: section header at %d is truncated", pos)
+		}
+		if sig := text(block[pos : pos+4]); sig != "8BIM" && sig != "8B64" {
+			return sections, fmt.Errorf("ParseImageSourceData: no section signature at %d", pos)
+		}
+		section := PhotoshopSection{Key: text(block[pos+4 : pos+8])}
+		length := order.Uint32(block[pos+8:])
+		section.Offset = pos + 12
+		if length > size-section.Offset {
+			return sections, fmt.Errorf("ParseImageSourceData: section %q extends past end of block", section.Key)
+		}
+		section.Data = block[section.Offset : section.Offset+length]
+		sections = append(sections, section)
+		pos = section.Offset + length
+		// Sections may be padded, with zeros.
+		for pos < size && block[pos] == 0 {
+			pos++
+		}
+	}
+	return sections, nil
+}
+
+// Return the sections of the ImageSourceData field of a node.
+func (node IFDNode) ImageSourceSections() ([]PhotoshopSection, error) {
+	data, ok := node.GetBytes(ImageSourceData)
+	if !ok {
+		return nil, errors.New("ImageSourceSections: ImageSourceData field not found")
+	}
+	return ParseImageSourceData(data, node.Order)
+}
@@ -0,0 +1,77 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Create an Adobe Photoshop Document Data Block with given sections,
+// each padded to a multiple of 4 bytes.
+func testPhotoshopBlock(order binary.ByteOrder, keys []string, data [][]byte) []byte {
+	text := func(s string) []byte {
+		if order == binary.LittleEndian {
+			return []byte(reverse4([]byte(s)))
+		}
+		return []byte(s)
+	}
+	block := []byte(photoshopDataBlockHeader)
+	for i, key := range keys {
+		block = append(block, text("8BIM")...)
+		block = append(block, text(key)...)
+		length := make([]byte, 4)
+		order.PutUint32(length, uint32(len(data[i])))
+		block = append(append(block, length...), data[i]...)
+		for len(block)%4 != 0 {
+			block = append(block, 0)
+		}
+	}
+	return block
+}
+
+func TestImageSourceData(t *testing.T) {
+	keys := []string{"Layr", "LMsk", "Patt"}
+	data := [][]byte{[]byte("layer records"), {1, 2, 3, 4}, []byte("pattern")}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		block := testPhotoshopBlock(order, keys, data)
+		root := NewIFDNode(TIFFSpace)
+		root.Order = order
+		root.SetUndefined(ImageSourceData, block)
+		buf := make([]byte, HeaderSize+root.TreeSize())
+		PutHeader(buf, order, HeaderSize)
+		if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		node, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sections, err := node.ImageSourceSections()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sections) != len(keys) {
+			t.Fatalf("Found %d sections", len(sections))
+		}
+		for i, section := range sections {
+			if section.Key != keys[i] || !bytes.Equal(section.Data, data[i]) {
+				t.Errorf("Section %d is %q: %q", i, section.Key, section.Data)
+			}
+		}
+		// Repacking preserves the block exactly.
+		out := make([]byte, HeaderSize+node.TreeSize())
+		PutHeader(out, order, HeaderSize)
+		if _, err := node.PutIFDTree(out, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(out, block) {
+			t.Error("Block wasn't preserved")
+		}
+		if _, err := ParseImageSourceData(block[:len(block)-8], order); err == nil {
+			t.Error("Truncated block didn't cause an error")
+		}
+	}
+	if _, err := ParseImageSourceData([]byte("Not a Photoshop block"), binary.BigEndian); err == nil {
+		t.Error("Missing header didn't cause an error")
+	}
+}
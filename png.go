@@ -0,0 +1,124 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Signature at the start of a PNG file.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Position and size of a chunk in a PNG file.
+type pngChunk struct {
+	typ  string
+	pos  uint32 // Position of the chunk length.
+	size uint32 // Size of the chunk data.
+}
+
+// Return the chunks in a PNG file.
+func pngChunks(buf []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(buf, pngSignature) {
+		return nil, errors.New("Not a PNG file")
+	}
+	var chunks []pngChunk
+	bufsize := uint32(len(buf))
+	pos := uint32(len(pngSignature))
+	for pos < bufsize {
+		if pos+12 < pos || pos+12 > bufsize {
+			return chunks, errors.New("PNG chunk extends past end of file")
+		}
+		size := binary.BigEndian.Uint32(buf[pos:])
+		if size > bufsize-pos-12 {
+			return chunks, errors.New("PNG chunk extends past end of file")
+		}
+		end := pos + 12 + size
+		chunk := pngChunk{string(buf[pos+4 : pos+8]), pos, size}
+		chunks = append(chunks, chunk)
+		pos = end
+		if chunk.typ == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// Return the TIFF block from the eXIf chunk in a PNG file, or nil if
+// there is none. The returned slice points into buf.
+func GetPNGExif(buf []byte) ([]byte, error) {
+	chunks, err := pngChunks(buf)
+	for _, chunk := range chunks {
+		if chunk.typ == "eXIf" {
+			exif := buf[chunk.pos+8 : chunk.pos+8+chunk.size]
+			// Some software includes the header used in
+			// JPEG APP1 segments, which isn't permitted by
+			// the PNG specification.
+			return bytes.TrimPrefix(exif, ExifHeader), nil
+		}
+	}
+	return nil, err
+}
+
+// Decode the eXIf chunk in a PNG file into an IFDNode tree. Returns a
+// nil node if the file doesn't contain an eXIf chunk.
+func GetPNGExifTree(buf []byte) (*IFDNode, error) {
+	exif, err := GetPNGExif(buf)
+	if exif == nil {
+		return nil, err
+	}
+	return getTIFFTree(exif)
+}
+
+// Return a new PNG file in which the eXIf chunk is replaced by one
+// containing the given TIFF block. If the file has no eXIf chunk, one
+// will be inserted before the first IDAT chunk, as required by the
+// PNG specification.
+func PutPNGExif(buf []byte, exif []byte) ([]byte, error) {
+	chunks, err := pngChunks(buf)
+	if err != nil {
+		return nil, err
+	}
+	start := uint32(0)
+	end := uint32(0)
+	for _, chunk := range chunks {
+		if chunk.typ == "eXIf" {
+			start = chunk.pos
+			end = chunk.pos + 12 + chunk.size
+			break
+		}
+		if chunk.typ == "IDAT" && start == 0 {
+			start = chunk.pos
+			end = start
+		}
+	}
+	if start == 0 {
+		return nil, errors.New("PNG file has no IDAT chunk")
+	}
+	if uint64(len(exif)) > 1<<31-1 {
+		return nil, fmt.Errorf("Exif block size %d too large for PNG chunk", len(exif))
+	}
+	out := make([]byte, 0, len(buf)-int(end-start)+12+len(exif))
+	out = append(out, buf[:start]...)
+	var word [4]byte
+	binary.BigEndian.PutUint32(word[:], uint32(len(exif)))
+	out = append(out, word[:]...)
+	crcStart := len(out)
+	out = append(out, "eXIf"...)
+	out = append(out, exif...)
+	binary.BigEndian.PutUint32(word[:], crc32.ChecksumIEEE(out[crcStart:]))
+	out = append(out, word[:]...)
+	out = append(out, buf[end:]...)
+	return out, nil
+}
+
+// Serialize an IFDNode tree and embed it in a PNG file as for
+// PutPNGExif.
+func PutPNGExifTree(buf []byte, node *IFDNode) ([]byte, error) {
+	exif, err := putTIFFTree(node)
+	if err != nil {
+		return nil, err
+	}
+	return PutPNGExif(buf, exif)
+}
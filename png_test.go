@@ -0,0 +1,63 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// Embed an Exif tree in a PNG file and read it back.
+func TestPNGExif(t *testing.T) {
+	var pngbuf bytes.Buffer
+	if err := png.Encode(&pngbuf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.LittleEndian
+	node.Fields = []Field{{Orientation, SHORT, 1, []byte{6, 0}}}
+	out, err := PutPNGExifTree(pngbuf.Bytes(), node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("PNG file with eXIf chunk not valid: %v", err)
+	}
+	chunks, err := pngChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		if chunk.typ == "eXIf" {
+			data := out[chunk.pos+4 : chunk.pos+8+chunk.size]
+			if binary.BigEndian.Uint32(out[chunk.pos+8+chunk.size:]) != crc32.ChecksumIEEE(data) {
+				t.Error("Wrong CRC in eXIf chunk")
+			}
+		}
+	}
+	getnode, err := GetPNGExifTree(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getnode == nil || getnode.Fields[0].Short(0, getnode.Order) != 6 {
+		t.Fatal("Exif tree not read back")
+	}
+	out2, err := PutPNGExifTree(out, getnode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, out2) {
+		t.Error("eXIf chunk not replaced")
+	}
+}
+
+// A chunk with a length near 2^32 is an error, not a panic.
+func TestPNGChunkOverflow(t *testing.T) {
+	buf := append([]byte{}, pngSignature...)
+	buf = append(buf, 0xFF, 0xFF, 0xFF, 0xFC, 'e', 'X', 'I', 'f')
+	if _, err := GetPNGExif(buf); err == nil {
+		t.Error("Chunk length past end of file not detected")
+	}
+}
@@ -0,0 +1,72 @@
+package tiff66
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// State of PrintTree: the writer and the first error from writing.
+type treePrinter struct {
+	w     io.Writer
+	limit uint32
+	err   error
+}
+
+// Write a line indented by depth levels, unless an error has occurred.
+func (p *treePrinter) line(depth int, format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, "%s%s\n", strings.Repeat("  ", depth), fmt.Sprintf(format, args...))
+	}
+}
+
+// Print a node's fields, image data and sub-IFDs, indented by depth
+// levels, under a heading with a given label.
+func (p *treePrinter) node(node *IFDNode, label string, depth int) {
+	p.line(depth, "%s: %s", label, node)
+	space := node.GetSpace()
+	names := space.TagNames()
+	for _, field := range node.Fields {
+		p.line(depth+1, "%s", field.text(node.Order, names, space.Formatter(field.Tag), p.limit))
+	}
+	for _, id := range node.GetImageData() {
+		p.line(depth+1, "Image data %s: %s, %d bytes", names[id.OffsetTag], plural(id.numSegments(), "segment"), id.Size())
+	}
+	for _, sub := range node.SubIFDs {
+		tagName, found := names[sub.Tag]
+		if !found {
+			tagName = fmt.Sprintf("Unknown %d(0x%X)", sub.Tag, sub.Tag)
+		}
+		p.chain(sub.Node, tagName, depth+1)
+	}
+}
+
+// Print a node and its Next chain, labelling the nodes after the first
+// with their positions in the chain.
+func (p *treePrinter) chain(node *IFDNode, label string, depth int) {
+	for i, n := range node.Chain() {
+		if i == 0 {
+			p.node(n, label, depth)
+		} else {
+			p.node(n, fmt.Sprintf("%s, next %d", label, i), depth)
+		}
+	}
+}
+
+// Print a tree with indentation showing the structure: each IFD is
+// followed by its fields, its image data and then its sub-IFDs, which
+// are labelled with the tags that point to them and indented a further
+// level. The IFDs of the root's Next chain, which are the pages of a
+// multi-page file, are labelled IFD0, IFD1 and so on. Field values are
+// printed as for Field.PrintSpace, up to a given limit (or 0 for no
+// limit).
+func (node *IFDNode) PrintTree(w io.Writer, limit uint32) error {
+	p := treePrinter{w: w, limit: limit}
+	for i, page := range node.Chain() {
+		if i > 0 {
+			p.line(0, "")
+		}
+		p.node(page, fmt.Sprintf("IFD%d", i), 0)
+	}
+	return p.err
+}
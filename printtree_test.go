@@ -0,0 +1,66 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestPrintTree(t *testing.T) {
+	root := grayPage(t, 10)
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = root.Order
+	exif.SetASCII(LensModel, "A lens with a long name")
+	interop := NewIFDNode(InteropSpace)
+	interop.Order = root.Order
+	interop.SetASCII(InteroperabilityIndex, "R98")
+	if err := exif.setSubIFD(InteroperabilityIFD, interop, InteropSpace); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.SetExifIFD(exif); err != nil {
+		t.Fatal(err)
+	}
+	root.Fix()
+	root.Next = grayPage(t, 20)
+	var out bytes.Buffer
+	if err := root.PrintTree(&out, 3); err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	for _, line := range []string{
+		"IFD0: TIFF IFD, " + root.Order.String(),
+		"\n  ExifIFD Long(1) ",
+		"\n  Image data StripOffsets: 1 segment, 16 bytes\n",
+		"\n  ExifIFD: Exif IFD, " + root.Order.String() + ", 2 fields, 1 sub-IFD\n",
+		"\n    LensModel ASCII(24) \"A l\"...\n",
+		"\n    InteroperabilityIFD: Interop IFD, " + root.Order.String() + ", 1 field\n",
+		"\n      InteroperabilityIndex ASCII(4) \"R98\"\n",
+		"\n\nIFD1: TIFF IFD, ",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("Tree doesn't contain %q:\n%s", line, text)
+		}
+	}
+	if strings.Index(text, "LensModel") > strings.Index(text, "IFD1") {
+		t.Error("Exif IFD printed after the next page")
+	}
+
+	// Next chain of a sub-IFD.
+	sub := NewIFDNode(TIFFSpace)
+	sub.Order = binary.BigEndian
+	sub.Next = NewIFDNode(TIFFSpace)
+	sub.Next.Order = binary.BigEndian
+	page := NewIFDNode(TIFFSpace)
+	page.Order = binary.BigEndian
+	if err := page.AddSubIFD(SubIFDs, sub); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := page.PrintTree(&out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\n  SubIFDs, next 1: TIFF IFD") {
+		t.Errorf("Sub-IFD chain not labelled:\n%s", out.String())
+	}
+}
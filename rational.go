@@ -0,0 +1,127 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Return the greatest common divisor of two numbers, or 0 if both are
+// 0.
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Reduce a RATIONAL value to lowest terms. Values with zero
+// denominators are returned unchanged.
+func ReduceRational(num, denom uint32) (uint32, uint32) {
+	if denom == 0 {
+		return num, denom
+	}
+	g := uint32(gcd(uint64(num), uint64(denom)))
+	return num / g, denom / g
+}
+
+// Reduce a SRATIONAL value to lowest terms, with a positive
+// denominator, so that the sign is on the numerator. Values with zero
+// denominators are returned unchanged, as are values such as
+// -2147483648/-1 that can't be represented with a positive
+// denominator.
+func ReduceSRational(num, denom int32) (int32, int32) {
+	if denom == 0 {
+		return num, denom
+	}
+	n, d := int64(num), int64(denom)
+	if d < 0 {
+		n, d = -n, -d
+	}
+	absN := n
+	if absN < 0 {
+		absN = -absN
+	}
+	g := int64(gcd(uint64(absN), uint64(d)))
+	n, d = n/g, d/g
+	if n > math.MaxInt32 || d > math.MaxInt32 {
+		return num, denom
+	}
+	return int32(n), int32(d)
+}
+
+// Return the indexes of the values of a RATIONAL or SRATIONAL field
+// whose denominators are zero, including 0/0, which Exif uses for
+// unknown values. Values past the end of the field's data are ignored.
+func (f Field) ZeroDenominators(order binary.ByteOrder) []uint32 {
+	var zeros []uint32
+	for i := uint32(0); i < f.rationalCount(); i++ {
+		if _, denom := f.AnyRational(i, order); denom == 0 {
+			zeros = append(zeros, i)
+		}
+	}
+	return zeros
+}
+
+// Return the number of values of a field if it's a rational type,
+// limited to those present in its data, or 0 for other types.
+func (f Field) rationalCount() uint32 {
+	if !f.Type.IsRational() {
+		return 0
+	}
+	if n := uint32(len(f.Data) / 8); n < f.Count {
+		return n
+	}
+	return f.Count
+}
+
+// Apply a function to the values of a rational field, replacing the
+// field's data with a changed copy if any value changes, so that data
+// shared with the input or with other fields isn't modified. Return
+// whether any value was changed.
+func (f *Field) mapRationals(order binary.ByteOrder, fn func(num, denom int64) (int64, int64)) bool {
+	var changed Field
+	for i := uint32(0); i < f.rationalCount(); i++ {
+		num, denom := f.AnyRational(i, order)
+		newNum, newDenom := fn(num, denom)
+		if newNum == num && newDenom == denom {
+			continue
+		}
+		if changed.Data == nil {
+			changed = *f
+			changed.Data = append([]byte(nil), f.Data...)
+		}
+		changed.PutAnyRational(newNum, newDenom, i, order)
+	}
+	if changed.Data == nil {
+		return false
+	}
+	f.Data = changed.Data
+	return true
+}
+
+// Reduce the values of a RATIONAL or SRATIONAL field to lowest terms,
+// as for ReduceRational and ReduceSRational, returning whether any
+// were changed.
+func (f *Field) ReduceRationals(order binary.ByteOrder) bool {
+	signed := f.Type == SRATIONAL
+	return f.mapRationals(order, func(num, denom int64) (int64, int64) {
+		if signed {
+			n, d := ReduceSRational(int32(num), int32(denom))
+			return int64(n), int64(d)
+		}
+		n, d := ReduceRational(uint32(num), uint32(denom))
+		return int64(n), int64(d)
+	})
+}
+
+// Replace the values of a RATIONAL or SRATIONAL field that have zero
+// denominators and nonzero numerators, which are undefined, with 0/0,
+// which Exif uses for unknown values. Return whether any were changed.
+func (f *Field) RepairZeroDenominators(order binary.ByteOrder) bool {
+	return f.mapRationals(order, func(num, denom int64) (int64, int64) {
+		if denom == 0 {
+			return 0, 0
+		}
+		return num, denom
+	})
+}
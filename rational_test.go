@@ -0,0 +1,75 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestReduceRational(t *testing.T) {
+	for _, c := range [][4]uint32{
+		{10, 1250, 1, 125},
+		{0, 5, 0, 1},
+		{7, 0, 7, 0},
+		{0, 0, 0, 0},
+		{math.MaxUint32, math.MaxUint32, 1, 1},
+	} {
+		if n, d := ReduceRational(c[0], c[1]); n != c[2] || d != c[3] {
+			t.Errorf("ReduceRational(%d, %d) returned %d/%d", c[0], c[1], n, d)
+		}
+	}
+	for _, c := range [][4]int32{
+		{4, -6, -2, 3},
+		{-4, -6, 2, 3},
+		{-4, 6, -2, 3},
+		{3, 0, 3, 0},
+		{math.MinInt32, -2, 1 << 30, 1},
+		{math.MinInt32, -1, math.MinInt32, -1},
+		{1, math.MinInt32, 1, math.MinInt32},
+	} {
+		if n, d := ReduceSRational(c[0], c[1]); n != c[2] || d != c[3] {
+			t.Errorf("ReduceSRational(%d, %d) returned %d/%d", c[0], c[1], n, d)
+		}
+	}
+}
+
+func TestFixRationals(t *testing.T) {
+	order := binary.BigEndian
+	buf := make([]byte, 24)
+	exposure := Field{ExposureTime, RATIONAL, 3, buf}
+	exposure.PutRational(10, 1250, 0, order)
+	exposure.PutRational(5, 0, 1, order)
+	exposure.PutRational(0, 0, 2, order)
+	bias := Field{ExposureBiasValue, SRATIONAL, 1, make([]byte, 8)}
+	bias.PutSRational(2, -6, 0, order)
+	original := append([]byte(nil), buf...)
+	if zeros := exposure.ZeroDenominators(order); len(zeros) != 2 || zeros[0] != 1 || zeros[1] != 2 {
+		t.Errorf("Zero denominators found at %v", zeros)
+	}
+	node := NewIFDNode(ExifSpace)
+	node.Order = order
+	node.Fields = []Field{exposure, bias}
+	node.Fix()
+	if f := node.MustField(ExposureTime); !bytes.Equal(f.Data, original) {
+		t.Error("Fix normalized rationals by default")
+	}
+	if err := node.FixWithOptions(&FixOptions{ReduceRationals: true, RepairZeroDenominators: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, original) {
+		t.Error("Shared field data was modified")
+	}
+	f := node.MustField(ExposureTime)
+	for i, expected := range [][2]uint32{{1, 125}, {0, 0}, {0, 0}} {
+		if n, d := f.Rational(uint32(i), order); n != expected[0] || d != expected[1] {
+			t.Errorf("ExposureTime value %d is %d/%d", i, n, d)
+		}
+	}
+	if n, d := node.MustField(ExposureBiasValue).SRational(0, order); n != -1 || d != 3 {
+		t.Errorf("ExposureBiasValue is %d/%d", n, d)
+	}
+	if f.ReduceRationals(order) || f.RepairZeroDenominators(order) {
+		t.Error("Normalized field was changed again")
+	}
+}
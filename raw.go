@@ -0,0 +1,345 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The size of a Canon CR2 header: a TIFF header followed by "CR", a
+// major and minor version number (2 and 0), and the position of the
+// raw IFD.
+const CR2HeaderSize = 16
+
+var cr2Magic = []byte("CR")
+
+// Index of the raw image IFD in the Next chain of a CR2 file.
+const cr2RawIFDIndex = 3
+
+// Tags found in the raw IFD of CR2 files, as named by Exiftool.
+const (
+	CR2CFAPattern = 0xC5E0
+	CR2Slice      = 0xC640 // Widths of the slices of the raw image.
+	SRawType      = 0xC6C5
+)
+
+// Mappings from tags in CR2 IFDs to strings, including all of
+// TagNames.
+var CR2TagNames = mergeTagNames(TagNames, map[Tag]string{
+	CR2CFAPattern: "CR2CFAPattern",
+	CR2Slice:      "CR2Slice",
+	SRawType:      "SRawType",
+})
+
+// Try to read a Canon CR2 header from a slice. Returns an indication
+// of validity, the byte order, the position of the 0th IFD, and the
+// position of the raw IFD. A CR2 file is also a valid TIFF file and
+// can be decoded with GetHeader and GetIFDTree, but the raw IFD
+// position is only found in the CR2 header.
+func GetCR2Header(buf []byte) (bool, binary.ByteOrder, uint32, uint32) {
+	valid, order, ifdPos := GetHeader(buf)
+	if !valid || len(buf) < CR2HeaderSize || string(buf[8:10]) != string(cr2Magic) || buf[10] != 2 {
+		return false, order, 0, 0
+	}
+	return true, order, ifdPos, order.Uint32(buf[12:])
+}
+
+// Create a CR2 header at the beginning of a byte slice with given
+// byte ordering, position of the 0th IFD and position of the raw
+// IFD. CR2HeaderSize bytes will be used.
+func PutCR2Header(buf []byte, order binary.ByteOrder, ifdPos, rawIFDPos uint32) {
+	PutHeader(buf, order, ifdPos)
+	copy(buf[8:], cr2Magic)
+	buf[10] = 2
+	buf[11] = 0
+	order.PutUint32(buf[12:], rawIFDPos)
+}
+
+// Return the IFD holding the raw sensor data in a tree decoded from a
+// CR2 file, which is the fourth IFD in the Next chain, or nil if not
+// found. Its image data is the compressed raw image.
+func (node *IFDNode) CR2RawIFD() *IFDNode {
+	for i := 0; node != nil && i < cr2RawIFDIndex; i++ {
+		node = node.Next
+	}
+	return node
+}
+
+// Return the position of the nth IFD following the one at pos in the
+// Next chain of a serialized tree.
+func nthIFDPos(buf []byte, order binary.ByteOrder, pos uint32, n int) (uint32, error) {
+	for i := 0; i < n; i++ {
+		if pos+2 < pos || pos+2 > uint32(len(buf)) {
+			return 0, fmt.Errorf("IFD at %d past end of buffer", pos)
+		}
+		nextPos := pos + TableSize(order.Uint16(buf[pos:])) - 4
+		if nextPos < pos || nextPos+4 > uint32(len(buf)) {
+			return 0, fmt.Errorf("IFD at %d extends past end of buffer", pos)
+		}
+		pos = order.Uint32(buf[nextPos:])
+		if pos == 0 {
+			return 0, errors.New("Next chain too short")
+		}
+	}
+	return pos, nil
+}
+
+// Serialize an IFD tree as a CR2 file, including the header, into a
+// byte slice, which must have space for CR2HeaderSize +
+// node.TreeSize() bytes. The raw IFD position in the header is set
+// to the position of the fourth IFD in the Next chain. Returns the
+// position following the last byte used.
+func (node IFDNode) PutCR2IFDTree(buf []byte) (uint32, error) {
+	next, err := node.PutIFDTree(buf, CR2HeaderSize)
+	if err != nil {
+		return 0, err
+	}
+	rawPos, err := nthIFDPos(buf, node.Order, CR2HeaderSize, cr2RawIFDIndex)
+	if err != nil {
+		return 0, fmt.Errorf("Can't find CR2 raw IFD: %s", err)
+	}
+	PutCR2Header(buf, node.Order, CR2HeaderSize, rawPos)
+	return next, nil
+}
+
+// Compression value for Nikon's compressed NEF raw data.
+const NikonNEFCompression = 34713
+
+// PhotometricInterpretation value for color filter array sensor data,
+// from TIFF/EP.
+const PhotometricCFA = 32803
+
+// TIFF/EP tags found in the raw IFDs of Nikon NEF files.
+const (
+	CFARepeatPatternDim = 0x828D
+	CFAPattern2         = 0x828E
+	BatteryLevel        = 0x828F
+	TIFFEPStandardID    = 0x9216
+	TIFFEPSensingMethod = 0x9217
+)
+
+// Mappings from tags in NEF IFDs to strings, including all of
+// TagNames.
+var NEFTagNames = mergeTagNames(TagNames, map[Tag]string{
+	CFARepeatPatternDim: "CFARepeatPatternDim",
+	CFAPattern2:         "CFAPattern2",
+	BatteryLevel:        "BatteryLevel",
+	TIFFEPStandardID:    "TIFFEPStandardID",
+	TIFFEPSensingMethod: "SensingMethod",
+})
+
+// Mappings from tags in TIFF IFDs to strings, as returned by
+// TIFFSpace.TagNames. CR2 and NEF raw IFDs are decoded in TIFFSpace,
+// so their tags are included.
+var tiffSpaceTagNames = mergeTagNames(CR2TagNames, NEFTagNames)
+
+// Return the SubIFD of node that holds a raw image, with a
+// NewSubfileType of 0 and color filter array photometric
+// interpretation, or nil if none.
+func (node *IFDNode) cfaSubIFD() *IFDNode {
+	for _, sub := range node.SubIFDs {
+		if sub.Tag != SubIFDs {
+			continue
+		}
+		subType, _ := sub.Node.integerField(NewSubfileType)
+		photometric, _ := sub.Node.integerField(PhotometricInterpretation)
+		if subType == 0 && photometric == PhotometricCFA {
+			return sub.Node
+		}
+	}
+	return nil
+}
+
+// Return the IFD holding the raw sensor data in a tree decoded from a
+// Nikon NEF file, or nil if not found. The raw image is in one of the
+// SubIFDs of IFD 0, with a NewSubfileType of 0 and color filter array
+// photometric interpretation; IFD 0 itself holds a thumbnail. Its
+// image data is the raw image strips.
+func (node *IFDNode) NEFRawIFD() *IFDNode {
+	return node.cfaSubIFD()
+}
+
+// Compression value for Sony's ARW raw data.
+const SonyARWCompression = 32767
+
+// Return node if it holds a raw image with color filter array
+// photometric interpretation, otherwise the SubIFD that does, or nil
+// if none.
+func (node *IFDNode) cfaIFD() *IFDNode {
+	if photometric, _ := node.integerField(PhotometricInterpretation); photometric == PhotometricCFA {
+		return node
+	}
+	return node.cfaSubIFD()
+}
+
+// Return the IFD holding the raw sensor data in a tree decoded from a
+// Sony ARW file, or nil if not found. In most ARW files the raw image
+// is in a SubIFD of IFD 0, as for NEF, but in some early versions
+// it's in IFD 0 itself.
+func (node *IFDNode) ARWRawIFD() *IFDNode {
+	return node.cfaIFD()
+}
+
+// In Sony ARW and SR2 files, tag 0xC634 (DNGPrivateData in DNG files)
+// in IFD 0 is a pointer to the SR2Private IFD.
+const SR2Private = 0xC634
+
+// Tags in SR2Private IFDs.
+const (
+	SR2SubIFDOffset = 0x7200 // Encrypted SR2SubIFD, stored as image data.
+	SR2SubIFDLength = 0x7201
+	SR2SubIFDKey    = 0x7221
+	IDCIFD          = 0x7240 // Image Data Converter IFDs.
+	IDC2IFD         = 0x7241
+	MRWInfo         = 0x7250
+)
+
+// Mappings from SR2Private tags to strings.
+var SR2PrivateTagNames = map[Tag]string{
+	SR2SubIFDOffset: "SR2SubIFDOffset",
+	SR2SubIFDLength: "SR2SubIFDLength",
+	SR2SubIFDKey:    "SR2SubIFDKey",
+	IDCIFD:          "IDC_IFD",
+	IDC2IFD:         "IDC2_IFD",
+	MRWInfo:         "MRWInfo",
+}
+
+// Tags in Sony IDC IFDs, which are written by Sony's Image Data
+// Converter software.
+const (
+	IDCPreviewStart  = 0x201
+	IDCPreviewLength = 0x202
+)
+
+// Mappings from Sony IDC tags to strings.
+var SonyIDCTagNames = map[Tag]string{
+	IDCPreviewStart:  "IDCPreviewStart",
+	IDCPreviewLength: "IDCPreviewLength",
+}
+
+// Indicate if a field in a TIFF IFD is a pointer to an SR2Private IFD.
+func isSR2PrivatePointer(field Field) bool {
+	return field.Tag == SR2Private && (field.Type == LONG || field.Type == IFD) && field.Count == 1
+}
+
+// SpaceRec for SR2Private nodes, found in Sony ARW and SR2 files. The
+// encrypted SR2SubIFD isn't decoded, but is retained as image data so
+// that it's preserved when the file is rewritten.
+type SR2PrivateSpaceRec struct {
+	offsetField, sizeField Field
+	imageData              []ImageData
+}
+
+func (*SR2PrivateSpaceRec) GetSpace() TagSpace {
+	return SR2PrivateSpace
+}
+
+func (*SR2PrivateSpaceRec) IsMakerNote() bool {
+	return false
+}
+
+func (*SR2PrivateSpaceRec) nodeSize(node IFDNode) uint64 {
+	return node.genericSize()
+}
+
+func (rec *SR2PrivateSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	switch field.Tag {
+	case IDCIFD, IDC2IFD:
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(SonyIDCSpace))
+	case SR2SubIFDOffset:
+		rec.offsetField = field
+	case SR2SubIFDLength:
+		rec.sizeField = field
+	}
+	if rec.offsetField.Tag != 0 && rec.sizeField.Tag != 0 {
+		imageData, err := newImageData(src, order, rec.offsetField, rec.sizeField)
+		rec.offsetField = Field{}
+		rec.sizeField = Field{}
+		if err != nil {
+			return nil, err
+		}
+		rec.imageData = append(rec.imageData, *imageData)
+	}
+	return nil, nil
+}
+
+func (*SR2PrivateSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
+}
+
+func (*SR2PrivateSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
+}
+
+func (*SR2PrivateSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	return node.genericPutIFDTree(buf, pos)
+}
+
+func (rec *SR2PrivateSpaceRec) GetImageData() []ImageData {
+	return rec.imageData
+}
+
+// SpaceRec for Sony IDC nodes, referred to by SR2Private IFDs. The
+// preview image is retained as image data.
+type SonyIDCSpaceRec struct {
+	offsetField, sizeField Field
+	imageData              []ImageData
+}
+
+func (*SonyIDCSpaceRec) GetSpace() TagSpace {
+	return SonyIDCSpace
+}
+
+func (*SonyIDCSpaceRec) IsMakerNote() bool {
+	return false
+}
+
+func (*SonyIDCSpaceRec) nodeSize(node IFDNode) uint64 {
+	return node.genericSize()
+}
+
+func (rec *SonyIDCSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	switch field.Tag {
+	case IDCPreviewStart:
+		rec.offsetField = field
+	case IDCPreviewLength:
+		rec.sizeField = field
+	}
+	if rec.offsetField.Tag != 0 && rec.sizeField.Tag != 0 {
+		imageData, err := newImageData(src, order, rec.offsetField, rec.sizeField)
+		rec.offsetField = Field{}
+		rec.sizeField = Field{}
+		if err != nil {
+			return nil, err
+		}
+		rec.imageData = append(rec.imageData, *imageData)
+	}
+	return nil, nil
+}
+
+func (*SonyIDCSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
+}
+
+func (*SonyIDCSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
+}
+
+func (*SonyIDCSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
+	return node.genericPutIFDTree(buf, pos)
+}
+
+func (rec *SonyIDCSpaceRec) GetImageData() []ImageData {
+	return rec.imageData
+}
+
+// Compression value for Pentax's compressed PEF raw data.
+const PentaxPEFCompression = 65535
+
+// Return the IFD holding the raw sensor data in a tree decoded from a
+// Pentax PEF file, or nil if not found. The raw image is usually in
+// IFD 0, and its image data is the raw image strips. Preview images
+// are found in the Pentax1 maker note.
+func (node *IFDNode) PEFRawIFD() *IFDNode {
+	return node.cfaIFD()
+}
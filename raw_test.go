@@ -0,0 +1,272 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Create a CR2 file with four IFDs in the Next chain and check that
+// the raw IFD is found via the header and the tree.
+func TestCR2(t *testing.T) {
+	order := binary.LittleEndian
+	raw := []byte("raw sensor data")
+	var nodes [4]*IFDNode
+	for i := range nodes {
+		nodes[i] = NewIFDNode(TIFFSpace)
+		nodes[i].Order = order
+		nodes[i].Fields = []Field{{ImageWidth, SHORT, 1, []byte{byte(i), 0}}}
+		if i > 0 {
+			nodes[i-1].Next = nodes[i]
+		}
+	}
+	nodes[3].Fields = []Field{
+		{StripOffsets, LONG, 1, make([]byte, 4)},
+		{StripByteCounts, LONG, 1, make([]byte, 4)},
+		{CR2Slice, SHORT, 3, []byte{1, 0, 5, 0, 5, 0}},
+	}
+	nodes[3].Fields[1].PutLong(uint32(len(raw)), 0, order)
+	nodes[3].SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{raw}}}}
+	buf := make([]byte, CR2HeaderSize+nodes[0].TreeSize())
+	if _, err := nodes[0].PutCR2IFDTree(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	valid, getorder, pos, rawPos := GetCR2Header(buf)
+	if !valid || getorder != order || pos != CR2HeaderSize {
+		t.Fatal("CR2 header not valid")
+	}
+	if valid, _, _ := GetHeader(buf); !valid {
+		t.Error("CR2 header not valid as TIFF header")
+	}
+	root, err := GetIFDTree(buf, getorder, pos, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawIFD := root.CR2RawIFD()
+	if rawIFD == nil {
+		t.Fatal("Raw IFD not found")
+	}
+	imageData := rawIFD.GetImageData()
+	if len(imageData) != 1 || string(imageData[0].Segments[0]) != string(raw) {
+		t.Error("Raw image data not found")
+	}
+	fromHeader, err := GetIFDTree(buf, getorder, rawPos, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fromHeader.Fields) != 3 || fromHeader.Fields[2].Tag != CR2Slice {
+		t.Error("Raw IFD position in header is incorrect")
+	}
+	if valid, _, _, _ := GetCR2Header(buf[:HeaderSize]); valid {
+		t.Error("Truncated CR2 header accepted")
+	}
+	if name := TIFFSpace.TagNames()[CR2Slice]; name != "CR2Slice" {
+		t.Errorf("CR2Slice named %q", name)
+	}
+}
+
+// Create a NEF-like tree with the raw image in a SubIFD, and check
+// that the raw IFD is found and survives repacking.
+func TestNEF(t *testing.T) {
+	order := binary.BigEndian
+	raw := []byte("raw sensor data")
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.Fields = []Field{
+		{NewSubfileType, LONG, 1, []byte{0, 0, 0, 1}},
+		{SubIFDs, LONG, 1, make([]byte, 4)},
+	}
+	sub := NewIFDNode(TIFFSpace)
+	sub.Order = order
+	sub.Fields = []Field{
+		{NewSubfileType, LONG, 1, []byte{0, 0, 0, 0}},
+		{Compression, SHORT, 1, []byte{0x87, 0x99}},
+		{PhotometricInterpretation, SHORT, 1, []byte{0x80, 0x23}},
+		{StripOffsets, LONG, 1, make([]byte, 4)},
+		{StripByteCounts, LONG, 1, make([]byte, 4)},
+		{CFARepeatPatternDim, SHORT, 2, []byte{0, 2, 0, 2}},
+	}
+	sub.Fields[4].PutLong(uint32(len(raw)), 0, order)
+	sub.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{raw}}}}
+	root.SubIFDs = []SubIFD{{SubIFDs, sub}}
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawIFD := getroot.NEFRawIFD()
+	if rawIFD == nil {
+		t.Fatal("Raw IFD not found")
+	}
+	if c, _ := rawIFD.integerField(Compression); c != NikonNEFCompression {
+		t.Error("Wrong compression in raw IFD")
+	}
+	getroot.Fix()
+	out := make([]byte, HeaderSize+getroot.TreeSize())
+	PutHeader(out, order, HeaderSize)
+	if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(buf) {
+		t.Error("Repacked NEF differs from original")
+	}
+	if name := TIFFSpace.TagNames()[CFAPattern2]; name != "CFAPattern2" {
+		t.Errorf("CFAPattern2 named %q", name)
+	}
+}
+
+// Create an ARW-like tree with a raw SubIFD and an SR2Private IFD
+// that refers to an IDC IFD, and check that it's decoded and
+// survives repacking.
+func TestARW(t *testing.T) {
+	order := binary.LittleEndian
+	raw := []byte("raw sensor data")
+	sr2 := []byte("encrypted SR2SubIFD")
+	preview := []byte("IDC preview")
+	imageNode := func(space TagSpace, offsetTag, sizeTag Tag, data []byte, fields ...Field) *IFDNode {
+		node := NewIFDNode(space)
+		node.Order = order
+		size := make([]byte, 4)
+		order.PutUint32(size, uint32(len(data)))
+		node.Fields = append(fields, Field{offsetTag, LONG, 1, make([]byte, 4)}, Field{sizeTag, LONG, 1, size})
+		node.Fix()
+		id := []ImageData{{OffsetTag: offsetTag, SizeTag: sizeTag, Segments: []ImageSegment{data}}}
+		switch rec := node.SpaceRec.(type) {
+		case *TIFFSpaceRec:
+			rec.imageData = id
+		case *SR2PrivateSpaceRec:
+			rec.imageData = id
+		case *SonyIDCSpaceRec:
+			rec.imageData = id
+		}
+		return node
+	}
+	idc := imageNode(SonyIDCSpace, IDCPreviewStart, IDCPreviewLength, preview)
+	private := imageNode(SR2PrivateSpace, SR2SubIFDOffset, SR2SubIFDLength, sr2,
+		Field{SR2SubIFDKey, LONG, 1, []byte{1, 2, 3, 4}},
+		Field{IDCIFD, LONG, 1, make([]byte, 4)})
+	private.SubIFDs = []SubIFD{{IDCIFD, idc}}
+	rawIFD := imageNode(TIFFSpace, StripOffsets, StripByteCounts, raw,
+		Field{NewSubfileType, LONG, 1, []byte{0, 0, 0, 0}},
+		Field{Compression, SHORT, 1, []byte{0xFF, 0x7F}},
+		Field{PhotometricInterpretation, SHORT, 1, []byte{0x23, 0x80}})
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	root.Fields = []Field{
+		{SubIFDs, LONG, 1, make([]byte, 4)},
+		{SR2Private, LONG, 1, make([]byte, 4)},
+	}
+	root.SubIFDs = []SubIFD{{SubIFDs, rawIFD}, {SR2Private, private}}
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getroot.ARWRawIFD() == nil {
+		t.Error("Raw IFD not found")
+	}
+	if len(getroot.SubIFDs) != 2 || getroot.SubIFDs[1].Node.GetSpace() != SR2PrivateSpace {
+		t.Fatal("SR2Private IFD not found")
+	}
+	getprivate := getroot.SubIFDs[1].Node
+	if id := getprivate.GetImageData(); len(id) != 1 || string(id[0].Segments[0]) != string(sr2) {
+		t.Error("SR2SubIFD not found")
+	}
+	if len(getprivate.SubIFDs) != 1 || getprivate.SubIFDs[0].Node.GetSpace() != SonyIDCSpace {
+		t.Fatal("IDC IFD not found")
+	}
+	if id := getprivate.SubIFDs[0].Node.GetImageData(); len(id) != 1 || string(id[0].Segments[0]) != string(preview) {
+		t.Error("IDC preview not found")
+	}
+	out := make([]byte, HeaderSize+getroot.TreeSize())
+	PutHeader(out, order, HeaderSize)
+	if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(buf) {
+		t.Error("Repacked ARW differs from original")
+	}
+}
+
+// Create PEF-like trees with the raw image in IFD 0 and a preview
+// image in a Pentax1 maker note, with each type of maker note label,
+// and check that they're decoded and survive repacking.
+func TestPEF(t *testing.T) {
+	order := binary.BigEndian
+	raw := []byte("raw sensor data")
+	preview := []byte("preview image")
+	for _, label := range [][]byte{[]byte("AOC\000MM"), []byte("PENTAX \000MM")} {
+		maker := NewIFDNode(Pentax1Space)
+		maker.Order = order
+		maker.Fields = []Field{
+			{pentax1PreviewImageLength, LONG, 1, make([]byte, 4)},
+			{pentax1PreviewImageStart, LONG, 1, make([]byte, 4)},
+		}
+		maker.Fields[0].PutLong(uint32(len(preview)), 0, order)
+		rec := maker.SpaceRec.(*Pentax1SpaceRec)
+		rec.label = label
+		rec.relative = label[0] == 'P'
+		rec.imageData = []ImageData{{OffsetTag: pentax1PreviewImageStart, SizeTag: pentax1PreviewImageLength, Segments: []ImageSegment{preview}}}
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = order
+		exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+		exif.SubIFDs = []SubIFD{{makerNote, maker}}
+		root := NewIFDNode(TIFFSpace)
+		root.Order = order
+		root.Fields = []Field{
+			{Compression, SHORT, 1, []byte{0xFF, 0xFF}},
+			{PhotometricInterpretation, SHORT, 1, []byte{0x80, 0x23}},
+			{StripOffsets, LONG, 1, make([]byte, 4)},
+			{StripByteCounts, LONG, 1, make([]byte, 4)},
+			{ExifIFD, LONG, 1, make([]byte, 4)},
+		}
+		root.Fields[3].PutLong(uint32(len(raw)), 0, order)
+		root.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{raw}}}}
+		root.SubIFDs = []SubIFD{{ExifIFD, exif}}
+		buf := make([]byte, HeaderSize+root.TreeSize())
+		PutHeader(buf, order, HeaderSize)
+		if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+
+		getroot, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawIFD := getroot.PEFRawIFD()
+		if rawIFD != getroot {
+			t.Fatal("Raw IFD not found")
+		}
+		if c, _ := rawIFD.integerField(Compression); c != PentaxPEFCompression {
+			t.Error("Wrong compression in raw IFD")
+		}
+		if len(getroot.SubIFDs) != 1 || len(getroot.SubIFDs[0].Node.SubIFDs) != 1 {
+			t.Fatal("Maker note not found")
+		}
+		getmaker := getroot.SubIFDs[0].Node.SubIFDs[0].Node
+		if getmaker.GetSpace() != Pentax1Space {
+			t.Fatalf("Maker note with label %q not identified", label)
+		}
+		if id := getmaker.GetImageData(); len(id) != 1 || string(id[0].Segments[0]) != string(preview) {
+			t.Error("Preview image not found")
+		}
+		out := make([]byte, HeaderSize+getroot.TreeSize())
+		PutHeader(out, order, HeaderSize)
+		if _, err := getroot.PutIFDTree(out, HeaderSize); err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != string(buf) {
+			t.Errorf("Repacked PEF with label %q differs from original", label)
+		}
+	}
+}
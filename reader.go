@@ -0,0 +1,392 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Input to the IFD tree decoder. It's a window onto a TIFF block,
+// which is either held in memory in a byte slice, or read on demand
+// from an io.ReaderAt.
+type source struct {
+	buf  []byte      // Input data if held in memory, otherwise nil.
+	r    io.ReaderAt // Input data if not held in memory.
+	base uint32      // Position of the window in the original input.
+	size uint32      // Size of the window.
+	// If non-zero, byte-sized field data larger than this isn't
+	// read from a reader until it's needed.
+	lazySize uint32
+	// Parse options and problem count, shared by sub-sources, or
+	// nil for the default lenient parsing.
+	state *parseState
+	// Nesting of the IFD being read.
+	depth int
+	// Correction added to offsets read from IFD entries, for maker
+	// notes whose offsets are relative to the wrong base.
+	delta int64
+}
+
+// Create a source for a byte slice.
+func bufSource(buf []byte) source {
+	return source{buf: buf, size: uint32(len(buf))}
+}
+
+// Create a source for an io.ReaderAt with given size.
+func readerSource(r io.ReaderAt, size uint32) source {
+	return source{r: r, size: size}
+}
+
+// Return the size of the source.
+func (src source) len() uint32 {
+	return src.size
+}
+
+// Indicate if the source is read on demand instead of being held in
+// memory.
+func (src source) isReader() bool {
+	return src.buf == nil && src.r != nil
+}
+
+// Return a new source that starts at pos in an existing source. Used
+// for maker notes with offsets relative to their own start.
+func (src source) sub(pos uint32) source {
+	if pos > src.size {
+		pos = src.size
+	}
+	sub := src
+	sub.base += pos
+	sub.size -= pos
+	sub.delta = 0
+	if src.buf != nil {
+		sub.buf = src.buf[pos:]
+	}
+	return sub
+}
+
+// Return the position in the source of an offset read from an IFD
+// entry, after applying any correction for a maker note with the wrong
+// offset base. Offsets that the correction moves outside the range of
+// positions are returned as math.MaxUint32, which is past the end of
+// any source.
+func (src source) offset(off uint32) uint32 {
+	if src.delta == 0 {
+		return off
+	}
+	pos := int64(off) + src.delta
+	if pos < 0 || pos > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(pos)
+}
+
+// Return size bytes of data at pos. If the source is a byte slice,
+// the result points into the slice, otherwise the data is read into
+// a newly allocated slice.
+func (src source) data(pos, size uint32) ([]byte, error) {
+	if pos+size < pos || pos+size > src.size {
+		return nil, fmt.Errorf("Data at %d with size %d extends past end of input", pos, size)
+	}
+	if src.buf != nil {
+		return src.buf[pos : pos+size], nil
+	}
+	data := make([]byte, size)
+	if _, err := src.r.ReadAt(data, int64(src.base)+int64(pos)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Indicate if the data at pos starts with prefix.
+func (src source) hasPrefix(pos uint32, prefix []byte) bool {
+	data, err := src.data(pos, uint32(len(prefix)))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data, prefix)
+}
+
+// Indicate if the data for a field should be left unread. Only
+// fields with byte-sized types are deferred, since other types may
+// contain pointers or sizes that are needed during decoding.
+func (src source) deferField(field Field) bool {
+	return src.isReader() && src.lazySize > 0 && field.Type.Size() == 1 && field.Size() > src.lazySize
+}
+
+// Adapter to read from an io.ReadSeeker as an io.ReaderAt. Each read
+// seeks to the required position, so it can't be used concurrently.
+type readSeekerAt struct {
+	rs io.ReadSeeker
+}
+
+func (r readSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.rs, p)
+}
+
+// Try to read a TIFF header from the start of a reader. Returns an
+// indication of validity, the byte order, and the position of the 0th
+// IFD, as for GetHeader.
+func GetHeaderReader(r io.ReadSeeker) (bool, binary.ByteOrder, uint32, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, nil, 0, err
+	}
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil, 0, nil
+		}
+		return false, nil, 0, err
+	}
+	valid, order, pos := GetHeader(buf)
+	return valid, order, pos, nil
+}
+
+// Create an IFDNode tree as for GetIFDTree, but reading from r
+// instead of a byte slice, which must represent an entire TIFF file.
+// IFD tables and field data are read on demand, so that the file
+// doesn't need to be held in memory. Image data isn't read: the
+// ImageData in the tree only records the positions and sizes of the
+// segments, which can be read later with ImageData.Load. Field data
+// in the tree doesn't refer to a shared buffer, so modifying it won't
+// affect other fields.
+func GetIFDTreeReader(r io.ReadSeeker, order binary.ByteOrder, pos uint32, space TagSpace) (*IFDNode, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if end > math.MaxUint32 {
+		// Offsets in TIFF files are limited to 32 bits.
+		end = math.MaxUint32
+	}
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		ra = readSeekerAt{r}
+	}
+	ifdPositions := make(posMap)
+	return getIFDTreeIter(readerSource(ra, uint32(end)), order, pos, NewSpaceRec(space), ifdPositions)
+}
+
+// Indicate if the segments of the image data are present. They may
+// not be if the image data was decoded with GetIFDTreeReader.
+func (id ImageData) IsLoaded() bool {
+	return id.Segments != nil || id.reader == nil
+}
+
+// Read the segments of image data that was decoded from a reader
+// with GetIFDTreeReader. Does nothing if the data is already present.
+func (id *ImageData) Load() error {
+	if id.IsLoaded() {
+		return nil
+	}
+	if len(id.Offsets) != len(id.Sizes) {
+		return errors.New("ImageData.Load: number of offsets and sizes don't match")
+	}
+	segments := make([]ImageSegment, len(id.Offsets))
+	for i := range id.Offsets {
+		segments[i] = make([]byte, id.Sizes[i])
+		if _, err := id.reader.ReadAt(segments[i], int64(id.Offsets[i])); err != nil {
+			return err
+		}
+	}
+	id.Segments = segments
+	return nil
+}
+
+// Read the segments of the image data consecutively from r, with the
+// sizes given by Sizes, replacing any loaded segments. Returns the
+// number of bytes read. This is the reverse of WriteTo, for image data
+// that has been processed as a stream, but unlike io.ReaderFrom, it
+// stops reading at the end of the last segment.
+func (id *ImageData) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	segments := make([]ImageSegment, len(id.Sizes))
+	for i, size := range id.Sizes {
+		segments[i] = make([]byte, size)
+		read, err := io.ReadFull(r, segments[i])
+		n += int64(read)
+		if err != nil {
+			return n, err
+		}
+	}
+	id.Segments = segments
+	return n, nil
+}
+
+// Return the number of image data segments, whether loaded or not.
+func (id ImageData) numSegments() int {
+	if id.IsLoaded() {
+		return len(id.Segments)
+	}
+	return len(id.Sizes)
+}
+
+// Return the size of the ith image data segment, whether loaded or not.
+func (id ImageData) segmentSize(i int) uint32 {
+	if id.IsLoaded() {
+		return uint32(len(id.Segments[i]))
+	}
+	return id.Sizes[i]
+}
+
+// Read image data segments that haven't been loaded directly into
+// buf at pos, placing them consecutively.
+func (id ImageData) loadInto(buf []byte, pos uint32) error {
+	for i := range id.Offsets {
+		if _, err := id.reader.ReadAt(buf[pos:pos+id.Sizes[i]], int64(id.Offsets[i])); err != nil {
+			return err
+		}
+		pos += id.Sizes[i]
+	}
+	return nil
+}
+
+// Location of field data that hasn't been read.
+type pendingData struct {
+	tag Tag
+	pos uint32
+	r   io.ReaderAt
+}
+
+// Create an IFDNode tree as for GetIFDTreeReader, but reading from an
+// io.ReaderAt with given size. Field data with a byte-sized type
+// (BYTE, ASCII, SBYTE or UNDEFINED) that's larger than lazySize bytes
+// is also left unread: such fields will have nil Data until loaded
+// with IFDNode.Load or IFDNode.LoadAll. This may reduce the cost of
+// decoding considerably if only some of the metadata is required.
+// Other types aren't deferred, since they may contain pointers or
+// sizes that are needed during decoding.
+func GetIFDTreeLazy(r io.ReaderAt, size int64, order binary.ByteOrder, pos uint32, space TagSpace, lazySize uint32) (*IFDNode, error) {
+	if size > math.MaxUint32 {
+		size = math.MaxUint32
+	}
+	src := readerSource(r, uint32(size))
+	src.lazySize = lazySize
+	ifdPositions := make(posMap)
+	return getIFDTreeIter(src, order, pos, NewSpaceRec(space), ifdPositions)
+}
+
+// Read data with given size for a field that hasn't been loaded.
+func (node IFDNode) pendingFieldData(tag Tag, size uint32) ([]byte, error) {
+	for _, p := range node.pending {
+		if p.tag == tag {
+			data := make([]byte, size)
+			if _, err := p.r.ReadAt(data, int64(p.pos)); err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("Data for field %d(0x%X) is missing", tag, tag)
+}
+
+// Indicate if the data for a field in the node hasn't been read.
+func (node IFDNode) isPending(tag Tag) bool {
+	for _, p := range node.pending {
+		if p.tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Indicate if all field data in the node has been read. Doesn't check
+// image data or other nodes.
+func (node IFDNode) IsLoaded() bool {
+	return len(node.pending) == 0
+}
+
+// Read the data for fields in the node with the given tags, if they
+// were left unread by GetIFDTreeLazy.
+func (node *IFDNode) Load(tags []Tag) error {
+	for _, field := range node.FindFields(tags) {
+		if field.Data != nil || field.Size() == 0 {
+			continue
+		}
+		data, err := node.pendingFieldData(field.Tag, field.Size())
+		if err != nil {
+			return err
+		}
+		field.Data = data
+	}
+	pending := node.pending[:0]
+	for _, p := range node.pending {
+		loaded := false
+		for _, tag := range tags {
+			if p.tag == tag {
+				loaded = true
+			}
+		}
+		if !loaded {
+			pending = append(pending, p)
+		}
+	}
+	node.pending = pending
+	return nil
+}
+
+// Read all field data and image data that hasn't been loaded, in the
+// node and all the nodes to which it refers.
+func (node *IFDNode) LoadAll() error {
+	if len(node.pending) > 0 {
+		tags := make([]Tag, len(node.pending))
+		for i := range node.pending {
+			tags[i] = node.pending[i].tag
+		}
+		if err := node.Load(tags); err != nil {
+			return err
+		}
+	}
+	imageData := node.GetImageData()
+	for i := range imageData {
+		if err := imageData[i].Load(); err != nil {
+			return err
+		}
+	}
+	for i := range node.SubIFDs {
+		if err := node.SubIFDs[i].Node.LoadAll(); err != nil {
+			return err
+		}
+	}
+	if node.Next != nil {
+		return node.Next.LoadAll()
+	}
+	return nil
+}
+
+// Copy the field data and image data in a tree, so that it no longer
+// refers to the buffer from which it was decoded, allowing the buffer
+// to be garbage collected while the tree is retained. Fields that
+// shared data will each have their own copy. Data that hasn't been
+// loaded from a reader remains unloaded.
+func (node *IFDNode) Detach() {
+	detach := func(data []byte) []byte {
+		if data == nil {
+			return nil
+		}
+		copied := make([]byte, len(data))
+		copy(copied, data)
+		return copied
+	}
+	node.Walk(func(n *IFDNode, _ Tag) error {
+		for i := range n.Fields {
+			n.Fields[i].Data = detach(n.Fields[i].Data)
+		}
+		if rec, ok := n.SpaceRec.(*PhaseOneSpaceRec); ok {
+			// The maker note is written from its original data.
+			rec.data = detach(rec.data)
+		}
+		imageData := n.GetImageData()
+		for i := range imageData {
+			for j := range imageData[i].Segments {
+				imageData[i].Segments[j] = detach(imageData[i].Segments[j])
+			}
+		}
+		return nil
+	})
+}
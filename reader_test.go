@@ -0,0 +1,205 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// Create a TIFF buffer with a strip of image data, and check that it
+// can be read back with GetIFDTreeReader, with the image data only
+// loaded on demand.
+func TestReader(t *testing.T) {
+	order := binary.BigEndian
+	strip := []byte("0123456789")
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.Fields = make([]Field, 3)
+	node.Fields[0] = Field{ImageDescription, ASCII, 0, nil}
+	node.Fields[0].PutASCII("A description")
+	node.Fields[0].Count = uint32(len(node.Fields[0].Data))
+	node.Fields[1] = Field{StripOffsets, LONG, 1, make([]byte, 4)}
+	node.Fields[2] = Field{StripByteCounts, LONG, 1, make([]byte, 4)}
+	node.Fields[2].PutLong(uint32(len(strip)), 0, order)
+	node.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{strip}}}}
+	buf := make([]byte, HeaderSize+node.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := node.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(buf)
+	valid, getorder, pos, err := GetHeaderReader(r)
+	if err != nil || !valid {
+		t.Fatal("Header not valid")
+	}
+	root, err := GetIFDTreeReader(r, getorder, pos, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Fields) != 3 || root.Fields[0].ASCII() != "A description" {
+		t.Error("Fields not read back correctly")
+	}
+	imageData := root.GetImageData()
+	if len(imageData) != 1 {
+		t.Fatal("Image data not found")
+	}
+	if imageData[0].IsLoaded() {
+		t.Error("Image data loaded before Load was called")
+	}
+	if imageData[0].Size() != uint64(len(strip)) {
+		t.Error("Wrong image data size")
+	}
+
+	// Repack without loading the image data.
+	out := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(out, order, HeaderSize)
+	if _, err := root.PutIFDTree(out, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Error("Repacked file differs from original")
+	}
+
+	if err := imageData[0].Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(imageData[0].Segments[0], strip) {
+		t.Error("Loaded image data is incorrect")
+	}
+}
+
+// Check that GetIFDTreeLazy defers reading large field data until
+// it's loaded.
+func TestLazy(t *testing.T) {
+	order := binary.LittleEndian
+	blob := bytes.Repeat([]byte("blob"), 25)
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.Fields = []Field{
+		{Compression, SHORT, 1, []byte{1, 0}},
+		{XResolution, RATIONAL, 1, []byte{72, 0, 0, 0, 1, 0, 0, 0}},
+		{ICCProfile, UNDEFINED, uint32(len(blob)), blob},
+	}
+	buf := make([]byte, HeaderSize+node.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := node.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	root, err := GetIFDTreeLazy(bytes.NewReader(buf), int64(len(buf)), order, HeaderSize, TIFFSpace, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Fields[1].Data == nil {
+		t.Error("Small field data wasn't read")
+	}
+	if root.Fields[2].Data != nil || root.IsLoaded() {
+		t.Error("Large field data was read")
+	}
+	out := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(out, order, HeaderSize)
+	if _, err := root.PutIFDTree(out, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Error("Repacked file differs from original")
+	}
+	if err := root.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root.Fields[2].Data, blob) || !root.IsLoaded() {
+		t.Error("Large field data wasn't loaded")
+	}
+}
+
+// Check that a detached tree doesn't change when the buffer from
+// which it was decoded is overwritten.
+func TestDetach(t *testing.T) {
+	order := binary.BigEndian
+	node, err := EncodeImage(image.NewGray(image.Rect(0, 0, 8, 8)), &EncodeOptions{Order: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.SetASCII(Software, "Some software or other")
+	var out bytes.Buffer
+	if err := node.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	orig := out.Bytes()
+	for _, detachOption := range []bool{false, true} {
+		buf := append([]byte(nil), orig...)
+		root, err := GetIFDTreeOptions(buf, order, order.Uint32(buf[4:]), TIFFSpace, &ParseOptions{Detach: detachOption})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !detachOption {
+			root.Detach()
+		}
+		for i := range buf {
+			buf[i] = 0xFF
+		}
+		if software, ok := root.GetASCII(Software); !ok || software != "Some software or other" {
+			t.Errorf("Field data changed: %q", software)
+		}
+		if width, ok := root.GetLongs(ImageWidth); !ok || width[0] != 8 {
+			t.Error("Field in IFD table changed")
+		}
+		if segment := root.GetImageData()[0].Segments[0]; len(segment) != 64 || segment[0] != 0 {
+			t.Error("Image data changed")
+		}
+	}
+}
+
+// Stream image data that hasn't been loaded with WriteTo, and read it
+// back into segments with ReadFrom.
+func TestImageDataWriteTo(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 300, 300))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7)
+	}
+	// Strips larger than the copy buffer.
+	root, err := EncodeImage(gray, &EncodeOptions{Order: binary.BigEndian, RowsPerStrip: 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	node, err := GetIFDTreeReader(bytes.NewReader(buf), binary.BigEndian, binary.BigEndian.Uint32(buf[4:]), TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := node.GetImageData()[0]
+	var streamed bytes.Buffer
+	n, err := id.WriteTo(&streamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(gray.Pix)) || !bytes.Equal(streamed.Bytes(), gray.Pix) {
+		t.Fatalf("Streamed %d bytes, which differ from the image", n)
+	}
+	if id.IsLoaded() {
+		t.Error("WriteTo loaded the image data")
+	}
+	read := ImageData{Sizes: id.Sizes}
+	if n, err := read.ReadFrom(&streamed); err != nil || n != int64(len(gray.Pix)) {
+		t.Fatalf("ReadFrom returned %d, %v", n, err)
+	}
+	if len(read.Segments) != 2 || !bytes.Equal(read.Segments[1], gray.Pix[250*300:]) {
+		t.Error("Segments read differ from the image")
+	}
+	if _, err := read.ReadFrom(bytes.NewReader(gray.Pix[:100])); err == nil {
+		t.Error("Short input didn't cause an error")
+	}
+}
+
+// Check that the total size of image data doesn't wrap in 32 bits.
+func TestImageDataSize(t *testing.T) {
+	id := ImageData{Offsets: []uint32{0, 0}, Sizes: []uint32{0xFFFFFFFF, 2}, reader: bytes.NewReader(nil)}
+	if size := id.Size(); size != 0x100000001 {
+		t.Errorf("Size returned %d", size)
+	}
+}
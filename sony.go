@@ -0,0 +1,197 @@
+package tiff66
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tables for the substitution cipher used for some Sony1 fields. Each
+// byte b less than 249 is enciphered as b*b*b mod 249, and larger
+// values are unchanged.
+var sonyEncipherTable, sonyDecipherTable [256]byte
+
+func init() {
+	for i := 0; i < 256; i++ {
+		c := i
+		if i < 249 {
+			c = i * i * i % 249
+		}
+		sonyEncipherTable[i] = byte(c)
+		sonyDecipherTable[c] = byte(i)
+	}
+}
+
+// Return a copy of data with the Sony substitution cipher applied.
+func SonyEncipher(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = sonyEncipherTable[b]
+	}
+	return out
+}
+
+// Return a copy of data with the Sony substitution cipher reversed.
+func SonyDecipher(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = sonyDecipherTable[b]
+	}
+	return out
+}
+
+// Indicate if a Sony1 field is enciphered.
+func SonyEnciphered(tag Tag) bool {
+	switch tag {
+	case 0x2010, 0x900B, 0x9050, 0x940A, 0x940C, 0x940E, 0x9416:
+		return true
+	}
+	return tag >= 0x9400 && tag <= 0x9406
+}
+
+// Return the deciphered data of an enciphered field in a Sony1 maker
+// note node.
+func (node IFDNode) SonyDeciphered(tag Tag) ([]byte, error) {
+	if node.GetSpace() != Sony1Space {
+		return nil, fmt.Errorf("SonyDeciphered: node is in %s space, not Sony1", node.GetSpace().Name())
+	}
+	if !SonyEnciphered(tag) {
+		return nil, fmt.Errorf("SonyDeciphered: field %d(0x%X) isn't enciphered", tag, tag)
+	}
+	data, ok := node.GetBytes(tag)
+	if !ok {
+		return nil, fmt.Errorf("SonyDeciphered: field %d(0x%X) not found", tag, tag)
+	}
+	return SonyDecipher(data), nil
+}
+
+// Set an enciphered field in a Sony1 maker note node from deciphered
+// data, replacing any existing field with the same tag.
+func (node *IFDNode) SetSonyEnciphered(tag Tag, data []byte) error {
+	if node.GetSpace() != Sony1Space {
+		return fmt.Errorf("SetSonyEnciphered: node is in %s space, not Sony1", node.GetSpace().Name())
+	}
+	if !SonyEnciphered(tag) {
+		return fmt.Errorf("SetSonyEnciphered: field %d(0x%X) isn't enciphered", tag, tag)
+	}
+	node.SetUndefined(tag, SonyEncipher(data))
+	return nil
+}
+
+// Layout of the values at the start of Tag2010, which are the same in
+// all versions.
+var sony1Tag2010 = BlockDef{
+	{0x00, LONG, "SequenceImageNumber", 0},
+	{0x04, LONG, "SequenceFileNumber", 0},
+	{0x08, BYTE, "ReleaseMode2", 0},
+}
+
+// Layout of Tag9050 for models from the ILCE-7 onwards.
+var sony1Tag9050b = BlockDef{
+	{0x39, BYTE, "FlashStatus", 0},
+	{0x3A, LONG, "ShutterCount", 0},
+	{0x46, SHORT, "SonyExposureTime", 0},
+	{0x48, SHORT, "SonyFNumber", 0},
+	{0x4B, BYTE, "ReleaseMode2", 0},
+	{0x50, LONG, "ShutterCount2", 0},
+}
+
+// Layouts of the enciphered binary blocks in Sony1 maker notes, named
+// as by Exiftool: "Tag" and the tag number in hex, with a letter for
+// blocks that vary with the camera model.
+var Sony1BlockDefs = map[string]BlockDef{
+	"Tag2010b": sony1Tag2010,
+	"Tag2010c": sony1Tag2010,
+	"Tag2010d": sony1Tag2010,
+	"Tag2010e": sony1Tag2010,
+	"Tag2010f": sony1Tag2010,
+	"Tag2010g": sony1Tag2010,
+	"Tag2010h": sony1Tag2010,
+	"Tag2010i": sony1Tag2010,
+	"Tag9050a": {
+		{0x00, BYTE, "MaxAperture", 0},
+		{0x01, BYTE, "MinAperture", 0},
+		{0x31, BYTE, "FlashStatus", 0},
+		{0x32, LONG, "ShutterCount", 0},
+		{0x3A, SHORT, "SonyExposureTime", 0},
+		{0x3C, SHORT, "SonyFNumber", 0},
+		{0x3F, BYTE, "ReleaseMode2", 0},
+	},
+	"Tag9050b": sony1Tag9050b,
+	"Tag9050c": sony1Tag9050b,
+	"Tag9400a": {
+		{0x08, LONG, "SequenceImageNumber", 0},
+		{0x0C, LONG, "SequenceFileNumber", 0},
+		{0x10, BYTE, "ReleaseMode2", 0},
+	},
+	"Tag9402": {
+		{0x04, SBYTE, "AmbientTemperature", 0},
+		{0x16, BYTE, "FocusMode", 0x7F},
+		{0x17, BYTE, "AFAreaMode", 0},
+		{0x2D, BYTE, "FocusPosition2", 0},
+	},
+	"Tag940c": {
+		{0x08, BYTE, "LensMount2", 0},
+		{0x09, SHORT, "LensType3", 0},
+		{0x0B, SHORT, "CameraE-mountVersion", 0},
+		{0x0D, SHORT, "LensE-mountVersion", 0},
+		{0x14, SHORT, "LensFirmwareVersion", 0},
+	},
+}
+
+// Decipher and decode an enciphered binary block from a Sony1 maker
+// note node, using the named layout from Sony1BlockDefs, which must be
+// for the given tag.
+func (node IFDNode) SonyBlock(tag Tag, layout string) (ArrayValues, error) {
+	def, ok := Sony1BlockDefs[layout]
+	if !ok {
+		return nil, fmt.Errorf("SonyBlock: unknown layout %q", layout)
+	}
+	if !strings.HasPrefix(strings.ToLower(layout), fmt.Sprintf("tag%04x", uint16(tag))) {
+		return nil, fmt.Errorf("SonyBlock: layout %q isn't for field %d(0x%X)", layout, tag, tag)
+	}
+	data, err := node.SonyDeciphered(tag)
+	if err != nil {
+		return nil, err
+	}
+	return def.Decode(data, node.Order)
+}
+
+// Camera models using each version of the Sony1 blocks that vary with
+// the model. Models not listed use the last version of the block.
+var sony1BlockModels = []struct {
+	layout string
+	models []string
+}{
+	{"Tag2010b", []string{"SLT-A65", "SLT-A65V", "SLT-A77", "SLT-A77V", "NEX-5N", "NEX-7", "NEX-F3", "NEX-VG20", "NEX-VG20E"}},
+	{"Tag2010c", []string{"SLT-A37", "SLT-A57", "SLT-A99", "SLT-A99V", "NEX-5R", "NEX-6", "NEX-VG30", "NEX-VG30E", "NEX-VG900", "DSC-RX100", "DSC-RX1"}},
+	{"Tag2010d", []string{"DSC-HX10V", "DSC-HX20V", "DSC-HX200V", "DSC-TX66", "DSC-TX200V", "DSC-TX300V", "DSC-WX50", "DSC-WX100", "DSC-WX150"}},
+	{"Tag2010e", []string{"SLT-A58", "NEX-3N", "NEX-5T", "ILCE-3000", "ILCE-3500", "ILCE-5000", "ILCE-6000", "ILCE-7", "ILCE-7R", "ILCE-7S", "DSC-RX10", "DSC-RX100M2", "DSC-RX100M3", "DSC-RX1R"}},
+	{"Tag2010f", []string{"DSC-HX50", "DSC-HX50V", "DSC-TX30", "DSC-WX60", "DSC-WX200", "DSC-WX300"}},
+	{"Tag2010g", []string{"ILCA-77M2", "ILCE-5100", "ILCE-7M2", "ILCE-QX1", "DSC-HX400V", "DSC-HX60V", "DSC-QX30", "DSC-RX1RM2"}},
+	{"Tag2010h", []string{"ILCA-68", "ILCA-99M2", "ILCE-6300", "ILCE-6500", "ILCE-7RM2", "ILCE-7SM2", "DSC-RX10M2", "DSC-RX10M3", "DSC-RX100M4", "DSC-RX100M5"}},
+	{"Tag2010i", nil},
+	{"Tag9050a", []string{"SLT-A33", "SLT-A35", "SLT-A37", "SLT-A55", "SLT-A55V", "SLT-A57", "SLT-A58", "SLT-A65", "SLT-A65V", "SLT-A77", "SLT-A77V", "SLT-A99", "SLT-A99V", "NEX-3", "NEX-3N", "NEX-5", "NEX-5N", "NEX-5R", "NEX-5T", "NEX-6", "NEX-7", "NEX-C3", "NEX-F3"}},
+	{"Tag9050b", []string{"ILCA-68", "ILCA-77M2", "ILCA-99M2", "ILCE-3000", "ILCE-3500", "ILCE-5000", "ILCE-5100", "ILCE-6000", "ILCE-6100", "ILCE-6300", "ILCE-6400", "ILCE-6500", "ILCE-6600", "ILCE-7", "ILCE-7M2", "ILCE-7M3", "ILCE-7R", "ILCE-7RM2", "ILCE-7RM3", "ILCE-7RM4", "ILCE-7S", "ILCE-7SM2", "ILCE-9", "ILCE-QX1"}},
+	{"Tag9050c", nil},
+}
+
+// Return the name of the layout in Sony1BlockDefs for a block that
+// varies with the camera model, given the Model field from the TIFF
+// IFD, or "" if the field's layout doesn't depend on the model.
+func SonyBlockLayout(tag Tag, model string) string {
+	prefix := fmt.Sprintf("Tag%04x", uint16(tag))
+	model = strings.TrimSpace(model)
+	last := ""
+	for _, version := range sony1BlockModels {
+		if !strings.HasPrefix(version.layout, prefix) {
+			continue
+		}
+		last = version.layout
+		for _, m := range version.models {
+			if m == model {
+				return version.layout
+			}
+		}
+	}
+	return last
+}
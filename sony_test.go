@@ -0,0 +1,91 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSonyCipher(t *testing.T) {
+	plain := make([]byte, 256)
+	for i := range plain {
+		plain[i] = byte(i)
+	}
+	enciphered := SonyEncipher(plain)
+	if enciphered[2] != 8 || enciphered[10] != 1000%249 || enciphered[250] != 250 {
+		t.Error("Wrong enciphered values")
+	}
+	if !bytes.Equal(SonyDecipher(enciphered), plain) {
+		t.Error("Deciphered data doesn't match")
+	}
+}
+
+func TestSonyBlock(t *testing.T) {
+	node := NewIFDNode(Sony1Space)
+	node.Order = binary.LittleEndian
+	block := make([]byte, 0x20)
+	block[0] = 0x07
+	binary.LittleEndian.PutUint32(block[0x08:], 12)
+	block[0x10] = 2
+	if err := node.SetSonyEnciphered(0x9400, block); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := node.GetBytes(0x9400); bytes.Equal(raw, block) {
+		t.Error("Block wasn't enciphered")
+	}
+	vals, err := node.SonyBlock(0x9400, "Tag9400a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := vals.Get("SequenceImageNumber"); v != 12 {
+		t.Errorf("SequenceImageNumber is %d", v)
+	}
+	if v, _ := vals.Get("ReleaseMode2"); v != 2 {
+		t.Errorf("ReleaseMode2 is %d", v)
+	}
+	if _, err := node.SonyBlock(0x9400, "Tag940c"); err == nil {
+		t.Error("Layout for wrong tag didn't cause an error")
+	}
+	if _, err := node.SonyBlock(0x9402, "Tag9402"); err == nil {
+		t.Error("Missing field didn't cause an error")
+	}
+	if err := node.SetSonyEnciphered(0x0102, block); err == nil {
+		t.Error("Field that isn't enciphered didn't cause an error")
+	}
+}
+
+func TestSonyBlockLayout(t *testing.T) {
+	tests := []struct {
+		tag    Tag
+		model  string
+		layout string
+	}{
+		{0x9050, "SLT-A77V", "Tag9050a"},
+		{0x9050, "ILCE-7M3", "Tag9050b"},
+		{0x9050, "ILCE-1", "Tag9050c"},
+		{0x2010, "NEX-7", "Tag2010b"},
+		{0x2010, "ILCE-7RM2", "Tag2010h"},
+		{0x2010, "ILCE-9", "Tag2010i"},
+		{0x9400, "ILCE-9", ""},
+	}
+	for _, test := range tests {
+		if layout := SonyBlockLayout(test.tag, test.model); layout != test.layout {
+			t.Errorf("Layout for %X in %s is %q, want %q", test.tag, test.model, layout, test.layout)
+		}
+	}
+
+	node := NewIFDNode(Sony1Space)
+	node.Order = binary.LittleEndian
+	block := make([]byte, 0x60)
+	binary.LittleEndian.PutUint32(block[0x3A:], 12345)
+	if err := node.SetSonyEnciphered(0x9050, block); err != nil {
+		t.Fatal(err)
+	}
+	vals, err := node.SonyBlock(0x9050, SonyBlockLayout(0x9050, "ILCE-7M3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := vals.Get("ShutterCount"); v != 12345 {
+		t.Errorf("ShutterCount is %d", v)
+	}
+}
@@ -0,0 +1,57 @@
+package tiff66
+
+// Statistics about an IFDNode tree, as returned by Stats.
+type TreeStats struct {
+	IFDs      int              // Total number of IFDs, including maker notes.
+	Spaces    map[TagSpace]int // Number of IFDs in each tag space.
+	Fields    int              // Total number of fields.
+	MaxFields int              // Largest number of fields in an IFD.
+	// Total size of field data stored outside IFD tables, excluding
+	// fields that have been decoded into sub-IFDs, such as maker
+	// notes.
+	DataBytes uint64
+	// Total size of image data segments.
+	ImageBytes uint64
+	// Maximum nesting of IFDs, counting both sub-IFDs and Next IFDs,
+	// with the root at depth 1, as for ParseOptions.MaxDepth.
+	MaxDepth int
+}
+
+// Return statistics about a tree: the number of IFDs in each tag space,
+// the number of fields, the sizes of external field data and image
+// data, and the maximum depth. The values found in typical input can be
+// used to choose limits in ParseOptions.
+func Stats(root *IFDNode) TreeStats {
+	stats := TreeStats{Spaces: make(map[TagSpace]int)}
+	stats.add(root, 1)
+	return stats
+}
+
+// Add a node, its sub-IFDs and its Next chain to the statistics, with
+// the node at a given depth.
+func (stats *TreeStats) add(node *IFDNode, depth int) {
+	for ; node != nil; node, depth = node.Next, depth+1 {
+		stats.IFDs++
+		stats.Spaces[node.GetSpace()]++
+		stats.Fields += len(node.Fields)
+		if len(node.Fields) > stats.MaxFields {
+			stats.MaxFields = len(node.Fields)
+		}
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+		for _, field := range node.Fields {
+			size := uint64(field.Type.Size()) * uint64(field.Count)
+			if size <= 4 || (field.Type.Size() == 1 && node.nthSubIFD(field.Tag, 0) >= 0) {
+				continue
+			}
+			stats.DataBytes += size
+		}
+		for _, id := range node.GetImageData() {
+			stats.ImageBytes += id.Size()
+		}
+		for _, sub := range node.SubIFDs {
+			stats.add(sub.Node, depth+1)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package tiff66
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	root := grayPage(t, 10)
+	root.SetASCII(Software, "Some software")
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = root.Order
+	exif.SetASCII(LensModel, "A lens")
+	if err := root.SetExifIFD(exif); err != nil {
+		t.Fatal(err)
+	}
+	root.Fix()
+	page := grayPage(t, 20)
+	root.Next = page
+	stats := Stats(root)
+	if stats.IFDs != 3 || stats.Spaces[TIFFSpace] != 2 || stats.Spaces[ExifSpace] != 1 {
+		t.Errorf("Wrong IFD counts %d %v", stats.IFDs, stats.Spaces)
+	}
+	fields := len(root.Fields) + len(exif.Fields) + len(page.Fields)
+	if stats.Fields != fields || stats.MaxFields != len(root.Fields) {
+		t.Errorf("Wrong field counts %d %d", stats.Fields, stats.MaxFields)
+	}
+	if stats.ImageBytes != 32 {
+		t.Errorf("Image data size is %d, expected 32", stats.ImageBytes)
+	}
+	if stats.DataBytes < uint64(len("Some software")+len("A lens")) {
+		t.Errorf("External data size is %d", stats.DataBytes)
+	}
+	if stats.MaxDepth != 2 {
+		t.Errorf("Maximum depth is %d, expected 2", stats.MaxDepth)
+	}
+	page.Next = grayPage(t, 30)
+	if stats = Stats(root); stats.MaxDepth != 3 {
+		t.Errorf("Maximum depth is %d, expected 3", stats.MaxDepth)
+	}
+	// The statistics of a tree can be used as parse limits.
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	_, order, pos := GetHeader(buf)
+	opts := ParseOptions{MaxIFDs: stats.IFDs, MaxFields: stats.MaxFields, MaxDepth: stats.MaxDepth}
+	if _, err := GetIFDTreeOptions(buf, order, pos, TIFFSpace, &opts); err != nil {
+		t.Errorf("Reading with limits from Stats failed: %v", err)
+	}
+	opts.MaxDepth--
+	if _, err := GetIFDTreeOptions(buf, order, pos, TIFFSpace, &opts); err == nil {
+		t.Error("Reading with a lower depth limit didn't fail")
+	}
+	if stats = Stats(nil); stats.IFDs != 0 || stats.MaxDepth != 0 {
+		t.Error("Nil tree has non-zero statistics")
+	}
+}
@@ -0,0 +1,105 @@
+package tiff66
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Number of characters or bytes of data shown by Field.String.
+const stringLimit = 32
+
+// Return the name of a TIFF type, or its number if it's unknown, e.g.,
+// "Type(14)".
+func (t Type) String() string {
+	if name, found := TypeNames[t]; found {
+		return name
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// A tag qualified by the namespace in which it's interpreted.
+type SpaceTag struct {
+	Space TagSpace
+	Tag   Tag
+}
+
+// Return a tag with its space, e.g., "Exif:DateTimeOriginal", or
+// "Exif:0xC000" if the tag has no name. This is the syntax accepted by
+// tiff66edit.
+func (st SpaceTag) String() string {
+	if name, found := st.Space.TagNames()[st.Tag]; found {
+		return st.Space.Name() + ":" + name
+	}
+	return fmt.Sprintf("%s:0x%X", st.Space.Name(), st.Tag)
+}
+
+// Return a field's tag, type, count and the start of its data, e.g.,
+// `Software(0x131) ASCII(5) "GIMP"`. A field doesn't record its tag
+// space, so the tag is named only if it's a TIFF or Exif tag. Nor does
+// it record its byte order, so the values of types larger than a byte
+// are shown as hex data in the order they're stored.
+func (f Field) String() string {
+	name, found := TagNames[f.Tag]
+	if !found {
+		name, found = ExifTagNames[f.Tag]
+	}
+	if !found {
+		name = "Unknown"
+	}
+	str := fmt.Sprintf("%s(0x%X) %s(%d)", name, f.Tag, f.Type, f.Count)
+	data := f.Data
+	if size := f.Size(); uint64(len(data)) > uint64(size) {
+		data = data[:size]
+	}
+	switch {
+	case f.Data == nil && f.Count > 0:
+		return str + " data not loaded"
+	case f.Type == ASCII:
+		if len(data) > stringLimit {
+			return fmt.Sprintf("%s %q...", str, data[:stringLimit])
+		}
+		return fmt.Sprintf("%s %q", str, strings.TrimRight(string(data), "\000"))
+	case f.Type.Size() == 0:
+		return str + " unknown data type"
+	}
+	more := ""
+	if len(data) > stringLimit {
+		data, more = data[:stringLimit], "..."
+	}
+	unit := int(f.Type.Size())
+	if f.Type.IsRational() {
+		unit /= 2
+	}
+	vals := make([]string, 0, len(data)/unit)
+	for i := 0; i+unit <= len(data); i += unit {
+		vals = append(vals, fmt.Sprintf("%X", data[i:i+unit]))
+	}
+	return fmt.Sprintf("%s %s%s", str, strings.Join(vals, " "), more)
+}
+
+// Return an IFD's tag space, byte order and numbers of fields and
+// sub-IFDs, e.g., "Exif IFD, BigEndian, 12 fields, 1 sub-IFD".
+func (node IFDNode) String() string {
+	space := "Unspecified"
+	if node.SpaceRec != nil {
+		space = node.GetSpace().Name()
+	}
+	order := "no byte order"
+	if node.Order != nil {
+		order = node.Order.String()
+	}
+	str := fmt.Sprintf("%s IFD, %s, %s", space, order, plural(len(node.Fields), "field"))
+	if len(node.SubIFDs) > 0 {
+		str += ", " + plural(len(node.SubIFDs), "sub-IFD")
+	}
+	return str
+}
+
+// Return a count followed by a noun, with an "s" appended if the count
+// isn't 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
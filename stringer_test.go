@@ -0,0 +1,55 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStringers(t *testing.T) {
+	if s := fmt.Sprint(SRATIONAL); s != "SRational" {
+		t.Errorf("SRATIONAL formatted as %q", s)
+	}
+	if s := fmt.Sprint(Type(14)); s != "Type(14)" {
+		t.Errorf("Unknown type formatted as %q", s)
+	}
+	if s := fmt.Sprint(SpaceTag{ExifSpace, DateTimeOriginal}); s != "Exif:DateTimeOriginal" {
+		t.Errorf("SpaceTag formatted as %q", s)
+	}
+	if s := fmt.Sprint(SpaceTag{GPSSpace, 0xC000}); s != "GPS:0xC000" {
+		t.Errorf("Unnamed SpaceTag formatted as %q", s)
+	}
+
+	order := binary.BigEndian
+	node := NewIFDNode(ExifSpace)
+	node.Order = order
+	node.SetASCII(Software, "GIMP")
+	node.SetShorts(Orientation, []uint16{1, 2})
+	node.SetASCII(ImageDescription, strings.Repeat("x", 40))
+	node.Fields = append(node.Fields, Field{0xC000, RATIONAL, 1, []byte{0, 0, 0, 1, 0, 0, 0, 2}})
+	node.Fields = append(node.Fields, Field{0xC001, LONG, 1, nil})
+	expected := []string{
+		`ImageDescription(0x10E) ASCII(41) "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"...`,
+		`Orientation(0x112) Short(2) 0001 0002`,
+		`Software(0x131) ASCII(5) "GIMP"`,
+		`Unknown(0xC000) Rational(1) 00000001 00000002`,
+		`Unknown(0xC001) Long(1) data not loaded`,
+	}
+	for i, f := range node.Fields {
+		if s := fmt.Sprint(f); s != expected[i] {
+			t.Errorf("Field formatted as %q, expected %q", s, expected[i])
+		}
+	}
+	if s := fmt.Sprint(node); s != "Exif IFD, BigEndian, 5 fields" {
+		t.Errorf("Node formatted as %q", s)
+	}
+	root := NewIFDNode(TIFFSpace)
+	root.Order = binary.LittleEndian
+	if err := root.SetExifIFD(node); err != nil {
+		t.Fatal(err)
+	}
+	if s := root.String(); s != "TIFF IFD, LittleEndian, 1 field, 1 sub-IFD" {
+		t.Errorf("Root formatted as %q", s)
+	}
+}
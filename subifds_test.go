@@ -75,3 +75,54 @@ func TestSubIFDs(t *testing.T) {
 		t.Error("Wrong value in second sub-IFD.")
 	}
 }
+
+func TestAddSubIFD(t *testing.T) {
+	order := binary.LittleEndian
+	newNode := func(space TagSpace, tag Tag, val uint16) *IFDNode {
+		node := NewIFDNode(space)
+		node.Order = order
+		node.Fields = []Field{shortField(tag, order, val)}
+		return node
+	}
+	root := newNode(TIFFSpace, Compression, 1)
+	for i := uint16(0); i < 2; i++ {
+		if err := root.AddSubIFD(SubIFDs, newNode(TIFFSpace, Compression, 10+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := root.SetExifIFD(newNode(ExifSpace, ExposureProgram, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.SetExifIFD(newNode(ExifSpace, ExposureProgram, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.SetGPSIFD(newNode(ExifSpace, ExposureProgram, 2)); err == nil {
+		t.Error("SetGPSIFD accepted an Exif IFD")
+	}
+	if err := root.AddSubIFD(Compression, newNode(TIFFSpace, Compression, 1)); err == nil {
+		t.Error("AddSubIFD accepted a SHORT pointer field")
+	}
+	if f := root.FindFields([]Tag{SubIFDs}); len(f) != 1 || f[0].Count != 2 {
+		t.Fatal("SubIFDs field not created with two pointers")
+	}
+	buf, err := putTIFFTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reread, err := getTIFFTree(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reread.SubIFDs) != 3 {
+		t.Fatalf("Read back %d sub-IFDs, expected 3", len(reread.SubIFDs))
+	}
+	for i, sub := range reread.SubIFDs {
+		val := sub.Node.Fields[0].Short(0, order)
+		switch {
+		case i < 2 && (sub.Tag != SubIFDs || val != 10+uint16(i)):
+			t.Errorf("Wrong sub-IFD %d", i)
+		case i == 2 && (sub.Tag != ExifIFD || sub.Node.GetSpace() != ExifSpace || val != 2):
+			t.Error("Wrong Exif IFD")
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package tiff66
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Expected types and count of a field, from the TIFF, Exif or DNG
+// specifications.
+type TagDef struct {
+	Types []Type
+	Count uint32 // Number of values, or 0 if it may vary.
+}
+
+// Common field definitions.
+var (
+	defASCII       = TagDef{[]Type{ASCII}, 0}
+	defShort1      = TagDef{[]Type{SHORT}, 1}
+	defShorts      = TagDef{[]Type{SHORT}, 0}
+	defShortLong1  = TagDef{[]Type{SHORT, LONG}, 1}
+	defShortLongs  = TagDef{[]Type{SHORT, LONG}, 0}
+	defRational1   = TagDef{[]Type{RATIONAL}, 1}
+	defRational3   = TagDef{[]Type{RATIONAL}, 3}
+	defSRational1  = TagDef{[]Type{SRATIONAL}, 1}
+	defUndefined   = TagDef{[]Type{UNDEFINED}, 0}
+	defUndefined4  = TagDef{[]Type{UNDEFINED}, 4}
+	defIFDPointer  = TagDef{[]Type{LONG, IFD}, 1}
+	defIFDPointers = TagDef{[]Type{LONG, IFD}, 0}
+	defRef         = TagDef{[]Type{ASCII}, 2} // GPS reference letter.
+)
+
+// Definitions of baseline and extension TIFF fields.
+var TIFFTagDefs = map[Tag]TagDef{
+	NewSubfileType:              {[]Type{LONG}, 1},
+	SubfileType:                 defShort1,
+	ImageWidth:                  defShortLong1,
+	ImageLength:                 defShortLong1,
+	BitsPerSample:               defShorts,
+	Compression:                 defShort1,
+	PhotometricInterpretation:   defShort1,
+	Threshholding:               defShort1,
+	CellWidth:                   defShort1,
+	CellLength:                  defShort1,
+	FillOrder:                   defShort1,
+	DocumentName:                defASCII,
+	ImageDescription:            defASCII,
+	Make:                        defASCII,
+	Model:                       defASCII,
+	StripOffsets:                defShortLongs,
+	Orientation:                 defShort1,
+	SamplesPerPixel:             defShort1,
+	RowsPerStrip:                defShortLong1,
+	StripByteCounts:             defShortLongs,
+	MinSampleValue:              defShorts,
+	MaxSampleValue:              defShorts,
+	XResolution:                 defRational1,
+	YResolution:                 defRational1,
+	PlanarConfiguration:         defShort1,
+	PageName:                    defASCII,
+	XPosition:                   defRational1,
+	YPosition:                   defRational1,
+	GrayResponseUnit:            defShort1,
+	GrayResponseCurve:           defShorts,
+	T4Options:                   {[]Type{LONG}, 1},
+	T6Options:                   {[]Type{LONG}, 1},
+	ResolutionUnit:              defShort1,
+	PageNumber:                  {[]Type{SHORT}, 2},
+	TransferFunction:            defShorts,
+	Software:                    defASCII,
+	DateTime:                    {[]Type{ASCII}, 20},
+	Artist:                      defASCII,
+	HostComputer:                defASCII,
+	Predictor:                   defShort1,
+	WhitePoint:                  {[]Type{RATIONAL}, 2},
+	PrimaryChromaticities:       {[]Type{RATIONAL}, 6},
+	ColorMap:                    defShorts,
+	HalftoneHints:               {[]Type{SHORT}, 2},
+	TileWidth:                   defShortLong1,
+	TileLength:                  defShortLong1,
+	TileOffsets:                 {[]Type{LONG}, 0},
+	TileByteCounts:              defShortLongs,
+	SubIFDs:                     defIFDPointers,
+	InkSet:                      defShort1,
+	NumberOfInks:                defShort1,
+	ExtraSamples:                defShorts,
+	SampleFormat:                defShorts,
+	JPEGTables:                  defUndefined,
+	JPEGProc:                    defShort1,
+	JPEGInterchangeFormat:       {[]Type{LONG}, 1},
+	JPEGInterchangeFormatLength: {[]Type{LONG}, 1},
+	YCbCrCoefficients:           defRational3,
+	YCbCrSubSampling:            {[]Type{SHORT}, 2},
+	YCbCrPositioning:            defShort1,
+	ReferenceBlackWhite:         {[]Type{RATIONAL}, 6},
+	Copyright:                   defASCII,
+	ExifIFD:                     defIFDPointer,
+	GPSIFD:                      defIFDPointer,
+}
+
+// Definitions of Exif fields.
+var ExifTagDefs = map[Tag]TagDef{
+	ExposureTime:                        defRational1,
+	FNumber:                             defRational1,
+	ExposureProgram:                     defShort1,
+	SpectralSensitivity:                 defASCII,
+	PhotographicSensitivity:             defShorts,
+	SensitivityType:                     defShort1,
+	StandardOutputSensitivity:           {[]Type{LONG}, 1},
+	RecommendedExposureIndex:            {[]Type{LONG}, 1},
+	ISOSpeed:                            {[]Type{LONG}, 1},
+	ExifVersion:                         defUndefined4,
+	DateTimeOriginal:                    {[]Type{ASCII}, 20},
+	DateTimeDigitized:                   {[]Type{ASCII}, 20},
+	OffsetTime:                          {[]Type{ASCII}, 7},
+	OffsetTimeOriginal:                  {[]Type{ASCII}, 7},
+	OffsetTimeDigitized:                 {[]Type{ASCII}, 7},
+	ComponentsConfiguration:             defUndefined4,
+	CompressedBitsPerPixel:              defRational1,
+	ShutterSpeedValue:                   defSRational1,
+	ApertureValue:                       defRational1,
+	BrightnessValue:                     defSRational1,
+	ExposureBiasValue:                   defSRational1,
+	MaxApertureValue:                    defRational1,
+	SubjectDistance:                     defRational1,
+	MeteringMode:                        defShort1,
+	LightSource:                         defShort1,
+	Flash:                               defShort1,
+	FocalLength:                         defRational1,
+	SubjectArea:                         defShorts,
+	MakerNote:                           defUndefined,
+	UserComment:                         defUndefined,
+	SubSecTime:                          defASCII,
+	SubSecTimeOriginal:                  defASCII,
+	SubSecTimeDigitized:                 defASCII,
+	Temperature:                         defSRational1,
+	Humidity:                            defRational1,
+	Pressure:                            defRational1,
+	WaterDepth:                          defSRational1,
+	Acceleration:                        defRational1,
+	CameraElevationAngle:                defSRational1,
+	FlashpixVersion:                     defUndefined4,
+	ColorSpace:                          defShort1,
+	PixelXDimension:                     defShortLong1,
+	PixelYDimension:                     defShortLong1,
+	RelatedSoundFile:                    {[]Type{ASCII}, 13},
+	InteroperabilityIFD:                 defIFDPointer,
+	FlashEnergy:                         defRational1,
+	FocalPlaneXResolution:               defRational1,
+	FocalPlaneYResolution:               defRational1,
+	FocalPlaneResolutionUnit:            defShort1,
+	SubjectLocation:                     {[]Type{SHORT}, 2},
+	ExposureIndex:                       defRational1,
+	SensingMethod:                       defShort1,
+	FileSource:                          {[]Type{UNDEFINED}, 1},
+	SceneType:                           {[]Type{UNDEFINED}, 1},
+	CFAPattern:                          defUndefined,
+	CustomRendered:                      defShort1,
+	ExposureMode:                        defShort1,
+	WhiteBalance:                        defShort1,
+	DigitalZoomRatio:                    defRational1,
+	FocalLengthIn35mmFilm:               defShort1,
+	SceneCaptureType:                    defShort1,
+	GainControl:                         defShort1,
+	Contrast:                            defShort1,
+	Saturation:                          defShort1,
+	Sharpness:                           defShort1,
+	DeviceSettingDescription:            defUndefined,
+	SubjectDistanceRange:                defShort1,
+	ImageUniqueID:                       {[]Type{ASCII}, 33},
+	CameraOwnerName:                     defASCII,
+	BodySerialNumber:                    defASCII,
+	LensSpecification:                   {[]Type{RATIONAL}, 4},
+	LensMake:                            defASCII,
+	LensModel:                           defASCII,
+	LensSerialNumber:                    defASCII,
+	ImageTitle:                          defASCII,
+	Photographer:                        defASCII,
+	ImageEditor:                         defASCII,
+	CameraFirmware:                      defASCII,
+	RAWDevelopingSoftware:               defASCII,
+	ImageEditingSoftware:                defASCII,
+	MetadataEditingSoftware:             defASCII,
+	CompositeImage:                      defShort1,
+	SourceImageNumberOfCompositeImage:   {[]Type{SHORT}, 2},
+	SourceExposureTimesOfCompositeImage: defUndefined,
+	Gamma:                               defRational1,
+}
+
+// Definitions of GPS fields.
+var GPSTagDefs = map[Tag]TagDef{
+	GPSVersionID:         {[]Type{BYTE}, 4},
+	GPSLatitudeRef:       defRef,
+	GPSLatitude:          defRational3,
+	GPSLongitudeRef:      defRef,
+	GPSLongitude:         defRational3,
+	GPSAltitudeRef:       {[]Type{BYTE}, 1},
+	GPSAltitude:          defRational1,
+	GPSTimeStamp:         defRational3,
+	GPSSatellites:        defASCII,
+	GPSStatus:            defRef,
+	GPSMeasureMode:       defRef,
+	GPSDOP:               defRational1,
+	GPSSpeedRef:          defRef,
+	GPSSpeed:             defRational1,
+	GPSTrackRef:          defRef,
+	GPSTrack:             defRational1,
+	GPSImgDirectionRef:   defRef,
+	GPSImgDirection:      defRational1,
+	GPSMapDatum:          defASCII,
+	GPSDestLatitudeRef:   defRef,
+	GPSDestLatitude:      defRational3,
+	GPSDestLongitudeRef:  defRef,
+	GPSDestLongitude:     defRational3,
+	GPSDestBearingRef:    defRef,
+	GPSDestBearing:       defRational1,
+	GPSDestDistanceRef:   defRef,
+	GPSDestDistance:      defRational1,
+	GPSProcessingMethod:  defUndefined,
+	GPSAreaInformation:   defUndefined,
+	GPSDateStamp:         {[]Type{ASCII}, 11},
+	GPSDifferential:      defShort1,
+	GPSHPositioningError: defRational1,
+}
+
+// Definitions of Interoperability fields.
+var InteropTagDefs = map[Tag]TagDef{
+	InteroperabilityIndex:   defASCII,
+	InteroperabilityVersion: defUndefined4,
+	RelatedImageFileFormat:  defASCII,
+	RelatedImageWidth:       defShortLong1,
+	RelatedImageLength:      defShortLong1,
+}
+
+// Return the field definitions for a tag space, or nil if there are
+// none. DNG IFDs use the TIFF definitions.
+func (space TagSpace) TagDefs() map[Tag]TagDef {
+	switch space {
+	case TIFFSpace, DNGSpace:
+		return TIFFTagDefs
+	case ExifSpace:
+		return ExifTagDefs
+	case GPSSpace:
+		return GPSTagDefs
+	case InteropSpace:
+		return InteropTagDefs
+	}
+	return nil
+}
+
+// Check a field against its definition, if any, returning an error
+// describing the problem.
+func (def TagDef) check(f Field) error {
+	typeOK := false
+	for _, t := range def.Types {
+		if f.Type == t {
+			typeOK = true
+		}
+	}
+	if !typeOK {
+		expected := ""
+		for i, t := range def.Types {
+			if i > 0 {
+				expected += " or "
+			}
+			expected += t.Name()
+		}
+		return fmt.Errorf("has type %s, expected %s", f.Type.Name(), expected)
+	}
+	if def.Count != 0 && f.Count != def.Count {
+		return fmt.Errorf("has count %d, expected %d", f.Count, def.Count)
+	}
+	return nil
+}
+
+// Check the types and counts of the fields in a tree against the
+// definitions for their tag spaces, returning a multierror with an
+// entry for each field that doesn't match. Fields with unknown tags,
+// and maker note IFDs, aren't checked. Tags that occur more than once
+// in an IFD, including maker note IFDs, are also reported.
+func (node *IFDNode) Validate() error {
+	var err error
+	node.Walk(func(n *IFDNode, _ Tag) error {
+		space := n.GetSpace()
+		for _, tag := range n.DuplicateTags() {
+			err = multierror.Append(err, fmt.Errorf("%s IFD has duplicate field %d(0x%X)", space.Name(), tag, tag))
+		}
+		defs := space.TagDefs()
+		names := space.TagNames()
+		for _, f := range n.Fields {
+			def, found := defs[f.Tag]
+			if !found {
+				continue
+			}
+			if problem := def.check(f); problem != nil {
+				err = multierror.Append(err, fmt.Errorf("%s field %s(0x%X) %v", space.Name(), names[f.Tag], f.Tag, problem))
+			}
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,45 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dst, src := mergeTrees()
+	if err := dst.Validate(); err != nil {
+		t.Errorf("Valid tree reported as invalid: %v", err)
+	}
+	if err := src.Validate(); err != nil {
+		t.Errorf("Valid tree reported as invalid: %v", err)
+	}
+	// Orientation as LONG with count 3, and a GPS reference without
+	// NUL terminator.
+	dst.AddFields([]Field{longField(Orientation, binary.LittleEndian, 1, 1, 1)})
+	src.SubIFDs[1].Node.AddFields([]Field{{GPSLatitudeRef, ASCII, 1, []byte("N")}})
+	err := dst.Validate()
+	if err == nil || !strings.Contains(err.Error(), "Orientation(0x112) has type Long, expected Short") {
+		t.Errorf("Wrong Orientation type not reported: %v", err)
+	}
+	err = src.Validate()
+	if err == nil || !strings.Contains(err.Error(), "GPSLatitudeRef(0x1) has count 1, expected 2") {
+		t.Errorf("Wrong GPSLatitudeRef count not reported: %v", err)
+	}
+}
+
+func TestValidateNewExifFields(t *testing.T) {
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = binary.BigEndian
+	exif.SetShorts(CompositeImage, []uint16{2})
+	exif.SetShorts(SourceImageNumberOfCompositeImage, []uint16{3, 3})
+	exif.SetASCII(ImageTitle, "Title")
+	exif.SetRationals(Humidity, [][2]uint32{{55, 1}})
+	if err := exif.Validate(); err != nil {
+		t.Errorf("Valid fields reported as invalid: %v", err)
+	}
+	exif.SetShorts(CameraElevationAngle, []uint16{10})
+	if err := exif.Validate(); err == nil || !strings.Contains(err.Error(), "CameraElevationAngle") {
+		t.Errorf("Wrong CameraElevationAngle type not reported: %v", err)
+	}
+}
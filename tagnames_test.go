@@ -0,0 +1,113 @@
+package tiff66
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTagNames(t *testing.T) {
+	for space := TIFFSpace; space <= Hasselblad1Space; space++ {
+		names := space.TagNames()
+		switch space {
+		case UnknownSpace:
+			if names != nil {
+				t.Errorf("%s: expected no tag names", space.Name())
+			}
+		default:
+			if len(names) == 0 {
+				t.Errorf("%s: no tag names", space.Name())
+			}
+		}
+	}
+	if name := ExifSpace.TagNames()[DateTimeOriginal]; name != "DateTimeOriginal" {
+		t.Errorf("Exif DateTimeOriginal named %q", name)
+	}
+	custom := map[Tag]string{1: "One"}
+	RegisterTagNames(UnknownSpace, custom)
+	RegisterTagNames(GPSSpace, custom)
+	if UnknownSpace.TagNames()[1] != "One" || GPSSpace.TagNames()[1] != "One" {
+		t.Error("Registered tag names not returned")
+	}
+	RegisterTagNames(UnknownSpace, nil)
+	RegisterTagNames(GPSSpace, nil)
+	if UnknownSpace.TagNames() != nil || GPSSpace.TagNames()[GPSVersionID] != "GPSVersionID" {
+		t.Error("Tag names registration not removed")
+	}
+}
+
+func TestTagByName(t *testing.T) {
+	if tag, found := ExifSpace.TagByName("DateTimeOriginal"); !found || tag != DateTimeOriginal {
+		t.Errorf("DateTimeOriginal lookup returned %d, %v", tag, found)
+	}
+	if _, found := TIFFSpace.TagByName("DateTimeOriginal"); found {
+		t.Error("DateTimeOriginal found in TIFF space")
+	}
+	exif := NewIFDNode(ExifSpace)
+	exif.Fields = []Field{{DateTimeOriginal, ASCII, 20, []byte("2020:01:02 03:04:05\000")}}
+	root := NewIFDNode(TIFFSpace)
+	root.Fields = []Field{{ExifIFD, LONG, 1, []byte{0, 0, 0, 0}}}
+	root.SubIFDs = []SubIFD{{ExifIFD, exif}}
+	if root.FieldByName("DateTimeOriginal") != nil {
+		t.Error("FieldByName found field in sub-IFD")
+	}
+	node, field := root.FindFieldByName("DateTimeOriginal")
+	if node != exif || field == nil || field.ASCII() != "2020:01:02 03:04:05" {
+		t.Error("FindFieldByName didn't find DateTimeOriginal")
+	}
+	if node, field := root.FindFieldByName("Nonexistent"); node != nil || field != nil {
+		t.Error("FindFieldByName found nonexistent field")
+	}
+}
+
+func TestRegisterTagSpace(t *testing.T) {
+	names := map[Tag]string{1: "One"}
+	space, err := RegisterTagSpace(TagSpaceDef{"RegisterTagSpace test", names, true, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if space <= lastSpace || !space.Valid() {
+		t.Errorf("Registered space %d isn't valid", space)
+	}
+	if space.Name() != "RegisterTagSpace test" || space.TagNames()[1] != "One" {
+		t.Error("Registered space has wrong name or tag names")
+	}
+	if _, ok := NewSpaceRec(space).(*GenericSpaceRec); !ok {
+		t.Error("Registered space with Next pointers doesn't use GenericSpaceRec")
+	}
+	nonext, err := RegisterTagSpace(TagSpaceDef{"RegisterTagSpace test 2", nil, false, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonext == space {
+		t.Error("Same space allocated twice")
+	}
+	if _, ok := NewSpaceRec(nonext).(*NoNextSpaceRec); !ok {
+		t.Error("Registered space without Next pointers doesn't use NoNextSpaceRec")
+	}
+	if _, err := RegisterTagSpace(TagSpaceDef{"Exif", nil, false, nil}); err == nil {
+		t.Error("Duplicate space name didn't cause an error")
+	}
+	if (lastSpace + 1).Valid() {
+		t.Error("Unregistered space is valid")
+	}
+	if parsed, err := ParseTagSpace("RegisterTagSpace test"); err != nil || parsed != space {
+		t.Errorf("ParseTagSpace returned %d, %v for a registered space", parsed, err)
+	}
+}
+
+func TestParseTagSpace(t *testing.T) {
+	for space := TIFFSpace; space <= lastSpace; space++ {
+		if parsed, err := ParseTagSpace(space.Name()); err != nil || parsed != space {
+			t.Errorf("ParseTagSpace(%q) returned %d, %v", space.Name(), parsed, err)
+		}
+	}
+	if _, err := ParseTagSpace("exif"); err == nil {
+		t.Error("ParseTagSpace isn't case sensitive")
+	}
+	if name := (lastSpace + 1).Name(); name != fmt.Sprintf("TagSpace(%d)", lastSpace+1) {
+		t.Errorf("Invalid space has name %q", name)
+	}
+	if _, err := ParseTagSpace((lastSpace + 1).Name()); err == nil {
+		t.Error("ParseTagSpace accepted the name of an invalid space")
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
+	"io"
 	"math"
 	"sort"
+	"strings"
+	"sync"
 )
 
 type Type uint8
@@ -193,6 +196,7 @@ const (
 	IPTC                        = 0x83BB // Mentioned in XMP part 3
 	ModelTiepointTag            = 0x8482 // GeoTIFF
 	ModelTransformationTag      = 0x85D8 // GeoTIFF
+	LeafData                    = 0x8606 // Leaf MOS raw files
 	PSIR                        = 0x8649 // Photoshop Image Resources, Mentioned in XMP part 3
 	ExifIFD                     = 0x8769 // Exif 2.3
 	ICCProfile                  = 0x8773 // ICC.1:2003-09
@@ -296,6 +300,7 @@ var TagNames = map[Tag]string{
 	IPTC:                        "IPTC",
 	ModelTiepointTag:            "ModelTiepointTag",
 	ModelTransformationTag:      "ModelTransformationTag",
+	LeafData:                    "LeafData",
 	PSIR:               "PSIR",
 	ExifIFD:            "ExifIFD",
 	ICCProfile:         "ICCProfile",
@@ -314,9 +319,14 @@ type Field struct {
 	Data  []byte
 }
 
-// Field data size.
+// Field data size. A size that doesn't fit in 32 bits is returned as
+// math.MaxUint32, instead of wrapping.
 func (f Field) Size() uint32 {
-	return f.Type.Size() * f.Count
+	size := uint64(f.Type.Size()) * uint64(f.Count)
+	if size > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(size)
 }
 
 // Return a BYTE field's ith data element.
@@ -460,8 +470,9 @@ func (field Field) PutAnyRational(n int64, d int64, i uint32, order binary.ByteO
 		field.PutRational(uint32(n), uint32(d), i, order)
 	case SRATIONAL:
 		field.PutSRational(int32(n), int32(d), i, order)
+	default:
+		panic("PutAnyRational called with wrong type field")
 	}
-	panic("PutAnyRational called with wrong type field")
 }
 
 // Return a FLOAT field's ith data element.
@@ -504,22 +515,16 @@ func (f Field) PutAnyFloat(val float64, i uint32, order binary.ByteOrder) {
 		f.PutFloat(float32(val), i, order)
 	case DOUBLE:
 		f.PutDouble(val, i, order)
+	default:
+		panic("PutAnyFloat called with wrong type field")
 	}
-	panic("PutAnyFloat called with wrong type field")
 }
 
 // Return an ASCII field data as a string. It omits the terminating NUL if
-// present but retains any other NULs.
+// present but retains any other NULs. Bytes that aren't ASCII are
+// converted according to the policy set with SetStringPolicy.
 func (f Field) ASCII() string {
-	l := len(f.Data)
-	if l == 0 {
-		return ""
-	}
-	if f.Data[l-1] == 0 {
-		return string(f.Data[:l-1])
-	} else {
-		return string(f.Data)
-	}
+	return f.DecodeASCII(stringPolicy)
 }
 
 // Set an ASCII field data from a string, including a trailing NUL. The
@@ -530,69 +535,282 @@ func (f *Field) PutASCII(val string) {
 	f.Data[len(val)] = 0
 }
 
-// Helper for Field.Print: print a field's data values.
-func printValues(f Field, order binary.ByteOrder, limit uint32, print func(Field, uint32, binary.ByteOrder)) {
+// A single data element of a field, with the field's type. Integer
+// elements, including those of BYTE, ASCII, UNDEFINED and IFD fields,
+// are held in Int. Rational elements are held in Num and Denom, and
+// floating point elements in Float.
+type Value struct {
+	Type       Type
+	Int        int64
+	Num, Denom int64
+	Float      float64
+}
+
+// Return a field's ith data element as a Value, without the caller
+// needing to check the field type first, and true, or a zero Value and
+// false if the field has an unknown type.
+func (f Field) Value(i uint32, order binary.ByteOrder) (Value, bool) {
+	val := Value{Type: f.Type}
+	switch {
+	case f.Type.IsIntegral():
+		val.Int = f.AnyInteger(i, order)
+	case f.Type == ASCII || f.Type == UNDEFINED:
+		val.Int = int64(f.Data[i])
+	case f.Type == IFD:
+		val.Int = int64(f.Long(i, order))
+	case f.Type.IsRational():
+		val.Num, val.Denom = f.AnyRational(i, order)
+	case f.Type.IsFloat():
+		val.Float = f.AnyFloat(i, order)
+	default:
+		return val, false
+	}
+	return val, true
+}
+
+// Return the number of a field's data elements that are present in its
+// data, which may be fewer than its count, or 0 if it has an unknown
+// type.
+func (f Field) present() uint32 {
+	size := f.Type.Size()
+	if size == 0 {
+		return 0
+	}
+	if n := uint32(len(f.Data)) / size; n < f.Count {
+		return n
+	}
+	return f.Count
+}
+
+// Return a field's data elements as Values. Only the elements present
+// in the field's data are returned, and none if the field has an
+// unknown type.
+func (f Field) Values(order binary.ByteOrder) []Value {
+	vals := make([]Value, f.present())
+	for i := range vals {
+		vals[i], _ = f.Value(uint32(i), order)
+	}
+	return vals
+}
+
+// Set a field's ith data element from a Value. The value may have a
+// different type from the field, e.g., a SHORT value may be stored in
+// a LONG field, but it must be of the same kind: integer, rational or
+// floating point. The value is truncated if it doesn't fit in the
+// field's type.
+func (f Field) SetValue(val Value, i uint32, order binary.ByteOrder) error {
+	byteType := func(t Type) bool {
+		return t == ASCII || t == UNDEFINED
+	}
+	intType := func(t Type) bool {
+		return t.IsIntegral() || byteType(t) || t == IFD
+	}
+	switch {
+	case intType(f.Type) && intType(val.Type):
+		switch {
+		case byteType(f.Type):
+			f.Data[i] = byte(val.Int)
+		case f.Type == IFD:
+			f.PutLong(uint32(val.Int), i, order)
+		default:
+			f.PutAnyInteger(val.Int, i, order)
+		}
+	case f.Type.IsRational() && val.Type.IsRational():
+		f.PutAnyRational(val.Num, val.Denom, i, order)
+	case f.Type.IsFloat() && val.Type.IsFloat():
+		f.PutAnyFloat(val.Float, i, order)
+	default:
+		return fmt.Errorf("Can't store %s value in %s field", val.Type.Name(), f.Type.Name())
+	}
+	return nil
+}
+
+// Return an error if a field's ith data element isn't present, either
+// because i is out of range, or because the field has an unknown type
+// or less data than its count requires. The Checked accessors use this
+// so that malformed fields in untrusted files can't cause panics.
+func (f Field) CheckIndex(i uint32) error {
+	typeSize := f.Type.Size()
+	if typeSize == 0 {
+		return fmt.Errorf("Field %d(0x%X) has unknown type %d", f.Tag, f.Tag, f.Type)
+	}
+	if i >= f.Count {
+		return fmt.Errorf("Field %d(0x%X) has no element %d, count is %d", f.Tag, f.Tag, i, f.Count)
+	}
+	if (uint64(i)+1)*uint64(typeSize) > uint64(len(f.Data)) {
+		return fmt.Errorf("Field %d(0x%X) data is too short for element %d", f.Tag, f.Tag, i)
+	}
+	return nil
+}
+
+// Return an error if a field doesn't have one of the given types or
+// doesn't have an ith data element.
+func (f Field) checkAccess(i uint32, ok bool, kind string) error {
+	if !ok {
+		return fmt.Errorf("Field %d(0x%X) has type %s, not %s", f.Tag, f.Tag, f.Type.Name(), kind)
+	}
+	return f.CheckIndex(i)
+}
+
+// Return a SHORT field's ith data element, or an error if the field
+// has another type or the element isn't present.
+func (f Field) CheckedShort(i uint32, order binary.ByteOrder) (uint16, error) {
+	if err := f.checkAccess(i, f.Type == SHORT, "Short"); err != nil {
+		return 0, err
+	}
+	return f.Short(i, order), nil
+}
+
+// Return a LONG or IFD field's ith data element, or an error if the
+// field has another type or the element isn't present.
+func (f Field) CheckedLong(i uint32, order binary.ByteOrder) (uint32, error) {
+	if err := f.checkAccess(i, f.Type == LONG || f.Type == IFD, "Long"); err != nil {
+		return 0, err
+	}
+	return f.Long(i, order), nil
+}
+
+// Return a RATIONAL field's ith data element, or an error if the field
+// has another type or the element isn't present.
+func (f Field) CheckedRational(i uint32, order binary.ByteOrder) (uint32, uint32, error) {
+	if err := f.checkAccess(i, f.Type == RATIONAL, "Rational"); err != nil {
+		return 0, 0, err
+	}
+	n, d := f.Rational(i, order)
+	return n, d, nil
+}
+
+// Return an integral-valued field's ith data element, or an error if
+// the field doesn't have an integral type or the element isn't present.
+func (f Field) CheckedAnyInteger(i uint32, order binary.ByteOrder) (int64, error) {
+	if err := f.checkAccess(i, f.Type.IsIntegral(), "an integer type"); err != nil {
+		return 0, err
+	}
+	return f.AnyInteger(i, order), nil
+}
+
+// Return a rational-valued field's ith data element, or an error if
+// the field doesn't have a rational type or the element isn't present.
+func (f Field) CheckedAnyRational(i uint32, order binary.ByteOrder) (int64, int64, error) {
+	if err := f.checkAccess(i, f.Type.IsRational(), "a rational type"); err != nil {
+		return 0, 0, err
+	}
+	n, d := f.AnyRational(i, order)
+	return n, d, nil
+}
+
+// Return a floating point field's ith data element, or an error if the
+// field doesn't have a floating point type or the element isn't
+// present.
+func (f Field) CheckedAnyFloat(i uint32, order binary.ByteOrder) (float64, error) {
+	if err := f.checkAccess(i, f.Type.IsFloat(), "a floating point type"); err != nil {
+		return 0, err
+	}
+	return f.AnyFloat(i, order), nil
+}
+
+// Return a field's ith data element as a Value, or an error if the
+// field has an unknown type or the element isn't present.
+func (f Field) CheckedValue(i uint32, order binary.ByteOrder) (Value, error) {
+	if err := f.CheckIndex(i); err != nil {
+		return Value{}, err
+	}
+	val, _ := f.Value(i, order)
+	return val, nil
+}
+
+// Return all of a field's data elements as Values, or an error if the
+// field has an unknown type or its data is shorter than its count
+// requires.
+func (f Field) CheckedValues(order binary.ByteOrder) ([]Value, error) {
+	if f.Count > 0 {
+		if err := f.CheckIndex(f.Count - 1); err != nil {
+			return nil, err
+		}
+	}
+	return f.Values(order), nil
+}
+
+// Helper for Field.text: format a field's data values.
+func formatValues(f Field, order binary.ByteOrder, limit uint32, format func(Field, uint32, binary.ByteOrder) string) string {
 	n := f.Count
 	if limit > 0 && n > limit {
 		n = limit
 	}
+	var b strings.Builder
 	for i := uint32(0); i < n; i++ {
-		print(f, i, order)
+		b.WriteString(" " + format(f, i, order))
 	}
 	if limit > 0 && f.Count > limit {
-		fmt.Print("...")
+		b.WriteString("...")
 	}
-	fmt.Println()
+	return b.String()
 }
 
-// Print a field's name, type, array size, and values up to a given
-// limit (or 0 for no limit).  Names are taken from a map, so that it
-// can work on private IFDs as long as they use the standard TIFF data
-// types.
-func (f Field) Print(order binary.ByteOrder, tagNames map[Tag]string, limit uint32) {
-	tagName, found := tagNames[f.Tag]
-	if found {
-		fmt.Printf("%s %s(%d)", tagName, f.Type.Name(), f.Count)
+// Return a field's name, type, array size, and values up to a given
+// limit (or 0 for no limit), as printed by Print. The values are
+// rendered by a formatter if it's not nil and handles the field.
+func (f Field) text(order binary.ByteOrder, tagNames map[Tag]string, formatter Formatter, limit uint32) string {
+	var str string
+	if tagName, found := tagNames[f.Tag]; found {
+		str = fmt.Sprintf("%s %s(%d)", tagName, f.Type.Name(), f.Count)
 	} else {
-		fmt.Printf("Unknown %d(0x%X) %s(%d)", f.Tag, f.Tag, f.Type.Name(), f.Count)
+		str = fmt.Sprintf("Unknown %d(0x%X) %s(%d)", f.Tag, f.Tag, f.Type.Name(), f.Count)
+	}
+	if f.Data == nil && f.Size() > 0 {
+		return str + " data not loaded"
+	}
+	if formatter != nil {
+		if val, ok := formatter.Format(f, order, limit); ok {
+			return str + " " + val
+		}
 	}
 	switch {
 	case f.Type == ASCII:
-		str := f.ASCII()
-		if limit > 0 && len(str) > int(limit) {
-			fmt.Printf(" %q...\n", str[:limit])
-		} else {
-			fmt.Printf(" %q\n", str)
+		val := f.ASCII()
+		if limit > 0 && len(val) > int(limit) {
+			return fmt.Sprintf("%s %q...", str, val[:limit])
 		}
+		return fmt.Sprintf("%s %q", str, val)
 	case f.Type.IsRational():
-		ratPrinter := func(f Field, i uint32, order binary.ByteOrder) {
+		return str + formatValues(f, order, limit, func(f Field, i uint32, order binary.ByteOrder) string {
 			num, denom := f.AnyRational(i, order)
-			fmt.Printf(" %d/%d", num, denom)
-		}
-		printValues(f, order, limit, ratPrinter)
+			return fmt.Sprintf("%d/%d", num, denom)
+		})
 	case f.Type.IsIntegral():
-		intPrinter := func(f Field, i uint32, order binary.ByteOrder) {
-			fmt.Printf(" %d", f.AnyInteger(i, order))
-		}
-		printValues(f, order, limit, intPrinter)
+		return str + formatValues(f, order, limit, func(f Field, i uint32, order binary.ByteOrder) string {
+			return fmt.Sprintf("%d", f.AnyInteger(i, order))
+		})
 	case f.Type == UNDEFINED:
-		undefPrinter := func(f Field, i uint32, order binary.ByteOrder) {
-			fmt.Printf(" %X", f.Data[i])
-		}
-		printValues(f, order, limit, undefPrinter)
+		return str + formatValues(f, order, limit, func(f Field, i uint32, order binary.ByteOrder) string {
+			return fmt.Sprintf("%X", f.Data[i])
+		})
 	case f.Type.IsFloat():
-		floatPrinter := func(f Field, i uint32, order binary.ByteOrder) {
-			fmt.Printf(" %e", f.AnyFloat(i, order))
-		}
-		printValues(f, order, limit, floatPrinter)
+		return str + formatValues(f, order, limit, func(f Field, i uint32, order binary.ByteOrder) string {
+			return fmt.Sprintf("%e", f.AnyFloat(i, order))
+		})
 	case f.Type == IFD:
-		ifdPrinter := func(f Field, i uint32, order binary.ByteOrder) {
-			fmt.Printf(" %X", f.Long(i, order))
-		}
-		printValues(f, order, limit, ifdPrinter)
-	default:
-		fmt.Println(" unknown data type")
+		return str + formatValues(f, order, limit, func(f Field, i uint32, order binary.ByteOrder) string {
+			return fmt.Sprintf("%X", f.Long(i, order))
+		})
 	}
+	return str + " unknown data type"
+}
+
+// Print a field's name, type, array size, and values up to a given
+// limit (or 0 for no limit).  Names are taken from a map, so that it
+// can work on private IFDs as long as they use the standard TIFF data
+// types. Since the tag space isn't known, formatters registered with
+// RegisterFormatter aren't used; PrintSpace uses them.
+func (f Field) Print(order binary.ByteOrder, tagNames map[Tag]string, limit uint32) {
+	fmt.Println(f.text(order, tagNames, nil, limit))
+}
+
+// Print a field as for Print, with the tag names of a given tag space,
+// and its values rendered by the formatter registered for its space and
+// tag, if any.
+func (f Field) PrintSpace(order binary.ByteOrder, space TagSpace, limit uint32) {
+	fmt.Println(f.text(order, space.TagNames(), space.Formatter(f.Tag), limit))
 }
 
 // Slice pointing to a single segment of image data.
@@ -603,6 +821,23 @@ type ImageData struct {
 	OffsetTag Tag
 	SizeTag   Tag
 	Segments  []ImageSegment
+	// Positions and sizes of the segments in the original input,
+	// if decoded from a file. If Segments is nil, these are used
+	// to read the data on demand.
+	Offsets []uint32
+	Sizes   []uint32
+	reader  io.ReaderAt // Source for segments that haven't been loaded.
+}
+
+// Return the total size of the image data segments. The size is 64
+// bits so that it can't overflow, but may be too large for a TIFF
+// file.
+func (id ImageData) Size() uint64 {
+	size := uint64(0)
+	for i := 0; i < id.numSegments(); i++ {
+		size += uint64(id.segmentSize(i))
+	}
+	return size
 }
 
 // The size of a TIFF header.
@@ -663,6 +898,25 @@ type IFDNode struct {
 	SpaceRec
 	SubIFDs []SubIFD // Links to sub-IFD nodes linked by fields.
 	Next    *IFDNode // Tail link to next node.
+	// Field data that hasn't been read, if decoded with
+	// GetIFDTreeLazy.
+	pending []pendingData
+	// Set while writing with WriteIFDTree.
+	stream *imageStream
+	// Set while writing with WriteIFDTreeShared.
+	sharing *dataSharing
+	// Set while writing with AppendIFDTree.
+	origin *writeOrigin
+	// Position of the IFD in the input from which it was decoded,
+	// if decoded is true.
+	decodedPos uint32
+	decoded    bool
+}
+
+// Return the position of the IFD in the input from which it was
+// decoded, and whether it was decoded from an input.
+func (node IFDNode) Position() (uint32, bool) {
+	return node.decodedPos, node.decoded
 }
 
 // TIFF subifd and the field in the parent that referred to it.
@@ -698,16 +952,17 @@ func maxTableEntries(size uint32) uint32 {
 
 // Serialized size of a node, including its IFD, external data, image
 // data, and maker note headers, but excluding other nodes to which it
-// refers.
-func (node IFDNode) NodeSize() uint32 {
+// refers. The size is 64 bits so that it can't overflow, but may be
+// too large for a TIFF file.
+func (node IFDNode) NodeSize() uint64 {
 	return node.SpaceRec.nodeSize(node)
 }
 
 // Version of NodeSize for generic TIFF nodes.
-func (node IFDNode) genericSize() uint32 {
-	size := node.TableSize()
+func (node IFDNode) genericSize() uint64 {
+	size := uint64(node.TableSize())
 FIELDLOOP:
-	for _, field := range node.Fields {
+	for idx, field := range node.Fields {
 		// Don't double-count arrays that have been unpacked
 		// into subIFDs (such as maker notes). Assume that any
 		// subIFD field with a single-byte type is such an array.
@@ -718,15 +973,20 @@ FIELDLOOP:
 				}
 			}
 		}
-		fsize := field.Size()
+		if node.sharing.isDuplicate(&node.Fields[idx]) {
+			// Refers to data written for another field.
+			continue
+		}
+		// Field.Size saturates for large counts.
+		fsize := uint64(field.Type.Size()) * uint64(field.Count)
 		if fsize > 4 {
 			size += fsize
 		}
 	}
-	imageData := node.GetImageData()
-	for _, id := range imageData {
-		for _, seg := range id.Segments {
-			size += uint32(len(seg))
+	if node.stream == nil {
+		imageData := node.GetImageData()
+		for _, id := range imageData {
+			size += id.Size()
 		}
 	}
 	return size
@@ -740,20 +1000,26 @@ func Align(pos uint32) uint32 {
 	return pos
 }
 
+// Align a 64 bit size to the next word boundary.
+func align64(size uint64) uint64 {
+	return size + size%2
+}
+
 // Return the serialized size of a node and all the nodes to which it refers.
-// Includes all external data, image data, and maker note headers.
-func (node IFDNode) TreeSize() uint32 {
+// Includes all external data, image data, and maker note headers. As
+// for NodeSize, the size can't overflow, but PutIFDTree will return
+// ErrNeedsBigTIFF if it doesn't fit in a TIFF file.
+func (node IFDNode) TreeSize() uint64 {
 	size := node.NodeSize()
 	for i := 0; i < len(node.SubIFDs); i++ {
-		size = Align(size)
+		size = align64(size)
 		size += node.SubIFDs[i].Node.TreeSize()
 	}
 	if node.Next != nil {
-		size = Align(size)
+		size = align64(size)
 		size += node.Next.TreeSize()
 	}
 	return size
-
 }
 
 // Return pointers to fields in the IFD that match the given tags. The
@@ -772,7 +1038,69 @@ func (node IFDNode) FindFields(tags []Tag) []*Field {
 	return fields
 }
 
-// Add some fields to an IFD.
+// Return a pointer to the first field in the IFD with a given tag, and
+// whether it was found.
+func (node IFDNode) FindField(tag Tag) (*Field, bool) {
+	for i := range node.Fields {
+		if node.Fields[i].Tag == tag {
+			return &node.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// Return a pointer to the first field in the IFD with a given tag.
+// Panics if the field isn't found, so should only be used when the
+// field is known to be present, such as after validation.
+func (node IFDNode) MustField(tag Tag) *Field {
+	field, found := node.FindField(tag)
+	if !found {
+		panic(fmt.Sprintf("MustField: field %d(0x%X) not found in %s IFD", tag, tag, node.GetSpace().Name()))
+	}
+	return field
+}
+
+// Return the value of an integer field with a single value, and
+// whether it was found.
+func (node IFDNode) integerField(tag Tag) (uint32, bool) {
+	field, found := node.FindField(tag)
+	if !found || field.Count == 0 || !field.Type.IsIntegral() {
+		return 0, false
+	}
+	return uint32(field.AnyInteger(0, node.Order)), true
+}
+
+// Return a pointer to the field in an IFD with a given tag name, as
+// known for the IFD's tag space, or nil if not found.
+func (node IFDNode) FieldByName(name string) *Field {
+	tag, found := node.GetSpace().TagByName(name)
+	if !found {
+		return nil
+	}
+	field, _ := node.FindField(tag)
+	return field
+}
+
+// Search a node and all the nodes to which it refers, in the order
+// used by Walk, for a field with a given tag name. Returns the first
+// field found and the node containing it, or nils if not found.
+func (node *IFDNode) FindFieldByName(name string) (*IFDNode, *Field) {
+	var foundNode *IFDNode
+	var foundField *Field
+	found := errors.New("found")
+	node.Walk(func(n *IFDNode, _ Tag) error {
+		if f := n.FieldByName(name); f != nil {
+			foundNode, foundField = n, f
+			return found
+		}
+		return nil
+	})
+	return foundNode, foundField
+}
+
+// Add some fields to an IFD and sort all the fields by tag. Tags that
+// are already present aren't detected, so use AddField when duplicates
+// are possible.
 func (node *IFDNode) AddFields(fields []Field) {
 	addLen := len(fields)
 	if addLen == 0 {
@@ -794,6 +1122,87 @@ func (node *IFDNode) AddFields(fields []Field) {
 	node.Fields = newFields
 }
 
+// Add a field to an IFD, inserting it before the first field with a
+// larger tag, so that sorted fields stay sorted. If the IFD already has
+// a field with the same tag, the first such field is replaced if
+// replace is true, otherwise an error is returned and the IFD isn't
+// changed.
+func (node *IFDNode) AddField(field Field, replace bool) error {
+	if existing, found := node.FindField(field.Tag); found {
+		if !replace {
+			return fmt.Errorf("AddField: node already has field %d(0x%X)", field.Tag, field.Tag)
+		}
+		*existing = field
+		return nil
+	}
+	i := 0
+	for i < len(node.Fields) && node.Fields[i].Tag < field.Tag {
+		i++
+	}
+	node.Fields = append(node.Fields, Field{})
+	copy(node.Fields[i+1:], node.Fields[i:])
+	node.Fields[i] = field
+	return nil
+}
+
+// Replace the field in an IFD that has the same tag as the given
+// field, or add it if there isn't one.
+func (node *IFDNode) replaceField(field Field) {
+	node.AddField(field, true)
+}
+
+// Add a sub-IFD to a node, and create or extend the field with the
+// given tag that points to it. If the child is a maker note, the field
+// is created with UNDEFINED type, and the node mustn't already have a
+// field with the tag. Otherwise the field is created with LONG type,
+// or if it already exists, it must have a type with size 4 and is
+// extended with a placeholder for the new pointer, which will be
+// filled in when the tree is serialized.
+func (node *IFDNode) AddSubIFD(tag Tag, child *IFDNode) error {
+	if child == nil {
+		return errors.New("AddSubIFD: child is nil")
+	}
+	fields := node.FindFields([]Tag{tag})
+	if child.IsMakerNote() {
+		if len(fields) > 0 || node.nthSubIFD(tag, 0) >= 0 {
+			return fmt.Errorf("AddSubIFD: node already has field %d(0x%X)", tag, tag)
+		}
+		node.SubIFDs = append(node.SubIFDs, SubIFD{tag, child})
+		node.AddField(Field{tag, UNDEFINED, 0, nil}, true)
+		return nil
+	}
+	if len(fields) > 0 && fields[0].Type.Size() != 4 {
+		return fmt.Errorf("AddSubIFD: field %d(0x%X) has type %s, can't point to a sub-IFD", tag, tag, fields[0].Type.Name())
+	}
+	node.SubIFDs = append(node.SubIFDs, SubIFD{tag, child})
+	node.addSubIFDPointer(Field{tag, LONG, 0, nil})
+	return nil
+}
+
+// Helper for SetExifIFD and SetGPSIFD.
+func (node *IFDNode) setSubIFD(tag Tag, child *IFDNode, space TagSpace) error {
+	if child == nil || child.GetSpace() != space {
+		return fmt.Errorf("Sub-IFD for field %d(0x%X) must have space %s", tag, tag, space.Name())
+	}
+	if idx := node.nthSubIFD(tag, 0); idx >= 0 {
+		node.SubIFDs[idx].Node = child
+		return nil
+	}
+	return node.AddSubIFD(tag, child)
+}
+
+// Set the Exif IFD of a TIFF node, replacing any existing one, and
+// create the ExifIFD field if required. The child must have ExifSpace.
+func (node *IFDNode) SetExifIFD(child *IFDNode) error {
+	return node.setSubIFD(ExifIFD, child, ExifSpace)
+}
+
+// Set the GPS IFD of a TIFF node, replacing any existing one, and
+// create the GPSIFD field if required. The child must have GPSSpace.
+func (node *IFDNode) SetGPSIFD(child *IFDNode) error {
+	return node.setSubIFD(GPSIFD, child, GPSSpace)
+}
+
 // Delete some fields from an IFD.
 func (node *IFDNode) DeleteFields(tags []Tag) {
 	shift := 0
@@ -820,7 +1229,7 @@ func (node *IFDNode) DeleteFields(tags []Tag) {
 // the next IFD. The error may be a multierror structure.
 func GetIFDTree(buf []byte, order binary.ByteOrder, pos uint32, space TagSpace) (*IFDNode, error) {
 	ifdPositions := make(posMap)
-	return getIFDTreeIter(buf, order, pos, NewSpaceRec(space), ifdPositions)
+	return getIFDTreeIter(bufSource(buf), order, pos, NewSpaceRec(space), ifdPositions)
 }
 
 // Map and key for cycle detection, by recording the positions of
@@ -834,41 +1243,69 @@ func GetIFDTree(buf []byte, order binary.ByteOrder, pos uint32, space TagSpace)
 // unlikely since there's usually only a single maker note.
 type posMap map[[2]uint32]bool
 
-func posKey(buf []byte, pos uint32) [2]uint32 {
-	return [2]uint32{uint32(len(buf)), pos}
+func posKey(src source, pos uint32) [2]uint32 {
+	return [2]uint32{src.len(), pos}
 }
 
 // Helper for GetIFDTree.
-func getIFDTreeIter(buf []byte, order binary.ByteOrder, pos uint32, spaceRec SpaceRec, ifdPositions posMap) (*IFDNode, error) {
+func getIFDTreeIter(src source, order binary.ByteOrder, pos uint32, spaceRec SpaceRec, ifdPositions posMap) (*IFDNode, error) {
 	var node IFDNode
 	node.Order = order
 	node.SpaceRec = spaceRec
-	return &node, node.SpaceRec.getIFDTree(&node, buf, pos, ifdPositions)
+	src.depth++
+	if err := src.enterIFD(pos); err != nil {
+		return &node, err
+	}
+	return &node, node.SpaceRec.getIFDTree(&node, src, pos, ifdPositions)
 }
 
 // Version of getIFDTreeIter without subspace-specific header processing. Try to read fields and process sub-IFDs.
-func (node *IFDNode) genericGetIFDTreeIter(buf []byte, pos uint32, ifdPositions posMap) error {
+func (node *IFDNode) genericGetIFDTreeIter(src source, pos uint32, ifdPositions posMap) error {
 	space := node.GetSpace()
 	// ifdpos is the byte position in the file, except in certain maker notes.
 	ifdpos := pos
-	if ifdPositions[posKey(buf, pos)] {
+	if src.stopped() {
+		return nil
+	}
+	if !src.visitIFD(ifdPositions, pos) {
 		return fmt.Errorf("IFD cycle detected in %s IFD at %d", space.Name(), ifdpos)
 	}
-	ifdPositions[posKey(buf, pos)] = true
+	node.decodedPos = src.base + pos
+	node.decoded = true
 	node.SubIFDs = make([]SubIFD, 0, 10)
-	bufsize := uint32(len(buf))
+	bufsize := src.len()
 	if pos+2 < pos || pos+2 > bufsize {
 		return fmt.Errorf("Could not read %s IFD at %d: past end of input", space.Name(), ifdpos)
 	}
 	order := node.Order
 	// Whether to process the pointer at the end of the IFD that points to the next one.
 	processNext := true
-	entries := order.Uint16(buf[pos:]) // IFD entry count.
-	var err error
+	countData, err := src.data(pos, 2)
+	if err != nil {
+		return fmt.Errorf("Could not read %s IFD at %d: %s", space.Name(), ifdpos, err)
+	}
+	entries := order.Uint16(countData) // IFD entry count.
+	stop := false                      // Whether to stop reading due to a problem.
+	if newPos, newEntries, found := src.resyncIFD(order, pos); found {
+		if err, stop = src.addProblem(err, fmt.Errorf("%s IFD at %d isn't plausible, reading table found at %d", space.Name(), ifdpos, newPos)); stop {
+			return err
+		}
+		if !src.visitIFD(ifdPositions, newPos) {
+			return multierror.Append(err, fmt.Errorf("IFD cycle detected in %s IFD at %d", space.Name(), newPos))
+		}
+		pos, ifdpos, entries = newPos, newPos, newEntries
+		node.decodedPos = src.base + pos
+	}
 	if entries == 0 {
 		// Technically an error since the TIFF spec doesn't permit IFDs with no entries. There may still be
 		// a Next pointer.
-		err = multierror.Append(err, fmt.Errorf("%s IFD at %d doesn't contain any fields", space.Name(), ifdpos))
+		if err, stop = src.addProblem(err, fmt.Errorf("%s IFD at %d doesn't contain any fields", space.Name(), ifdpos)); stop {
+			return err
+		}
+	}
+	if limitErr := src.checkFields(ifdpos, entries); limitErr != nil {
+		err, _ = src.addProblem(err, limitErr)
+		return err
 	}
 	tabsize := TableSize(entries)
 	if pos+tabsize < pos || pos+tabsize > bufsize {
@@ -876,39 +1313,102 @@ func (node *IFDNode) genericGetIFDTreeIter(buf []byte, pos uint32, ifdPositions
 		// The table extends past the end of the buffer:
 		// examine the table for possibly valid fields - tags
 		// should be increasing in value.
-		entries = uint16(maxTableEntries(bufsize - pos))
+		entries = 0
+		if bufsize-pos >= TableOverhead {
+			entries = uint16(maxTableEntries(bufsize - pos))
+		}
+		tabsize = TableSize(entries)
+	}
+	if limitErr := src.useData(uint64(tabsize)); limitErr != nil {
+		err, _ = src.addProblem(err, limitErr)
+		return err
+	}
+	// The table, excluding the entry count.
+	table, tableErr := src.data(pos+2, tabsize-2)
+	if tableErr != nil {
+		err, _ = src.addProblem(err, fmt.Errorf("Could not read %s IFD at %d: %s", space.Name(), ifdpos, tableErr))
+		return err
+	}
+	if !processNext {
 		for i, last := uint16(0), Tag(0); i < entries; i++ {
-			tagpos := pos + 2 + uint32(i*TableEntrySize)
-			tag := Tag(order.Uint16(buf[tagpos:]))
+			tag := Tag(order.Uint16(table[uint32(i)*TableEntrySize:]))
 			if tag < last {
 				entries = i
 				break
 			}
 			last = tag
 		}
-		err = multierror.Append(err, fmt.Errorf("%s IFD at %d extends past end of input, attempting to read %d entries", space.Name(), ifdpos, entries))
+		if err, stop = src.addProblem(err, fmt.Errorf("%s IFD at %d extends past end of input, attempting to read %d entries", space.Name(), ifdpos, entries)); stop {
+			return err
+		}
 	}
-	pos += 2
+	if processNext && node.SpaceRec.IsMakerNote() {
+		if delta, found := src.makerNoteDelta(order, table, pos, entries, space); found {
+			if err, stop = src.addProblem(err, fmt.Errorf("Offsets in %s maker note IFD at %d have the wrong base, correcting them by %d", space.Name(), ifdpos, delta)); stop {
+				return err
+			}
+			src.delta = delta
+		}
+	}
+	if processNext && src.scoringEntries() {
+		if limit, overlap := src.overlapEntries(order, table, pos, entries); limit < entries {
+			processNext = false
+			entries = limit
+			if err, stop = src.addProblem(err, fmt.Errorf("%s IFD at %d overlaps field data at %d, reading %d entries", space.Name(), ifdpos, overlap, entries)); stop {
+				return err
+			}
+		}
+	}
+	tpos := uint32(0) // Position in table.
+	lastTag := Tag(0) // Tag of the previous entry.
 	fields := make([]Field, 0, entries)
 	for i := uint16(0); i < entries; i++ {
 		var field Field
-		field.Tag = Tag(order.Uint16(buf[pos:]))
-		pos += 2
-		field.Type = Type(order.Uint16(buf[pos:]))
-		pos += 2
-		field.Count = order.Uint32(buf[pos:])
-		pos += 4
+		field.Tag = Tag(order.Uint16(table[tpos:]))
+		tpos += 2
+		field.Type = Type(order.Uint16(table[tpos:]))
+		tpos += 2
+		field.Count = order.Uint32(table[tpos:])
+		tpos += 4
 		size := field.Size()
-		dataPos := pos
-		pos += 4
+		dataPos := pos + 2 + tpos
+		ordered := i == 0 || field.Tag > lastTag
+		lastTag = field.Tag
+		if src.scoringEntries() && entryScore(field, ordered, src.offset(order.Uint32(table[tpos:])), pos, pos+tabsize, bufsize) < plausibleEntryScore {
+			if err, stop = src.addProblem(err, fmt.Errorf("Skipping implausible field %d with tag %d (0x%0X) in %s IFD at %d", i, field.Tag, field.Tag, space.Name(), ifdpos)); stop {
+				break
+			}
+			tpos += 4
+			continue
+		}
+		if typeSize := field.Type.Size(); uint64(typeSize)*uint64(field.Count) > math.MaxUint32 || typeSize == 0 && field.Count > 4 {
+			// The data would be larger than the input can be,
+			// or has an unknown type and can only be located if
+			// it fits in the entry.
+			if err, stop = src.addProblem(err, fmt.Errorf("Skipping field %d with tag %d (0x%0X) in %s IFD at %d: invalid count %d for type %d", i, field.Tag, field.Tag, space.Name(), ifdpos, field.Count, field.Type)); stop {
+				break
+			}
+			tpos += 4
+			continue
+		}
 		if size <= 4 {
-			field.Data = buf[dataPos : dataPos+size]
+			field.Data = table[tpos : tpos+size]
 		} else {
-			dataPos = order.Uint32(buf[dataPos:])
+			dataPos = src.offset(order.Uint32(table[tpos:]))
+			if count := src.truncatedCount(field, dataPos); count > 0 && !(space == Sony1Space && field.Tag == sony1PreviewImage) {
+				if err, stop = src.addProblem(err, fmt.Errorf("Truncating field %d with tag %d (0x%0X) in %s IFD at %d from %d to %d values: data at %d past end of input", i, field.Tag, field.Tag, space.Name(), ifdpos, field.Count, count, dataPos)); stop {
+					break
+				}
+				field.Count = count
+				size = field.Size()
+			}
 			if dataPos+size < dataPos || dataPos+size > bufsize {
 				if space == Sony1Space && field.Tag == sony1PreviewImage {
 					if field.Type != UNDEFINED {
-						err = multierror.Append(err, fmt.Errorf("Skipping field PreviewImage in Sony1 IFD because wrong type %s", field.Type.Name()))
+						if err, stop = src.addProblem(err, fmt.Errorf("Skipping field PreviewImage in Sony1 IFD because wrong type %s", field.Type.Name())); stop {
+							break
+						}
+						tpos += 4
 						continue
 					}
 					// Field data is outside the current Exif block. Just save the size and position.
@@ -917,66 +1417,89 @@ func (node *IFDNode) genericGetIFDTreeIter(buf []byte, pos uint32, ifdPositions
 					order.PutUint32(field.Data[4:], dataPos)
 					field.Count = 8
 				} else {
-					err = multierror.Append(err, fmt.Errorf("Skipping field %d with tag %d (0x%0X) in %s IFD at %d: data at %d past end of input", i, field.Tag, field.Tag, space.Name(), ifdpos, dataPos))
+					if err, stop = src.addProblem(err, fmt.Errorf("Skipping field %d with tag %d (0x%0X) in %s IFD at %d: data at %d past end of input", i, field.Tag, field.Tag, space.Name(), ifdpos, dataPos)); stop {
+						break
+					}
+					tpos += 4
 					continue
 				}
+			} else if src.deferField(field) {
+				// Leave the data to be read later.
+				node.pending = append(node.pending, pendingData{field.Tag, src.base + dataPos, src.r})
+			} else if limitErr := src.useData(uint64(size)); limitErr != nil {
+				err, stop = src.addProblem(err, limitErr)
+				break
 			} else {
-				field.Data = buf[dataPos : dataPos+size]
+				var dataErr error
+				field.Data, dataErr = src.data(dataPos, size)
+				if dataErr != nil {
+					if err, stop = src.addProblem(err, fmt.Errorf("Skipping field %d with tag %d (0x%0X) in %s IFD at %d: %s", i, field.Tag, field.Tag, space.Name(), ifdpos, dataErr)); stop {
+						break
+					}
+					tpos += 4
+					continue
+				}
+				src.addRegion(LayoutRegion{Start: src.base + dataPos, End: src.base + dataPos + size, Kind: RegionFieldData, Space: space, IFDPos: src.base + pos, Tag: field.Tag})
 			}
 		}
+		tpos += 4
 		// Space-specific field processing, including subIFD recursion.
-		subIFDs, fieldErr := node.SpaceRec.takeField(buf, order, ifdPositions, i, field, dataPos)
-		if fieldErr != nil {
-			err = multierror.Append(err, fieldErr)
-		}
+		subIFDs, fieldErr := node.SpaceRec.takeField(src, order, ifdPositions, i, field, dataPos)
 		if subIFDs != nil {
 			node.SubIFDs = append(node.SubIFDs, subIFDs...)
 		}
 		fields = append(fields, field)
+		if fieldErr != nil {
+			if err, stop = src.addProblem(err, fieldErr); stop {
+				break
+			}
+		}
 	}
 	node.Fields = fields
-	if processNext {
-		footerErr := node.SpaceRec.getFooter(node, buf, pos, ifdPositions)
+	node.addLayout(src, pos, tabsize)
+	if processNext && !stop {
+		footerErr := node.SpaceRec.getFooter(node, src, pos+2+tpos, ifdPositions)
 		if footerErr != nil {
-			err = multierror.Append(err, footerErr)
+			err, _ = src.addProblem(err, footerErr)
 		}
 	}
 	return err
 }
 
 // Generic processing of the "next" pointer at the end of an IFD. Modifies node.
-func (node *IFDNode) genericGetFooter(buf []byte, pos uint32, nextSpace TagSpace, ifdPositions posMap) error {
-	buflen := uint32(len(buf))
+func (node *IFDNode) genericGetFooter(src source, pos uint32, nextSpace TagSpace, ifdPositions posMap) error {
+	buflen := src.len()
 	space := node.GetSpace()
-	if pos+4 < pos || pos+4 > buflen {
+	nextData, err := src.data(pos, 4)
+	if err != nil {
 		// This shouldn't happen, since table size is checked earlier.
 		return fmt.Errorf("Can't read Next pointer in %s IFD; past end of input", space.Name())
 	}
-	next := node.Order.Uint32(buf[pos:])
+	next := node.Order.Uint32(nextData)
 	if next > 0 {
 		if next >= buflen {
 			return fmt.Errorf("Next pointer %d in %s IFD past end of input", next, space.Name())
 		}
 		var err error
-		node.Next, err = getIFDTreeIter(buf, node.Order, next, NewSpaceRec(nextSpace), ifdPositions)
+		node.Next, err = getIFDTreeIter(src, node.Order, next, NewSpaceRec(nextSpace), ifdPositions)
 		return err
 	}
 	return nil
 }
 
 // Similar to genericGetFooter, but additionally add an error if a next IFD is found.
-func (node *IFDNode) unexpectedFooter(buf []byte, pos uint32, ifdPositions posMap) error {
-	buflen := uint32(len(buf))
+func (node *IFDNode) unexpectedFooter(src source, pos uint32, ifdPositions posMap) error {
 	space := node.GetSpace()
-	if pos+4 < pos || pos+4 > buflen {
+	nextData, err := src.data(pos, 4)
+	if err != nil {
 		// This shouldn't happen, since table size is checked earlier.
 		return fmt.Errorf("Can't read Next pointer in %s IFD; past end of input", space.Name())
 	}
-	next := node.Order.Uint32(buf[pos:])
+	next := node.Order.Uint32(nextData)
 	if next != 0 {
 		err := fmt.Errorf("Unexpected pointer %d to next IFD in %s IFD", next, space.Name())
 		// Unexpected, but process it anyway.
-		return multierror.Append(err, node.genericGetFooter(buf, pos, space, ifdPositions))
+		return multierror.Append(err, node.genericGetFooter(src, pos, space, ifdPositions))
 	}
 	return nil
 }
@@ -1006,10 +1529,21 @@ const (
 	Olympus1ImageProcessingSpace TagSpace = 17
 	Olympus1FocusInfoSpace       TagSpace = 18
 	Panasonic1Space              TagSpace = 19
-	Sony1Space                   TagSpace = 21 // last
+	Sony1Space                   TagSpace = 21
+	DNGSpace                     TagSpace = 22
+	SR2PrivateSpace              TagSpace = 23
+	SonyIDCSpace                 TagSpace = 24
+	Pentax1Space                 TagSpace = 25
+	Leica1Space                  TagSpace = 26
+	PhaseOneSpace                TagSpace = 27
+	Hasselblad1Space             TagSpace = 28 // last
 )
 
-// Return the name of a tag namespace.
+// The largest built-in TagSpace.
+const lastSpace = Hasselblad1Space
+
+// Return the name of a tag namespace. Values that aren't valid are
+// named by number, e.g., "TagSpace(40)".
 func (space TagSpace) Name() string {
 	switch space {
 	case TIFFSpace:
@@ -1054,10 +1588,89 @@ func (space TagSpace) Name() string {
 		return "Panasonic1"
 	case Sony1Space:
 		return "Sony1"
+	case DNGSpace:
+		return "DNG"
+	case SR2PrivateSpace:
+		return "SR2Private"
+	case SonyIDCSpace:
+		return "SonyIDC"
+	case Pentax1Space:
+		return "Pentax1"
+	case Leica1Space:
+		return "Leica1"
+	case PhaseOneSpace:
+		return "PhaseOne"
+	case Hasselblad1Space:
+		return "Hasselblad1"
 	case UnknownSpace:
 		return "Unknown"
 	}
-	panic("TagSpace.Name: invalid value")
+	if def, ok := registeredSpaces[space]; ok {
+		return def.Name
+	}
+	return fmt.Sprintf("TagSpace(%d)", uint8(space))
+}
+
+// Return the tag namespace with a given name, as returned by Name,
+// including spaces registered with RegisterTagSpace. Names are case
+// sensitive.
+func ParseTagSpace(name string) (TagSpace, error) {
+	for space := TIFFSpace; ; space++ {
+		if space.Valid() && space.Name() == name {
+			return space, nil
+		}
+		if space == ^TagSpace(0) {
+			break
+		}
+	}
+	return 0, fmt.Errorf("ParseTagSpace: unknown tag space %q", name)
+}
+
+// Definition of a tag namespace registered with RegisterTagSpace.
+type TagSpaceDef struct {
+	Name     string
+	TagNames map[Tag]string // May be nil.
+	// Indicates if IFDs in the space may have a Next pointer to
+	// another IFD in the same space. Otherwise Next pointers are
+	// treated as errors.
+	Next bool
+	// If not nil, creates the SpaceRec for nodes in the space,
+	// usually a CustomSpaceRec. Next is then ignored.
+	NewSpaceRec func() SpaceRec
+}
+
+// Tag namespaces registered with RegisterTagSpace.
+var registeredSpaces = make(map[TagSpace]TagSpaceDef)
+
+// Register a new tag namespace, for IFDs that aren't defined by this
+// package, and return its TagSpace. Spaces are allocated downwards from
+// the largest TagSpace value, so they don't conflict with the built-in
+// spaces. Nodes in the space are decoded generically, unless the
+// definition supplies its own SpaceRec.
+func RegisterTagSpace(def TagSpaceDef) (TagSpace, error) {
+	if def.Name == "" {
+		return 0, errors.New("RegisterTagSpace: name is empty")
+	}
+	if _, err := ParseTagSpace(def.Name); err == nil {
+		return 0, fmt.Errorf("RegisterTagSpace: name %q is already used", def.Name)
+	}
+	for space := ^TagSpace(0); space > lastSpace; space-- {
+		if _, ok := registeredSpaces[space]; !ok {
+			registeredSpaces[space] = def
+			return space, nil
+		}
+	}
+	return 0, errors.New("RegisterTagSpace: no free tag spaces")
+}
+
+// Indicate if a TagSpace is one of the built-in spaces or has been
+// registered with RegisterTagSpace.
+func (space TagSpace) Valid() bool {
+	if space <= lastSpace {
+		return true
+	}
+	_, ok := registeredSpaces[space]
+	return ok
 }
 
 // Return the byte order for an IFD with given tag namespace, given a
@@ -1067,17 +1680,113 @@ func (space TagSpace) ByteOrder(deforder binary.ByteOrder) binary.ByteOrder {
 	return deforder
 }
 
+// Tag name maps registered with RegisterTagNames.
+var registeredTagNames = make(map[TagSpace]map[Tag]string)
+
+// Register a map of tag names for a tag namespace, which will be
+// returned by TagSpace.TagNames in preference to the built-in map, if
+// any. A nil map removes a previous registration.
+func RegisterTagNames(space TagSpace, names map[Tag]string) {
+	if names == nil {
+		delete(registeredTagNames, space)
+	} else {
+		registeredTagNames[space] = names
+	}
+}
+
+// Return the mappings from tags to strings for a tag namespace, or nil
+// if none are known.
+func (space TagSpace) TagNames() map[Tag]string {
+	if names, ok := registeredTagNames[space]; ok {
+		return names
+	}
+	switch space {
+	case TIFFSpace:
+		return tiffSpaceTagNames
+	case ExifSpace:
+		return ExifTagNames
+	case GPSSpace:
+		return GPSTagNames
+	case InteropSpace:
+		return InteropTagNames
+	case Canon1Space:
+		return Canon1TagNames
+	case Fujifilm1Space:
+		return Fujifilm1TagNames
+	case Nikon1Space:
+		return Nikon1TagNames
+	case MPFIndexSpace:
+		return MPFIndexTagNames
+	case MPFAttributeSpace:
+		return MPFAttributeTagNames
+	case Nikon2Space:
+		return Nikon2TagNames
+	case Nikon2PreviewSpace:
+		return Nikon2PreviewTagNames
+	case Nikon2ScanSpace:
+		return Nikon2ScanTagNames
+	case Olympus1Space:
+		return Olympus1TagNames
+	case Olympus1EquipmentSpace:
+		return Olympus1EquipmentTagNames
+	case Olympus1CameraSettingsSpace:
+		return Olympus1CameraSettingsTagNames
+	case Olympus1RawDevelopmentSpace:
+		return Olympus1RawDevelopmentTagNames
+	case Olympus1RawDev2Space:
+		return Olympus1RawDev2TagNames
+	case Olympus1ImageProcessingSpace:
+		return Olympus1ImageProcessingTagNames
+	case Olympus1FocusInfoSpace:
+		return Olympus1FocusInfoTagNames
+	case Panasonic1Space:
+		return Panasonic1TagNames
+	case Sony1Space:
+		return Sony1TagNames
+	case DNGSpace:
+		return DNGTagNames
+	case SR2PrivateSpace:
+		return SR2PrivateTagNames
+	case SonyIDCSpace:
+		return SonyIDCTagNames
+	case Pentax1Space:
+		return Pentax1TagNames
+	case Leica1Space:
+		return Leica1TagNames
+	case PhaseOneSpace:
+		return PhaseOneTagNames
+	case Hasselblad1Space:
+		return Hasselblad1TagNames
+	}
+	return registeredSpaces[space].TagNames
+}
+
+// Return the tag with a given name in a tag namespace, according to
+// the map returned by TagNames, and whether it was found. If several
+// tags have the name, the lowest is returned.
+func (space TagSpace) TagByName(name string) (Tag, bool) {
+	var tag Tag
+	found := false
+	for t, n := range space.TagNames() {
+		if n == name && (!found || t < tag) {
+			tag = t
+			found = true
+		}
+	}
+	return tag, found
+}
+
 // An interface for node-space-specific functionality.
 type SpaceRec interface {
 	GetSpace() TagSpace
 	IsMakerNote() bool
-	nodeSize(IFDNode) uint32
-	takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error)
-	getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error
+	nodeSize(IFDNode) uint64
+	takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error)
+	getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error
 	// Called by getIFDTree to process the part of the IFD
 	// following the field entries, usually 4 bytes with the next
 	// IFD or zero. The next IFD will be read recursively.
-	getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error
+	getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error
 	putIFDTree(IFDNode, []byte, uint32) (uint32, error)
 	// Return ImageData, which can be the arrays of scan data that may be
 	// found in TIFF nodes, or any other data that's specified with
@@ -1097,7 +1806,7 @@ func NewSpaceRec(space TagSpace) SpaceRec {
 	case Fujifilm1Space:
 		return &Fujifilm1SpaceRec{}
 	case MPFIndexSpace:
-		return &MPFIndexSpaceRec{}
+		return &MPFIndexSpaceRec{space: MPFIndexSpace}
 	case Nikon1Space:
 		return &Nikon1SpaceRec{}
 	case Nikon2Space:
@@ -1110,9 +1819,31 @@ func NewSpaceRec(space TagSpace) SpaceRec {
 		return &Panasonic1SpaceRec{}
 	case Sony1Space:
 		return &Sony1SpaceRec{}
+	case DNGSpace:
+		return &DNGSpaceRec{}
+	case SR2PrivateSpace:
+		return &SR2PrivateSpaceRec{}
+	case SonyIDCSpace:
+		return &SonyIDCSpaceRec{}
+	case Pentax1Space:
+		return &Pentax1SpaceRec{}
+	case Leica1Space:
+		return &Leica1SpaceRec{}
+	case PhaseOneSpace:
+		return &PhaseOneSpaceRec{}
+	case Hasselblad1Space:
+		return &Hasselblad1SpaceRec{}
 	default:
 		// Don't expect Next pointers to be present in any of the
 		// known IFDs, but permit them in unknown IFDs.
+		if def, ok := registeredSpaces[space]; ok {
+			if def.NewSpaceRec != nil {
+				return def.NewSpaceRec()
+			}
+			if def.Next {
+				return &GenericSpaceRec{space: space}
+			}
+		}
 		if space != UnknownSpace {
 			return &NoNextSpaceRec{space: space}
 		}
@@ -1120,20 +1851,63 @@ func NewSpaceRec(space TagSpace) SpaceRec {
 	}
 }
 
+// Return the SpaceRec for the ith sub-IFD of a field. SpaceRecs may
+// record data for their IFD, such as image segments, so each sub-IFD
+// needs its own. spaceRec hasn't been used yet, so a copy of an
+// ExifSpaceRec retains only the camera make and model.
+func subIFDSpaceRec(spaceRec SpaceRec, i uint32) SpaceRec {
+	if i == 0 {
+		return spaceRec
+	}
+	if exif, ok := spaceRec.(*ExifSpaceRec); ok {
+		rec := *exif
+		return &rec
+	}
+	return NewSpaceRec(spaceRec.GetSpace())
+}
+
 // Recursively read SubIFDs specified with a given field. Such fields
-// contain pointer(s) to the SubIFD location(s).
-func recurseSubIFDs(buf []byte, order binary.ByteOrder, ifdPositions posMap, field Field, spaceRec SpaceRec) ([]SubIFD, error) {
-	var subIFDs []SubIFD
+// contain pointer(s) to the SubIFD location(s). If parse options allow,
+// the sub-IFDs are read in parallel. Only the pointers present in the
+// field's data are followed.
+func recurseSubIFDs(src source, order binary.ByteOrder, ifdPositions posMap, field Field, spaceRec SpaceRec) ([]SubIFD, error) {
+	count := field.Count
+	if present := uint32(len(field.Data) / 4); present < count {
+		count = present
+	}
+	nodes := make([]*IFDNode, count)
+	errs := make([]error, count)
+	n := count // Number of sub-IFDs to return.
+	var wg sync.WaitGroup
+	for i := uint32(0); i < count; i++ {
+		rec := subIFDSpaceRec(spaceRec, i)
+		pos := src.offset(field.Long(i, order))
+		if i+1 < count && src.reserveWorker() {
+			wg.Add(1)
+			go func(i uint32) {
+				defer wg.Done()
+				defer src.releaseWorker()
+				nodes[i], errs[i] = getIFDTreeIter(src, order, pos, rec, ifdPositions)
+				if errs[i] != nil {
+					src.countProblem(errs[i])
+				}
+			}(i)
+			continue
+		}
+		nodes[i], errs[i] = getIFDTreeIter(src, order, pos, rec, ifdPositions)
+		if errs[i] != nil && src.countProblem(errs[i]) {
+			n = i + 1
+			break
+		}
+	}
+	wg.Wait()
+	subIFDs := make([]SubIFD, n)
 	var err error
-	for i := uint32(0); i < field.Count; i++ {
-		var sub SubIFD
-		sub.Tag = field.Tag
-		var suberr error
-		sub.Node, suberr = getIFDTreeIter(buf, order, field.Long(i, order), spaceRec, ifdPositions)
-		if suberr != nil {
-			err = multierror.Append(err, suberr)
+	for i := range subIFDs {
+		subIFDs[i] = SubIFD{field.Tag, nodes[i]}
+		if errs[i] != nil {
+			err = multierror.Append(err, errs[i])
 		}
-		subIFDs = append(subIFDs, sub)
 	}
 	return subIFDs, err
 }
@@ -1151,27 +1925,27 @@ func (*GenericSpaceRec) IsMakerNote() bool {
 	return false
 }
 
-func (*GenericSpaceRec) nodeSize(node IFDNode) uint32 {
+func (*GenericSpaceRec) nodeSize(node IFDNode) uint64 {
 	return node.genericSize()
 }
 
-func (rec *GenericSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (rec *GenericSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	// Process a field of type IFD: these declare a subIFD, and
 	// can be potentially found in any IFD.  Assume the subIFD has
 	// the same space as the current IFD.
 	if field.Type == IFD {
-		return recurseSubIFDs(buf, order, ifdPositions, field, NewSpaceRec(rec.space))
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(rec.space))
 	}
 	return nil, nil
 }
 
-func (*GenericSpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+func (*GenericSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 }
 
-func (rec *GenericSpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *GenericSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	// Assume any following IFD has the same space as the current.
-	return node.genericGetFooter(buf, pos, rec.space, ifdPositions)
+	return node.genericGetFooter(src, pos, rec.space, ifdPositions)
 }
 
 func (*GenericSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -1196,26 +1970,26 @@ func (*NoNextSpaceRec) IsMakerNote() bool {
 	return false
 }
 
-func (*NoNextSpaceRec) nodeSize(node IFDNode) uint32 {
+func (*NoNextSpaceRec) nodeSize(node IFDNode) uint64 {
 	return node.genericSize()
 }
 
-func (rec *NoNextSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (rec *NoNextSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	// Process a field of type IFD: these declare a subIFD, and
 	// can be potentially found in any IFD.  Assume the subIFD has
 	// the same space as the current IFD.
 	if field.Type == IFD {
-		return recurseSubIFDs(buf, order, ifdPositions, field, NewSpaceRec(rec.space))
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(rec.space))
 	}
 	return nil, nil
 }
 
-func (*NoNextSpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+func (*NoNextSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 }
 
-func (rec *NoNextSpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.unexpectedFooter(buf, pos, ifdPositions)
+func (rec *NoNextSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.unexpectedFooter(src, pos, ifdPositions)
 }
 
 func (*NoNextSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -1247,27 +2021,69 @@ func (*TIFFSpaceRec) IsMakerNote() bool {
 	return false
 }
 
-func (*TIFFSpaceRec) nodeSize(node IFDNode) uint32 {
+func (*TIFFSpaceRec) nodeSize(node IFDNode) uint64 {
 	return node.genericSize()
 }
 
-func newImageData(buf []byte, order binary.ByteOrder, offsetField, sizeField Field) (*ImageData, error) {
-	segments := make([]ImageSegment, offsetField.Count)
-	for i := uint32(0); i < offsetField.Count; i++ {
+// Bytes charged against ParseOptions.MaxDataBytes for each image data
+// segment: its offset, its size and, if the data is held in memory, a
+// slice header.
+const (
+	imageDataEntryBytes   = 8
+	imageSegmentSliceSize = 24
+)
+
+// Create ImageData from a pair of offset and size fields. If the
+// source is a reader, the segments aren't read, only their positions
+// and sizes are recorded.
+func newImageData(src source, order binary.ByteOrder, offsetField, sizeField Field) (*ImageData, error) {
+	count := offsetField.Count
+	if !offsetField.Type.IsIntegral() || !sizeField.Type.IsIntegral() || count > offsetField.present() || count > sizeField.present() {
+		return nil, fmt.Errorf("Image data tags %d / %d have invalid types or counts", offsetField.Tag, sizeField.Tag)
+	}
+	imageData := ImageData{OffsetTag: offsetField.Tag, SizeTag: sizeField.Tag}
+	charge := uint64(imageDataEntryBytes)
+	if src.isReader() {
+		imageData.reader = src.r
+	} else if src.state != nil && src.state.input != nil {
+		imageData.reader = src.state.input
+	} else {
+		charge += imageSegmentSliceSize
+	}
+	if err := src.useData(charge * uint64(count)); err != nil {
+		return nil, err
+	}
+	imageData.Offsets = make([]uint32, count)
+	imageData.Sizes = make([]uint32, count)
+	if imageData.reader == nil {
+		imageData.Segments = make([]ImageSegment, count)
+	}
+	for i := uint32(0); i < count; i++ {
 		offset := uint32(offsetField.AnyInteger(i, order))
 		size := uint32(sizeField.AnyInteger(i, order))
-		bufsize := uint32(len(buf))
+		bufsize := src.len()
 		if offset+size < offset || offset+size > bufsize {
 			return nil, fmt.Errorf("Image data for tags %d / %d extends past end of input", offsetField.Tag, sizeField.Tag)
 		}
-		segments[i] = buf[offset : offset+size]
+		imageData.Offsets[i] = src.base + offset
+		imageData.Sizes[i] = size
+		if imageData.Segments != nil {
+			imageData.Segments[i], _ = src.data(offset, size)
+		}
 	}
-	return &ImageData{offsetField.Tag, sizeField.Tag, segments}, nil
+	return &imageData, nil
+}
+
+// Add a segment that was read from the input at offset.
+func (id *ImageData) appendSegment(seg []byte, offset uint32) {
+	id.Segments = append(id.Segments, seg)
+	id.Offsets = append(id.Offsets, offset)
+	id.Sizes = append(id.Sizes, uint32(len(seg)))
 }
 
 // Store image data in the TIFF space rec.
-func (rec *TIFFSpaceRec) appendImageData(buf []byte, order binary.ByteOrder, offsetField, sizeField Field) error {
-	imageData, err := newImageData(buf, order, offsetField, sizeField)
+func (rec *TIFFSpaceRec) appendImageData(src source, order binary.ByteOrder, offsetField, sizeField Field) error {
+	imageData, err := newImageData(src, order, offsetField, sizeField)
 	if err != nil {
 		return err
 	}
@@ -1275,7 +2091,11 @@ func (rec *TIFFSpaceRec) appendImageData(buf []byte, order binary.ByteOrder, off
 	return nil
 }
 
-func (rec *TIFFSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (rec *TIFFSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+	// Private IFD in Sony raw files.
+	if isSR2PrivatePointer(field) {
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(SR2PrivateSpace))
+	}
 	// SubIFDs.
 	if field.Type == IFD || field.Tag == SubIFDs || field.Tag == ExifIFD || field.Tag == GPSIFD {
 		var spaceRec SpaceRec
@@ -1286,7 +2106,7 @@ func (rec *TIFFSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositi
 		} else {
 			spaceRec = NewSpaceRec(TIFFSpace)
 		}
-		return recurseSubIFDs(buf, order, ifdPositions, field, spaceRec)
+		return recurseSubIFDs(src, order, ifdPositions, field, spaceRec)
 	}
 
 	// ImageData tags.
@@ -1297,9 +2117,12 @@ func (rec *TIFFSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositi
 			rec.sizeFields[i] = field
 		}
 		if rec.offsetFields[i].Tag != 0 && rec.sizeFields[i].Tag != 0 {
-			rec.appendImageData(buf, order, rec.offsetFields[i], rec.sizeFields[i])
-			rec.offsetFields[i].Tag = 0
-			rec.sizeFields[i].Tag = 0
+			err := rec.appendImageData(src, order, rec.offsetFields[i], rec.sizeFields[i])
+			rec.offsetFields[i] = Field{}
+			rec.sizeFields[i] = Field{}
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	switch field.Tag {
@@ -1312,43 +2135,51 @@ func (rec *TIFFSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositi
 
 		// Old-style JPEG tags have no size fields.
 	case JPEGQTables:
-		segments := make([]ImageSegment, field.Count)
+		imageData := ImageData{OffsetTag: field.Tag}
 		for i := uint32(0); i < field.Count; i++ {
 			offset := field.Long(i, order)
 			size := uint32(64)
-			bufsize := uint32(len(buf))
-			if offset+size < offset || offset+size > bufsize {
+			seg, err := src.data(offset, size)
+			if err != nil {
 				return nil, fmt.Errorf("Image data for tag %d extends past end of input", field.Tag)
 			}
-			segments[i] = buf[offset : offset+size]
+			imageData.appendSegment(seg, src.base+offset)
 		}
-		rec.imageData = append(rec.imageData, ImageData{field.Tag, Tag(0), segments})
+		rec.imageData = append(rec.imageData, imageData)
 	case JPEGDCTables, JPEGACTables:
-		segments := make([]ImageSegment, field.Count)
+		imageData := ImageData{OffsetTag: field.Tag}
 		for i := uint32(0); i < field.Count; i++ {
 			offset := field.Long(i, order)
-			bufsize := uint32(len(buf))
-			if offset+16 < offset || offset+16 > bufsize {
+			counts, err := src.data(offset, 16)
+			if err != nil {
 				return nil, fmt.Errorf("Image data for tag %d extends past end of input", field.Tag)
 			}
 			numvals := uint32(0)
 			for j := uint32(0); j < 16; j++ {
-				numvals += uint32(buf[offset+j])
+				numvals += uint32(counts[j])
 			}
 			size := 16 + numvals
-			segments[i] = buf[offset : offset+size]
+			seg, err := src.data(offset, size)
+			if err != nil {
+				return nil, fmt.Errorf("Image data for tag %d extends past end of input", field.Tag)
+			}
+			imageData.appendSegment(seg, src.base+offset)
 		}
-		rec.imageData = append(rec.imageData, ImageData{field.Tag, Tag(0), segments})
+		rec.imageData = append(rec.imageData, imageData)
 	}
 	return nil, nil
 }
 
-func (*TIFFSpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+func (*TIFFSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	if hasDNGVersion(src, node.Order, pos) {
+		// IFD 0 of a DNG file.
+		node.SpaceRec = NewSpaceRec(DNGSpace)
+	}
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 }
 
-func (*TIFFSpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetFooter(buf, pos, node.GetSpace(), ifdPositions)
+func (*TIFFSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetFooter(src, pos, node.GetSpace(), ifdPositions)
 }
 
 func (*TIFFSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -1376,41 +2207,50 @@ func (*ExifSpaceRec) IsMakerNote() bool {
 	return false
 }
 
-func (*ExifSpaceRec) nodeSize(node IFDNode) uint32 {
+func (*ExifSpaceRec) nodeSize(node IFDNode) uint64 {
 	return node.genericSize()
 }
 
-func (rec *ExifSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (rec *ExifSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	// SubIFDs.
 	if field.Type == IFD || field.Tag == interOpIFD {
 		subspace := ExifSpace
 		if field.Tag == interOpIFD {
 			subspace = InteropSpace
 		}
-		return recurseSubIFDs(buf, order, ifdPositions, field, NewSpaceRec(subspace))
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(subspace))
 	}
 	// Maker notes
-	if field.Tag == makerNote {
-		space := identifyMakerNote(buf, dataPos, rec.make, rec.model)
-		if space != TagSpace(0) {
+	if field.Tag == makerNote && (src.state == nil || !src.state.opts.OpaqueMakerNotes) {
+		spaceRec := identifyMakerNote(src, dataPos, field.Size(), rec.make, rec.model)
+		if spaceRec != nil {
 			var sub SubIFD
 			var err error
 			sub.Tag = field.Tag
-			sub.Node, err = getIFDTreeIter(buf, order, dataPos, NewSpaceRec(space), ifdPositions)
+			space := spaceRec.GetSpace()
+			if phaseOne, ok := spaceRec.(*PhaseOneSpaceRec); ok {
+				// The maker note contains the raw image data,
+				// so its full size is needed.
+				phaseOne.size = field.Count
+			}
+			sub.Node, err = getIFDTreeIter(src, order, dataPos, spaceRec, ifdPositions)
+			if start := src.base + dataPos; sub.Node.decoded && sub.Node.decodedPos > start {
+				src.addRegion(LayoutRegion{Start: start, End: sub.Node.decodedPos, Kind: RegionMakerNoteHeader, Space: space, IFDPos: sub.Node.decodedPos, Tag: field.Tag})
+			}
 			return []SubIFD{sub}, err
 		}
 	}
 	return nil, nil
 }
 
-func (*ExifSpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+func (*ExifSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 }
 
-func (rec *ExifSpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *ExifSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	// The next IFD after an Exif IFD is a thumbnail encoded as
 	// TIFF.
-	return node.genericGetFooter(buf, pos, TIFFSpace, ifdPositions)
+	return node.genericGetFooter(src, pos, TIFFSpace, ifdPositions)
 }
 
 func (*ExifSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -1434,27 +2274,27 @@ func (*MPFIndexSpaceRec) IsMakerNote() bool {
 	return false
 }
 
-func (*MPFIndexSpaceRec) nodeSize(node IFDNode) uint32 {
+func (*MPFIndexSpaceRec) nodeSize(node IFDNode) uint64 {
 	return node.genericSize()
 }
 
-func (rec *MPFIndexSpaceRec) takeField(buf []byte, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
+func (rec *MPFIndexSpaceRec) takeField(src source, order binary.ByteOrder, ifdPositions posMap, idx uint16, field Field, dataPos uint32) ([]SubIFD, error) {
 	// Process a field of type IFD: these declare a subIFD, and
 	// can be potentially found in any IFD.  Assume the subIFD has
 	// the same space as the current IFD.
 	if field.Type == IFD {
-		return recurseSubIFDs(buf, order, ifdPositions, field, NewSpaceRec(rec.space))
+		return recurseSubIFDs(src, order, ifdPositions, field, NewSpaceRec(rec.space))
 	}
 	return nil, nil
 }
 
-func (*MPFIndexSpaceRec) getIFDTree(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
-	return node.genericGetIFDTreeIter(buf, pos, ifdPositions)
+func (*MPFIndexSpaceRec) getIFDTree(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
+	return node.genericGetIFDTreeIter(src, pos, ifdPositions)
 }
 
-func (rec *MPFIndexSpaceRec) getFooter(node *IFDNode, buf []byte, pos uint32, ifdPositions posMap) error {
+func (rec *MPFIndexSpaceRec) getFooter(node *IFDNode, src source, pos uint32, ifdPositions posMap) error {
 	// MPFIndex space may be followd by an MPFAttribute space.
-	return node.genericGetFooter(buf, pos, MPFAttributeSpace, ifdPositions)
+	return node.genericGetFooter(src, pos, MPFAttributeSpace, ifdPositions)
 }
 
 func (*MPFIndexSpaceRec) putIFDTree(node IFDNode, buf []byte, pos uint32) (uint32, error) {
@@ -1491,22 +2331,48 @@ func (node IFDNode) putImageData(buf []byte, order binary.ByteOrder, pos uint32)
 		}
 		offsetData := make([]byte, offsetFields[i].Size())
 		offsetMap[offsetTags[i]] = offsetData
-		for j, seg := range id.Segments {
-			copy(buf[pos:], seg)
+		// If the tree is being written to a stream, the image
+		// data is placed after the IFDs instead of in buf, or
+		// left at its original position when appending.
+		inline := node.stream == nil
+		keep := !inline && node.stream.keepOriginal && id.hasOriginalPositions(node.stream.file, node.stream.fileSize)
+		if keep {
+			// Nothing to write.
+		} else if !inline {
+			node.stream.imageData = append(node.stream.imageData, id)
+		} else if !id.IsLoaded() {
+			// Read the data directly into the output.
+			if err := id.loadInto(buf, pos); err != nil {
+				return pos, nil, err
+			}
+		}
+		for j := 0; j < id.numSegments(); j++ {
+			segpos := node.filePos(buf, pos)
+			if keep {
+				segpos = id.Offsets[j]
+			} else if !inline {
+				segpos = node.stream.next
+			} else if id.IsLoaded() {
+				copy(buf[pos:], id.Segments[j])
+			}
 			if offsetFields[i].Type == LONG {
-				order.PutUint32(offsetData[j*4:], pos)
+				order.PutUint32(offsetData[j*4:], segpos)
 			} else {
 				// Rewriting a file may fail if an offset
 				// is in a SHORT field and we are trying to
 				// write it too high. The solution is
 				// probably to convert such fields to LONG
 				// before encoding, IFDNode.Fix().
-				if pos >= 2<<15 {
+				if segpos >= 2<<15 {
 					return pos, offsetMap, errors.New("putImageData: position is too large for SHORT field")
 				}
-				order.PutUint16(offsetData[j*2:], uint16(pos))
+				order.PutUint16(offsetData[j*2:], uint16(segpos))
+			}
+			if inline {
+				pos += id.segmentSize(j)
+			} else if !keep {
+				node.stream.next += id.segmentSize(j)
 			}
-			pos += uint32(len(seg))
 		}
 	}
 	return pos, offsetMap, nil
@@ -1545,7 +2411,7 @@ func (node IFDNode) put(buf []byte, pos uint32, subifds []IFDpos, nextptr uint32
 	pos += 2
 	var lastTag Tag
 	var subifdPtrs = make([]*IFDpos, 0, len(subifds))
-	for _, field := range node.Fields {
+	for fieldIdx, field := range node.Fields {
 		if field.Tag < lastTag {
 			return 0, fmt.Errorf("IFDNode.Put: tags are out of order, %d(0x%X) is followed by %d(0x%X)", lastTag, lastTag, field.Tag, field.Tag)
 		}
@@ -1574,7 +2440,7 @@ func (node IFDNode) put(buf []byte, pos uint32, subifds []IFDpos, nextptr uint32
 			}
 			order.PutUint32(buf[pos:], subifdPtrs[0].Size)
 			pos += 4
-			order.PutUint32(buf[pos:], subifdPtrs[0].Pos)
+			order.PutUint32(buf[pos:], node.filePos(buf, subifdPtrs[0].Pos))
 			pos += 4
 			continue
 		}
@@ -1592,25 +2458,40 @@ func (node IFDNode) put(buf []byte, pos uint32, subifds []IFDpos, nextptr uint32
 			}
 			data = make([]byte, size)
 			for i := range subifdPtrs {
-				order.PutUint32(data[i*4:], subifdPtrs[i].Pos)
+				order.PutUint32(data[i*4:], node.filePos(buf, subifdPtrs[i].Pos))
 			}
 		} else {
 			fieldOffsets := offsets[field.Tag]
 			if fieldOffsets != nil {
 				// Image data offset field.
 				data = fieldOffsets
+			} else if data == nil && size > 0 {
+				data, err = node.pendingFieldData(field.Tag, size)
+				if err != nil {
+					return 0, err
+				}
 			}
 		}
 		if size <= 4 {
 			copy(buf[pos:], "\000\000\000\000")
 			copy(buf[pos:], data[0:size])
+		} else if node.sharing.isDuplicate(&node.Fields[fieldIdx]) {
+			sharedPos, ok := node.sharing.sharedPos(&node.Fields[fieldIdx])
+			if !ok {
+				return 0, errors.New("IFDNode.Put: shared field data hasn't been written")
+			}
+			order.PutUint32(buf[pos:], node.filePos(buf, sharedPos))
 		} else {
-			order.PutUint32(buf[pos:], datapos)
+			order.PutUint32(buf[pos:], node.filePos(buf, datapos))
 			copy(buf[datapos:datapos+size], data)
+			node.sharing.written(&node.Fields[fieldIdx], datapos)
 			datapos += size
 		}
 		pos += 4
 	}
+	if nextptr != 0 {
+		nextptr = node.filePos(buf, nextptr)
+	}
 	order.PutUint32(buf[pos:], nextptr)
 	return datapos, nil
 }
@@ -1623,6 +2504,21 @@ func (node IFDNode) put(buf []byte, pos uint32, subifds []IFDpos, nextptr uint32
 // in the IFDs must be in ascending order, according to the TIFF
 // specification.
 func (node IFDNode) PutIFDTree(buf []byte, pos uint32) (uint32, error) {
+	// Check the size once for the whole tree, so that positions
+	// within it can't overflow and writes stay within buf.
+	end := uint64(pos) + node.TreeSize()
+	if end > math.MaxUint32 {
+		return 0, ErrNeedsBigTIFF{"PutIFDTree", end}
+	}
+	if end > uint64(len(buf)) {
+		return 0, fmt.Errorf("PutIFDTree: buffer size %d is less than the %d bytes needed", len(buf), end)
+	}
+	return node.putIFDTree(buf, pos)
+}
+
+// Version of PutIFDTree without the size check, for nodes within a
+// tree that has already been checked.
+func (node IFDNode) putIFDTree(buf []byte, pos uint32) (uint32, error) {
 	// Allow the PutIFDTree function to be selected according to
 	// the node space. Normal TIFF nodes will call
 	// genericPutIFDTree below.
@@ -1636,13 +2532,17 @@ func (node IFDNode) genericPutIFDTree(buf []byte, pos uint32) (uint32, error) {
 	// refers to, recording their positions.
 	nsubs := len(node.SubIFDs)
 	subpos := make([]IFDpos, nsubs)
-	next := pos + node.genericSize()
+	next := pos + uint32(node.genericSize())
 	var err error
 	for i := 0; i < nsubs; i++ {
 		next = Align(next)
 		subpos[i].Tag = node.SubIFDs[i].Tag
 		subpos[i].Pos = next
-		nextTmp, err := node.SubIFDs[i].Node.PutIFDTree(buf, next)
+		sub, err := node.SubIFDs[i].Node.inOrder(node.Order)
+		if err != nil {
+			return 0, err
+		}
+		nextTmp, err := sub.putIFDTree(buf, next)
 		if err != nil {
 			return 0, err
 		}
@@ -1653,10 +2553,13 @@ func (node IFDNode) genericPutIFDTree(buf []byte, pos uint32) (uint32, error) {
 	if node.Next != nil {
 		next = Align(next)
 		nextPos = next
-		next, err = node.Next.PutIFDTree(buf, next)
+		nextNode, err := node.Next.inOrder(node.Order)
 		if err != nil {
 			return 0, err
 		}
+		if next, err = nextNode.putIFDTree(buf, next); err != nil {
+			return 0, err
+		}
 	}
 	_, err = node.put(buf, pos, subpos, nextPos)
 	if err != nil {
@@ -1665,16 +2568,79 @@ func (node IFDNode) genericPutIFDTree(buf []byte, pos uint32) (uint32, error) {
 	return next, nil
 }
 
-// TIFF fixes: *) Sort the fields into ascending Tag order *) TIFF
-// allows a SHORT field to contain a pointer to image data. This can
-// fail if we write image data at a different location in the file, so
-// convert such fields to LONG. *) Add missing NUL terminators in
-// ASCII field data. Additional fixes may be added later.
-func (node *IFDNode) fixIFD() {
-	sort.Slice(node.Fields, func(i, j int) bool { return node.Fields[i].Tag < node.Fields[j].Tag })
+// Repairs made by FixWithOptions. Fix makes the repairs selected by
+// DefaultFixOptions.
+type FixOptions struct {
+	// Handling of fields with duplicate tags. DuplicateReport
+	// leaves them, returning an error from FixWithOptions.
+	Duplicates DuplicatePolicy
+	// Sort the fields into ascending tag order, as required for
+	// encoding.
+	SortFields bool
+	// Convert SHORT fields that point to image data to LONG, since
+	// the data may be written at a position that doesn't fit in 16
+	// bits.
+	PromoteOffsets bool
+	// Add missing NUL terminators to ASCII field data.
+	TerminateASCII bool
+	// Delete fields with unknown types, whose data size can't be
+	// determined, so they're written with no data.
+	DropUnknownTypes bool
+	// Reduce the counts of fields whose data is shorter than their
+	// type and count require, such as fields truncated when
+	// decoding, to the number of values present. Fields whose data
+	// hasn't been loaded are unchanged.
+	ClampCounts bool
+	// Replace RATIONAL and SRATIONAL values with zero denominators
+	// and nonzero numerators with 0/0, as for
+	// Field.RepairZeroDenominators.
+	RepairZeroDenominators bool
+	// Reduce RATIONAL and SRATIONAL values to lowest terms, with the
+	// signs of SRATIONAL values on their numerators, as for
+	// Field.ReduceRationals.
+	ReduceRationals bool
+}
+
+// Return the options used by Fix: duplicates are deleted, keeping the
+// first field, fields are sorted, image data offsets are promoted to
+// LONG and ASCII fields are NUL terminated.
+func DefaultFixOptions() FixOptions {
+	return FixOptions{Duplicates: DuplicateKeepFirst, SortFields: true, PromoteOffsets: true, TerminateASCII: true}
+}
+
+// Apply the selected fixes to an IFD.
+func (node *IFDNode) fixIFD(opts *FixOptions) {
+	if opts.Duplicates != DuplicateReport {
+		for _, tag := range node.DuplicateTags() {
+			node.fixDuplicate(tag, opts.Duplicates)
+		}
+	}
+	if opts.DropUnknownTypes {
+		fields := node.Fields[:0]
+		for _, field := range node.Fields {
+			if field.Type.Size() != 0 {
+				fields = append(fields, field)
+			}
+		}
+		node.Fields = fields
+	}
+	if opts.SortFields {
+		sort.SliceStable(node.Fields, func(i, j int) bool { return node.Fields[i].Tag < node.Fields[j].Tag })
+	}
 	imageData := node.GetImageData()
-	for _, field := range node.Fields {
-		if field.Type == SHORT {
+	for i := range node.Fields {
+		field := &node.Fields[i]
+		if opts.ClampCounts && field.Type.Size() != 0 && uint64(len(field.Data)) < uint64(field.Size()) && !node.isPending(field.Tag) {
+			field.Count = uint32(len(field.Data)) / field.Type.Size()
+			field.Data = field.Data[:field.Size()]
+		}
+		if opts.RepairZeroDenominators {
+			field.RepairZeroDenominators(node.Order)
+		}
+		if opts.ReduceRationals {
+			field.ReduceRationals(node.Order)
+		}
+		if opts.PromoteOffsets && field.Type == SHORT {
 			for j := range imageData {
 				if imageData[j].OffsetTag == field.Tag {
 					offsets := make([]uint32, field.Count)
@@ -1688,8 +2654,8 @@ func (node *IFDNode) fixIFD() {
 					}
 				}
 			}
-		} else if field.Type == ASCII {
-			if field.Count > 0 && field.Data[field.Count-1] != 0 {
+		} else if opts.TerminateASCII && field.Type == ASCII {
+			if field.Count > 0 && uint32(len(field.Data)) >= field.Count && field.Data[field.Count-1] != 0 {
 				field.Count++
 				newData := make([]byte, field.Count)
 				copy(newData, field.Data)
@@ -1699,15 +2665,65 @@ func (node *IFDNode) fixIFD() {
 	}
 }
 
-// Apply IFD fixes to all IFDs in a tree.
+// Apply IFD fixes to all IFDs in a tree, with the options returned by
+// DefaultFixOptions.
 func (node *IFDNode) Fix() {
-	node.fixIFD()
-	for i := 0; i < len(node.SubIFDs); i++ {
-		node.SubIFDs[i].Node.Fix()
+	opts := DefaultFixOptions()
+	node.FixWithOptions(&opts)
+}
+
+// Apply the selected IFD fixes to all IFDs in a tree. If opts is nil,
+// the defaults are used. The error is from FixDuplicates, if
+// opts.Duplicates is DuplicateReport and duplicate tags are found; the
+// other fixes are still made.
+func (node *IFDNode) FixWithOptions(opts *FixOptions) error {
+	if opts == nil {
+		defaults := DefaultFixOptions()
+		opts = &defaults
 	}
-	if node.Next != nil {
-		node.Next.Fix()
+	var err error
+	if opts.Duplicates == DuplicateReport {
+		err = node.FixDuplicates(DuplicateReport)
 	}
+	node.Walk(func(n *IFDNode, _ Tag) error {
+		n.fixIFD(opts)
+		return nil
+	})
+	return err
+}
+
+// Value that can be returned by the function passed to Walk, to skip
+// the sub-IFDs of the current node. Its Next chain is still visited.
+var SkipSubIFDs = errors.New("Skip sub-IFDs")
+
+// Call fn for a node and all the nodes to which it refers. Each node
+// is visited before its sub-IFDs, in the order they appear in
+// SubIFDs, which are visited before its Next chain. parentTag is the
+// tag of the field that links the node or the head of its Next chain
+// to its parent, or 0 for the root node and its Next chain. If fn
+// returns SkipSubIFDs, the sub-IFDs of the node aren't visited; any
+// other error stops the walk and is returned.
+func (node *IFDNode) Walk(fn func(node *IFDNode, parentTag Tag) error) error {
+	return node.walk(fn, 0)
+}
+
+// Helper for Walk.
+func (node *IFDNode) walk(fn func(node *IFDNode, parentTag Tag) error, parentTag Tag) error {
+	for ; node != nil; node = node.Next {
+		err := fn(node, parentTag)
+		if err == SkipSubIFDs {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, sub := range node.SubIFDs {
+			if err := sub.Node.walk(fn, sub.Tag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // Delete the nth SubIFD from a node, also removing its reference in the fields.
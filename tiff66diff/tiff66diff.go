@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	tiff "github.com/garyhouston/tiff66"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// Read a TIFF file, or the Exif block of a JPEG file.
+func readTree(fileName string) (*tiff.IFDNode, error) {
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) >= 2 && buf[0] == 0xFF && buf[1] == 0xD8 {
+		root, err := tiff.GetJPEGExifTree(buf)
+		if root == nil && err == nil {
+			err = fmt.Errorf("%s: no Exif block found", fileName)
+		}
+		return root, err
+	}
+	valid, order, ifdPos := tiff.GetHeader(buf)
+	if !valid {
+		return nil, fmt.Errorf("%s: not a valid TIFF or JPEG file", fileName)
+	}
+	return tiff.GetIFDTree(buf, order, ifdPos, tiff.TIFFSpace)
+}
+
+// Return a field's type, count and the values present in its data, up
+// to a limit (or 0 for no limit).
+func formatField(f *tiff.Field, order binary.ByteOrder, limit uint32) string {
+	str := fmt.Sprintf("%s(%d)", f.Type.Name(), f.Count)
+	if f.Type.Size() == 0 {
+		return str + " unknown type"
+	}
+	if f.Data == nil && f.Size() > 0 {
+		return str + " data not loaded"
+	}
+	if f.Type == tiff.ASCII {
+		val := f.ASCII()
+		if limit > 0 && len(val) > int(limit) {
+			return fmt.Sprintf("%s %q...", str, val[:limit])
+		}
+		return fmt.Sprintf("%s %q", str, val)
+	}
+	n := f.Count
+	if present := uint32(len(f.Data)) / f.Type.Size(); present < n {
+		n = present
+	}
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	vals := make([]string, n)
+	for i := range vals {
+		idx := uint32(i)
+		switch {
+		case f.Type == tiff.UNDEFINED:
+			vals[i] = fmt.Sprintf("%X", f.Data[idx])
+		case f.Type.IsRational():
+			num, denom := f.AnyRational(idx, order)
+			vals[i] = fmt.Sprintf("%d/%d", num, denom)
+		case f.Type.IsFloat():
+			vals[i] = fmt.Sprintf("%g", f.AnyFloat(idx, order))
+		default:
+			val, _ := f.Value(idx, order)
+			vals[i] = fmt.Sprintf("%d", val.Int)
+		}
+	}
+	str += " " + strings.Join(vals, " ")
+	if n < f.Count {
+		str += "..."
+	}
+	return str
+}
+
+// Print the differences between the IFDs and fields of two files, and
+// exit with status 1 if there are any.
+func main() {
+	var length uint
+	logger := log.New(os.Stderr, "", 0)
+	flag.UintVar(&length, "m", 20, "maximum values to print or 0 for no limit")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		logger.Fatalf("Usage: %s [-m max values] file1 file2\n", os.Args[0])
+	}
+	oldRoot, err := readTree(flag.Arg(0))
+	if oldRoot == nil {
+		logger.Fatal(err)
+	} else if err != nil {
+		logger.Print(err)
+	}
+	newRoot, err := readTree(flag.Arg(1))
+	if newRoot == nil {
+		logger.Fatal(err)
+	} else if err != nil {
+		logger.Print(err)
+	}
+	limit := uint32(length)
+	diffs := tiff.DiffTrees(oldRoot, newRoot)
+	for _, d := range diffs {
+		kind := strings.ToLower(d.Kind.String())
+		if d.Old == nil && d.New == nil {
+			fmt.Printf("%s: %s %s IFD\n", d.Path, kind, d.Space.Name())
+			continue
+		}
+		name, found := d.Space.TagNames()[d.Tag]
+		if !found {
+			name = fmt.Sprintf("Unknown %d(0x%X)", d.Tag, d.Tag)
+		}
+		switch d.Kind {
+		case tiff.DiffAdded:
+			fmt.Printf("%s: %s %s %s\n", d.Path, kind, name, formatField(d.New, d.NewOrder, limit))
+		case tiff.DiffRemoved:
+			fmt.Printf("%s: %s %s %s\n", d.Path, kind, name, formatField(d.Old, d.OldOrder, limit))
+		default:
+			fmt.Printf("%s: %s %s %s -> %s\n", d.Path, kind, name, formatField(d.Old, d.OldOrder, limit), formatField(d.New, d.NewOrder, limit))
+		}
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	tiff "github.com/garyhouston/tiff66"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A flag that may be given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(val string) error {
+	*l = append(*l, val)
+	return nil
+}
+
+// Spaces searched for tag names without a space prefix, and the tags
+// that link them to the root IFD.
+var editSpaces = []struct {
+	space tiff.TagSpace
+	tag   tiff.Tag
+}{
+	{tiff.TIFFSpace, 0},
+	{tiff.ExifSpace, tiff.ExifIFD},
+	{tiff.GPSSpace, tiff.GPSIFD},
+}
+
+// Resolve a tag given by name or number, optionally preceded by a
+// space name and colon, e.g., "Artist", "0x13B" or "Exif:0x9003".
+// Numbers without a space are in TIFF space.
+func parseTag(name string) (tiff.TagSpace, tiff.Tag, error) {
+	spaceName := ""
+	if i := strings.Index(name, ":"); i >= 0 {
+		spaceName, name = name[:i], name[i+1:]
+	}
+	for _, s := range editSpaces {
+		if spaceName != "" && spaceName != s.space.Name() {
+			continue
+		}
+		if num, err := strconv.ParseUint(name, 0, 16); err == nil {
+			return s.space, tiff.Tag(num), nil
+		}
+		if tag, found := s.space.TagByName(name); found {
+			return s.space, tag, nil
+		}
+	}
+	if spaceName != "" {
+		return 0, 0, fmt.Errorf("Tag %q not found in space %q", name, spaceName)
+	}
+	return 0, 0, fmt.Errorf("Tag %q not found", name)
+}
+
+// Return the first node in a tree with a given space, creating it as a
+// sub-IFD of the root if create is true.
+func spaceNode(root *tiff.IFDNode, space tiff.TagSpace, create bool) (*tiff.IFDNode, error) {
+	if root.GetSpace() == space {
+		return root, nil
+	}
+	var found *tiff.IFDNode
+	root.Walk(func(node *tiff.IFDNode, _ tiff.Tag) error {
+		if found == nil && node.GetSpace() == space {
+			found = node
+		}
+		return nil
+	})
+	if found != nil || !create {
+		return found, nil
+	}
+	found = tiff.NewIFDNode(space)
+	found.Order = root.Order
+	var err error
+	switch space {
+	case tiff.ExifSpace:
+		err = root.SetExifIFD(found)
+	case tiff.GPSSpace:
+		err = root.SetGPSIFD(found)
+	default:
+		err = fmt.Errorf("Can't create %s IFD", space.Name())
+	}
+	return found, err
+}
+
+// Return the type for a new value of a field: the type of an existing
+// field, or the first type in its definition, or ASCII.
+func fieldType(node *tiff.IFDNode, tag tiff.Tag) tiff.Type {
+	if fields := node.FindFields([]tiff.Tag{tag}); len(fields) > 0 {
+		return fields[0].Type
+	}
+	if def, found := node.GetSpace().TagDefs()[tag]; found && len(def.Types) > 0 {
+		return def.Types[0]
+	}
+	return tiff.ASCII
+}
+
+// Create a field of a given type from a string. Numeric values are
+// separated by commas or spaces, and rationals may be given as
+// fractions or decimals.
+func parseField(tag tiff.Tag, typ tiff.Type, val string, order binary.ByteOrder) (tiff.Field, error) {
+	field := tiff.Field{Tag: tag, Type: typ}
+	switch typ {
+	case tiff.ASCII:
+		field.PutASCII(val)
+		field.Count = uint32(len(field.Data))
+		return field, nil
+	case tiff.UNDEFINED:
+		field.Data = []byte(val)
+		field.Count = uint32(len(field.Data))
+		return field, nil
+	}
+	vals := strings.FieldsFunc(val, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(vals) == 0 {
+		return field, errors.New("No values given")
+	}
+	field.Count = uint32(len(vals))
+	field.Data = make([]byte, field.Count*typ.Size())
+	for i, v := range vals {
+		idx := uint32(i)
+		switch {
+		case typ.IsIntegral():
+			n, err := strconv.ParseInt(v, 0, 64)
+			if err != nil {
+				return field, err
+			}
+			field.PutAnyInteger(n, idx, order)
+		case typ.IsRational():
+			r, ok := new(big.Rat).SetString(v)
+			if !ok {
+				return field, fmt.Errorf("Invalid rational %q", v)
+			}
+			num, denom := r.Num(), r.Denom()
+			if typ == tiff.RATIONAL {
+				if num.Sign() < 0 || !num.IsUint64() || num.Uint64() > math.MaxUint32 || denom.Uint64() > math.MaxUint32 {
+					return field, fmt.Errorf("Rational %q out of range", v)
+				}
+				field.PutRational(uint32(num.Uint64()), uint32(denom.Uint64()), idx, order)
+			} else {
+				if !num.IsInt64() || num.Int64() < math.MinInt32 || num.Int64() > math.MaxInt32 || denom.Uint64() > math.MaxInt32 {
+					return field, fmt.Errorf("Rational %q out of range", v)
+				}
+				field.PutSRational(int32(num.Int64()), int32(denom.Int64()), idx, order)
+			}
+		case typ.IsFloat():
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return field, err
+			}
+			field.PutAnyFloat(f, idx, order)
+		default:
+			return field, fmt.Errorf("Can't set values of type %s", typ.Name())
+		}
+	}
+	return field, nil
+}
+
+// Set a field from an argument of the form name=value. If add is true,
+// the field mustn't already exist.
+func setField(root *tiff.IFDNode, arg string, add bool) error {
+	i := strings.Index(arg, "=")
+	if i < 0 {
+		return fmt.Errorf("Missing value in %q", arg)
+	}
+	space, tag, err := parseTag(arg[:i])
+	if err != nil {
+		return err
+	}
+	node, err := spaceNode(root, space, true)
+	if err != nil {
+		return err
+	}
+	exists := len(node.FindFields([]tiff.Tag{tag})) > 0
+	if add && exists {
+		return fmt.Errorf("Field %s already exists", arg[:i])
+	}
+	field, err := parseField(tag, fieldType(node, tag), arg[i+1:], node.Order)
+	if err != nil {
+		return fmt.Errorf("%s: %s", arg[:i], err)
+	}
+	return node.AddField(field, true)
+}
+
+// Delete a field, and any sub-IFDs it points to.
+func deleteField(root *tiff.IFDNode, name string) error {
+	space, tag, err := parseTag(name)
+	if err != nil {
+		return err
+	}
+	node, _ := spaceNode(root, space, false)
+	if node == nil || len(node.FindFields([]tiff.Tag{tag})) == 0 {
+		return fmt.Errorf("Field %s not found", name)
+	}
+	for i := 0; i < len(node.SubIFDs); i++ {
+		if node.SubIFDs[i].Tag == tag {
+			node.DeleteSubIFD(i)
+			i--
+		}
+	}
+	node.DeleteFields([]tiff.Tag{tag})
+	return nil
+}
+
+// Edit the fields of a TIFF file, or the Exif block of a JPEG file,
+// and write the result to a new file.
+func main() {
+	var sets, adds, deletes listFlag
+	logger := log.New(os.Stderr, "", 0)
+	flag.Var(&sets, "set", "set a field, as name=value; may be repeated")
+	flag.Var(&adds, "add", "add a field that doesn't exist, as name=value; may be repeated")
+	flag.Var(&deletes, "delete", "delete a field and any sub-IFDs it points to; may be repeated")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		logger.Fatalf("Usage: %s [-set name=value] [-add name=value] [-delete name] file outfile\n", os.Args[0])
+	}
+	buf, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	jpeg := len(buf) >= 2 && buf[0] == 0xFF && buf[1] == 0xD8
+	var root *tiff.IFDNode
+	if jpeg {
+		root, err = tiff.GetJPEGExifTree(buf)
+		if root == nil && err == nil {
+			root = tiff.NewIFDNode(tiff.TIFFSpace)
+			root.Order = binary.BigEndian
+		}
+	} else {
+		valid, order, ifdPos := tiff.GetHeader(buf)
+		if !valid {
+			logger.Fatal("Not a valid TIFF or JPEG file")
+		}
+		root, err = tiff.GetIFDTree(buf, order, ifdPos, tiff.TIFFSpace)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	for _, name := range deletes {
+		if err := deleteField(root, name); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	for _, arg := range sets {
+		if err := setField(root, arg, false); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	for _, arg := range adds {
+		if err := setField(root, arg, true); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	root.Fix()
+	var out []byte
+	if jpeg {
+		out, err = tiff.PutJPEGExifTree(buf, root)
+	} else {
+		out = make([]byte, tiff.HeaderSize+root.TreeSize())
+		tiff.PutHeader(out, root.Order, tiff.HeaderSize)
+		var next uint32
+		next, err = root.PutIFDTree(out, tiff.HeaderSize)
+		out = out[:next]
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err = ioutil.WriteFile(flag.Arg(1), out, 0644); err != nil {
+		logger.Fatal(err)
+	}
+}
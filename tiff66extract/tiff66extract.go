@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	tiff "github.com/garyhouston/tiff66"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Writes extracted payloads to files named from a prefix.
+type extractor struct {
+	prefix string
+	counts map[string]int
+	logger *log.Logger
+}
+
+// Write data to a file named from the prefix, a kind and an extension.
+// Kinds that occur more than once are numbered.
+func (e *extractor) write(kind, ext string, data []byte, numbered bool) {
+	name := e.prefix + "-" + kind
+	if numbered {
+		e.counts[kind]++
+		name = fmt.Sprintf("%s-%d", name, e.counts[kind])
+	}
+	name += ext
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		e.logger.Fatal(err)
+	}
+	fmt.Printf("%s: %d bytes\n", name, len(data))
+}
+
+// Return the concatenated segments of some image data.
+func joinSegments(id tiff.ImageData) []byte {
+	var data []byte
+	for _, seg := range id.Segments {
+		data = append(data, seg...)
+	}
+	return data
+}
+
+// Write the image data of a node: thumbnails, maker note previews and
+// image strips or tiles.
+func (e *extractor) imageData(node *tiff.IFDNode, inMakerNote bool) {
+	for _, id := range node.GetImageData() {
+		if len(id.Segments) == 0 {
+			continue
+		}
+		data := joinSegments(id)
+		switch {
+		case inMakerNote:
+			e.write("preview", ".jpg", data, true)
+		case id.OffsetTag == tiff.JPEGInterchangeFormat:
+			e.write("thumbnail", ".jpg", data, true)
+		case id.OffsetTag == tiff.StripOffsets:
+			e.write("strips", ".raw", data, true)
+		case id.OffsetTag == tiff.TileOffsets:
+			e.write("tiles", ".raw", data, true)
+		}
+	}
+}
+
+// Write the ICC profile, XMP packet and IPTC block of a node, if they
+// haven't already been found in another node.
+func (e *extractor) metadata(node *tiff.IFDNode) {
+	if profile, ok := node.GetICCProfile(); ok && e.counts["icc"] == 0 {
+		e.counts["icc"]++
+		e.write("profile", ".icc", profile, false)
+	}
+	if xmp, ok := node.GetXMP(); ok && e.counts["xmp"] == 0 {
+		e.counts["xmp"]++
+		e.write("xmp", ".xml", []byte(xmp), false)
+	}
+	// The IPTC field may have LONG type, so isn't read with GetBytes.
+	if fields := node.FindFields([]tiff.Tag{tiff.IPTC}); len(fields) > 0 && e.counts["iptc"] == 0 {
+		if f := fields[0]; uint64(len(f.Data)) >= uint64(f.Size()) {
+			e.counts["iptc"]++
+			e.write("iptc", ".iim", f.Data[:f.Size()], false)
+		}
+	}
+}
+
+// Extract embedded payloads from a TIFF file, or the Exif block of a
+// JPEG file, to separate files.
+func main() {
+	var prefix string
+	logger := log.New(os.Stderr, "", 0)
+	flag.StringVar(&prefix, "o", "", "prefix for output files, default is the input file name without extension")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		logger.Fatalf("Usage: %s [-o prefix] file\n", os.Args[0])
+	}
+	fileName := flag.Arg(0)
+	if prefix == "" {
+		prefix = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	var root *tiff.IFDNode
+	if len(buf) >= 2 && buf[0] == 0xFF && buf[1] == 0xD8 {
+		if root, err = tiff.GetJPEGExifTree(buf); root == nil && err == nil {
+			logger.Fatal("No Exif block found")
+		}
+	} else {
+		valid, order, ifdPos := tiff.GetHeader(buf)
+		if !valid {
+			logger.Fatal("Not a valid TIFF or JPEG file")
+		}
+		root, err = tiff.GetIFDTree(buf, order, ifdPos, tiff.TIFFSpace)
+	}
+	if err != nil {
+		logger.Print(err)
+	}
+	if root == nil {
+		os.Exit(1)
+	}
+	e := extractor{prefix: prefix, counts: make(map[string]int), logger: logger}
+	// Maker notes and their sub-IFDs.
+	inMakerNote := make(map[*tiff.IFDNode]bool)
+	root.Walk(func(node *tiff.IFDNode, _ tiff.Tag) error {
+		if node.IsMakerNote() || inMakerNote[node] {
+			inMakerNote[node] = true
+			for _, sub := range node.SubIFDs {
+				inMakerNote[sub.Node] = true
+			}
+			for next := node.Next; next != nil; next = next.Next {
+				inMakerNote[next] = true
+			}
+		}
+		e.imageData(node, inMakerNote[node])
+		e.metadata(node)
+		return nil
+	})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	tiff "github.com/garyhouston/tiff66"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// A position, from the command line or a GPX track.
+type point struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  *float64  `xml:"ele"`
+	Time time.Time `xml:"time"`
+}
+
+// The parts of a GPX file that are used.
+type gpx struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []point `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// Read the track points from a GPX file, sorted by time.
+func readGPX(fileName string) ([]point, error) {
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var g gpx
+	if err := xml.Unmarshal(buf, &g); err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	var points []point
+	for _, trk := range g.Tracks {
+		for _, seg := range trk.Segments {
+			for _, p := range seg.Points {
+				if !p.Time.IsZero() {
+					points = append(points, p)
+				}
+			}
+		}
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%s: no track points with times found", fileName)
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, nil
+}
+
+// Return the position at a time in a track, interpolated between the
+// surrounding points if they are no more than maxGap apart, or else the
+// nearest point if it's within maxGap.
+func matchTrack(points []point, t time.Time, maxGap time.Duration) (point, error) {
+	i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(t) })
+	if i < len(points) && points[i].Time.Equal(t) {
+		return points[i], nil
+	}
+	if i > 0 && i < len(points) {
+		before, after := points[i-1], points[i]
+		if gap := after.Time.Sub(before.Time); gap <= maxGap {
+			frac := float64(t.Sub(before.Time)) / float64(gap)
+			p := point{
+				Lat:  before.Lat + (after.Lat-before.Lat)*frac,
+				Lon:  before.Lon + (after.Lon-before.Lon)*frac,
+				Time: t,
+			}
+			if before.Ele != nil && after.Ele != nil {
+				ele := *before.Ele + (*after.Ele-*before.Ele)*frac
+				p.Ele = &ele
+			}
+			return p, nil
+		}
+	}
+	nearest := -1
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(points) {
+			continue
+		}
+		if nearest < 0 || absDuration(points[j].Time.Sub(t)) < absDuration(points[nearest].Time.Sub(t)) {
+			nearest = j
+		}
+	}
+	if nearest >= 0 && absDuration(points[nearest].Time.Sub(t)) <= maxGap {
+		p := points[nearest]
+		p.Time = t
+		return p, nil
+	}
+	return point{}, fmt.Errorf("No track point within %s of %s", maxGap, t.Format(time.RFC3339))
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Return the time an image was taken, from DateTimeOriginal or else
+// DateTime. Times without an offset field are interpreted in loc.
+func imageTime(root *tiff.IFDNode, loc *time.Location) (time.Time, error) {
+	t, _, err := root.GetCaptureTime(loc)
+	if err != nil {
+		t, _, err = root.GetDateTime(tiff.DateTime, loc)
+	}
+	if err != nil {
+		return t, errors.New("No date/time found in the image")
+	}
+	return t, nil
+}
+
+// Return the GPS IFD of a tree, creating it if needed.
+func gpsNode(root *tiff.IFDNode) (*tiff.IFDNode, error) {
+	for _, sub := range root.SubIFDs {
+		if sub.Tag == tiff.GPSIFD && sub.Node.GetSpace() == tiff.GPSSpace {
+			return sub.Node, nil
+		}
+	}
+	gps := tiff.NewIFDNode(tiff.GPSSpace)
+	gps.Order = root.Order
+	gps.AddField(tiff.Field{Tag: tiff.GPSVersionID, Type: tiff.BYTE, Count: 4, Data: []byte{2, 3, 0, 0}}, true)
+	return gps, root.SetGPSIFD(gps)
+}
+
+// Write a position to the GPS IFD of a tree.
+func setPosition(root *tiff.IFDNode, p point) error {
+	gps, err := gpsNode(root)
+	if err != nil {
+		return err
+	}
+	if err := gps.SetGPSLatLong(p.Lat, p.Lon); err != nil {
+		return err
+	}
+	if p.Ele != nil {
+		if err := gps.SetGPSAltitude(*p.Ele); err != nil {
+			return err
+		}
+	}
+	if !p.Time.IsZero() {
+		gps.SetGPSTime(p.Time)
+	}
+	return nil
+}
+
+// Write GPS fields to a TIFF file, or the Exif block of a JPEG file,
+// from coordinates given on the command line or from a GPX track
+// matched to the time the image was taken.
+func main() {
+	var lat, lon, alt float64
+	var timeStr, gpxFile, zone string
+	var maxGap time.Duration
+	logger := log.New(os.Stderr, "", 0)
+	flag.Float64Var(&lat, "lat", 0, "latitude in degrees, negative for south")
+	flag.Float64Var(&lon, "lon", 0, "longitude in degrees, negative for west")
+	flag.Float64Var(&alt, "alt", 0, "altitude in meters, negative for below sea level")
+	flag.StringVar(&timeStr, "time", "", "GPS time in RFC 3339 format, default is the time the image was taken")
+	flag.StringVar(&gpxFile, "gpx", "", "GPX file with a track to match by time")
+	flag.StringVar(&zone, "tz", "Z", "offset of the camera clock from UTC, e.g., +10:00, for images without an offset field")
+	flag.DurationVar(&maxGap, "max", 5*time.Minute, "maximum time from the image to a track point")
+	flag.Parse()
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if flag.NArg() != 2 || (gpxFile == "") == !(set["lat"] && set["lon"]) {
+		logger.Fatalf("Usage: %s -lat degrees -lon degrees [-alt meters] [-time time] file outfile\n       %s -gpx file [-tz offset] [-max duration] file outfile\n", os.Args[0], os.Args[0])
+	}
+	offset, err := time.Parse("Z07:00", zone)
+	if err != nil {
+		logger.Fatalf("Invalid offset %q", zone)
+	}
+	_, secs := offset.Zone()
+	loc := time.FixedZone("", secs)
+	buf, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	jpeg := len(buf) >= 2 && buf[0] == 0xFF && buf[1] == 0xD8
+	var root *tiff.IFDNode
+	if jpeg {
+		root, err = tiff.GetJPEGExifTree(buf)
+		if root == nil && err == nil {
+			root = tiff.NewIFDNode(tiff.TIFFSpace)
+			root.Order = binary.BigEndian
+		}
+	} else {
+		valid, order, ifdPos := tiff.GetHeader(buf)
+		if !valid {
+			logger.Fatal("Not a valid TIFF or JPEG file")
+		}
+		root, err = tiff.GetIFDTree(buf, order, ifdPos, tiff.TIFFSpace)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	var p point
+	if gpxFile != "" {
+		points, err := readGPX(gpxFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		t, err := imageTime(root, loc)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if p, err = matchTrack(points, t, maxGap); err != nil {
+			logger.Fatal(err)
+		}
+	} else {
+		p = point{Lat: lat, Lon: lon}
+		if set["alt"] {
+			p.Ele = &alt
+		}
+		if timeStr != "" {
+			if p.Time, err = time.Parse(time.RFC3339, timeStr); err != nil {
+				logger.Fatal(err)
+			}
+		} else {
+			// No GPS time is set if the image has no date/time.
+			p.Time, _ = imageTime(root, loc)
+		}
+	}
+	if err := setPosition(root, p); err != nil {
+		logger.Fatal(err)
+	}
+	root.Fix()
+	var out []byte
+	if jpeg {
+		out, err = tiff.PutJPEGExifTree(buf, root)
+	} else {
+		out = make([]byte, tiff.HeaderSize+root.TreeSize())
+		tiff.PutHeader(out, root.Order, tiff.HeaderSize)
+		var next uint32
+		next, err = root.PutIFDTree(out, tiff.HeaderSize)
+		out = out[:next]
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err = ioutil.WriteFile(flag.Arg(1), out, 0644); err != nil {
+		logger.Fatal(err)
+	}
+	fmt.Printf("%.6f %.6f\n", p.Lat, p.Lon)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	tiff "github.com/garyhouston/tiff66"
@@ -9,22 +11,95 @@ import (
 	"os"
 )
 
-func printNode(node *tiff.IFDNode, length uint32) {
+// Positions of the data of fields stored outside IFD tables, by IFD
+// position and tag, for the -v option.
+type dataKey struct {
+	ifdPos uint32
+	tag    tiff.Tag
+}
+
+type dataPositions map[dataKey]uint32
+
+func getDataPositions(buf []byte) dataPositions {
+	positions := make(dataPositions)
+	regions, _ := tiff.Layout(buf)
+	for _, region := range regions {
+		if region.Kind == tiff.RegionFieldData {
+			key := dataKey{region.IFDPos, region.Tag}
+			if _, found := positions[key]; !found {
+				positions[key] = region.Start
+			}
+		}
+	}
+	return positions
+}
+
+// Print the data of a field as hex, 16 bytes per line, with the
+// offsets of the lines in the file.
+func printHex(data []byte, pos uint32, limit uint32) {
+	if limit > 0 && uint32(len(data)) > limit {
+		data = data[:limit]
+	}
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		fmt.Printf("    %8d: % X\n", pos+uint32(i), data[i:end])
+	}
+}
+
+// Print the positions of the fields of a node in the file, and their
+// raw data.
+func printPositions(buf []byte, node *tiff.IFDNode, field tiff.Field, positions dataPositions, limit uint32) {
+	ifdPos, ok := node.Position()
+	if !ok || uint64(ifdPos)+2 > uint64(len(buf)) {
+		return
+	}
+	count := uint32(node.Order.Uint16(buf[ifdPos:]))
+	for i := uint32(0); i < count; i++ {
+		entry := ifdPos + 2 + i*tiff.TableEntrySize
+		if uint64(entry)+tiff.TableEntrySize > uint64(len(buf)) {
+			return
+		}
+		if tiff.Tag(node.Order.Uint16(buf[entry:])) != field.Tag {
+			continue
+		}
+		data := entry + 8
+		if field.Size() > 4 {
+			var found bool
+			if data, found = positions[dataKey{ifdPos, field.Tag}]; !found {
+				fmt.Printf("    entry at %d, data not read\n", entry)
+				return
+			}
+		}
+		fmt.Printf("    entry at %d, data at %d\n", entry, data)
+		if uint64(len(field.Data)) >= uint64(field.Size()) {
+			printHex(field.Data[:field.Size()], data, limit)
+		}
+		return
+	}
+}
+
+func printNode(buf []byte, node *tiff.IFDNode, length uint32, positions dataPositions) {
 	fmt.Println()
 	fields := node.Fields
 	space := node.GetSpace()
-	fmt.Printf("%s IFD with %d ", space.Name(), len(fields))
+	fmt.Printf("%s IFD ", space.Name())
+	if pos, ok := node.Position(); ok && positions != nil {
+		fmt.Printf("at %d ", pos)
+	}
+	fmt.Printf("with %d ", len(fields))
 	if len(fields) != 1 {
 		fmt.Println("entries:")
 	} else {
 		fmt.Println("entry:")
 	}
-	var names map[tiff.Tag]string
-	if space == tiff.TIFFSpace {
-		names = tiff.TagNames
-	}
 	for i := 0; i < len(fields); i++ {
-		fields[i].Print(node.Order, names, length)
+		fields[i].PrintSpace(node.Order, space, length)
+		if positions != nil {
+			printPositions(buf, node, fields[i], positions, length)
+		}
 	}
 	fmt.Println()
 	imageData := node.GetImageData()
@@ -38,36 +113,91 @@ func printNode(node *tiff.IFDNode, length uint32) {
 				entry = "entries"
 			}
 			fmt.Printf("%s has %d %s, first has length %d\n", tiff.TagNames[id.OffsetTag], len(id.Segments), entry, len(id.Segments[0]))
+			if positions != nil && len(id.Offsets) > 0 {
+				fmt.Printf("    first at %d\n", id.Offsets[0])
+			}
 		}
 	}
-	for i := 0; i < len(node.SubIFDs); i++ {
-		printNode(node.SubIFDs[i].Node, length)
+}
+
+// Return the TIFF block from a file, which is either a TIFF file or a
+// JPEG, PNG, WebP or HEIF file containing an Exif block.
+func getTIFF(buf []byte) ([]byte, error) {
+	var exif []byte
+	var err error
+	switch {
+	case len(buf) >= 2 && buf[0] == 0xFF && buf[1] == 0xD8:
+		exif, err = tiff.GetJPEGExif(buf)
+	case bytes.HasPrefix(buf, []byte("\x89PNG")):
+		exif, err = tiff.GetPNGExif(buf)
+	case len(buf) >= 12 && string(buf[:4]) == "RIFF" && string(buf[8:12]) == "WEBP":
+		exif, err = tiff.GetWebPExif(buf)
+	case len(buf) >= 8 && string(buf[4:8]) == "ftyp":
+		exif, err = tiff.GetHEIFExif(buf)
+	default:
+		return buf, nil
 	}
-	if node.Next != nil {
-		printNode(node.Next, length)
+	if exif == nil && err == nil {
+		err = errors.New("No Exif block found")
 	}
+	return exif, err
 }
 
-// Read and diplay all the IFDs of a TIFF file, including any private IFDs that can be
+// Read and diplay all the IFDs of a TIFF file, or the Exif block of
+// another type of file, including any private IFDs that can be
 // detected.
 func main() {
 	var length uint
+	var jsonOutput, exiftoolOutput, treeOutput, latin1, verbose bool
 	logger := log.New(os.Stderr, "", 0)
 	flag.UintVar(&length, "m", 20, "maximum values to print or 0 for no limit")
+	flag.BoolVar(&jsonOutput, "j", false, "print the IFDs as JSON")
+	flag.BoolVar(&exiftoolOutput, "e", false, "print the fields in Exiftool's tab-separated style")
+	flag.BoolVar(&treeOutput, "t", false, "print the IFDs as an indented tree")
+	flag.BoolVar(&latin1, "l", false, "decode text that isn't valid UTF-8 as Latin-1")
+	flag.BoolVar(&verbose, "v", false, "print positions of IFDs and fields, and field data in hex")
 	flag.Parse()
 	if flag.NArg() != 1 {
-		logger.Fatalf("Usage: %s [-m max values] file\n", os.Args[0])
+		logger.Fatalf("Usage: %s [-m max values] [-j | -e | -t] [-l] [-v] file\n", os.Args[0])
+	}
+	if latin1 {
+		tiff.SetStringPolicy(tiff.StringLatin1Fallback)
 	}
 	buf, err := ioutil.ReadFile(flag.Arg(0))
 	if err != nil {
 		logger.Fatal(err)
 	}
+	buf, err = getTIFF(buf)
+	if err != nil {
+		logger.Fatal(err)
+	}
 	valid, order, ifdPos := tiff.GetHeader(buf)
 	if !valid {
 		logger.Fatal("Not a valid TIFF file")
 	}
 	root, err := tiff.GetIFDTree(buf, order, ifdPos, tiff.TIFFSpace)
-	printNode(root, uint32(length))
+	if jsonOutput {
+		if jerr := tiff.ExportJSON(os.Stdout, root); jerr != nil {
+			logger.Fatal(jerr)
+		}
+	} else if exiftoolOutput {
+		if eerr := tiff.ExportExiftool(os.Stdout, root); eerr != nil {
+			logger.Fatal(eerr)
+		}
+	} else if treeOutput {
+		if terr := root.PrintTree(os.Stdout, uint32(length)); terr != nil {
+			logger.Fatal(terr)
+		}
+	} else {
+		var positions dataPositions
+		if verbose {
+			positions = getDataPositions(buf)
+		}
+		root.Walk(func(node *tiff.IFDNode, _ tiff.Tag) error {
+			printNode(buf, node, uint32(length), positions)
+			return nil
+		})
+	}
 	if err != nil {
 		logger.Print(err)
 	}
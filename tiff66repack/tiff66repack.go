@@ -1,45 +1,271 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
 	tiff "github.com/garyhouston/tiff66"
 	"io/ioutil"
 	"log"
 	"os"
 )
 
+// Delete the sub-IFDs with a given tag from the TIFF and Exif nodes in
+// a tree, and any fields with the tag that don't have a decoded
+// sub-IFD, such as unsupported maker notes.
+func stripSubIFDs(root *tiff.IFDNode, tag tiff.Tag) {
+	root.Walk(func(node *tiff.IFDNode, _ tiff.Tag) error {
+		if space := node.GetSpace(); space != tiff.TIFFSpace && space != tiff.ExifSpace {
+			return nil
+		}
+		for i := 0; i < len(node.SubIFDs); i++ {
+			if node.SubIFDs[i].Tag == tag {
+				node.DeleteSubIFD(i)
+				i--
+			}
+		}
+		node.DeleteFields([]tiff.Tag{tag})
+		return nil
+	})
+}
+
+// Indicate if a node contains a thumbnail or reduced-resolution image.
+func isThumbnail(node *tiff.IFDNode) bool {
+	if len(node.FindFields([]tiff.Tag{tiff.JPEGInterchangeFormat})) > 0 {
+		return true
+	}
+	types, ok := node.GetLongs(tiff.NewSubfileType)
+	return ok && len(types) > 0 && types[0]&1 != 0
+}
+
+// Delete thumbnails from a tree: IFDs following the root with reduced
+// resolution images, such as the thumbnail IFD of an Exif block, and
+// reduced resolution images in SubIFDs, such as DNG previews. The root
+// itself isn't deleted.
+func stripThumbnails(root *tiff.IFDNode) {
+	for node := root; node.Next != nil; {
+		if isThumbnail(node.Next) {
+			node.Next = node.Next.Next
+		} else {
+			node = node.Next
+		}
+	}
+	root.Walk(func(node *tiff.IFDNode, _ tiff.Tag) error {
+		for i := 0; i < len(node.SubIFDs); i++ {
+			if node.SubIFDs[i].Tag == tiff.SubIFDs && isThumbnail(node.SubIFDs[i].Node) {
+				node.DeleteSubIFD(i)
+				i--
+			}
+		}
+		return nil
+	})
+}
+
+// Write a tree decoded from buf to a new file, keeping unchanged data
+// at its original offsets. If the tree can be patched into the input,
+// only the entries and data that changed are overwritten, and buf is
+// modified; otherwise the new IFDs, and any image data that changed,
+// are appended to a copy of the input. Returns whether the tree was
+// appended.
+func preserveLayout(root *tiff.IFDNode, buf []byte, ifdPos uint32, fileName string) (bool, error) {
+	// PatchIFDTree leaves buf unchanged if it fails.
+	if err := tiff.PatchIFDTree(buf, root, ifdPos); err == nil {
+		return false, ioutil.WriteFile(fileName, buf, 0644)
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return true, err
+	}
+	if _, err = file.Write(buf); err == nil {
+		_, err = root.AppendIFDTree(file, int64(len(buf)))
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return true, err
+}
+
+// Decode a TIFF file from a reader, reading field data on demand and
+// leaving image data in the file. Returns the tree, its byte order,
+// and the file, which must remain open until the output is written.
+func openStream(fileName string) (*tiff.IFDNode, binary.ByteOrder, *os.File, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	head := make([]byte, tiff.CR2HeaderSize)
+	file.ReadAt(head, 0)
+	if cr2, _, _, _ := tiff.GetCR2Header(head); cr2 {
+		file.Close()
+		return nil, nil, nil, errors.New("CR2 files can't be streamed")
+	}
+	valid, order, ifdPos, err := tiff.GetHeaderReader(file)
+	if err == nil && !valid {
+		err = errors.New("Not a valid TIFF file")
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	root, err := tiff.GetIFDTreeReader(file, order, ifdPos, tiff.TIFFSpace)
+	if root == nil {
+		file.Close()
+	}
+	return root, order, file, err
+}
+
+// Write a tree to a new file with WriteIFDTree, which streams image
+// data that hasn't been loaded from its source in chunks.
+func writeStream(root *tiff.IFDNode, fileName string, share bool) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if share {
+		err = root.WriteIFDTreeShared(w)
+	} else {
+		err = root.WriteIFDTree(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 // Decode a TIFF file, then re-encode it and write to a new file.
 func main() {
+	var swap, stripGPS, stripMakerNote, stripThumbnail, preserve, share, stream bool
+	var makerNotes string
 	logger := log.New(os.Stderr, "", 0)
-	if len(os.Args) != 3 {
-		logger.Fatalf("Usage: %s file outfile\n", os.Args[0])
+	flag.BoolVar(&swap, "s", false, "write the output in the opposite byte order")
+	flag.BoolVar(&stripGPS, "strip-gps", false, "delete GPS IFDs")
+	flag.BoolVar(&stripMakerNote, "strip-makernote", false, "delete maker notes, same as -makernote drop")
+	flag.StringVar(&makerNotes, "makernote", "rewrite", "maker note policy: keep (copy byte-identically), rewrite (decode and re-encode) or drop")
+	flag.BoolVar(&stripThumbnail, "strip-thumbnail", false, "delete thumbnail and preview images")
+	flag.BoolVar(&preserve, "p", false, "preserve the layout of the file, patching or appending changes")
+	flag.BoolVar(&share, "share", false, "write identical field data only once")
+	flag.BoolVar(&stream, "stream", false, "copy image data from the input to the output in chunks, without reading the whole file into memory")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		logger.Fatalf("Usage: %s [-s | -p | -stream] [-share] [-strip-gps] [-makernote keep|rewrite|drop] [-strip-thumbnail] file outfile\n", os.Args[0])
 	}
-	buf, err := ioutil.ReadFile(os.Args[1])
-	if err != nil {
-		logger.Fatal(err)
+	if swap && preserve {
+		logger.Fatal("The byte order can't be changed when preserving the layout")
+	}
+	if share && preserve {
+		logger.Fatal("Field data can't be shared when preserving the layout")
+	}
+	if stream && (preserve || makerNotes == "keep") {
+		logger.Fatal("The layout and maker notes can't be preserved when streaming")
 	}
-	valid, order, ifdPos := tiff.GetHeader(buf)
-	if !valid {
-		logger.Fatal("Not a valid TIFF file")
+	switch makerNotes {
+	case "keep", "rewrite":
+	case "drop":
+		stripMakerNote = true
+	default:
+		logger.Fatalf("Invalid maker note policy %q", makerNotes)
+	}
+	var buf []byte
+	var order binary.ByteOrder
+	var ifdPos uint32
+	var root *tiff.IFDNode
+	var err error
+	if stream {
+		var file *os.File
+		root, order, file, err = openStream(flag.Arg(0))
+		if root == nil {
+			logger.Fatal(err)
+		}
+		defer file.Close()
+	} else {
+		if buf, err = ioutil.ReadFile(flag.Arg(0)); err != nil {
+			logger.Fatal(err)
+		}
+		var valid bool
+		valid, order, ifdPos = tiff.GetHeader(buf)
+		if !valid {
+			logger.Fatal("Not a valid TIFF file")
+		}
+		// Maker notes that are kept aren't decoded, so they are
+		// copied as opaque field data.
+		opts := tiff.ParseOptions{OpaqueMakerNotes: makerNotes == "keep"}
+		root, err = tiff.GetIFDTreeOptions(buf, order, ifdPos, tiff.TIFFSpace, &opts)
 	}
-	root, err := tiff.GetIFDTree(buf, order, ifdPos, tiff.TIFFSpace)
 	if err != nil {
 		logger.Print(err)
 		logger.Print("Error(s) occurred during decoding, but will repack anyway.")
 	}
 	root.Fix()
+	if stripGPS {
+		stripSubIFDs(root, tiff.GPSIFD)
+	}
+	if stripMakerNote {
+		stripSubIFDs(root, tiff.MakerNote)
+	}
+	if stripThumbnail {
+		stripThumbnails(root)
+	}
+	if swap {
+		if order == binary.BigEndian {
+			order = binary.LittleEndian
+		} else {
+			order = binary.BigEndian
+		}
+		if err := root.ConvertByteOrder(order); err != nil {
+			logger.Fatal(err)
+		}
+	}
 	root = root.DeleteEmptyIFDs()
 	if root == nil {
 		logger.Fatal("Output TIFF file would have no fields; invalid according to TIFF spec.")
 	}
-	fileSize := tiff.HeaderSize + root.TreeSize()
-	out := make([]byte, fileSize)
-	tiff.PutHeader(out, order, tiff.HeaderSize)
-	next, err := root.PutIFDTree(out, tiff.HeaderSize)
+	if stream {
+		if err := writeStream(root, flag.Arg(1), share); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+	if preserve {
+		appended, err := preserveLayout(root, buf, ifdPos, flag.Arg(1))
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if appended {
+			logger.Print("Changes couldn't be made in place, so the IFDs were appended.")
+		}
+		return
+	}
+	var out []byte
+	var next uint32
+	cr2, _, _, _ := tiff.GetCR2Header(buf)
+	if share {
+		if cr2 {
+			logger.Fatal("Field data can't be shared in CR2 files")
+		}
+		var shared bytes.Buffer
+		err = root.WriteIFDTreeShared(&shared)
+		out = shared.Bytes()
+		next = uint32(len(out))
+	} else if cr2 {
+		// Preserve the CR2 header, which points to the raw IFD.
+		out = make([]byte, tiff.CR2HeaderSize+root.TreeSize())
+		next, err = root.PutCR2IFDTree(out)
+	} else {
+		out = make([]byte, tiff.HeaderSize+root.TreeSize())
+		tiff.PutHeader(out, order, tiff.HeaderSize)
+		next, err = root.PutIFDTree(out, tiff.HeaderSize)
+	}
 	if err != nil {
 		logger.Fatal(err)
 	}
 	out = out[:next]
-	if err = ioutil.WriteFile(os.Args[2], out, 0644); err != nil {
+	if err = ioutil.WriteFile(flag.Arg(1), out, 0644); err != nil {
 		logger.Fatal(err)
 	}
 }
@@ -0,0 +1,324 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Severity of a problem found by ValidateTIFF.
+type Severity uint8
+
+const (
+	SeverityWarning Severity = 0 // Unusual, but readable.
+	SeverityError   Severity = 1 // Invalid, data may be lost or misread.
+)
+
+// Return "warning" or "error".
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Marshal a severity as its string, for JSON reports.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// A problem found by ValidateTIFF.
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Space    string   `json:"space,omitempty"`  // Tag space of the IFD.
+	IFDPos   uint32   `json:"ifdPos,omitempty"` // Position of the IFD, or 0.
+	Tag      Tag      `json:"tag,omitempty"`    // Tag of the field, or 0.
+	Pos      uint32   `json:"pos"`              // Position in the file.
+	Message  string   `json:"message"`
+}
+
+// Return a description of a problem.
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s at %d", p.Severity, p.Pos)
+	if p.Space != "" {
+		s += fmt.Sprintf(" in %s IFD at %d", p.Space, p.IFDPos)
+	}
+	if p.Tag != 0 {
+		s += fmt.Sprintf(", field %d(0x%X)", p.Tag, p.Tag)
+	}
+	return s + ": " + p.Message
+}
+
+// The result of ValidateTIFF, listing problems in the order found.
+type ValidationReport struct {
+	Problems []Problem `json:"problems"`
+}
+
+// Return true if the report contains any errors, as opposed to only
+// warnings.
+func (r ValidationReport) HasErrors() bool {
+	for _, p := range r.Problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// A region of the file used by an IFD, field data or image data.
+type validateRegion struct {
+	start, end uint32
+	problem    Problem // Location and description of the user.
+}
+
+// State for ValidateTIFF.
+type validator struct {
+	buf     []byte
+	order   binary.ByteOrder
+	report  ValidationReport
+	regions []validateRegion
+	visited map[uint32]bool
+}
+
+// Add a problem to the report.
+func (v *validator) add(severity Severity, loc Problem, format string, args ...interface{}) {
+	loc.Severity = severity
+	loc.Message = fmt.Sprintf(format, args...)
+	v.report.Problems = append(v.report.Problems, loc)
+}
+
+// Record a region of the file, checking that it's within the file.
+func (v *validator) region(start, size uint64, loc Problem, what string) bool {
+	if start+size > uint64(len(v.buf)) {
+		loc.Pos = uint32(start)
+		v.add(SeverityError, loc, "%s extends past end of file", what)
+		return false
+	}
+	loc.Message = what
+	v.regions = append(v.regions, validateRegion{uint32(start), uint32(start + size), loc})
+	return true
+}
+
+// Return value i of an integer field in an IFD entry.
+func (v *validator) entryValue(entry Field, i uint32) uint32 {
+	if entry.Type == IFD {
+		return entry.Long(i, v.order)
+	}
+	return uint32(entry.AnyInteger(i, v.order))
+}
+
+// An IFD waiting to be checked.
+type validateIFD struct {
+	pos   uint32
+	space TagSpace
+}
+
+// Check an IFD, returning the IFDs to which it refers.
+func (v *validator) checkIFD(ifd validateIFD) []validateIFD {
+	loc := Problem{Space: ifd.space.Name(), IFDPos: ifd.pos, Pos: ifd.pos}
+	if v.visited[ifd.pos] {
+		v.add(SeverityError, loc, "IFD is referenced more than once")
+		return nil
+	}
+	v.visited[ifd.pos] = true
+	if ifd.pos%2 != 0 {
+		v.add(SeverityWarning, loc, "IFD isn't word aligned")
+	}
+	bufsize := uint64(len(v.buf))
+	if uint64(ifd.pos)+2 > bufsize {
+		v.add(SeverityError, loc, "IFD position is past end of file")
+		return nil
+	}
+	count := uint32(v.order.Uint16(v.buf[ifd.pos:]))
+	if !v.region(uint64(ifd.pos), uint64(TableSize(uint16(count))), loc, "IFD") {
+		return nil
+	}
+	if count == 0 {
+		v.add(SeverityWarning, loc, "IFD has no fields")
+	}
+	defs := ifd.space.TagDefs()
+	entries := make(map[Tag]Field)
+	seen := make(map[Tag]bool)
+	var lastTag Tag
+	for i := uint32(0); i < count; i++ {
+		pos := ifd.pos + 2 + i*TableEntrySize
+		entry := Field{
+			Tag:   Tag(v.order.Uint16(v.buf[pos:])),
+			Type:  Type(v.order.Uint16(v.buf[pos+2:])),
+			Count: v.order.Uint32(v.buf[pos+4:])}
+		floc := loc
+		floc.Tag = entry.Tag
+		floc.Pos = pos
+		if seen[entry.Tag] {
+			v.add(SeverityError, floc, "Duplicate tag")
+		} else if i > 0 && entry.Tag < lastTag {
+			v.add(SeverityError, floc, "Tag is out of order, follows %d(0x%X)", lastTag, lastTag)
+		}
+		seen[entry.Tag] = true
+		lastTag = entry.Tag
+		typeSize := entry.Type.Size()
+		if typeSize == 0 {
+			v.add(SeverityWarning, floc, "Unknown type %d", entry.Type)
+			continue
+		}
+		size := uint64(entry.Count) * uint64(typeSize)
+		dataPos := uint64(pos + 8)
+		if size > 4 {
+			dataPos = uint64(v.order.Uint32(v.buf[pos+8:]))
+			dloc := floc
+			dloc.Pos = uint32(dataPos)
+			if dataPos%2 != 0 {
+				v.add(SeverityWarning, dloc, "Field data isn't word aligned")
+			}
+			if !v.region(dataPos, size, floc, "Field data") {
+				continue
+			}
+		}
+		entry.Data = v.buf[dataPos : dataPos+size]
+		entries[entry.Tag] = entry
+		if def, found := defs[entry.Tag]; found {
+			if problem := def.check(entry); problem != nil {
+				v.add(SeverityWarning, floc, "Field %v", problem)
+			}
+		}
+	}
+	var refs []validateIFD
+	subIFD := func(tag Tag, space TagSpace) {
+		entry, found := entries[tag]
+		if !found || (entry.Type != LONG && entry.Type != IFD) {
+			return
+		}
+		for i := uint32(0); i < entry.Count; i++ {
+			refs = append(refs, validateIFD{v.entryValue(entry, i), space})
+		}
+	}
+	switch ifd.space {
+	case TIFFSpace:
+		subIFD(SubIFDs, TIFFSpace)
+		subIFD(ExifIFD, ExifSpace)
+		subIFD(GPSIFD, GPSSpace)
+		v.checkImageData(loc, entries)
+	case ExifSpace:
+		subIFD(InteroperabilityIFD, InteropSpace)
+	}
+	if next := v.order.Uint32(v.buf[ifd.pos+2+count*TableEntrySize:]); next != 0 {
+		if ifd.space == TIFFSpace {
+			refs = append(refs, validateIFD{next, TIFFSpace})
+		} else {
+			v.add(SeverityWarning, loc, "%s IFD has a next IFD at %d", ifd.space.Name(), next)
+		}
+	}
+	return refs
+}
+
+// Check the image data and required fields of a TIFF IFD.
+func (v *validator) checkImageData(loc Problem, entries map[Tag]Field) {
+	found := false
+	for i, offsetTag := range tiffOffsetTags {
+		offsets, ok := entries[offsetTag]
+		if !ok || !offsets.Type.IsIntegral() {
+			continue
+		}
+		found = true
+		sizeTag := tiffSizeTags[i]
+		floc := loc
+		floc.Tag = offsetTag
+		sizes, ok := entries[sizeTag]
+		if !ok || !sizes.Type.IsIntegral() {
+			v.add(SeverityError, floc, "Field %d(0x%X) not found", sizeTag, sizeTag)
+			continue
+		}
+		if sizes.Count != offsets.Count {
+			v.add(SeverityError, floc, "Has %d values, but field %d(0x%X) has %d", offsets.Count, sizeTag, sizeTag, sizes.Count)
+			continue
+		}
+		for j := uint32(0); j < offsets.Count; j++ {
+			v.region(uint64(v.entryValue(offsets, j)), uint64(v.entryValue(sizes, j)), floc, fmt.Sprintf("Image data segment %d", j))
+		}
+	}
+	_, strips := entries[StripOffsets]
+	_, tiles := entries[TileOffsets]
+	if !found {
+		v.add(SeverityWarning, loc, "IFD has no image data")
+		return
+	}
+	if !strips && !tiles {
+		// Only a JPEGInterchangeFormat image or free space.
+		return
+	}
+	for _, tag := range []Tag{ImageWidth, ImageLength, PhotometricInterpretation} {
+		if _, ok := entries[tag]; !ok {
+			v.add(SeverityError, loc, "Required field %s(0x%X) not found", TagNames[tag], tag)
+		}
+	}
+	for _, tag := range []Tag{XResolution, YResolution} {
+		if _, ok := entries[tag]; !ok {
+			v.add(SeverityWarning, loc, "Baseline field %s(0x%X) not found", TagNames[tag], tag)
+		}
+	}
+	if tiles {
+		for _, tag := range []Tag{TileWidth, TileLength} {
+			if _, ok := entries[tag]; !ok {
+				v.add(SeverityError, loc, "Required field %s(0x%X) not found", TagNames[tag], tag)
+			}
+		}
+	}
+}
+
+// Report regions of the file that overlap.
+func (v *validator) checkOverlaps() {
+	sort.SliceStable(v.regions, func(i, j int) bool { return v.regions[i].start < v.regions[j].start })
+	var last *validateRegion
+	for i := range v.regions {
+		r := &v.regions[i]
+		if r.start == r.end {
+			continue
+		}
+		if last != nil && r.start < last.end {
+			severity := SeverityError
+			if r.start == last.start && r.end == last.end {
+				// Probably intentionally shared.
+				severity = SeverityWarning
+			}
+			loc := r.problem
+			loc.Pos = r.start
+			lastDesc := last.problem.Message
+			if last.problem.Tag != 0 {
+				lastDesc += fmt.Sprintf(" of field %d(0x%X)", last.problem.Tag, last.problem.Tag)
+			}
+			v.add(severity, loc, "%s overlaps %s in %s IFD at %d", r.problem.Message, lastDesc, last.problem.Space, last.problem.IFDPos)
+		}
+		if last == nil || r.end > last.end {
+			last = r
+		}
+	}
+}
+
+// Check the structure of a TIFF file: the header, the IFDs reachable
+// from it via Next pointers and the SubIFDs, ExifIFD, GPSIFD and
+// InteroperabilityIFD fields, and the field and image data to which
+// they point. Problems found include invalid or unaligned positions,
+// data extending past the end of the file, tags out of order,
+// overlapping data regions, missing baseline fields and fields with
+// types or counts that don't match TagDefs. Maker notes and other
+// private IFDs aren't checked. Unlike the errors from GetIFDTree, each
+// problem in the returned report has a severity and a position.
+func ValidateTIFF(buf []byte) ValidationReport {
+	var v validator
+	valid, order, pos := GetHeader(buf)
+	if !valid {
+		v.add(SeverityError, Problem{}, "TIFF header not valid")
+		return v.report
+	}
+	v.buf = buf
+	v.order = order
+	v.visited = make(map[uint32]bool)
+	v.region(0, HeaderSize, Problem{}, "Header")
+	queue := []validateIFD{{pos, TIFFSpace}}
+	for len(queue) > 0 {
+		ifd := queue[0]
+		queue = append(queue[1:], v.checkIFD(ifd)...)
+	}
+	v.checkOverlaps()
+	return v.report
+}
@@ -0,0 +1,90 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"strings"
+	"testing"
+)
+
+// Return true if a report has a problem with the given severity, tag
+// and message text.
+func hasProblem(report ValidationReport, severity Severity, tag Tag, text string) bool {
+	for _, p := range report.Problems {
+		if p.Severity == severity && p.Tag == tag && strings.Contains(p.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateTIFF(t *testing.T) {
+	node, err := EncodeImage(image.NewGray(image.Rect(0, 0, 8, 8)), &EncodeOptions{Order: binary.BigEndian})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exif := NewIFDNode(ExifSpace)
+	exif.Order = binary.BigEndian
+	exif.SetUndefined(ExifVersion, []byte("0232"))
+	if err := node.SetExifIFD(exif); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := node.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	if report := ValidateTIFF(buf); len(report.Problems) != 0 {
+		t.Errorf("Valid file has problems: %v", report.Problems)
+	}
+
+	if report := ValidateTIFF([]byte("II*\000")); !report.HasErrors() {
+		t.Error("Short header not reported")
+	}
+
+	// Corrupt a copy of the file: swap the first two entries of
+	// IFD 0, point the strip at the IFD, and change ExifVersion's
+	// count.
+	bad := append([]byte(nil), buf...)
+	ifd := binary.BigEndian.Uint32(bad[4:])
+	entry := func(i uint32) []byte {
+		return bad[ifd+2+i*TableEntrySize : ifd+2+(i+1)*TableEntrySize]
+	}
+	var tmp [TableEntrySize]byte
+	copy(tmp[:], entry(0))
+	copy(entry(0), entry(1))
+	copy(entry(1), tmp[:])
+	count := uint32(binary.BigEndian.Uint16(bad[ifd:]))
+	for i := uint32(0); i < count; i++ {
+		e := entry(i)
+		switch Tag(binary.BigEndian.Uint16(e)) {
+		case StripOffsets:
+			binary.BigEndian.PutUint32(e[8:], ifd)
+		case ExifIFD:
+			exifPos := binary.BigEndian.Uint32(e[8:])
+			binary.BigEndian.PutUint32(bad[exifPos+2+4:], 3)
+		}
+	}
+	report := ValidateTIFF(bad)
+	if !report.HasErrors() {
+		t.Error("No errors reported")
+	}
+	if !hasProblem(report, SeverityError, ImageWidth, "out of order") {
+		t.Error("Tag order problem not reported")
+	}
+	if !hasProblem(report, SeverityError, StripOffsets, "overlaps IFD") {
+		t.Error("Overlapping image data not reported")
+	}
+	if !hasProblem(report, SeverityWarning, ExifVersion, "count 3, expected 4") {
+		t.Error("Wrong ExifVersion count not reported")
+	}
+	js, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(js, []byte(`"severity":"error"`)) {
+		t.Errorf("Wrong JSON report %s", js)
+	}
+}
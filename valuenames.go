@@ -0,0 +1,200 @@
+package tiff66
+
+// Names of the values of the Compression field, as used by Exiftool.
+var CompressionNames = map[int64]string{
+	1:     "Uncompressed",
+	2:     "CCITT 1D",
+	3:     "T4/Group 3 Fax",
+	4:     "T6/Group 4 Fax",
+	5:     "LZW",
+	6:     "JPEG (old-style)",
+	7:     "JPEG",
+	8:     "Adobe Deflate",
+	9:     "JBIG B&W",
+	10:    "JBIG Color",
+	99:    "JPEG",
+	262:   "Kodak 262",
+	32766: "Next",
+	32767: "Sony ARW Compressed",
+	32769: "Packed RAW",
+	32770: "Samsung SRW Compressed",
+	32771: "CCIRLEW",
+	32773: "PackBits",
+	32809: "Thunderscan",
+	32867: "Kodak KDC Compressed",
+	32908: "PixarFilm",
+	32909: "PixarLog",
+	32946: "Deflate",
+	32947: "DCS",
+	34661: "JBIG",
+	34676: "SGILog",
+	34677: "SGILog24",
+	34712: "JPEG 2000",
+	34713: "Nikon NEF Compressed",
+	34715: "JBIG2 TIFF FX",
+	34892: "Lossy JPEG",
+	34925: "LZMA2",
+	34926: "Zstd",
+	34927: "WebP",
+	34933: "PNG",
+	34934: "JPEG XR",
+	65000: "Kodak DCR Compressed",
+	65535: "Pentax PEF Compressed",
+}
+
+// Names of the values of the PhotometricInterpretation field.
+var PhotometricNames = map[int64]string{
+	0:     "WhiteIsZero",
+	1:     "BlackIsZero",
+	2:     "RGB",
+	3:     "RGB Palette",
+	4:     "Transparency Mask",
+	5:     "CMYK",
+	6:     "YCbCr",
+	8:     "CIELab",
+	9:     "ICCLab",
+	10:    "ITULab",
+	32803: "Color Filter Array",
+	32844: "Pixar LogL",
+	32845: "Pixar LogLuv",
+	32892: "Sequential Color Filter",
+	34892: "Linear Raw",
+}
+
+// Names of the values of the ResolutionUnit field, also used by
+// FocalPlaneResolutionUnit.
+var ResolutionUnitNames = map[int64]string{
+	1: "None",
+	2: "inches",
+	3: "cm",
+}
+
+// Names of the values of the Orientation field.
+var OrientationNames = map[int64]string{
+	1: "Horizontal (normal)",
+	2: "Mirror horizontal",
+	3: "Rotate 180",
+	4: "Mirror vertical",
+	5: "Mirror horizontal and rotate 270 CW",
+	6: "Rotate 90 CW",
+	7: "Mirror horizontal and rotate 90 CW",
+	8: "Rotate 270 CW",
+}
+
+// Names of the values of the Exif ExposureProgram field.
+var ExposureProgramNames = map[int64]string{
+	0: "Not Defined",
+	1: "Manual",
+	2: "Program AE",
+	3: "Aperture-priority AE",
+	4: "Shutter speed priority AE",
+	5: "Creative (Slow speed)",
+	6: "Action (High speed)",
+	7: "Portrait",
+	8: "Landscape",
+	9: "Bulb",
+}
+
+// Names of the values of the Exif MeteringMode field.
+var MeteringModeNames = map[int64]string{
+	0:   "Unknown",
+	1:   "Average",
+	2:   "Center-weighted average",
+	3:   "Spot",
+	4:   "Multi-spot",
+	5:   "Multi-segment",
+	6:   "Partial",
+	255: "Other",
+}
+
+// Names of the values of the Exif LightSource field.
+var LightSourceNames = map[int64]string{
+	0:   "Unknown",
+	1:   "Daylight",
+	2:   "Fluorescent",
+	3:   "Tungsten (Incandescent)",
+	4:   "Flash",
+	9:   "Fine Weather",
+	10:  "Cloudy",
+	11:  "Shade",
+	12:  "Daylight Fluorescent",
+	13:  "Day White Fluorescent",
+	14:  "Cool White Fluorescent",
+	15:  "White Fluorescent",
+	16:  "Warm White Fluorescent",
+	17:  "Standard Light A",
+	18:  "Standard Light B",
+	19:  "Standard Light C",
+	20:  "D55",
+	21:  "D65",
+	22:  "D75",
+	23:  "D50",
+	24:  "ISO Studio Tungsten",
+	255: "Other",
+}
+
+// Names of the values of the Exif Flash field. The value is a set of
+// bit fields, but only the combinations listed are defined.
+var FlashNames = map[int64]string{
+	0x00: "No Flash",
+	0x01: "Fired",
+	0x05: "Fired, Return not detected",
+	0x07: "Fired, Return detected",
+	0x08: "On, Did not fire",
+	0x09: "On, Fired",
+	0x0D: "On, Return not detected",
+	0x0F: "On, Return detected",
+	0x10: "Off, Did not fire",
+	0x14: "Off, Did not fire, Return not detected",
+	0x18: "Auto, Did not fire",
+	0x19: "Auto, Fired",
+	0x1D: "Auto, Fired, Return not detected",
+	0x1F: "Auto, Fired, Return detected",
+	0x20: "No flash function",
+	0x30: "Off, No flash function",
+	0x41: "Fired, Red-eye reduction",
+	0x45: "Fired, Red-eye reduction, Return not detected",
+	0x47: "Fired, Red-eye reduction, Return detected",
+	0x49: "On, Red-eye reduction",
+	0x4D: "On, Red-eye reduction, Return not detected",
+	0x4F: "On, Red-eye reduction, Return detected",
+	0x50: "Off, Red-eye reduction",
+	0x58: "Auto, Did not fire, Red-eye reduction",
+	0x59: "Auto, Fired, Red-eye reduction",
+	0x5D: "Auto, Fired, Red-eye reduction, Return not detected",
+	0x5F: "Auto, Fired, Red-eye reduction, Return detected",
+}
+
+// Value name tables for enumerated fields, by tag space and tag.
+var ValueNames = map[TagSpace]map[Tag]map[int64]string{
+	TIFFSpace: {
+		Compression:               CompressionNames,
+		PhotometricInterpretation: PhotometricNames,
+		ResolutionUnit:            ResolutionUnitNames,
+		Orientation:               OrientationNames,
+	},
+	ExifSpace: {
+		ExposureProgram:          ExposureProgramNames,
+		MeteringMode:             MeteringModeNames,
+		LightSource:              LightSourceNames,
+		Flash:                    FlashNames,
+		FocalPlaneResolutionUnit: ResolutionUnitNames,
+	},
+}
+
+// Return the name of a value of an enumerated field in a tag space,
+// such as "LZW" for Compression 5, and whether it was found.
+func (space TagSpace) ValueName(tag Tag, val int64) (string, bool) {
+	name, found := ValueNames[space][tag][val]
+	return name, found
+}
+
+// Return the name of the first value of an enumerated integer field in
+// a node, and whether the field and the name were found.
+func (node IFDNode) ValueName(tag Tag) (string, bool) {
+	field, found := node.FindField(tag)
+	if !found || !field.Type.IsIntegral() || field.CheckIndex(0) != nil {
+		return "", false
+	}
+	return node.GetSpace().ValueName(tag, field.AnyInteger(0, node.Order))
+}
@@ -0,0 +1,39 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestValueNames(t *testing.T) {
+	if name, found := TIFFSpace.ValueName(Compression, CompressionLZW); !found || name != "LZW" {
+		t.Errorf("Compression %d has name %q", CompressionLZW, name)
+	}
+	if _, found := ExifSpace.ValueName(Compression, CompressionLZW); found {
+		t.Error("Compression value name found in Exif space")
+	}
+	if _, found := TIFFSpace.ValueName(Orientation, 9); found {
+		t.Error("Name found for undefined Orientation value")
+	}
+	for space, tags := range ValueNames {
+		for tag := range tags {
+			if _, found := space.TagNames()[tag]; !found {
+				t.Errorf("Value names for unknown tag %d(0x%X) in %s space", tag, tag, space.Name())
+			}
+		}
+	}
+
+	node := NewIFDNode(ExifSpace)
+	node.Order = binary.LittleEndian
+	node.SetShorts(Flash, []uint16{0x19})
+	node.SetShorts(MeteringMode, nil)
+	if name, found := node.ValueName(Flash); !found || name != "Auto, Fired" {
+		t.Errorf("Flash has name %q", name)
+	}
+	if _, found := node.ValueName(MeteringMode); found {
+		t.Error("Name found for a field without values")
+	}
+	if _, found := node.ValueName(LightSource); found {
+		t.Error("Name found for a missing field")
+	}
+}
@@ -0,0 +1,51 @@
+package tiff66
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Check the order in which Walk visits the nodes of a tree, and the
+// effect of returning SkipSubIFDs or an error.
+func TestWalk(t *testing.T) {
+	interop := NewIFDNode(InteropSpace)
+	exif := NewIFDNode(ExifSpace)
+	exif.SubIFDs = []SubIFD{{interOpIFD, interop}}
+	gps := NewIFDNode(GPSSpace)
+	next := NewIFDNode(TIFFSpace)
+	root := NewIFDNode(TIFFSpace)
+	root.SubIFDs = []SubIFD{{ExifIFD, exif}, {GPSIFD, gps}}
+	root.Next = next
+	type visit struct {
+		node *IFDNode
+		tag  Tag
+	}
+	var visits []visit
+	record := func(node *IFDNode, tag Tag) error {
+		visits = append(visits, visit{node, tag})
+		if node == exif {
+			return SkipSubIFDs
+		}
+		return nil
+	}
+	if err := root.Walk(record); err != nil {
+		t.Fatal(err)
+	}
+	expected := []visit{{root, 0}, {exif, ExifIFD}, {gps, GPSIFD}, {next, 0}}
+	if !reflect.DeepEqual(visits, expected) {
+		t.Errorf("Unexpected visits: %v", visits)
+	}
+	stop := errors.New("stop")
+	count := 0
+	err := root.Walk(func(node *IFDNode, tag Tag) error {
+		count++
+		if node == interop {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 3 {
+		t.Errorf("Walk returned %v after %d visits", err, count)
+	}
+}
@@ -0,0 +1,67 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Position and size of a chunk in a WebP file.
+type webpChunk struct {
+	fourCC string
+	pos    uint32 // Position of the chunk data.
+	size   uint32 // Size of the chunk data, excluding padding.
+}
+
+// Return the chunks in a WebP file, which is a RIFF container.
+func webpChunks(buf []byte) ([]webpChunk, error) {
+	if len(buf) < 12 || string(buf[:4]) != "RIFF" || string(buf[8:12]) != "WEBP" {
+		return nil, errors.New("Not a WebP file")
+	}
+	var chunks []webpChunk
+	bufsize := uint64(len(buf))
+	// The RIFF size excludes the first 8 bytes. Some writers get it
+	// wrong, so don't trust it beyond the end of the buffer.
+	if riffEnd := 8 + uint64(binary.LittleEndian.Uint32(buf[4:])); riffEnd < bufsize {
+		bufsize = riffEnd
+	}
+	pos := uint64(12)
+	for pos < bufsize {
+		if pos+8 > bufsize {
+			return chunks, errors.New("WebP chunk header extends past end of file")
+		}
+		size := uint64(binary.LittleEndian.Uint32(buf[pos+4:]))
+		if pos+8+size > bufsize {
+			return chunks, errors.New("WebP chunk extends past end of file")
+		}
+		chunks = append(chunks, webpChunk{string(buf[pos : pos+4]), uint32(pos + 8), uint32(size)})
+		// Chunks are padded to an even size.
+		pos += 8 + size + size&1
+	}
+	return chunks, nil
+}
+
+// Return the TIFF block from the EXIF chunk in a WebP file, or nil if
+// there is none. The returned slice points into buf.
+func GetWebPExif(buf []byte) ([]byte, error) {
+	chunks, err := webpChunks(buf)
+	for _, chunk := range chunks {
+		if chunk.fourCC == "EXIF" {
+			exif := buf[chunk.pos : chunk.pos+chunk.size]
+			// Some software includes the header used in
+			// JPEG APP1 segments.
+			return bytes.TrimPrefix(exif, ExifHeader), nil
+		}
+	}
+	return nil, err
+}
+
+// Decode the EXIF chunk in a WebP file into an IFDNode tree. Returns a
+// nil node if the file doesn't contain an EXIF chunk.
+func GetWebPExifTree(buf []byte) (*IFDNode, error) {
+	exif, err := GetWebPExif(buf)
+	if exif == nil {
+		return nil, err
+	}
+	return getTIFFTree(exif)
+}
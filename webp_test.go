@@ -0,0 +1,47 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Read an Exif tree from a WebP file with an odd-sized chunk before
+// the EXIF chunk.
+func TestWebPExif(t *testing.T) {
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.BigEndian
+	node.Fields = []Field{{Orientation, SHORT, 1, []byte{0, 6}}}
+	exif, err := putTIFFTree(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(fourCC string, data []byte) []byte {
+		out := append([]byte(fourCC), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+		out = append(out, data...)
+		if len(data)%2 != 0 {
+			out = append(out, 0)
+		}
+		return out
+	}
+	body := append([]byte("WEBP"), chunk("VP8X", make([]byte, 10))...)
+	body = append(body, chunk("ICCP", []byte{1, 2, 3})...)
+	body = append(body, chunk("EXIF", append(append([]byte{}, ExifHeader...), exif...))...)
+	webp := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(webp[4:], uint32(len(body)))
+	webp = append(webp, body...)
+
+	getnode, err := GetWebPExifTree(webp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getnode == nil || len(getnode.Fields) != 1 || getnode.Fields[0].Short(0, getnode.Order) != 6 {
+		t.Fatal("Exif tree not read back")
+	}
+	if exif, err := GetWebPExif(webp[:len(webp)-4]); exif != nil || err == nil {
+		t.Error("Truncated WebP file didn't cause an error")
+	}
+	if _, err := GetWebPExif([]byte("RIFF\000\000\000\000AVI ")); err == nil {
+		t.Error("Non-WebP RIFF file didn't cause an error")
+	}
+}
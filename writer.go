@@ -0,0 +1,400 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Error returned when a tree is too large to be written as a TIFF
+// file, whose positions are 32 bits. It would need to be written as
+// BigTIFF, which isn't supported.
+type ErrNeedsBigTIFF struct {
+	Op   string // Function that returned the error.
+	Size uint64 // Size of the file, or the part of it that was too large.
+}
+
+func (e ErrNeedsBigTIFF) Error() string {
+	return fmt.Sprintf("%s: size %d is too large for a TIFF file, BigTIFF would be needed", e.Op, e.Size)
+}
+
+// Image data to be written after the IFDs and field data, when
+// writing a tree to a stream.
+type imageStream struct {
+	next      uint32      // Position for the next segment.
+	imageData []ImageData // Image data in the order it's to be written.
+	// If true, image data that's unchanged from its original
+	// position in the file isn't written, and refers to that
+	// position.
+	keepOriginal bool
+	file         io.ReaderAt // The file, for comparing loaded image data.
+	fileSize     int64       // Size of the file before writing.
+}
+
+// Set the image stream in a node and all the nodes to which it refers,
+// excluding maker notes, which may use offsets relative to their own
+// start and need their image data to remain inside them.
+func (node *IFDNode) setImageStream(stream *imageStream) {
+	node.stream = stream
+	for i := range node.SubIFDs {
+		if !node.SubIFDs[i].Node.IsMakerNote() {
+			node.SubIFDs[i].Node.setImageStream(stream)
+		}
+	}
+	if node.Next != nil {
+		node.Next.setImageStream(stream)
+	}
+}
+
+// Position in the file of a buffer into which a tree is serialized,
+// when the buffer doesn't start at the beginning of the file, as when
+// appending.
+type writeOrigin struct {
+	offset uint32 // File position of the start of the buffer.
+	size   int    // Size of the buffer.
+}
+
+// Set the write origin in a node and all the nodes to which it refers,
+// including maker notes.
+func (node *IFDNode) setOrigin(origin *writeOrigin) {
+	node.Walk(func(n *IFDNode, _ Tag) error {
+		n.origin = origin
+		return nil
+	})
+}
+
+// Return the value to be written for a position in buf, offset by the
+// write origin if any. Maker notes with offsets relative to their own
+// start are written into a suffix of the buffer, and their positions
+// aren't adjusted.
+func (node IFDNode) filePos(buf []byte, pos uint32) uint32 {
+	if node.origin == nil || len(buf) != node.origin.size {
+		return pos
+	}
+	return pos + node.origin.offset
+}
+
+// Fields whose data is written only once when writing a tree with
+// WriteIFDTreeShared. Each duplicate field refers to the data of the
+// first field with the same data, in the order that the fields are
+// written.
+type dataSharing struct {
+	owners map[*Field]*Field // Duplicate fields and their owners.
+	pos    map[*Field]uint32 // Positions of owners' data, once written.
+}
+
+// Indicate if a field refers to another field's data.
+func (s *dataSharing) isDuplicate(field *Field) bool {
+	return s != nil && s.owners[field] != nil
+}
+
+// Return the position of the data to which a duplicate field refers,
+// and whether its owner has been written.
+func (s *dataSharing) sharedPos(field *Field) (uint32, bool) {
+	pos, ok := s.pos[s.owners[field]]
+	return pos, ok
+}
+
+// Record the position at which a field's data was written.
+func (s *dataSharing) written(field *Field, pos uint32) {
+	if s != nil {
+		s.pos[field] = pos
+	}
+}
+
+// Find the duplicate fields in a node and the nodes to which it
+// refers, in the order they're written by genericPutIFDTree: sub-IFDs,
+// then the next IFD, then the node itself. The sharing is set in each
+// node, excluding maker notes, whose data may be addressed relative to
+// their own start. Only external data that's stored as it is, and not
+// replaced with sub-IFD or image data positions, is shared. order is
+// the byte order of the node's parent; nodes in a different order are
+// written as converted copies, so they and their sub-IFDs aren't
+// shared.
+func (node *IFDNode) setDataSharing(s *dataSharing, first map[string]*Field, order binary.ByteOrder) {
+	if node.Order != order {
+		// Written as a converted copy, which isn't shared.
+		s = nil
+	}
+	node.sharing = s
+	for i := range node.SubIFDs {
+		if !node.SubIFDs[i].Node.IsMakerNote() {
+			node.SubIFDs[i].Node.setDataSharing(s, first, node.Order)
+		}
+	}
+	if node.Next != nil {
+		node.Next.setDataSharing(s, first, node.Order)
+	}
+	if s == nil {
+		return
+	}
+	imageData := node.GetImageData()
+FIELDLOOP:
+	for i := range node.Fields {
+		field := &node.Fields[i]
+		size := field.Size()
+		if size <= 4 || uint32(len(field.Data)) < size || node.nthSubIFD(field.Tag, 0) >= 0 {
+			continue
+		}
+		for _, id := range imageData {
+			if id.OffsetTag == field.Tag {
+				continue FIELDLOOP
+			}
+		}
+		// The type is included, since data of different types
+		// may be converted differently.
+		key := fmt.Sprintf("%d:%s", field.Type, field.Data[:size])
+		if owner, found := first[key]; found {
+			s.owners[field] = owner
+		} else {
+			first[key] = field
+		}
+	}
+}
+
+// Serialize a complete TIFF file to w, including the header, with the
+// node as the root IFD and the byte order given by the node. Unlike
+// PutIFDTree, the caller doesn't need to allocate a buffer for the
+// entire file: only the IFDs and field data are assembled in memory,
+// and image data is written after them, directly from its segments.
+// Image data that hasn't been loaded, if the tree was decoded with
+// GetIFDTreeReader or GetIFDTreeLazy, is copied from its source
+// without being held in memory. Image data in maker notes isn't
+// moved, since it may be addressed relative to the maker note.
+func (node *IFDNode) WriteIFDTree(w io.Writer) error {
+	return node.writeIFDTree(w, nil)
+}
+
+// Serialize a complete TIFF file to w as for WriteIFDTree, but with
+// fields that have identical data, such as ICC profiles or transfer
+// functions repeated in each page of a multi-page file, referring to a
+// single copy of the data. This can substantially reduce the size of
+// the file. Fields in maker notes aren't shared.
+func (node *IFDNode) WriteIFDTreeShared(w io.Writer) error {
+	sharing := &dataSharing{owners: make(map[*Field]*Field), pos: make(map[*Field]uint32)}
+	node.setDataSharing(sharing, make(map[string]*Field), node.Order)
+	defer node.setDataSharing(nil, nil, node.Order)
+	return node.writeIFDTree(w, nil)
+}
+
+// Serialize a complete TIFF file to w as for WriteIFDTree, followed by
+// the given ranges of buf, which would otherwise be lost when the file
+// is rewritten. The ranges are usually those returned by Unreferenced
+// for the buffer from which the tree was decoded. They're written
+// verbatim in the order given, after the image data, so a trailer
+// remains at the end of the file, but other ranges aren't kept at
+// their original positions.
+func (node *IFDNode) WriteIFDTreePreserving(w io.Writer, buf []byte, ranges []UnreferencedRange) error {
+	extra := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		if r.Start > r.End || uint64(r.End) > uint64(len(buf)) {
+			return fmt.Errorf("WriteIFDTreePreserving: range %d-%d is outside the buffer", r.Start, r.End)
+		}
+		extra[i] = buf[r.Start:r.End]
+	}
+	return node.writeIFDTree(w, extra)
+}
+
+// Serialize a complete TIFF file to w, with extra data written at the
+// end.
+func (node *IFDNode) writeIFDTree(w io.Writer, extra [][]byte) error {
+	stream := &imageStream{}
+	node.setImageStream(stream)
+	defer node.setImageStream(nil)
+	metaSize := uint64(HeaderSize) + uint64(node.TreeSize())
+	if metaSize > math.MaxUint32 {
+		return ErrNeedsBigTIFF{"WriteIFDTree", metaSize}
+	}
+	buf := make([]byte, metaSize)
+	stream.next = uint32(metaSize)
+	PutHeader(buf, node.Order, HeaderSize)
+	if _, err := node.PutIFDTree(buf, HeaderSize); err != nil {
+		return err
+	}
+	total := metaSize
+	for _, id := range stream.imageData {
+		total += id.Size()
+	}
+	for _, data := range extra {
+		total += uint64(len(data))
+	}
+	if total > math.MaxUint32 {
+		return ErrNeedsBigTIFF{"WriteIFDTree", total}
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	for _, id := range stream.imageData {
+		if _, err := id.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	for _, data := range extra {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size of the buffer used to copy image data that hasn't been loaded.
+const copyChunkSize = 64 * 1024
+
+// Write the segments of the image data consecutively to w. Segments
+// that haven't been loaded are copied from their source without being
+// held in memory.
+func (id ImageData) Copy(w io.Writer) error {
+	_, err := id.WriteTo(w)
+	return err
+}
+
+// Write the segments of the image data consecutively to w, as for
+// Copy, returning the number of bytes written, so that ImageData can be
+// used as an io.WriterTo. Segments that haven't been loaded are read
+// from their source in chunks, so that strips can be piped from an
+// input file to an output file.
+func (id ImageData) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	if id.IsLoaded() {
+		for _, seg := range id.Segments {
+			written, err := w.Write(seg)
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+		}
+		return n, nil
+	}
+	chunk := make([]byte, copyChunkSize)
+	for i := range id.Offsets {
+		section := io.NewSectionReader(id.reader, int64(id.Offsets[i]), int64(id.Sizes[i]))
+		written, err := io.CopyBuffer(w, section, chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		if written < int64(id.Sizes[i]) {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, nil
+}
+
+// Adapter for writing sequentially to an io.WriterAt.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.off)
+	ow.off += int64(n)
+	return n, err
+}
+
+// Indicate if image data is unchanged from the positions and sizes
+// from which it was decoded in a file of the given size, so that it can
+// be left in place when appending. Segments that haven't been loaded
+// are unchanged; loaded segments are compared with the file, or with
+// the source from which they were loaded if the file can't be read.
+func (id ImageData) hasOriginalPositions(file io.ReaderAt, size int64) bool {
+	n := id.numSegments()
+	if len(id.Offsets) != n || len(id.Sizes) != n {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if id.segmentSize(i) != id.Sizes[i] || int64(id.Offsets[i])+int64(id.Sizes[i]) > size {
+			return false
+		}
+	}
+	if !id.IsLoaded() {
+		return true
+	}
+	if file == nil {
+		file = id.reader
+	}
+	if file == nil {
+		return false
+	}
+	chunk := make([]byte, copyChunkSize)
+	for i, seg := range id.Segments {
+		for done := 0; done < len(seg); {
+			n := min(len(seg)-done, len(chunk))
+			if _, err := file.ReadAt(chunk[:n], int64(id.Offsets[i])+int64(done)); err != nil {
+				return false
+			}
+			if !bytes.Equal(chunk[:n], seg[done:done+n]) {
+				return false
+			}
+			done += n
+		}
+	}
+	return true
+}
+
+// Append a tree to an existing TIFF file with the given size, from
+// which the tree was decoded, and point the file's header to the new
+// root IFD. This is the traditional way of editing a TIFF file, which
+// avoids rewriting image data. Image data that's unchanged from the
+// positions and sizes from which it was decoded isn't written, and the
+// new IFDs refer to the original segments; other image data is
+// appended after the IFDs. Loaded image data is compared with the
+// file if w is also an io.ReaderAt, such as an *os.File, and otherwise
+// with the source from which it was decoded; if neither is available,
+// it's appended. The node's byte order must be the same as the file's.
+// The old IFDs and field data remain in the file, which grows with each
+// edit, and can be removed by rewriting it with WriteIFDTree. Returns
+// the new size of the file.
+func (node *IFDNode) AppendIFDTree(w io.WriterAt, size int64) (int64, error) {
+	if size < HeaderSize || size > math.MaxUint32 {
+		return 0, errors.New("AppendIFDTree: invalid file size")
+	}
+	base := Align(uint32(size))
+	stream := &imageStream{keepOriginal: true, fileSize: size}
+	stream.file, _ = w.(io.ReaderAt)
+	node.setImageStream(stream)
+	defer node.setImageStream(nil)
+	treeSize := node.TreeSize()
+	end := uint64(base) + treeSize
+	if end > math.MaxUint32 {
+		return 0, ErrNeedsBigTIFF{"AppendIFDTree", end}
+	}
+	// The tree is serialized at the start of buf, with the
+	// positions it refers to offset by base.
+	buf := make([]byte, treeSize)
+	node.setOrigin(&writeOrigin{offset: base, size: len(buf)})
+	defer node.setOrigin(nil)
+	stream.next = uint32(end)
+	if _, err := node.PutIFDTree(buf, 0); err != nil {
+		return 0, err
+	}
+	for _, id := range stream.imageData {
+		end += id.Size()
+	}
+	if end > math.MaxUint32 {
+		return 0, ErrNeedsBigTIFF{"AppendIFDTree", end}
+	}
+	if base > uint32(size) {
+		if _, err := w.WriteAt([]byte{0}, size); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := w.WriteAt(buf, int64(base)); err != nil {
+		return 0, err
+	}
+	out := &offsetWriter{w, int64(base) + int64(len(buf))}
+	for _, id := range stream.imageData {
+		if _, err := id.WriteTo(out); err != nil {
+			return 0, err
+		}
+	}
+	var root [4]byte
+	node.Order.PutUint32(root[:], base)
+	if _, err := w.WriteAt(root[:], 4); err != nil {
+		return 0, err
+	}
+	return int64(end), nil
+}
@@ -0,0 +1,376 @@
+package tiff66
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"testing"
+)
+
+// Write a tree with image data in the root and a sub-IFD to a stream,
+// and check that it can be read back.
+func TestWriteIFDTree(t *testing.T) {
+	order := binary.LittleEndian
+	strip1 := []byte("first strip")
+	strip2 := []byte("second strip")
+	newNode := func(strip []byte) *IFDNode {
+		node := NewIFDNode(TIFFSpace)
+		node.Order = order
+		node.Fields = []Field{
+			{StripOffsets, LONG, 1, make([]byte, 4)},
+			{StripByteCounts, LONG, 1, make([]byte, 4)},
+		}
+		node.Fields[1].PutLong(uint32(len(strip)), 0, order)
+		node.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Segments: []ImageSegment{strip}}}}
+		return node
+	}
+	root := newNode(strip1)
+	sub := newNode(strip2)
+	root.AddFields([]Field{{SubIFDs, IFD, 1, make([]byte, 4)}})
+	root.SubIFDs = []SubIFD{{SubIFDs, sub}}
+
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	buf := out.Bytes()
+	if buf[len(buf)-1] != strip2[len(strip2)-1] {
+		t.Error("Image data not at end of output")
+	}
+	valid, getorder, pos := GetHeader(buf)
+	if !valid || getorder != order {
+		t.Fatal("Header not valid")
+	}
+	getroot, err := GetIFDTree(buf, getorder, pos, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getroot.SubIFDs) != 1 {
+		t.Fatal("Sub-IFD not read back")
+	}
+	if !bytes.Equal(getroot.GetImageData()[0].Segments[0], strip1) {
+		t.Error("Wrong image data in root")
+	}
+	if !bytes.Equal(getroot.SubIFDs[0].Node.GetImageData()[0].Segments[0], strip2) {
+		t.Error("Wrong image data in sub-IFD")
+	}
+
+	// Rewrite from a reader, without loading the image data.
+	rroot, err := GetIFDTreeReader(bytes.NewReader(buf), getorder, pos, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out2 bytes.Buffer
+	if err := rroot.WriteIFDTree(&out2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out2.Bytes(), buf) {
+		t.Error("Rewritten file differs")
+	}
+}
+
+// An in-memory file for testing AppendIFDTree.
+type memFile struct {
+	buf []byte
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(f.buf) {
+		f.buf = append(f.buf, make([]byte, end-len(f.buf))...)
+	}
+	return copy(f.buf[off:], p), nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Edit a file by appending a new tree, and check that the original
+// image data is reused and new image data is appended.
+func TestAppendIFDTree(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		root, err := EncodeImage(gray, &EncodeOptions{Order: order, RowsPerStrip: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = order
+		exif.SetASCII(LensModel, "A lens")
+		if err := root.SetExifIFD(exif); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := root.WriteIFDTree(&out); err != nil {
+			t.Fatal(err)
+		}
+		orig := append([]byte(nil), out.Bytes()...)
+		// Make the file size odd, to check alignment.
+		orig = append(orig, 0)
+		file := &memFile{append([]byte(nil), orig...)}
+		node, err := GetIFDTree(file.buf, order, order.Uint32(file.buf[4:]), TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.Detach()
+		node.SetASCII(Software, "Some software or other")
+		node.SubIFDs[0].Node.SetASCII(LensModel, "Another lens")
+		thumb, err := EncodeImage(image.NewGray(image.Rect(0, 0, 4, 4)), &EncodeOptions{Order: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.Next = thumb
+		size, err := node.AppendIFDTree(file, int64(len(orig)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(file.buf)) {
+			t.Errorf("Returned size %d, file size %d", size, len(file.buf))
+		}
+		if !bytes.Equal(file.buf[:4], orig[:4]) || !bytes.Equal(file.buf[8:len(orig)], orig[8:]) {
+			t.Error("Original data was changed")
+		}
+		appended, err := GetIFDTree(file.buf, order, order.Uint32(file.buf[4:]), TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Uint32(file.buf[4:]) < uint32(len(orig)) {
+			t.Error("Root IFD wasn't appended")
+		}
+		if s, _ := appended.GetASCII(Software); s != "Some software or other" {
+			t.Errorf("Software is %q", s)
+		}
+		if s, _ := appended.SubIFDs[0].Node.GetASCII(LensModel); s != "Another lens" {
+			t.Errorf("LensModel is %q", s)
+		}
+		for _, offset := range appended.GetImageData()[0].Offsets {
+			if offset >= uint32(len(orig)) {
+				t.Error("Image data was rewritten")
+			}
+		}
+		if appended.Next == nil || appended.Next.GetImageData()[0].Offsets[0] < uint32(len(orig)) {
+			t.Fatal("New image data wasn't appended")
+		}
+		img, err := appended.DecodeImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(img, gray) {
+			t.Error("Image changed")
+		}
+		if _, err := appended.Next.DecodeImage(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Append trees with maker notes whose offsets are absolute or relative
+// to the maker note, and check that their fields can be read back.
+func TestAppendIFDTreeMakerNotes(t *testing.T) {
+	order := binary.LittleEndian
+	for _, relative := range []bool{false, true} {
+		maker := NewIFDNode(Leica1Space)
+		maker.Order = order
+		rec := maker.SpaceRec.(*Leica1SpaceRec)
+		rec.label = []byte("LEICA\000\010\000")
+		if relative {
+			rec.label = []byte("LEICA\000\000\000")
+		}
+		rec.relative = relative
+		maker.SetASCII(0x0303, "Serial number 1234")
+		exif := NewIFDNode(ExifSpace)
+		exif.Order = order
+		exif.Fields = []Field{{makerNote, UNDEFINED, 0, nil}}
+		exif.SubIFDs = []SubIFD{{makerNote, maker}}
+		root := NewIFDNode(TIFFSpace)
+		root.Order = order
+		root.SetASCII(Make, "Leica Camera AG")
+		if err := root.SetExifIFD(exif); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := root.WriteIFDTree(&out); err != nil {
+			t.Fatal(err)
+		}
+		orig := out.Bytes()
+		file := &memFile{append([]byte(nil), orig...)}
+		node, err := GetIFDTree(file.buf, order, order.Uint32(file.buf[4:]), TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.Detach()
+		node.SetASCII(Software, "Some software or other")
+		if _, err := node.AppendIFDTree(file, int64(len(orig))); err != nil {
+			t.Fatal(err)
+		}
+		appended, err := GetIFDTree(file.buf, order, order.Uint32(file.buf[4:]), TIFFSpace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Uint32(file.buf[4:]) < uint32(len(orig)) {
+			t.Error("Root IFD wasn't appended")
+		}
+		getmaker := appended.SubIFDs[0].Node.SubIFDs[0].Node
+		if s, _ := getmaker.GetASCII(0x0303); s != "Serial number 1234" {
+			t.Errorf("Relative %v: field decoded as %q", relative, s)
+		}
+	}
+}
+
+// Check that image data whose content was changed without changing
+// its size is appended rather than left in place.
+func TestAppendIFDTreeChanged(t *testing.T) {
+	order := binary.LittleEndian
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	root, err := EncodeImage(gray, &EncodeOptions{Order: order, RowsPerStrip: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := root.WriteIFDTree(&out); err != nil {
+		t.Fatal(err)
+	}
+	orig := out.Bytes()
+	file := &memFile{append([]byte(nil), orig...)}
+	node, err := GetIFDTree(file.buf, order, order.Uint32(file.buf[4:]), TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Detach()
+	node.GetImageData()[0].Segments[1][0] = 0xFF
+	if _, err := node.AppendIFDTree(file, int64(len(orig))); err != nil {
+		t.Fatal(err)
+	}
+	appended, err := GetIFDTree(file.buf, order, order.Uint32(file.buf[4:]), TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appended.GetImageData()[0].Offsets[0] < uint32(len(orig)) {
+		t.Error("Changed image data wasn't appended")
+	}
+	img, err := appended.DecodeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.(*image.Gray).Pix[16*4] != 0xFF {
+		t.Error("Image data wasn't changed")
+	}
+}
+
+// Check that a tree whose image data would overflow 32 bit positions
+// returns ErrNeedsBigTIFF instead of being written with wrapped
+// positions.
+func TestNeedsBigTIFF(t *testing.T) {
+	order := binary.BigEndian
+	node := NewIFDNode(TIFFSpace)
+	node.Order = order
+	node.Fields = []Field{
+		{StripOffsets, LONG, 2, make([]byte, 8)},
+		{StripByteCounts, LONG, 2, make([]byte, 8)},
+	}
+	sizes := []uint32{0xFFFFFF00, 0x200}
+	node.SetLongs(StripByteCounts, sizes)
+	// Unloaded image data, which is never read.
+	node.SpaceRec = &TIFFSpaceRec{imageData: []ImageData{{OffsetTag: StripOffsets, SizeTag: StripByteCounts, Offsets: []uint32{0, 0}, Sizes: sizes, reader: bytes.NewReader(nil)}}}
+	var out bytes.Buffer
+	err := node.WriteIFDTree(&out)
+	bigErr, ok := err.(ErrNeedsBigTIFF)
+	if !ok {
+		t.Fatalf("Expected ErrNeedsBigTIFF, got %v", err)
+	}
+	if bigErr.Size <= 0xFFFFFF00+0x200 {
+		t.Errorf("Size %d in error is too small", bigErr.Size)
+	}
+	if out.Len() != 0 {
+		t.Error("Data was written")
+	}
+}
+
+// Check that TreeSize doesn't overflow for a field whose size exceeds
+// 32 bits, and that PutIFDTree rejects such a tree or a buffer that's
+// too small.
+func TestTreeSizeOverflow(t *testing.T) {
+	node := NewIFDNode(TIFFSpace)
+	node.Order = binary.LittleEndian
+	// 8 * 0x20000001 wraps to 8 in 32 bits.
+	node.Fields = []Field{{0x8000, DOUBLE, 0x20000001, nil}}
+	if size := node.Fields[0].Size(); size != 0xFFFFFFFF {
+		t.Errorf("Field.Size returned %d", size)
+	}
+	if size := node.TreeSize(); size != uint64(node.TableSize())+8*0x20000001 {
+		t.Errorf("TreeSize returned %d", size)
+	}
+	if _, err := node.PutIFDTree(make([]byte, 64), HeaderSize); err == nil {
+		t.Error("Oversized tree was written")
+	} else if _, ok := err.(ErrNeedsBigTIFF); !ok {
+		t.Errorf("Expected ErrNeedsBigTIFF, got %v", err)
+	}
+	node.Fields = []Field{{0x8000, DOUBLE, 2, make([]byte, 16)}}
+	if _, err := node.PutIFDTree(make([]byte, HeaderSize+node.TreeSize()-1), HeaderSize); err == nil {
+		t.Error("Tree was written to a buffer that's too small")
+	}
+	if _, err := node.PutIFDTree(make([]byte, HeaderSize+node.TreeSize()), HeaderSize); err != nil {
+		t.Error(err)
+	}
+}
+
+// Write a multi-page file in which each page has the same large field,
+// and check that the data is written once and read back in each page.
+func TestWriteIFDTreeShared(t *testing.T) {
+	order := binary.BigEndian
+	profile := bytes.Repeat([]byte("profile data "), 100)
+	var root, last *IFDNode
+	for i := 0; i < 3; i++ {
+		page, err := EncodeImage(image.NewGray(image.Rect(0, 0, 4, 4)), &EncodeOptions{Order: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.SetUndefined(ICCProfile, profile)
+		if root == nil {
+			root = page
+		} else {
+			last.Next = page
+		}
+		last = page
+	}
+	var plain, shared bytes.Buffer
+	if err := root.WriteIFDTree(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.WriteIFDTreeShared(&shared); err != nil {
+		t.Fatal(err)
+	}
+	// Other fields, such as the resolutions, are also shared.
+	if saved := plain.Len() - shared.Len(); saved < 2*len(profile) {
+		t.Errorf("Sharing saved %d bytes, expected at least %d", saved, 2*len(profile))
+	}
+	if root.sharing != nil || root.Next.sharing != nil {
+		t.Error("Sharing not cleared after writing")
+	}
+	buf := shared.Bytes()
+	getroot, err := GetIFDTree(buf, order, order.Uint32(buf[4:]), TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := 0
+	for node := getroot; node != nil; node = node.Next {
+		pages++
+		if got, ok := node.GetICCProfile(); !ok || !bytes.Equal(got, profile) {
+			t.Errorf("Page %d has wrong profile", pages)
+		}
+	}
+	if pages != 3 {
+		t.Errorf("Read %d pages", pages)
+	}
+}
@@ -0,0 +1,75 @@
+package tiff66
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Start of the trailer of an XMP packet wrapper.
+const xmpTrailerStart = "<?xpacket end="
+
+// Return the XMP packet from the XMP field of a node. The bool is false
+// if the field isn't found, doesn't have BYTE or UNDEFINED type, or its
+// data isn't loaded.
+func (node IFDNode) GetXMP() (string, bool) {
+	data, ok := node.GetBytes(XMP)
+	if !ok {
+		return "", false
+	}
+	return strings.TrimRight(string(data), "\000"), true
+}
+
+// Set the XMP field of a node to a packet, resizing the field as
+// needed. The field has BYTE type, unless an existing field has
+// UNDEFINED type.
+func (node *IFDNode) SetXMP(packet string) {
+	if fields := node.FindFields([]Tag{XMP}); len(fields) > 0 && fields[0].Type == UNDEFINED {
+		node.SetUndefined(XMP, []byte(packet))
+		return
+	}
+	node.SetBytes(XMP, []byte(packet))
+}
+
+// Return an XMP packet adjusted to a given size in bytes, by replacing
+// the whitespace padding before the trailer of its packet wrapper. The
+// padding is made of spaces, with a newline every 100 bytes.
+func PadXMP(packet string, size int) (string, error) {
+	end := strings.LastIndex(packet, xmpTrailerStart)
+	if end < 0 {
+		return "", errors.New("PadXMP: packet has no wrapper trailer")
+	}
+	body := strings.TrimRight(packet[:end], " \t\r\n")
+	trailer := packet[end:]
+	padding := size - len(body) - len(trailer)
+	if padding < 1 {
+		return "", fmt.Errorf("PadXMP: packet needs %d bytes, more than %d", len(body)+len(trailer)+1, size)
+	}
+	padded := make([]byte, 0, size)
+	padded = append(padded, body...)
+	for i := 0; i < padding; i++ {
+		if i%100 == 0 {
+			padded = append(padded, '\n')
+		} else {
+			padded = append(padded, ' ')
+		}
+	}
+	return string(append(padded, trailer...)), nil
+}
+
+// Set the XMP field of a node to a packet padded to the size of the
+// existing field, so that the file can be updated in place, e.g., with
+// UpdateInBuffer or PatchIFDTree. It fails if there's no existing
+// field, or the packet doesn't fit.
+func (node *IFDNode) SetXMPPadded(packet string) error {
+	fields := node.FindFields([]Tag{XMP})
+	if len(fields) == 0 {
+		return errors.New("SetXMPPadded: XMP field not found")
+	}
+	padded, err := PadXMP(packet, int(fields[0].Size()))
+	if err != nil {
+		return err
+	}
+	node.SetXMP(padded)
+	return nil
+}
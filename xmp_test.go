@@ -0,0 +1,69 @@
+package tiff66
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestXMP(t *testing.T) {
+	header := "<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>"
+	trailer := "<?xpacket end=\"w\"?>"
+	packet := func(title string) string {
+		return header + "<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"><dc:title>" + title + "</dc:title></x:xmpmeta>\n" + trailer
+	}
+	order := binary.BigEndian
+	root := NewIFDNode(TIFFSpace)
+	root.Order = order
+	if _, ok := root.GetXMP(); ok {
+		t.Error("Missing XMP field found")
+	}
+	if err := root.SetXMPPadded(packet("x")); err == nil {
+		t.Error("SetXMPPadded without an existing field didn't cause an error")
+	}
+	padded, err := PadXMP(packet("Original"), 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(padded) != 2048 || !strings.HasSuffix(padded, trailer) {
+		t.Fatalf("Padded packet has length %d", len(padded))
+	}
+	root.SetXMP(padded)
+	if field := root.FindFields([]Tag{XMP})[0]; field.Type != BYTE {
+		t.Errorf("XMP field has type %s", field.Type.Name())
+	}
+	buf := make([]byte, HeaderSize+root.TreeSize())
+	PutHeader(buf, order, HeaderSize)
+	if _, err := root.PutIFDTree(buf, HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update the packet in place.
+	node, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := node.GetXMP(); !ok || got != padded {
+		t.Fatal("XMP packet not decoded")
+	}
+	node.Detach()
+	if err := node.SetXMPPadded(packet("A longer title than before")); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateInBuffer(buf, node, XMP); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := GetIFDTree(buf, order, HeaderSize, TIFFSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := updated.GetXMP(); !strings.Contains(got, "A longer title") || len(got) != 2048 {
+		t.Errorf("Updated packet has length %d", len(got))
+	}
+	if err := node.SetXMPPadded(packet(strings.Repeat("x", 2048))); err == nil {
+		t.Error("Packet larger than the field didn't cause an error")
+	}
+	if _, err := PadXMP("<x:xmpmeta/>", 100); err == nil {
+		t.Error("Packet without a wrapper didn't cause an error")
+	}
+}